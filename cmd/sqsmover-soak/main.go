@@ -0,0 +1,136 @@
+// Command sqsmover-soak moves messages between in-memory queues while faults
+// are injected into every SQS call, retrying like an operator rerunning
+// sqsmover would, and checks that no message was lost or duplicated.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	messages  = kingpin.Flag("messages", "The number of messages to move.").Short('n').Default("10000").Int()
+	chaosRate = kingpin.Flag("chaos", "The probability of a fault in every SQS call and batch entry.").Default("0.1").Float64()
+	seed      = kingpin.Flag("seed", "Seeds the injected faults, runs with the same seed inject the same faults.").Default("1").Int64()
+	maxRounds = kingpin.Flag("max-rounds", "Give up after this many moves.").Default("1000").Int()
+	fifo      = kingpin.Flag("fifo", "Move between FIFO queues, deduplication must then prevent any duplicate.").Bool()
+)
+
+func main() {
+	kingpin.Parse()
+
+	fake := fakesqs.New()
+	now := time.Now()
+	fake.SetClock(func() time.Time { return now })
+
+	sourceName, destinationName := "soak-source", "soak-destination"
+	if *fifo {
+		sourceName, destinationName = sourceName+".fifo", destinationName+".fifo"
+	}
+
+	sourceURL := createQueue(fake, sourceName)
+	destinationURL := createQueue(fake, destinationName)
+	seedMessages(fake, sourceURL, *messages)
+
+	options := rtksqs.Options{SQS: rtksqs.WithChaos(fake, *chaosRate, *seed)}
+
+	rounds, failedRounds := 0, 0
+
+	for ; rounds < *maxRounds && len(fake.Bodies(sourceURL)) > 0; rounds++ {
+		source, err := rtksqs.OpenSource(nil, sourceName, options)
+		exitOnError("open source", err)
+
+		sink, err := rtksqs.OpenSink(nil, destinationName, options)
+		exitOnError("open destination", err)
+
+		if _, err := rtksqs.Move(source, sink, rtksqs.UnknownCount, rtksqs.MoveOptions{}); err != nil {
+			failedRounds++
+		}
+
+		source.Close()
+		sink.Close()
+
+		// Messages received by a failed move become visible again once
+		// sqsmover's visibility timeout expired.
+		now = now.Add(3 * time.Second)
+	}
+
+	left := fake.Bodies(sourceURL)
+	moved, lost, duplicated := compare(fake.Bodies(destinationURL), left, *messages)
+
+	fmt.Printf("%d moves, %d failed by injected faults\n", rounds, failedRounds)
+	fmt.Printf("%d of %d messages moved, %d left in the source, %d lost, %d duplicated\n",
+		moved, *messages, len(left), lost, duplicated)
+
+	// Failed deletes are retried while the messages are hidden, so sent
+	// messages aren't moved again, between standard queues too.
+	if lost > 0 || len(left) > 0 || duplicated > 0 {
+		os.Exit(1)
+	}
+}
+
+func exitOnError(step string, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s: %s\n", step, err)
+		os.Exit(1)
+	}
+}
+
+func createQueue(fake *fakesqs.SQS, name string) string {
+	resp, err := fake.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)})
+	exitOnError("create "+name, err)
+	return aws.StringValue(resp.QueueUrl)
+}
+
+func body(i int) string {
+	return fmt.Sprintf("soak-%08d", i)
+}
+
+func seedMessages(fake *fakesqs.SQS, url string, count int) {
+	for i := 0; i < count; i++ {
+		input := &sqs.SendMessageInput{QueueUrl: aws.String(url), MessageBody: aws.String(body(i))}
+
+		if *fifo {
+			input.MessageGroupId = aws.String(fmt.Sprintf("group-%d", i%16))
+			input.MessageDeduplicationId = aws.String(body(i))
+		}
+
+		_, err := fake.SendMessage(input)
+		exitOnError("seed the source", err)
+	}
+}
+
+// compare counts the expected messages which reached the destination, were
+// lost from both queues and were repeated in the destination.
+func compare(destination, source []string, count int) (moved, lost, duplicated int) {
+	seen := make(map[string]int, len(destination))
+	for _, b := range destination {
+		seen[b]++
+	}
+
+	left := make(map[string]bool, len(source))
+	for _, b := range source {
+		left[b] = true
+	}
+
+	for i := 0; i < count; i++ {
+		n := seen[body(i)]
+
+		switch {
+		case n == 0 && !left[body(i)]:
+			lost++
+		case n > 0:
+			moved++
+			duplicated += n - 1
+		}
+	}
+
+	return moved, lost, duplicated
+}
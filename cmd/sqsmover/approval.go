@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// slackApprovalScheme prefixes the Slack channel of --approval.
+const slackApprovalScheme = "slack:"
+
+// Reactions approving and declining a plan posted for --approval.
+const (
+	approveReaction = "white_check_mark"
+	declineReaction = "x"
+)
+
+// approvalPollInterval is how often the reactions of a posted plan are read.
+const approvalPollInterval = 10 * time.Second
+
+// slackAPIURL is the base URL of the Slack Web API.
+var slackAPIURL = "https://slack.com/api/"
+
+// slackApprover posts plans to a Slack channel with a bot token and reads
+// the reactions to them.
+type slackApprover struct {
+	client  *http.Client
+	token   string
+	channel string
+}
+
+// slackResponse holds the fields of Slack API responses approvals use.
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	UserID  string `json:"user_id"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+	Message struct {
+		Reactions []struct {
+			Name  string   `json:"name"`
+			Users []string `json:"users"`
+		} `json:"reactions"`
+	} `json:"message"`
+}
+
+// parseApproval returns the approver of an --approval of slack:<channel>,
+// posting with the bot token in $SLACK_BOT_TOKEN.
+func parseApproval(spec string) (*slackApprover, error) {
+	if !strings.HasPrefix(spec, slackApprovalScheme) || spec == slackApprovalScheme {
+		return nil, fmt.Errorf("--approval must be slack:<channel>, got %q", spec)
+	}
+
+	token := os.Getenv("SLACK_BOT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("--approval needs the Slack bot token in $SLACK_BOT_TOKEN")
+	}
+
+	return &slackApprover{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		token:   token,
+		channel: strings.TrimPrefix(spec, slackApprovalScheme),
+	}, nil
+}
+
+// call calls a Slack API method, POSTing body as JSON, or with a GET of the
+// query when body is nil.
+func (s *slackApprover) call(method string, query url.Values, body interface{}) (*slackResponse, error) {
+	var request *http.Request
+
+	if body == nil {
+		var err error
+		if request, err = http.NewRequest(http.MethodGet, slackAPIURL+method+"?"+query.Encode(), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		if request, err = http.NewRequest(http.MethodPost, slackAPIURL+method, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	request.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response slackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid response of %s: %s", method, err)
+	}
+
+	if !response.OK {
+		return nil, fmt.Errorf("%s failed: %s", method, response.Error)
+	}
+
+	return &response, nil
+}
+
+// post posts text to the channel, in the thread of ts unless empty.
+func (s *slackApprover) post(text, ts string) (*slackResponse, error) {
+	message := map[string]string{"channel": s.channel, "text": text}
+	if ts != "" {
+		message["thread_ts"] = ts
+	}
+
+	return s.call("chat.postMessage", nil, message)
+}
+
+// requestApproval posts the plan to the --approval channel and waits until
+// someone other than the bot reacts with approveReaction or
+// declineReaction, up to --approval-timeout. It logs the outcome and
+// reports whether the plan was approved.
+func requestApproval(plan []planItem) bool {
+	approver, err := parseApproval(*approval)
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("%s", err))
+		return false
+	}
+
+	auth, err := approver.call("auth.test", url.Values{}, nil)
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to ask for approval in Slack. Error: %s", err))
+		return false
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "sqsmover asks to go ahead with this plan, react with :%s: to approve or :%s: to decline.\n```\n", approveReaction, declineReaction)
+	formatPlan(&text, plan)
+	text.WriteString("```")
+
+	posted, err := approver.post(text.String(), "")
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to ask for approval in Slack. Error: %s", err))
+		return false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Waiting up to %s for approval in Slack channel %s", *approvalTimeout, approver.channel))
+
+	ticker := time.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(*approvalTimeout)
+
+	for time.Now().Before(deadline) {
+		<-ticker.C
+
+		reactions, err := approver.call("reactions.get", url.Values{"channel": {posted.Channel}, "timestamp": {posted.TS}, "full": {"true"}}, nil)
+		if err != nil {
+			log.Warn(color.New(color.FgYellow).Sprintf("Failed to read the approval reactions, trying again. Error: %s", err))
+			continue
+		}
+
+		// A decline outweighs approvals.
+		approvedBy, declinedBy := "", ""
+		for _, reaction := range reactions.Message.Reactions {
+			for _, user := range reaction.Users {
+				switch {
+				case user == auth.UserID:
+				case reaction.Name == approveReaction:
+					approvedBy = user
+				case reaction.Name == declineReaction:
+					declinedBy = user
+				}
+			}
+		}
+
+		switch {
+		case declinedBy != "":
+			summaryLog.Warn(color.New(color.FgYellow).Sprintf("Declined in Slack by %s, nothing was changed", declinedBy))
+			approver.post(fmt.Sprintf("Declined by <@%s>, nothing was changed.", declinedBy), posted.TS)
+			return false
+		case approvedBy != "":
+			summaryLog.Info(color.New(color.FgCyan).Sprintf("Approved in Slack by %s", approvedBy))
+			approver.post(fmt.Sprintf("Approved by <@%s>, going ahead.", approvedBy), posted.TS)
+			return true
+		}
+	}
+
+	summaryLog.Error(color.New(color.FgRed).Sprintf("Not approved in Slack within %s, nothing was changed", *approvalTimeout))
+	approver.post("Not approved in time, nothing was changed.", posted.TS)
+	return false
+}
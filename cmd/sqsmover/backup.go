@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// backupAndPurge backs up --queue to the S3 URL of --to and purges it, and
+// reports whether it succeeded.
+func backupAndPurge(sess *session.Session, openOptions rtksqs.Options) bool {
+	output := &backupOutput{Status: resultFailed, RunID: openOptions.RunID, Queue: *backupQueueName, Backup: *backupTo}
+	if jsonOutput() {
+		defer func() { writeJSON("backup result", output) }()
+	}
+
+	description, err := rtksqs.DescribeQueue(sess, *backupQueueName, openOptions)
+
+	if err != nil {
+		logAwsError("Failed to resolve queue attributes", err)
+		output.Error = err.Error()
+		return false
+	}
+
+	if !confirmPlan([]planItem{
+		{"Queue", description.URL},
+		{"Messages", "about " + description.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]},
+		{"Backup", *backupTo},
+		{"Purges", "the backed up messages, once uploaded"},
+	}) {
+		return false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Backing up %s to %s, hiding its messages for %s", *backupQueueName, *backupTo, *backupHold))
+
+	result, err := rtksqs.BackupAndPurge(sess, *backupQueueName, *backupTo, openOptions, rtksqs.BackupOptions{
+		Hold: *backupHold,
+		Progress: func(received int) {
+			log.Debugf("Received %d messages", received)
+		},
+		Uploaded: func(url string) {
+			log.Info(color.New(color.FgCyan).Sprintf("Uploaded %s", url))
+		},
+	})
+
+	output.Messages, output.Objects, output.Purged = result.Messages, result.Objects, result.Purged
+
+	if err != nil {
+		logAwsError("Failed to back up and purge "+*backupQueueName, err)
+		output.Error = err.Error()
+
+		if result.Purged > 0 {
+			summaryLog.Error(color.New(color.FgRed).Sprintf("Purged %d of %d backed up messages, the others were made visible again", result.Purged, result.Messages))
+		} else {
+			summaryLog.Error(color.New(color.FgRed).Sprintf("Nothing was purged, the %d received messages were made visible again", result.Messages))
+		}
+		return false
+	}
+
+	output.Status = resultSucceeded
+
+	if result.Messages == 0 {
+		summaryLog.Info("Looks like nothing to back up. Done.")
+		return true
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Done. Backed up %d messages to %d objects and purged them from %s", result.Messages, len(result.Objects), *backupQueueName))
+	return true
+}
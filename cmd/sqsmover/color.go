@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// setupColor decides whether the logs are colored. Colors are left out with
+// --no-color, when $NO_COLOR is set as https://no-color.org asks, on dumb
+// terminals and when stderr, which carries the logs, isn't a terminal, e.g.
+// redirected to a file while the progress bar is drawn on stdout. The log
+// handler translates the colors for consoles of older Windows versions.
+func setupColor() {
+	color.NoColor = *noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || !isTerminal(os.Stderr)
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"github.com/tj/go/term"
+)
+
+// errChunkDeclined stops a move when the next --chunk wasn't confirmed.
+var errChunkDeclined = errors.New("the next chunk was declined")
+
+// terminalAnswers reads the answers to every question asked on the terminal,
+// so none is lost in the buffer of another reader.
+var terminalAnswers = bufio.NewReader(os.Stdin)
+
+// planConfirmed is set once a plan was confirmed, so a run moving many times,
+// e.g. with --watch-alarm, asks only once.
+var planConfirmed bool
+
+// planItem is a line of the plan of a destructive run.
+type planItem struct {
+	name  string
+	value string
+}
+
+// askTerminal asks a yes or no question on the terminal and reports whether
+// it was answered with yes.
+func askTerminal(question string) bool {
+	fmt.Fprint(os.Stderr, color.New(color.FgYellow).Sprint(question+" [y/N] "))
+
+	answer, err := terminalAnswers.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// stdinIsTerminal reports whether questions can be answered on stdin.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(os.Stdin.Fd())
+}
+
+// confirmPlan prints the plan of a destructive run and asks on the terminal
+// whether to go ahead, unless --yes was passed. With --approval it asks in
+// Slack instead, which --yes doesn't skip. It reports false when the run was
+// declined, or can't be confirmed since stdin is no terminal, which fails the
+// run.
+func confirmPlan(plan []planItem) bool {
+	if planConfirmed {
+		return true
+	}
+
+	if *approval != "" {
+		printPlan(plan)
+
+		if !requestApproval(plan) {
+			exitCode = exitFailed
+			return false
+		}
+
+		planConfirmed = true
+		return true
+	}
+
+	if *assumeYes {
+		return true
+	}
+
+	printPlan(plan)
+
+	if !stdinIsTerminal() {
+		exitCode = exitFailed
+		log.Error(color.New(color.FgRed).Sprintf("Not going ahead, pass --yes to confirm when not running on a terminal"))
+		return false
+	}
+
+	if !askTerminal("Go ahead?") {
+		exitCode = exitFailed
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Declined, nothing was changed"))
+		return false
+	}
+
+	planConfirmed = true
+	return true
+}
+
+// printPlan prints the lines of a plan to stderr.
+func printPlan(plan []planItem) {
+	formatPlan(os.Stderr, plan)
+}
+
+// formatPlan writes the lines of a plan with aligned values.
+func formatPlan(out io.Writer, plan []planItem) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, item := range plan {
+		fmt.Fprintf(w, "%s:\t%s\n", item.name, item.value)
+	}
+	w.Flush()
+}
+
+// filterPlan describes the filters and hooks set by flags which skip
+// messages.
+func filterPlan() string {
+	var filters []string
+
+	if *bodyRegex != nil {
+		filters = append(filters, fmt.Sprintf("--body-regex %q", (*bodyRegex).String()))
+	}
+	for _, attribute := range *attributes {
+		filters = append(filters, "--attribute "+attribute)
+	}
+	for _, code := range *eventsErrorCodes {
+		filters = append(filters, "--eventbridge-error-code "+code)
+	}
+	if *olderThan > 0 {
+		filters = append(filters, fmt.Sprintf("--older-than %s", *olderThan))
+	}
+	if *minReceiveCount > 0 {
+		filters = append(filters, fmt.Sprintf("--min-receive-count %d", *minReceiveCount))
+	}
+	if *invert {
+		filters = append(filters, "--invert")
+	}
+	if *skipOlderThan > 0 {
+		filters = append(filters, fmt.Sprintf("--skip-older-than %s", *skipOlderThan))
+	}
+	if *maxReplays > 0 {
+		filters = append(filters, fmt.Sprintf("--max-replays %d", *maxReplays))
+	}
+	if *sample > 0 {
+		filters = append(filters, fmt.Sprintf("--sample %g --sample-seed %s", *sample, *sampleSeed))
+	}
+	for _, prefix := range *s3EventPrefixes {
+		filters = append(filters, "--s3-event-prefix "+prefix)
+	}
+	if *s3EventExists {
+		filters = append(filters, "--s3-event-exists")
+	}
+	if *transformPlugin != "" {
+		filters = append(filters, "--transform-plugin "+*transformPlugin)
+	}
+	if *transformExec != "" {
+		filters = append(filters, fmt.Sprintf("--transform-exec %q", *transformExec))
+	}
+	if *decideExec != "" {
+		filters = append(filters, fmt.Sprintf("--decide-exec %q", *decideExec))
+	}
+
+	if len(filters) == 0 {
+		return "none, every message is moved"
+	}
+
+	return strings.Join(filters, ", ")
+}
+
+// deletePlan describes what a move deletes from the source.
+func deletePlan() string {
+	deletes := "the moved messages, once sent"
+
+	if *deleteFiltered {
+		deletes += ", the messages the filters skip"
+	}
+
+	if *decideExec != "" {
+		deletes += ", the messages --decide-exec decides to delete, the messages it routes, once sent to their route"
+	}
+
+	if *quarantineQueue != "" {
+		deletes += ", the skipped messages, once quarantined in " + *quarantineQueue
+	}
+
+	return deletes
+}
+
+// sourceDeletePlan describes what a move from the source spec deletes from
+// it, nothing from dumps and stdin.
+func sourceDeletePlan(spec string) string {
+	if !rtksqs.DeletesFromSource(spec) {
+		return "nothing"
+	}
+	return deletePlan()
+}
+
+// chunkApproval is POSTed to --confirm-webhook before every next chunk.
+type chunkApproval struct {
+	RunID       string `json:"runId"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Moved       int    `json:"moved"`
+	Total       int    `json:"total"`
+	Chunk       int    `json:"chunk"`
+}
+
+// chunkConfirmer returns the rtksqs.MoveOptions.ConfirmChunk of
+// --confirm-each-chunk, asking on the terminal, or of --confirm-webhook.
+func chunkConfirmer(source rtksqs.Source, destination rtksqs.Sink, total int, runID string) func(moved int) error {
+	if *confirmWebhook != "" {
+		client := &http.Client{Timeout: *confirmTimeout}
+
+		return func(moved int) error {
+			log.Info(color.New(color.FgCyan).Sprintf("Moved %d messages, waiting for %s to approve the next %d", moved, *confirmWebhook, *chunkSize))
+
+			body, err := json.Marshal(chunkApproval{
+				RunID:       runID,
+				Source:      source.String(),
+				Destination: destination.String(),
+				Moved:       moved,
+				Total:       total,
+				Chunk:       *chunkSize,
+			})
+
+			if err != nil {
+				return err
+			}
+
+			resp, err := client.Post(*confirmWebhook, "application/json", bytes.NewReader(body))
+
+			if err != nil {
+				return fmt.Errorf("failed to ask %s for approval: %s", *confirmWebhook, err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				log.Warn(color.New(color.FgYellow).Sprintf("%s declined the next chunk with %s", *confirmWebhook, resp.Status))
+				return errChunkDeclined
+			}
+
+			return nil
+		}
+	}
+
+	return func(moved int) error {
+		if !askTerminal(fmt.Sprintf("Moved %d messages, move the next %d?", moved, *chunkSize)) {
+			return errChunkDeclined
+		}
+		return nil
+	}
+}
+
+// movePlan returns the plan of moving up to total messages from source to
+// destination, UnknownCount or 0 for all. spec is the source as given, which
+// tells whether it is deleted from, source may be its resolved URL.
+func movePlan(spec, source, destination string, total int) []planItem {
+	messages := "all"
+	if total > 0 {
+		messages = fmt.Sprintf("about %d", total)
+	}
+
+	return []planItem{
+		{"Source", source},
+		{"Destination", destination},
+		{"Messages", messages},
+		{"Filters", filterPlan()},
+		{"Deletes from the source", sourceDeletePlan(spec)},
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// controlFileInterval is how often --control-file is read.
+const controlFileInterval = time.Second
+
+// startMoveControl returns the control of all moves of the run, paused and
+// resumed by signals and by commands written to --control-file.
+func startMoveControl() *rtksqs.MoveControl {
+	control := rtksqs.NewMoveControl()
+
+	if pauseSignal != nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, pauseSignal, resumeSignal)
+
+		go func() {
+			for s := range signals {
+				if s == pauseSignal {
+					pauseMoves(control)
+				} else {
+					resumeMoves(control)
+				}
+			}
+		}()
+	}
+
+	if *controlFile != "" {
+		go watchControlFile(control)
+	}
+
+	return control
+}
+
+func pauseMoves(control *rtksqs.MoveControl) {
+	if control.Paused() {
+		return
+	}
+
+	control.Pause()
+	log.Warn(color.New(color.FgYellow).Sprintf("Paused, moves stop receiving after the batch being moved until resumed"))
+}
+
+func resumeMoves(control *rtksqs.MoveControl) {
+	if !control.Paused() {
+		return
+	}
+
+	control.Resume()
+	log.Info(color.New(color.FgCyan).Sprintf("Resumed"))
+}
+
+// watchControlFile applies the pause, resume and abort commands written to
+// --control-file. A command already in the file when the run starts, e.g.
+// left by an earlier run, is ignored.
+func watchControlFile(control *rtksqs.MoveControl) {
+	last := readControlFile()
+
+	for range time.Tick(controlFileInterval) {
+		command := readControlFile()
+
+		if command == last {
+			continue
+		}
+		last = command
+
+		switch command {
+		case "pause":
+			pauseMoves(control)
+		case "resume":
+			resumeMoves(control)
+		case "abort":
+			log.Warn(color.New(color.FgYellow).Sprintf("Aborting, moves stop after the batch being moved"))
+			control.Abort()
+		case "":
+		default:
+			log.Warn(color.New(color.FgYellow).Sprintf("Ignoring %q in %s, expected pause, resume or abort", command, *controlFile))
+		}
+	}
+}
+
+// readControlFile returns the command in --control-file, nothing when it
+// doesn't exist or can't be read.
+func readControlFile() string {
+	content, err := os.ReadFile(*controlFile)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(string(content)))
+}
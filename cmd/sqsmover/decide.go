@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// routeSinks are the sinks --decide-exec routes messages to, each opened the
+// first time a message is routed to it and shared by all moves.
+type routeSinks struct {
+	sess        *session.Session
+	openOptions rtksqs.Options
+
+	mu     sync.Mutex
+	routes []string
+	sinks  map[string]rtksqs.Sink
+	routed map[string]int
+}
+
+func newRouteSinks(sess *session.Session, openOptions rtksqs.Options) *routeSinks {
+	// Only the destination is recorded in the --id-map, and only it is
+	// pre-warmed.
+	openOptions.IDMap, openOptions.PrewarmGroups = "", false
+
+	return &routeSinks{sess: sess, openOptions: openOptions, sinks: map[string]rtksqs.Sink{}, routed: map[string]int{}}
+}
+
+// open returns the sink of a route, a queue or any other destination.
+func (r *routeSinks) open(route string) (rtksqs.Sink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sink, ok := r.sinks[route]; ok {
+		return sink, nil
+	}
+
+	sink, err := rtksqs.OpenSink(r.sess, route, r.openOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Routing messages to %s", sink))
+	r.routes = append(r.routes, route)
+	r.sinks[route] = sink
+	return sink, nil
+}
+
+// add counts a message routed to a route.
+func (r *routeSinks) add(message *sqs.Message, route string) {
+	r.mu.Lock()
+	r.routed[route]++
+	r.mu.Unlock()
+
+	log.Debugf("Routed message %s to %s", aws.StringValue(message.MessageId), route)
+}
+
+// close closes the sinks of all routes and logs how many messages were
+// routed to each.
+func (r *routeSinks) close() {
+	for _, route := range r.routes {
+		if err := r.sinks[route].Close(); err != nil {
+			logAwsError("Failed to close the route "+route, err)
+		}
+
+		summaryLog.Info(color.New(color.FgCyan).Sprintf("Routed %d messages to %s", r.routed[route], route))
+	}
+}
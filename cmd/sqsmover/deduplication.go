@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// contentDeduplicationIssue returns a warning when the destination is a FIFO
+// queue deduplicating by content, unless --unique-deduplication or a requeue
+// give every message a deduplication ID of its own.
+func contentDeduplicationIssue(destination rtksqs.Sink) (string, error) {
+	if *uniqueDedup || requeueing() {
+		return "", nil
+	}
+
+	deduplicates, err := rtksqs.ContentDeduplication(destination)
+	if err != nil || !deduplicates {
+		return "", err
+	}
+
+	return "The destination deduplicates by content, a message with the body of a message sent to it within 5 minutes is dropped without an error. " +
+		"--unique-deduplication keeps them, e.g. to replay messages exactly", nil
+}
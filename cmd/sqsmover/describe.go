@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// describeSections groups the queue attributes printed by describe, others
+// are printed last.
+var describeSections = []struct {
+	title      string
+	attributes []string
+}{
+	{"Queue", []string{
+		sqs.QueueAttributeNameQueueArn,
+		sqs.QueueAttributeNameCreatedTimestamp,
+		sqs.QueueAttributeNameLastModifiedTimestamp,
+	}},
+	{"Messages", []string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+	}},
+	{"Delivery", []string{
+		sqs.QueueAttributeNameVisibilityTimeout,
+		sqs.QueueAttributeNameDelaySeconds,
+		sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds,
+		sqs.QueueAttributeNameMessageRetentionPeriod,
+		sqs.QueueAttributeNameMaximumMessageSize,
+	}},
+	{"Redrive", []string{
+		sqs.QueueAttributeNameRedrivePolicy,
+		sqs.QueueAttributeNameRedriveAllowPolicy,
+	}},
+	{"Encryption", []string{
+		sqs.QueueAttributeNameSqsManagedSseEnabled,
+		sqs.QueueAttributeNameKmsMasterKeyId,
+		sqs.QueueAttributeNameKmsDataKeyReusePeriodSeconds,
+	}},
+	{"FIFO", []string{
+		sqs.QueueAttributeNameFifoQueue,
+		sqs.QueueAttributeNameContentBasedDeduplication,
+		sqs.QueueAttributeNameDeduplicationScope,
+		sqs.QueueAttributeNameFifoThroughputLimit,
+	}},
+	{"Access", []string{
+		sqs.QueueAttributeNamePolicy,
+	}},
+}
+
+// describeQueue prints all attributes of the --queue to stdout.
+func describeQueue(sess *session.Session, openOptions rtksqs.Options) {
+	description, err := rtksqs.DescribeQueue(sess, *describeName, openOptions)
+
+	if err != nil {
+		logAwsError("Failed to describe queue", err)
+		exitCode = exitFailed
+		return
+	}
+
+	if jsonOutput() {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(description); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to write the description. Error: %s", err))
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "URL\t%s\n", description.URL)
+
+	printed := map[string]bool{}
+	section := func(title string, names []string) {
+		fmt.Fprintf(w, "\n%s\n", title)
+		for _, name := range names {
+			printed[name] = true
+			fmt.Fprintf(w, "  %s\t%s\n", name, formatQueueAttribute(name, description.Attributes[name]))
+		}
+	}
+
+	for _, s := range describeSections {
+		var names []string
+		for _, name := range s.attributes {
+			if _, ok := description.Attributes[name]; ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			section(s.title, names)
+		}
+	}
+
+	var others []string
+	for name := range description.Attributes {
+		if !printed[name] {
+			others = append(others, name)
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		section("Other", others)
+	}
+
+	w.Flush()
+}
+
+// formatQueueAttribute renders durations, timestamps and sizes of queue
+// attributes readably, other values as they are.
+func formatQueueAttribute(name, value string) string {
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+
+	switch name {
+	case sqs.QueueAttributeNameVisibilityTimeout, sqs.QueueAttributeNameDelaySeconds,
+		sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds, sqs.QueueAttributeNameMessageRetentionPeriod,
+		sqs.QueueAttributeNameKmsDataKeyReusePeriodSeconds:
+		return (time.Duration(number) * time.Second).String()
+	case sqs.QueueAttributeNameCreatedTimestamp, sqs.QueueAttributeNameLastModifiedTimestamp:
+		return time.Unix(number, 0).UTC().Format(time.RFC3339)
+	case sqs.QueueAttributeNameMaximumMessageSize:
+		return formatSize(int(number))
+	default:
+		return value
+	}
+}
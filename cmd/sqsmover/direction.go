@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// Directions of --direction between --queue and its dead-letter queue.
+const (
+	directionToDLQ   = "to-dlq"
+	directionFromDLQ = "from-dlq"
+)
+
+// resolveDirection sets the source and destination of --direction from the
+// redrive policy of --queue. It logs failures and reports false.
+func resolveDirection(sess *session.Session, openOptions rtksqs.Options) bool {
+	deadLetterQueue, err := rtksqs.DeadLetterQueue(sess, *moveQueueName, openOptions)
+
+	if err != nil {
+		logAwsError("Failed to resolve the dead-letter queue of "+*moveQueueName, err)
+		return false
+	}
+
+	if *direction == directionToDLQ {
+		*sourceQueue, *destinationQueue = *moveQueueName, deadLetterQueue
+		log.Info(color.New(color.FgCyan).Sprintf("Moving %s to its dead-letter queue %s", *moveQueueName, deadLetterQueue))
+	} else {
+		*sourceQueue, *destinationQueue = deadLetterQueue, *moveQueueName
+		log.Info(color.New(color.FgCyan).Sprintf("Moving the dead-letter queue %s back to %s", deadLetterQueue, *moveQueueName))
+	}
+
+	if *quarantineQueue == *sourceQueue {
+		log.Error(color.New(color.FgRed).Sprintf("--quarantine-queue must not be the source %s, quarantined messages would be received again", *sourceQueue))
+		return false
+	}
+
+	return true
+}
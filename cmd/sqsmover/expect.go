@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// allowedDeviation returns how many messages the moved may deviate from the
+// expected count by the tolerance, a number of messages or a percentage of
+// the expected count.
+func allowedDeviation(tolerance string, expected int) (int, error) {
+	if percent := strings.TrimSuffix(tolerance, "%"); percent != tolerance {
+		value, err := strconv.ParseFloat(percent, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("--expect-tolerance must be a number of messages or a percentage, got %q", tolerance)
+		}
+		return int(float64(expected) * value / 100), nil
+	}
+
+	value, err := strconv.Atoi(tolerance)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("--expect-tolerance must be a number of messages or a percentage, got %q", tolerance)
+	}
+
+	return value, nil
+}
+
+// checkExpectedCount compares the moved messages with --expect-count and
+// logs a deviation beyond --expect-tolerance, which fails the run with
+// --strict.
+func checkExpectedCount(moved int) {
+	tolerance, _ := allowedDeviation(*expectTolerance, *expectCount)
+
+	deviation := moved - *expectCount
+	if deviation < 0 {
+		deviation = -deviation
+	}
+
+	if deviation <= tolerance {
+		summaryLog.Info(color.New(color.FgCyan).Sprintf("Moved %d messages, as expected %d ± %d", moved, *expectCount, tolerance))
+		return
+	}
+
+	if *strict {
+		exitCode = exitCountMismatch
+	}
+
+	summaryLog.Error(color.New(color.FgRed).Sprintf("Moved %d messages, expected %d ± %d", moved, *expectCount, tolerance))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// inFlightPollInterval is how often --wait-for-inflight checks the source.
+const inFlightPollInterval = 10 * time.Second
+
+// checkInFlight logs how many messages of the source are in flight, held by
+// consumers, which the move can't receive, so counts that don't add up
+// surprise no one. With --wait-for-inflight it waits until at most that many
+// are left. It logs failures and reports false.
+func checkInFlight(source rtksqs.Source) bool {
+	inFlightSource, ok := source.(rtksqs.InFlightSource)
+	if !ok {
+		return true
+	}
+
+	inFlight, err := inFlightSource.ApproximateInFlight()
+	if err != nil {
+		logAwsError("Failed to resolve queue attributes", err)
+		return false
+	}
+
+	if inFlight == 0 {
+		return true
+	}
+
+	log.Warn(color.New(color.FgYellow).Sprintf("Approximately %d messages of the source are in flight, held by consumers, they are only moved if they become visible again while moving", inFlight))
+
+	if *waitInFlight < 0 || inFlight <= *waitInFlight {
+		return true
+	}
+
+	deadline := time.Now().Add(*inFlightTimeout)
+
+	for inFlight > *waitInFlight {
+		if time.Now().After(deadline) {
+			log.Error(color.New(color.FgRed).Sprintf("Not moving, %d messages are still in flight after waiting %s", inFlight, *inFlightTimeout))
+			exitCode = exitFailed
+			return false
+		}
+
+		log.Info(color.New(color.FgCyan).Sprintf("Waiting until at most %d of %d messages are in flight", *waitInFlight, inFlight))
+		time.Sleep(inFlightPollInterval)
+
+		if inFlight, err = inFlightSource.ApproximateInFlight(); err != nil {
+			logAwsError("Failed to resolve queue attributes", err)
+			return false
+		}
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("%d messages are in flight, moving", inFlight))
+
+	return true
+}
+
+// competingRises is how many rises of the in-flight count in a row, beyond
+// what the move holds, are taken for another consumer of the source.
+const competingRises = 3
+
+// consumerWatch samples the in-flight count of the source while moving and
+// warns once when it keeps rising from receives of another consumer. A
+// move only holds its current batch and the messages it skipped in flight,
+// so a live queue redriven by mistake stands out.
+type consumerWatch struct {
+	source  rtksqs.InFlightSource
+	skipped int64
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startConsumerWatch samples the in-flight count of source every interval
+// until stopped, nil when the source has no in-flight count.
+func startConsumerWatch(source rtksqs.Source, interval time.Duration) *consumerWatch {
+	inFlightSource, ok := source.(rtksqs.InFlightSource)
+	if !ok {
+		return nil
+	}
+
+	w := &consumerWatch{
+		source:  inFlightSource,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go w.run(interval)
+
+	return w
+}
+
+// skip records a message the move skipped, which stays in flight until its
+// visibility timeout ends.
+func (w *consumerWatch) skip() {
+	if w != nil {
+		atomic.AddInt64(&w.skipped, 1)
+	}
+}
+
+func (w *consumerWatch) stop() {
+	if w != nil {
+		close(w.done)
+		<-w.stopped
+	}
+}
+
+func (w *consumerWatch) run(interval time.Duration) {
+	defer close(w.stopped)
+
+	start, err := w.source.ApproximateInFlight()
+	if err != nil {
+		log.Debugf("Failed to count the messages in flight, not watching for other consumers: %s", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, rises := start, 0
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		inFlight, err := w.source.ApproximateInFlight()
+		if err != nil {
+			continue
+		}
+
+		held := int(*maxBatchSize) + int(atomic.LoadInt64(&w.skipped))
+
+		if inFlight > last && inFlight-start > held {
+			rises++
+		} else {
+			rises = 0
+		}
+		last = inFlight
+
+		if rises == competingRises {
+			log.Warn(color.New(color.FgYellow).Sprintf("Messages in flight in %s rose from %d to %d while moving, more than the move holds: another consumer is receiving from it, make sure it is the queue you meant to move from",
+				w.source, start, inFlight))
+			return
+		}
+	}
+}
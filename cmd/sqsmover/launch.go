@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// launchPollInterval is how often the status and logs of a launched task are
+// read.
+const launchPollInterval = 5 * time.Second
+
+// launchLogInterval is the --log-interval of launched moves, which log their
+// progress instead of drawing a progress bar.
+const launchLogInterval = "30s"
+
+// launchedFlags aren't passed on to launched moves: the credentials, which
+// the task role replaces, the flags of launch itself, the logging to a local
+// file, and --approval and --yes, the move is confirmed before launching it.
+var launchedFlags = map[string]bool{
+	"profile":           true,
+	"access-key-id":     true,
+	"secret-access-key": true,
+	"session-token":     true,
+	"cluster":           true,
+	"task-def":          true,
+	"container":         true,
+	"subnet":            true,
+	"security-group":    true,
+	"assign-public-ip":  true,
+	"approval":          true,
+	"yes":               true,
+	"log-file":          true,
+	"log-file-max-size": true,
+	"log-file-max-age":  true,
+	"log-file-keep":     true,
+}
+
+// checkLaunchFlags exits when the move of the flags can't run in a task,
+// which has no access to local files and no terminal.
+func checkLaunchFlags() {
+	if *pairsFile != "" {
+		kingpin.Fatalf("launch can't be combined with --pairs, the task can't read the local file")
+	}
+
+	if *confirmEachChunk {
+		kingpin.Fatalf("launch can't be combined with --confirm-each-chunk, the task has no terminal")
+	}
+
+	for _, spec := range []string{*sourceQueue, *destinationQueue} {
+		if isLocalSpec(spec) {
+			kingpin.Fatalf("launch can't move %s, the task can't reach stdin, stdout or local files", spec)
+		}
+	}
+}
+
+// isLocalSpec reports whether a source or destination is stdin, stdout or a
+// local file.
+func isLocalSpec(spec string) bool {
+	if spec == rtksqs.StdioSpec {
+		return true
+	}
+
+	for _, scheme := range []string{"file://", "csv://", "sqlite://"} {
+		if strings.HasPrefix(spec, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// launchMove runs the move of the flags as a Fargate task of --cluster and
+// follows its logs until it stopped, confirming it first. Interrupting
+// launch stops following the task, which keeps moving. It reports whether
+// the task was launched and its container exited with 0.
+func launchMove(sess *session.Session) bool {
+	args, err := flagArgs(os.Args[1:], launchedFlags)
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to record the flags of the move. Error: %s", err))
+		return false
+	}
+
+	args = append(args, "--yes")
+	if !hasFlag(args, "region") {
+		args = append(args, "--region="+aws.StringValue(sess.Config.Region))
+	}
+	if !hasFlag(args, "log-interval") {
+		args = append(args, "--log-interval="+launchLogInterval)
+	}
+	command := append([]string{moveCommand.FullCommand()}, args...)
+
+	ecsClient := ecs.New(sess)
+
+	definition, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: launchTaskDef})
+
+	if err != nil {
+		logAwsError("Failed to describe the task definition", err)
+		return false
+	}
+
+	container, err := taskContainer(definition.TaskDefinition)
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("%s", err))
+		return false
+	}
+
+	plan := movePlan(*sourceQueue, *sourceQueue, *destinationQueue, *limit)
+	plan = append(plan,
+		planItem{"Runs as", fmt.Sprintf("a task of %s in cluster %s", aws.StringValue(definition.TaskDefinition.TaskDefinitionArn), *launchCluster)},
+		planItem{"Command", strings.Join(command, " ")},
+	)
+
+	if !confirmPlan(plan) {
+		return false
+	}
+
+	publicIP := ecs.AssignPublicIpDisabled
+	if *launchPublicIP {
+		publicIP = ecs.AssignPublicIpEnabled
+	}
+
+	run, err := ecsClient.RunTask(&ecs.RunTaskInput{
+		Cluster:        launchCluster,
+		TaskDefinition: definition.TaskDefinition.TaskDefinitionArn,
+		LaunchType:     aws.String(ecs.LaunchTypeFargate),
+		Count:          aws.Int64(1),
+		StartedBy:      aws.String("sqsmover"),
+		NetworkConfiguration: &ecs.NetworkConfiguration{
+			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+				Subnets:        aws.StringSlice(*launchSubnets),
+				SecurityGroups: aws.StringSlice(*launchGroups),
+				AssignPublicIp: aws.String(publicIP),
+			},
+		},
+		Overrides: &ecs.TaskOverride{
+			ContainerOverrides: []*ecs.ContainerOverride{{
+				Name:    container.Name,
+				Command: aws.StringSlice(command),
+			}},
+		},
+	})
+
+	if err != nil {
+		logAwsError("Failed to launch the task", err)
+		return false
+	}
+
+	if len(run.Failures) > 0 || len(run.Tasks) == 0 {
+		reason := "no task was started"
+		if len(run.Failures) > 0 {
+			reason = aws.StringValue(run.Failures[0].Reason)
+		}
+		log.Error(color.New(color.FgRed).Sprintf("Failed to launch the task: %s", reason))
+		return false
+	}
+
+	task := run.Tasks[0]
+	log.Info(color.New(color.FgCyan).Sprintf("Launched task %s", aws.StringValue(task.TaskArn)))
+
+	return followTask(sess, ecsClient, task, container)
+}
+
+// taskContainer returns the container of the task definition running the
+// move, named by --container or else the first one.
+func taskContainer(definition *ecs.TaskDefinition) (*ecs.ContainerDefinition, error) {
+	for _, container := range definition.ContainerDefinitions {
+		if *launchContainer == "" || aws.StringValue(container.Name) == *launchContainer {
+			return container, nil
+		}
+	}
+
+	if *launchContainer == "" {
+		return nil, fmt.Errorf("the task definition %s has no containers", aws.StringValue(definition.TaskDefinitionArn))
+	}
+
+	return nil, fmt.Errorf("the task definition %s has no container %s", aws.StringValue(definition.TaskDefinitionArn), *launchContainer)
+}
+
+// followTask prints the logs of the container of a launched task to stderr
+// until the task stopped, reading them from CloudWatch Logs when the
+// container logs with the awslogs driver. It reports whether the container
+// exited with 0.
+func followTask(sess *session.Session, ecsClient *ecs.ECS, task *ecs.Task, container *ecs.ContainerDefinition) bool {
+	var logs *taskLogs
+	if config := container.LogConfiguration; config != nil && aws.StringValue(config.LogDriver) == ecs.LogDriverAwslogs {
+		logs = newTaskLogs(sess, task, container)
+	} else {
+		log.Warn(color.New(color.FgYellow).Sprintf("The container %s doesn't log with the awslogs driver, only the status of the task is followed", aws.StringValue(container.Name)))
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	ticker := time.NewTicker(launchPollInterval)
+	defer ticker.Stop()
+
+	lastStatus := ""
+
+	for {
+		described, err := ecsClient.DescribeTasks(&ecs.DescribeTasksInput{Cluster: task.ClusterArn, Tasks: []*string{task.TaskArn}})
+
+		if err != nil {
+			log.Warn(color.New(color.FgYellow).Sprintf("Failed to read the status of the task, trying again. Error: %s", err))
+		} else if len(described.Tasks) > 0 {
+			task = described.Tasks[0]
+		}
+
+		if status := aws.StringValue(task.LastStatus); status != lastStatus {
+			log.Info(color.New(color.FgCyan).Sprintf("The task is %s", strings.ToLower(status)))
+			lastStatus = status
+		}
+
+		if logs != nil {
+			logs.print()
+		}
+
+		if lastStatus == ecs.DesiredStatusStopped {
+			return taskSucceeded(task, container)
+		}
+
+		select {
+		case <-interrupted:
+			summaryLog.Warn(color.New(color.FgYellow).Sprintf("Stopped following the task, it keeps moving. Stop it with: aws ecs stop-task --cluster %s --task %s", aws.StringValue(task.ClusterArn), aws.StringValue(task.TaskArn)))
+			return true
+		case <-ticker.C:
+		}
+	}
+}
+
+// taskSucceeded logs how a stopped task ended and reports whether its
+// container exited with 0.
+func taskSucceeded(task *ecs.Task, container *ecs.ContainerDefinition) bool {
+	for _, c := range task.Containers {
+		if aws.StringValue(c.Name) != aws.StringValue(container.Name) || c.ExitCode == nil {
+			continue
+		}
+
+		if code := aws.Int64Value(c.ExitCode); code != 0 {
+			summaryLog.Error(color.New(color.FgRed).Sprintf("The task failed, its container exited with %d", code))
+			return false
+		}
+
+		summaryLog.Info(color.New(color.FgCyan).Sprintf("The task succeeded"))
+		return true
+	}
+
+	summaryLog.Error(color.New(color.FgRed).Sprintf("The task stopped before the move ended: %s", aws.StringValue(task.StoppedReason)))
+	return false
+}
+
+// taskLogs reads the log stream of the container of a task, which the awslogs
+// driver names <prefix>/<container>/<task-id>.
+type taskLogs struct {
+	client    *cloudwatchlogs.CloudWatchLogs
+	group     string
+	stream    string
+	nextToken *string
+}
+
+func newTaskLogs(sess *session.Session, task *ecs.Task, container *ecs.ContainerDefinition) *taskLogs {
+	options := container.LogConfiguration.Options
+	taskArn := aws.StringValue(task.TaskArn)
+	taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+
+	config := aws.NewConfig()
+	if region := aws.StringValue(options["awslogs-region"]); region != "" {
+		config.Region = aws.String(region)
+	}
+
+	return &taskLogs{
+		client: cloudwatchlogs.New(sess, config),
+		group:  aws.StringValue(options["awslogs-group"]),
+		stream: aws.StringValue(options["awslogs-stream-prefix"]) + "/" + aws.StringValue(container.Name) + "/" + taskID,
+	}
+}
+
+// print prints the log events written since the last call to stderr. The
+// stream doesn't exist until the container started.
+func (l *taskLogs) print() {
+	for {
+		events, err := l.client.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(l.group),
+			LogStreamName: aws.String(l.stream),
+			StartFromHead: aws.Bool(true),
+			NextToken:     l.nextToken,
+		})
+
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return
+		}
+
+		if err != nil {
+			log.Warn(color.New(color.FgYellow).Sprintf("Failed to read the logs of the task, trying again. Error: %s", err))
+			return
+		}
+
+		for _, event := range events.Events {
+			fmt.Fprintln(os.Stderr, strings.TrimRight(aws.StringValue(event.Message), "\n"))
+		}
+
+		// The same token is returned once the end of the stream was reached.
+		done := l.nextToken != nil && aws.StringValue(events.NextForwardToken) == aws.StringValue(l.nextToken)
+		l.nextToken = events.NextForwardToken
+
+		if done || len(events.Events) == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// rotatedSuffix is the time layout appended to the names of rotated log
+// files, sorting them by age.
+const rotatedSuffix = "20060102-150405.000"
+
+// colorCodes matches the ANSI escape sequences coloring log messages.
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// rotatingFile appends to a log file, renaming it aside with the time as
+// suffix and starting a new one once it outgrows maxSize or was written for
+// longer than maxAge, keeping the newest keep of the rotated files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+// openRotatingFile opens the log file at path, appending to it when it
+// exists. maxSize and maxAge of 0 don't rotate by size or age, keep of 0
+// keeps all rotated files.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write writes p to the log file, rotating it first when p would make it
+// outgrow maxSize or it is older than maxAge.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log file aside, opens a new one and removes the
+// rotated files beyond keep.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.path, f.path+"."+time.Now().Format(rotatedSuffix)); err != nil {
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.keep == 0 {
+		return nil
+	}
+
+	rotated, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(rotated)
+	for len(rotated) > f.keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+// Close closes the log file.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// fileHandler writes log entries as plain lines of text, with the time,
+// level, message without colors and the fields sorted by name.
+type fileHandler struct {
+	file *rotatingFile
+}
+
+// HandleLog implements log.Handler.
+func (h *fileHandler) HandleLog(e *log.Entry) error {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %-5s %s", e.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(e.Level.String()), colorCodes.ReplaceAllString(e.Message, ""))
+
+	names := e.Fields.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		value := fmt.Sprint(e.Fields.Get(name))
+		if strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", name, value)
+	}
+	line.WriteString("\n")
+
+	_, err := h.file.Write([]byte(line.String()))
+	return err
+}
@@ -0,0 +1,1523 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/cli"
+	"github.com/apex/log/handlers/multi"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"github.com/tj/go-progress"
+	"github.com/tj/go/term"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// nolint: gochecknoglobals
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+	builtBy = ""
+)
+
+var (
+	moveCommand       = kingpin.Command("move", "Move messages from the source to the destination, the default command.").Default()
+	moveQueueName     = moveCommand.Flag("queue", "The queue moved to or from its dead-letter queue with --direction.").String()
+	direction         = moveCommand.Flag("direction", "Move --queue to its dead-letter queue, or its dead-letter queue back to it, resolved by its redrive policy.").Enum(directionToDLQ, directionFromDLQ)
+	planCommand       = kingpin.Command("plan", "Check and estimate the move of the flags and write it to a plan file for apply, without moving anything.")
+	planOut           = planCommand.Flag("out", "The plan file to write.").Default("sqsmover-plan.json").String()
+	applyCommand      = kingpin.Command("apply", "Move exactly as a plan file written by plan describes.")
+	applyPlanArg      = applyCommand.Arg("plan", "The plan file to apply.").Required().ExistingFile()
+	describeCommand   = kingpin.Command("describe", "Print all attributes of a queue.")
+	describeName      = describeCommand.Flag("queue", "The name of the queue to describe.").Required().String()
+	serveCommand      = kingpin.Command("serve", "Serve a gRPC API starting, following and canceling moves, see proto/sqsmover/v1/mover.proto.")
+	serveAddress      = serveCommand.Flag("address", "The address to serve the gRPC API on.").Default("localhost:50051").String()
+	taskCommand       = kingpin.Command("task", "Move the source and destination of a task input JSON, e.g. of a Step Functions state, and write a result JSON to stdout.")
+	taskInputArg      = taskCommand.Arg("input", "The task input JSON, read from stdin when not given.").String()
+	operateCommand    = kingpin.Command("operate", "Run the moves of QueueMove resources of Kubernetes, writing their progress to their status, see deploy/kubernetes.")
+	operateNamespace  = operateCommand.Flag("namespace", "Only run the QueueMoves of this namespace, of all namespaces by default.").String()
+	kubeAPI           = operateCommand.Flag("kube-api", "The URL of the Kubernetes API, e.g. http://localhost:8001 of kubectl proxy. The API of the cluster the operator runs in by default.").String()
+	launchCommand     = kingpin.Command("launch", "Run the move of the flags as a Fargate task of ECS, following its logs until it stopped.")
+	launchCluster     = launchCommand.Flag("cluster", "The ECS cluster to run the task in.").Required().String()
+	launchTaskDef     = launchCommand.Flag("task-def", "The task definition, family[:revision] or ARN, of the task. Its container runs sqsmover as entrypoint.").Required().String()
+	launchContainer   = launchCommand.Flag("container", "The container of the task definition running sqsmover, the first one by default.").String()
+	launchSubnets     = launchCommand.Flag("subnet", "A subnet of the task, can be repeated.").Required().Strings()
+	launchGroups      = launchCommand.Flag("security-group", "A security group of the task, can be repeated. The default security group of the VPC by default.").Strings()
+	launchPublicIP    = launchCommand.Flag("assign-public-ip", "Assign a public IP to the task, needed to pull the image and reach AWS from public subnets without a NAT gateway.").Bool()
+	backupCommand     = kingpin.Command("backup-and-purge", "Dump every message of a queue to S3, verified against its manifest, and only then purge the queue.")
+	backupQueueName   = backupCommand.Flag("queue", "The name of the queue to back up and purge.").Required().String()
+	backupTo          = backupCommand.Flag("to", "The s3://bucket/prefix URL the dump is uploaded to, compressed and encrypted with --compress and --encrypt.").Required().String()
+	backupHold        = backupCommand.Flag("hold", "How long the backed up messages are hidden until they are purged, at most 12h. The backup must be uploaded within it.").Default(rtksqs.DefaultBackupHold.String()).Duration()
+	migrateCommand    = kingpin.Command("migrate", "Mirror an old queue to a new one while its producers switch over, then with --cutover drain it and check it is empty.")
+	migrateFrom       = migrateCommand.Flag("from", "The old queue the producers switch away from.").Required().String()
+	migrateTo         = migrateCommand.Flag("to", "The new queue the producers switch to.").Required().String()
+	migrateCutover    = migrateCommand.Flag("cutover", "Stop mirroring once the old queue stayed empty for --quiet-period, drain it a last time and check it is empty. Mirrors until interrupted without it.").Bool()
+	migrateInterval   = migrateCommand.Flag("interval", "How long mirroring waits between moves once the old queue was drained.").Default("10s").Duration()
+	migrateQuiet      = migrateCommand.Flag("quiet-period", "How long the old queue must stay empty, nothing sent to it, in flight or delayed, before the cutover.").Default("5m").Duration()
+	touchCommand      = kingpin.Command("touch", "Receive the visible messages of a queue once and set their visibility, without sending or deleting any, e.g. to hold back its backlog from the consumers.")
+	touchQueueName    = touchCommand.Flag("queue", "The name of the queue to touch.").Required().String()
+	touchVisibility   = touchCommand.Flag("visibility", "The visibility timeout the touched messages get, at most 12h. 0 makes them visible right away.").Default("0s").Duration()
+	touchHold         = touchCommand.Flag("hold", "How long received messages are hidden until all were received, so none is received twice, at most 12h.").Default(rtksqs.DefaultTouchHold.String()).Duration()
+	sourceQueue       = kingpin.Flag("source", "The source queue name, URL or ARN, sqlite:// archive, file:// or csv:// dump, or - for stdin, to move messages from.").Short('s').String()
+	destinationQueue  = kingpin.Flag("destination", "The destination queue name, URL or ARN, sqlite:// archive, file:// or csv:// dump, pubsub:// topic, servicebus:// queue, kafka:// topic, nats:// subject, lambda:<function-name>, events:<bus-name>, or - for stdout, to move messages to.").Short('d').String()
+	pairsFile         = kingpin.Flag("pairs", "A file of source and destination pairs, separated by a comma or whitespace, one per line, to move concurrently instead of --source and --destination.").ExistingFile()
+	workers           = kingpin.Flag("workers", "The number of --pairs moved at a time.").Default("4").Int()
+	rampUp            = kingpin.Flag("ramp-up", "Start --pairs moves with one of the --workers and double the running workers at this interval, holding them once a pair failed. 0 starts every worker at once.").Default("5s").Duration()
+	rate              = kingpin.Flag("rate", "The maximum number of messages moved per second, shared by all --workers and moves of serve. Not limited by default.").Default("0").Float64()
+	workerRate        = kingpin.Flag("worker-rate", "The maximum number of messages each of the --workers and moves of serve moves per second. Not limited by default.").Default("0").Float64()
+	sendConcurrency   = kingpin.Flag("send-concurrency", "The maximum number of sends to the destinations outstanding at a time, shared by all --workers and moves of serve. A worker waits for a slot before receiving a batch. Only applies to --pairs and serve, not limited by default.").Default("0").Int()
+	assumeYes         = kingpin.Flag("yes", "Go ahead without asking for confirmation of destructive runs: moves deleting from a queue or sqlite:// archive, --delete-filtered and backup-and-purge.").Short('y').Bool()
+	approval          = kingpin.Flag("approval", "Post the plan of the run to this Slack channel with the bot token in $SLACK_BOT_TOKEN and wait for a :white_check_mark: reaction of someone else before changing anything, :x: declines. --yes doesn't skip it.").PlaceHolder("slack:CHANNEL").String()
+	approvalTimeout   = kingpin.Flag("approval-timeout", "How long to wait for --approval before giving up.").Default("1h").Duration()
+	region            = kingpin.Flag("region", "The AWS region for source and destination queues.").Short('r').Default("").String()
+	endpoint          = kingpin.Flag("endpoint", "Use a specific endpoint in an AWS region.").Short('e').Default("").String()
+	profile           = kingpin.Flag("profile", "Use a specific profile from AWS credentials file.").Short('p').String()
+	accessKeyID       = kingpin.Flag("access-key-id", "Use this AWS access key instead of the credential chain, e.g. for temporary credentials from another tool.").String()
+	secretAccessKey   = kingpin.Flag("secret-access-key", "The secret key of --access-key-id.").String()
+	sessionToken      = kingpin.Flag("session-token", "The session token of temporary --access-key-id credentials.").String()
+	refreshCreds      = kingpin.Flag("refresh-credentials", "Wait for fresh credentials from the credential chain, e.g. a renewed credentials file or a credential_process, when they expire instead of stopping the move.").Bool()
+	refreshTimeout    = kingpin.Flag("refresh-timeout", "How long --refresh-credentials waits for fresh credentials.").Default("15m").Duration()
+	roleArn           = kingpin.Flag("role-arn", "Assume this IAM role for the move, it is assumed again whenever its credentials expire.").String()
+	externalID        = kingpin.Flag("external-id", "The external ID --role-arn requires.").String()
+	roleDuration      = kingpin.Flag("role-duration", "How long the credentials of --role-arn are valid, at most 1h when the role is assumed with credentials of another role.").Default("1h").Duration()
+	limit             = kingpin.Flag("limit", "Limits total number of messages moved. No limit is set by default.").Short('l').Default("0").Int()
+	waitInFlight      = kingpin.Flag("wait-for-inflight", "Wait before moving until at most this many messages of the source queue are in flight, held by consumers, which can't be moved. Doesn't wait by default.").PlaceHolder("MAX").Default("-1").Int()
+	inFlightTimeout   = kingpin.Flag("inflight-timeout", "How long --wait-for-inflight waits before giving up.").Default("15m").Duration()
+	expectCount       = kingpin.Flag("expect-count", "The number of messages expected to be moved, a deviation beyond --expect-tolerance is logged as an error.").Default("-1").Int()
+	expectTolerance   = kingpin.Flag("expect-tolerance", "How far the moved messages may deviate from --expect-count, a number of messages or a percentage, e.g. 5 or 1%.").Default("0").String()
+	strict            = kingpin.Flag("strict", "Exit with 3 when the moved messages deviate from --expect-count beyond --expect-tolerance.").Bool()
+	maxBatchSize      = kingpin.Flag("batch-size", "The maximum number of messages received and sent at a time, 1 to 10. 1 moves every message on its own, e.g. to spare a fragile destination or to debug ordering.").Short('b').Default("10").Int64()
+	legacyBatchSize   = kingpin.Flag("batch", "Alias of --batch-size.").Hidden().Int64()
+	receiveWait       = kingpin.Flag("receive-wait", "The longest receives from the source queue long-poll, up to 20s. Shorter while receives come back full, the source is only taken for empty after waiting this long. 0 short-polls.").Default("10s").Duration()
+	finalSweep        = kingpin.Flag("final-sweep", "Take the source queue for empty after this many short polls, which don't wait, came back empty instead of after a receive waited --receive-wait. Finds the last messages of a distributed queue faster. 0 doesn't sweep.").Default("0").Int()
+	chunkSize         = kingpin.Flag("chunk", "Move this many messages at a time, confirming every next chunk with --confirm-each-chunk or --confirm-webhook.").Default("0").Int()
+	confirmEachChunk  = kingpin.Flag("confirm-each-chunk", "Ask on the terminal before moving every next --chunk.").Bool()
+	confirmWebhook    = kingpin.Flag("confirm-webhook", "POST the progress as JSON to this URL before moving every next --chunk, a 2xx response approves it.").String()
+	confirmTimeout    = kingpin.Flag("confirm-timeout", "How long to wait for --confirm-webhook to respond.").Default("1h").Duration()
+	bodyRegex         = kingpin.Flag("body-regex", "Only move messages whose body matches this regular expression.").Regexp()
+	attributes        = kingpin.Flag("attribute", "Only move messages with this message attribute, name or name=value, can be repeated.").PlaceHolder("NAME[=VALUE]").Strings()
+	olderThan         = kingpin.Flag("older-than", "Only move messages sent longer ago than this, e.g. 24h.").Default("0s").Duration()
+	minReceiveCount   = kingpin.Flag("min-receive-count", "Only move messages received at least this many times, counting the receive of the move.").Default("0").Int()
+	skipOlderThan     = kingpin.Flag("skip-older-than", "Skip messages sent longer ago than this, e.g. archived messages past the retention period the consumers expect.").Default("0s").Duration()
+	rewriteTimestamp  = kingpin.Flag("rewrite-timestamp", "Set this message attribute, a timestamp in Unix seconds, milliseconds or RFC 3339, to the time of the move, so replayed messages don't look stale.").String()
+	receiveAttributes = kingpin.Flag("receive-message-attribute", "A message attribute name, or prefix ending in .*, requested from the source queue, can be repeated. All are requested by default, others aren't moved.").PlaceHolder("NAME").Strings()
+	receiveSystem     = kingpin.Flag("receive-system-attribute", "A system attribute, e.g. SenderId or AWSTraceHeader, or All, requested from the source queue besides those the move needs, can be repeated.").PlaceHolder("NAME").Enums(append([]string{sqs.QueueAttributeNameAll}, sqs.MessageSystemAttributeName_Values()...)...)
+	preserveTimeline  = kingpin.Flag("preserve-timestamps", "Copy the SentTimestamp, ApproximateFirstReceiveTimestamp and, of FIFO messages, SequenceNumber into sqsmover.* message attributes, keeping the original timeline.").Bool()
+	uniqueDedup       = kingpin.Flag("unique-deduplication", "Give messages moved to a FIFO queue a new deduplication ID of the run and message ID, so none is dropped as a duplicate of a message sent within 5 minutes, e.g. by content-based deduplication of the same body.").Bool()
+	prewarmGroups     = kingpin.Flag("prewarm-groups", "Advanced: send a no-op marker with the sqsmover.prewarm message attribute to every message group of the destination FIFO queue before its first moved message, which consumers must filter, to avoid latency spikes of the first messages of new groups.").Bool()
+	sample            = kingpin.Flag("sample", "Only move this percentage of the messages the filters match, a pseudo-random sample chosen by message ID, e.g. for a canary.").PlaceHolder("PERCENT").Float64()
+	sampleSeed        = kingpin.Flag("sample-seed", "Seed the --sample, moves with the same seed select the same messages. The run ID by default.").String()
+	invert            = kingpin.Flag("invert", "Move the messages the filters don't match instead, e.g. everything but a known poison payload.").Bool()
+	deleteFiltered    = kingpin.Flag("delete-filtered", "Delete the messages the filters skip from the source instead of leaving them there.").Bool()
+	skipReportPath    = kingpin.Flag("skip-report", "Append the ID of every message the filters or hooks skip, deleted or quarantined included, with the reason to this NDJSON file.").PlaceHolder("FILE").String()
+	quarantineQueue   = kingpin.Flag("quarantine-queue", "Move the messages the filters, --skip-older-than or --max-replays skip to this queue instead of leaving them in the source.").String()
+	honorMaxReceive   = kingpin.Flag("honor-max-receive-count", "When moving a dead-letter queue back into its source queue, skip the messages received more often than the maxReceiveCount of the source queue, quarantining them with --quarantine-queue.").Bool()
+	backupQueue       = kingpin.Flag("backup-queue", "Send a copy of every moved message to this queue before the destination.").String()
+	queueTemplate     = kingpin.Flag("queue-template", "Create a missing --quarantine-queue or --backup-queue with the attributes and tags of this JSON file.").ExistingFile()
+	deleteEmptyQueues = kingpin.Flag("delete-empty-queues", "Delete the --quarantine-queue or --backup-queue when the run created it and sent nothing to it.").Bool()
+	csvColumns        = kingpin.Flag("csv-columns", "Comma separated columns written to a csv:// destination: id, body, md5, sent_timestamp, group_id, deduplication_id, attributes, attr:<name>, sys:<name>.").Default(rtksqs.DefaultCsvColumns).String()
+	compress          = kingpin.Flag("compress", "Compression for file:// and csv:// destinations.").Default(rtksqs.CompressNone).Enum(rtksqs.CompressNone, rtksqs.CompressGzip)
+	splitSize         = kingpin.Flag("split-size", "Start a new file:// or csv:// part once a part holds this much uncompressed data, e.g. 100MB. Not split by default.").Default("0").Bytes()
+	dumpShards        = kingpin.Flag("dump-shards", "Write file:// destinations in this many shard files at once, listed in order by the manifest, up to 99.").Default("1").Int()
+	memoryLimit       = kingpin.Flag("memory-limit", "Keep the heap below this size, e.g. 256MB, collecting garbage more often as it is approached, and abort the moves when what they hold outgrows it. Not limited by default.").Default("0").Bytes()
+	encrypt           = kingpin.Flag("encrypt", "Encrypt file:// and csv:// destinations with kms:<key-arn> or age:<recipient>.").String()
+	decryptIdentities = kingpin.Flag("decrypt-identity", "An age identity file used to decrypt file:// and csv:// sources, can be repeated. KMS encrypted dumps need no identity.").ExistingFiles()
+	decode            = kingpin.Flag("decode", "Add the body decoded with proto:<descriptor-set>:<message-name> or avro:<schema-file> to messages written to stdout or a file:// dump.").String()
+	redactPatterns    = kingpin.Flag("redact-pattern", "Mask the matches of this regular expression, e.g. emails or tokens, in the logs, the --skip-report and messages written to stdout, sqlite://, file:// and csv:// destinations, can be repeated.").PlaceHolder("REGEXP").RegexpList()
+	logLevel          = kingpin.Flag("log-level", "Only log messages of this level and above.").Default("info").Enum("debug", "info", "warn", "error")
+	output            = kingpin.Flag("output", "Print the results of describe, plan, backup-and-purge, migrate, touch and of every move as text, or as a line of JSON each on stdout, with the logs staying on stderr.").Default(outputText).Enum(outputText, outputJSON)
+	logFile           = kingpin.Flag("log-file", "Also append the logs to this file, without colors, e.g. to keep a history of the moves of serve on a VM.").String()
+	logFileMaxSize    = kingpin.Flag("log-file-max-size", "Rotate the --log-file once it would grow beyond this size, e.g. 100MB. 0 doesn't rotate by size.").Default("100MB").Bytes()
+	logFileMaxAge     = kingpin.Flag("log-file-max-age", "Rotate the --log-file once it was written for this long, e.g. 24h. 0 doesn't rotate by age.").Default("24h").Duration()
+	logFileKeep       = kingpin.Flag("log-file-keep", "Keep this many rotated --log-file files, removing older ones. 0 keeps all of them.").Default("7").Int()
+	noColor           = kingpin.Flag("no-color", "Don't color the logs, as when $NO_COLOR is set or stderr isn't a terminal.").Bool()
+	quiet             = kingpin.Flag("quiet", "Only log errors and the summary of the move, without a progress bar.").Short('q').Bool()
+	probe             = kingpin.Flag("probe", "Before moving, send a canary message to the source and destination queues, receive it back and delete it, logging the latencies and failing fast on missing permissions or unreachable endpoints.").Bool()
+	probeTimeout      = kingpin.Flag("probe-timeout", "How long --probe waits to receive a canary back.").Default("20s").Duration()
+	countInterval     = kingpin.Flag("count-interval", "Refresh the approximate count of the source at this interval while moving, so messages other producers send are moved too and the move ends once other consumers drained the source, and warn when another consumer receives from it. 0 moves the messages counted at the start.").Default("30s").Duration()
+	logInterval       = kingpin.Flag("log-interval", "Log throughput, remaining messages and ETA at this interval, e.g. 30s, instead of drawing a progress bar.").Default("0s").Duration()
+	trackReplays      = kingpin.Flag("track-replays", "Count how often each message was moved in the "+rtksqs.ReplayCountAttribute+" message attribute.").Bool()
+	maxReplays        = kingpin.Flag("max-replays", "Leave messages which were already moved this many times with --track-replays in the source. No limit is set by default.").Default("0").Int()
+	lambdaFormat      = kingpin.Flag("lambda-format", "Unwrap the original event from Lambda on-failure destination records, or wrap messages into such records.").Default(rtksqs.LambdaFormatNone).Enum(rtksqs.LambdaFormatNone, rtksqs.LambdaFormatUnwrap, rtksqs.LambdaFormatWrap)
+	lambdaFunctionArn = kingpin.Flag("lambda-function-arn", "The function ARN recorded in records written with --lambda-format wrap.").String()
+	snsFormat         = kingpin.Flag("sns-format", "Unwrap the message and its attributes from the SNS envelopes of a queue subscribed without raw message delivery, or wrap messages into such envelopes for one.").Default(rtksqs.SnsFormatNone).Enum(rtksqs.SnsFormatNone, rtksqs.SnsFormatUnwrap, rtksqs.SnsFormatWrap)
+	snsTopicArn       = kingpin.Flag("sns-topic-arn", "The topic ARN recorded in envelopes written with --sns-format wrap.").String()
+	s3EventPrefixes   = kingpin.Flag("s3-event-prefix", "Only move the S3 event notifications of objects below this s3://bucket/prefix, can be repeated. Other messages are skipped.").PlaceHolder("S3-URL").Strings()
+	s3EventExists     = kingpin.Flag("s3-event-exists", "Only move the S3 event notifications of created objects which still exist, checked with HeadObject. Other messages are skipped.").Bool()
+	eventsFormat      = kingpin.Flag("eventbridge-format", "Remove the error metadata EventBridge adds to the events in the dead-letter queue of a rule target, or add it to events for one.").Default(rtksqs.EventBridgeFormatNone).Enum(rtksqs.EventBridgeFormatNone, rtksqs.EventBridgeFormatUnwrap, rtksqs.EventBridgeFormatWrap)
+	eventsRuleArn     = kingpin.Flag("eventbridge-rule-arn", "The rule ARN recorded in events written with --eventbridge-format wrap.").String()
+	eventsTargetArn   = kingpin.Flag("eventbridge-target-arn", "The target ARN recorded in events written with --eventbridge-format wrap.").String()
+	eventsErrorCodes  = kingpin.Flag("eventbridge-error-code", "Only move the events EventBridge sent to a dead-letter queue with this ERROR_CODE, e.g. THROTTLING, can be repeated.").PlaceHolder("CODE").Strings()
+	transformPlugin   = kingpin.Flag("transform-plugin", "Rewrite messages with the Transformer a Go plugin exports, see the README.").PlaceHolder("FILE").String()
+	transformExec     = kingpin.Flag("transform-exec", "Rewrite messages with this command, run for every message with the message as JSON on stdin and writing the message to move, or nothing to skip it, to stdout.").PlaceHolder("COMMAND").String()
+	decideExec        = kingpin.Flag("decide-exec", "Decide what happens to every message with this command, run with the message as JSON on stdin and writing move, skip, delete or route:<queue> to stdout.").PlaceHolder("COMMAND").String()
+	lambdaRate        = kingpin.Flag("lambda-rate", "The maximum number of lambda:<function-name> invocations per second. Not limited by default.").Default("0").Float64()
+	verify            = kingpin.Flag("verify", "Read back the destination queue, sqlite:// archive, file:// or csv:// dump when done and check every moved message arrived unchanged.").Bool()
+	force             = kingpin.Flag("force", "Move even when the queues' redrive policies conflict with the move.").Bool()
+	watchAlarm        = kingpin.Flag("watch-alarm", "Keep running and move messages every time this CloudWatch alarm, e.g. on the source queue depth, goes into ALARM.").String()
+	watchInterval     = kingpin.Flag("watch-interval", "How often the --watch-alarm state is polled.").Default("1m").Duration()
+	backlogThreshold  = kingpin.Flag("dest-backlog-threshold", "Pause while the destination queue holds more than this many messages. Not throttled by default.").Default("0").Int()
+	backlogInterval   = kingpin.Flag("dest-backlog-interval", "How often the destination backlog is checked.").Default("10s").Duration()
+	waitForHTTP       = kingpin.Flag("wait-for-http", "Only move while this health check of the destination's consumer, e.g. https://consumer/healthz, responds with a 2xx status, pausing while it doesn't.").PlaceHolder("URL").String()
+	healthInterval    = kingpin.Flag("wait-for-http-interval", "How often --wait-for-http is polled, and how long it may take to respond.").Default("10s").Duration()
+	tuneDestination   = kingpin.Flag("tune-destination", "Override an attribute of the destination queue while moving, e.g. visibility=300, restored when done. Can be repeated.").PlaceHolder("ATTRIBUTE=VALUE").StringMap()
+	delaySeconds      = kingpin.Flag("delay-seconds", "Delay the delivery of every message sent to the destination queue by this many seconds, up to 900. Requeues the messages, deferring them, when the source is the destination.").Default("0").Int64()
+	offloadLarge      = kingpin.Flag("offload-large-to", "Upload the bodies of messages over 256 KB to this s3://bucket/prefix and send a pointer to them in the format of the SQS Extended Client Library instead of failing them.").PlaceHolder("S3-URL").String()
+	sendErrorLimit    = kingpin.Flag("send-error-threshold", "Keep moving past failed sends, leaving their messages in the source, and pause once more sends than this failed within --send-error-window. Stops at the first failed send by default.").Default("0").Int()
+	sendErrorWindow   = kingpin.Flag("send-error-window", "The sliding window failed sends are counted in for --send-error-threshold.").Default("1m").Duration()
+	breakerBackoff    = kingpin.Flag("breaker-backoff", "How long to pause once --send-error-threshold is exceeded, doubled every time sending still fails afterwards.").Default("10s").Duration()
+	breakerTrips      = kingpin.Flag("breaker-trips", "Stop once sending failed after this many pauses in a row.").Default("3").Int()
+	journalPath       = kingpin.Flag("journal", "Journal the sent messages in this file or in the DynamoDB table of a dynamodb://<table> URL before deleting them from the source, so messages a crash left in the source after sending them are deleted instead of sent again by the next run.").PlaceHolder("FILE").String()
+	retryStatePath    = kingpin.Flag("retry-state", "Keep the failed sends to every destination in this file, so a restarted --watch-alarm, serve or operate, e.g. a crash-looping container, keeps backing off instead of sending to a failing destination right away.").PlaceHolder("FILE").String()
+	throttleBackoff   = kingpin.Flag("throttle-backoff", "The longest pause all workers keep between sends while the destination throttles them, e.g. over the quotas of a FIFO queue or of KMS. Throttled messages are sent again. 0 fails throttled sends instead.").Default("30s").Duration()
+	showStats         = kingpin.Flag("stats", "Log a histogram of message sizes and attribute count percentiles when done.").Bool()
+	statsBy           = kingpin.Flag("stats-by", "Break down the moved, failed and skipped messages by the value of attribute:<name>, a message or system attribute, or json:<field>, a field of the JSON body, e.g. attribute:tenant_id, in the summary and the JSON results.").PlaceHolder("DIMENSION").String()
+	workerStats       = kingpin.Flag("worker-stats", "Log the batches every worker moved and the calls, errors and average latency of its receive, send and delete steps when done.").Bool()
+	controlFile       = kingpin.Flag("control-file", "Pause, resume or abort the moves when pause, resume or abort is written to this file.").String()
+	pprofAddress      = kingpin.Flag("pprof", "Serve the pprof profiling endpoints on this address, e.g. localhost:6060.").String()
+	cpuProfile        = kingpin.Flag("cpu-profile", "Write a CPU profile of the run to this file.").String()
+	memProfile        = kingpin.Flag("mem-profile", "Write a heap profile to this file when the run ends.").String()
+	idMap             = kingpin.Flag("id-map", "Write a CSV mapping of source to destination message IDs when moving to a queue.").String()
+	recordSQS         = kingpin.Flag("record-sqs", "Record the SQS calls of the run with their responses to this file, to reproduce a failure without the queues. The file holds the message bodies.").PlaceHolder("FILE").String()
+	replaySQS         = kingpin.Flag("replay-sqs", "Answer SQS calls with those recorded by --record-sqs instead of calling SQS.").Hidden().PlaceHolder("FILE").String()
+	chaos             = kingpin.Flag("chaos", "Inject faults into SQS calls with this probability, for testing recovery.").Hidden().Float64()
+)
+
+// credentialRefreshInterval is how often --refresh-credentials refreshes
+// expired credentials.
+const credentialRefreshInterval = 30 * time.Second
+
+// summaryLog logs the outcome of a move, which --quiet still shows.
+var summaryLog log.Interface = log.Log
+
+// rateLimiter paces all moves to --rate.
+var rateLimiter *rtksqs.RateLimiter
+
+// sendBackoff paces the sends of all moves while the destination throttles
+// them, nil with a --throttle-backoff of 0.
+var sendBackoff *rtksqs.SendBackoff
+
+// sendSlots limits the outstanding sends of all moves to --send-concurrency,
+// nil when it is 0.
+var sendSlots *rtksqs.SendSlots
+
+// retryState keeps the failed sends of all moves across restarts, nil
+// without --retry-state.
+var retryState *rtksqs.RetryState
+
+// sendJournal records the sent messages of all moves until they are deleted,
+// nil without --journal.
+var sendJournal rtksqs.SendJournal
+
+// s3Events filters S3 event notifications, nil without --s3-event-prefix
+// and --s3-event-exists.
+var s3Events rtksqs.MessageHook
+
+// transformer rewrites the messages of all moves, nil without
+// --transform-plugin and --transform-exec.
+var transformer rtksqs.Transformer
+
+// decide decides what happens to the messages of all moves and routes opens
+// the queues it routes them to, nil without --decide-exec.
+var decide func(message *sqs.Message) (rtksqs.Decision, error)
+var routes *routeSinks
+
+// moveControl pauses, resumes and aborts all moves.
+var moveControl *rtksqs.MoveControl
+
+// skippedMessages records the messages all moves didn't move with
+// --skip-report.
+var skippedMessages *skipReport
+
+// Exit codes of runs which didn't succeed.
+const (
+	exitFailed        = 1
+	exitCountMismatch = 3
+)
+
+// exitCode is the exit code of the run, set when a task failed or --strict
+// found a count mismatch.
+var exitCode int
+
+func main() {
+	// The exit code is set once the other deferred calls ran.
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	log.SetHandler(cli.Default)
+
+	// Blank lines go to stderr along with the logs, stdout may be a message sink.
+	fmt.Fprintln(os.Stderr)
+	defer fmt.Fprintln(os.Stderr)
+
+	kingpin.Version(buildVersion(version, commit, date, builtBy))
+	kingpin.UsageTemplate(kingpin.CompactUsageTemplate)
+	kingpin.CommandLine.VersionFlag.Short('v')
+	kingpin.CommandLine.HelpFlag.Short('h')
+
+	command := kingpin.Parse()
+	setupColor()
+
+	if command == applyCommand.FullCommand() {
+		command = loadPlan()
+	}
+
+	if (*accessKeyID == "") != (*secretAccessKey == "") || (*sessionToken != "" && *accessKeyID == "") {
+		kingpin.Fatalf("--access-key-id and --secret-access-key must be given together, --session-token needs both")
+	}
+
+	if *accessKeyID != "" && *profile != "" {
+		kingpin.Fatalf("--access-key-id can't be combined with --profile")
+	}
+
+	if *approval != "" {
+		if _, err := parseApproval(*approval); err != nil {
+			kingpin.Fatalf("%s", err)
+		}
+	}
+
+	if *recordSQS != "" && *replaySQS != "" {
+		kingpin.Fatalf("--record-sqs can't be combined with --replay-sqs")
+	}
+
+	if *rate < 0 || *workerRate < 0 {
+		kingpin.Fatalf("--rate and --worker-rate must not be negative")
+	}
+
+	if *rate > 0 {
+		rateLimiter = rtksqs.NewRateLimiter(*rate)
+	}
+
+	if *sendConcurrency < 0 {
+		kingpin.Fatalf("--send-concurrency must not be negative")
+	}
+
+	// A single move sends one batch at a time, there is nothing to limit.
+	if *sendConcurrency > 0 && *pairsFile == "" && command != serveCommand.FullCommand() {
+		kingpin.Fatalf("--send-concurrency only applies to --pairs and serve, a single move has one send outstanding at a time")
+	}
+
+	if *sendConcurrency > 0 {
+		sendSlots = rtksqs.NewSendSlots(*sendConcurrency)
+	}
+
+	if *countInterval < 0 {
+		kingpin.Fatalf("--count-interval must not be negative")
+	}
+
+	if *waitForHTTP != "" && !strings.HasPrefix(*waitForHTTP, "http://") && !strings.HasPrefix(*waitForHTTP, "https://") {
+		kingpin.Fatalf("--wait-for-http must be an http:// or https:// URL")
+	}
+
+	if *healthInterval <= 0 {
+		kingpin.Fatalf("--wait-for-http-interval must be positive")
+	}
+
+	if *statsBy != "" {
+		if _, err := rtksqs.NewDimensionStats(*statsBy); err != nil {
+			kingpin.Fatalf("invalid --stats-by: %s", err)
+		}
+	}
+
+	if *throttleBackoff < 0 {
+		kingpin.Fatalf("--throttle-backoff must not be negative")
+	}
+
+	if *throttleBackoff > 0 {
+		sendBackoff = rtksqs.NewSendBackoff(*throttleBackoff)
+	}
+
+	if *retryStatePath != "" {
+		state, err := rtksqs.OpenRetryState(*retryStatePath)
+		if err != nil {
+			kingpin.Fatalf("failed to read --retry-state: %s", err)
+		}
+		retryState = state
+	}
+
+	switch command {
+	case moveCommand.FullCommand():
+		checkMoveFlags()
+	case planCommand.FullCommand():
+		checkMoveFlags()
+		checkPlanFlags()
+	case launchCommand.FullCommand():
+		checkMoveFlags()
+		checkLaunchFlags()
+	case serveCommand.FullCommand(), taskCommand.FullCommand(), operateCommand.FullCommand():
+		checkInputFlags(command)
+	case migrateCommand.FullCommand():
+		checkMigrateFlags()
+	case touchCommand.FullCommand():
+		if *touchVisibility < 0 || *touchHold <= 0 {
+			kingpin.Fatalf("--visibility must not be negative and --hold must be positive")
+		}
+	}
+
+	checkOutputFlags(command)
+
+	var logHandler log.Handler = cli.Default
+
+	if *logFile != "" {
+		if *logFileMaxSize < 0 || *logFileMaxAge < 0 || *logFileKeep < 0 {
+			kingpin.Fatalf("--log-file-max-size, --log-file-max-age and --log-file-keep must not be negative")
+		}
+
+		file, err := openRotatingFile(*logFile, int64(*logFileMaxSize), *logFileMaxAge, *logFileKeep)
+		if err != nil {
+			kingpin.Fatalf("failed to open --log-file: %s", err)
+		}
+		defer file.Close()
+
+		logHandler = multi.New(cli.Default, &fileHandler{file: file})
+	}
+
+	if len(*redactPatterns) > 0 {
+		logHandler = &redactingHandler{handler: logHandler}
+	}
+
+	log.SetHandler(logHandler)
+
+	log.SetLevel(log.MustParseLevel(*logLevel))
+
+	if *quiet {
+		log.SetLevel(log.ErrorLevel)
+		summaryLog = &log.Logger{Handler: logHandler, Level: log.InfoLevel}
+	}
+
+	// Every log line carries the run ID, so concurrent runs can be told
+	// apart in aggregated logs.
+	runID := rtksqs.NewRunID()
+	log.Log = log.WithField("run_id", runID)
+	summaryLog = summaryLog.WithField("run_id", runID)
+
+	if *sample > 0 && *sampleSeed == "" {
+		*sampleSeed = runID
+		log.Info(color.New(color.FgCyan).Sprintf("Sampling with seed %s, pass --sample-seed %s to select the same messages again", runID, runID))
+	}
+
+	if *skipReportPath != "" {
+		report, err := openSkipReport(*skipReportPath)
+
+		if err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to open the skip report. Error: %s", err))
+			exitCode = exitFailed
+			return
+		}
+		defer report.Close()
+		skippedMessages = report
+	}
+
+	stop, err := startProfiling()
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to start profiling. Error: %s", err))
+		exitCode = exitFailed
+		return
+	}
+	defer stop()
+
+	options := session.Options{
+		Profile:                 *profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	}
+
+	if region != nil {
+		options.Config.Region = aws.String(*region)
+	}
+
+	// Our default "" value uses the AWS auto generated value
+	options.Config.Endpoint = aws.String(*endpoint)
+
+	if *accessKeyID != "" {
+		options.Config.Credentials = credentials.NewStaticCredentials(*accessKeyID, *secretAccessKey, *sessionToken)
+	}
+
+	if *refreshCreds {
+		rtksqs.WithCredentialRefresh(&options.Config, credentialRefreshInterval, *refreshTimeout)
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Unable to create AWS session for region %s. Error: %s", *region, err))
+		exitCode = exitFailed
+		return
+	}
+
+	if *roleArn != "" {
+		sess = sess.Copy(&aws.Config{Credentials: assumeRoleCredentials(sess, runID)})
+	}
+
+	rtksqs.Version = version
+
+	if *receiveWait < 0 || *receiveWait > rtksqs.MaxReceiveWait {
+		kingpin.Fatalf("--receive-wait must be between 0s and %s", rtksqs.MaxReceiveWait)
+	}
+
+	if *finalSweep < 0 {
+		kingpin.Fatalf("--final-sweep must not be negative")
+	}
+
+	if *legacyBatchSize != 0 {
+		*maxBatchSize = *legacyBatchSize
+	}
+
+	if *maxBatchSize < 1 || *maxBatchSize > rtksqs.DefaultBatchSize {
+		kingpin.Fatalf("--batch-size must be between 1 and %d", rtksqs.DefaultBatchSize)
+	}
+
+	if *dumpShards < 1 || *dumpShards > rtksqs.MaxDumpShards {
+		kingpin.Fatalf("--dump-shards must be between 1 and %d", rtksqs.MaxDumpShards)
+	}
+
+	if *delaySeconds < 0 || *delaySeconds > rtksqs.MaxDelaySeconds {
+		kingpin.Fatalf("--delay-seconds must be between 0 and %d", rtksqs.MaxDelaySeconds)
+	}
+
+	sqsClient, closeRecording, err := recordingClient(sess)
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to open the SQS recording. Error: %s", err))
+		exitCode = exitFailed
+		return
+	}
+	defer closeRecording()
+
+	if *journalPath != "" {
+		if sendJournal, err = rtksqs.OpenSendJournal(sess, *journalPath); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to open the journal %s. Error: %s", *journalPath, err))
+			exitCode = exitFailed
+			return
+		}
+		defer func() {
+			if err := sendJournal.Close(); err != nil {
+				log.Error(color.New(color.FgRed).Sprintf("Failed to close the journal %s. Error: %s", *journalPath, err))
+			}
+		}()
+	}
+
+	if len(*s3EventPrefixes) > 0 || *s3EventExists {
+		var objects s3iface.S3API
+		if *s3EventExists {
+			objects = s3.New(sess)
+		}
+
+		if s3Events, err = rtksqs.S3EventHook(*s3EventPrefixes, objects); err != nil {
+			kingpin.Fatalf("--s3-event-prefix: %s", err)
+		}
+	}
+
+	if *transformPlugin != "" || *transformExec != "" {
+		if transformer, err = loadTransformer(); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to load the transform. Error: %s", err))
+			exitCode = exitFailed
+			return
+		}
+	}
+
+	openOptions := rtksqs.Options{
+		SQS:               sqsClient,
+		CsvColumns:        *csvColumns,
+		Compress:          *compress,
+		SplitSize:         int64(*splitSize),
+		Encrypt:           *encrypt,
+		DecryptIdentities: *decryptIdentities,
+		IDMap:             *idMap,
+		Chaos:             *chaos,
+		RunID:             runID,
+		Decode:            *decode,
+		LambdaRate:        *lambdaRate,
+		DelaySeconds:      *delaySeconds,
+		ReceiveWait:       *receiveWait,
+		FinalSweep:        *finalSweep,
+		DumpShards:        *dumpShards,
+		Redact:            *redactPatterns,
+		OffloadLarge:      *offloadLarge,
+		PrewarmGroups:     *prewarmGroups,
+
+		ReceiveMessageAttributes: receivedMessageAttributes(),
+		ReceiveSystemAttributes:  receivedSystemAttributes(),
+	}
+
+	if *decideExec != "" {
+		if decide, err = rtksqs.DecideExec(*decideExec, 0); err != nil {
+			kingpin.Fatalf("--decide-exec: %s", err)
+		}
+
+		routes = newRouteSinks(sess, openOptions)
+		defer routes.close()
+	}
+
+	if command == describeCommand.FullCommand() {
+		describeQueue(sess, openOptions)
+		return
+	}
+
+	if command == backupCommand.FullCommand() {
+		if !backupAndPurge(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if command == touchCommand.FullCommand() {
+		if !touchQueue(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if command == planCommand.FullCommand() {
+		if !writePlan(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	moveControl = startMoveControl()
+
+	if *memoryLimit > 0 {
+		limitMemory(int64(*memoryLimit), moveControl)
+	}
+
+	if command == serveCommand.FullCommand() {
+		serveMoves(sess, openOptions)
+		return
+	}
+
+	if command == taskCommand.FullCommand() {
+		if !runTask(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if command == launchCommand.FullCommand() {
+		if !launchMove(sess) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if command == operateCommand.FullCommand() {
+		if !operateMoves(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if command == migrateCommand.FullCommand() {
+		if !migrateQueue(sess, openOptions) {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	if *direction != "" && !resolveDirection(sess, openOptions) {
+		exitCode = exitFailed
+		return
+	}
+
+	if *watchAlarm != "" {
+		watchAlarmAndMove(sess, openOptions)
+		return
+	}
+
+	if *pairsFile != "" {
+		pairs, err := readPairs(*pairsFile)
+
+		if err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to read pairs. Error: %s", err))
+			exitCode = exitFailed
+			return
+		}
+
+		if !confirmPairs(pairs) {
+			return
+		}
+
+		if err := movePairs(sess, openOptions, pairs); err != nil {
+			exitCode = exitFailed
+		}
+		return
+	}
+
+	runMove(sess, openOptions)
+}
+
+// assumeRoleCredentials returns the credentials of --role-arn, assumed with
+// the credentials of the session. They are assumed again a minute before they
+// expire, or when a call fails because they expired, so moves can outlast
+// them.
+func assumeRoleCredentials(sess *session.Session, runID string) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, *roleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "sqsmover-" + runID
+		p.Duration = *roleDuration
+		p.ExpiryWindow = time.Minute
+
+		if *externalID != "" {
+			p.ExternalID = externalID
+		}
+	})
+}
+
+// checkMoveFlags exits when the flags of the move command are incomplete or
+// conflict.
+func checkMoveFlags() {
+	checkFilterFlags()
+
+	if *chunkSize < 0 || (*chunkSize > 0) != (*confirmEachChunk || *confirmWebhook != "") {
+		kingpin.Fatalf("--chunk needs --confirm-each-chunk or --confirm-webhook, which need --chunk")
+	}
+
+	if _, err := allowedDeviation(*expectTolerance, *expectCount); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	if *strict && *expectCount < 0 {
+		kingpin.Fatalf("--strict needs --expect-count")
+	}
+
+	if *expectCount >= 0 && *watchAlarm != "" {
+		kingpin.Fatalf("--expect-count can't be combined with --watch-alarm, which moves many times")
+	}
+
+	if *confirmEachChunk && (*sourceQueue == rtksqs.StdioSpec || *watchAlarm != "") {
+		kingpin.Fatalf("--confirm-each-chunk reads answers from the terminal, it can't be combined with --source - or --watch-alarm")
+	}
+
+	if (*queueTemplate != "" || *deleteEmptyQueues) && *quarantineQueue == "" && *backupQueue == "" {
+		kingpin.Fatalf("--queue-template and --delete-empty-queues need --quarantine-queue or --backup-queue")
+	}
+
+	if *delaySeconds > 0 && tunesDelay() {
+		kingpin.Fatalf("--delay-seconds can't be combined with a --tune-destination of the delay")
+	}
+
+	if *quarantineQueue != "" && *quarantineQueue == *sourceQueue {
+		kingpin.Fatalf("--quarantine-queue must not be the source, quarantined messages would be received again")
+	}
+
+	if (*direction != "") != (*moveQueueName != "") {
+		kingpin.Fatalf("--direction and --queue must be given together")
+	}
+
+	if *direction != "" {
+		if *sourceQueue != "" || *destinationQueue != "" || *pairsFile != "" {
+			kingpin.Fatalf("--direction resolves the source and destination from --queue, it can't be combined with --source, --destination or --pairs")
+		}
+		return
+	}
+
+	if *pairsFile != "" {
+		if *sourceQueue != "" || *destinationQueue != "" {
+			kingpin.Fatalf("--pairs can't be combined with --source and --destination")
+		}
+		if *verify || *watchAlarm != "" || *idMap != "" || len(*tuneDestination) > 0 || *chunkSize > 0 || *quarantineQueue != "" || *backupQueue != "" || *waitInFlight >= 0 || *probe {
+			kingpin.Fatalf("--pairs can't be combined with --verify, --watch-alarm, --id-map, --tune-destination, --chunk, --quarantine-queue, --backup-queue, --wait-for-inflight or --probe")
+		}
+		if *workers < 1 {
+			kingpin.Fatalf("--workers must be at least 1")
+		}
+		if *rampUp < 0 {
+			kingpin.Fatalf("--ramp-up must not be negative")
+		}
+	} else if *sourceQueue == "" {
+		kingpin.Fatalf("required flag --source not provided")
+	} else if *destinationQueue == "" {
+		kingpin.Fatalf("required flag --destination not provided")
+	}
+}
+
+// checkInputFlags exits when flags conflict with serve or task, which take
+// the source and destination of moves from their input.
+func checkInputFlags(command string) {
+	checkFilterFlags()
+
+	if *sourceQueue != "" || *destinationQueue != "" || *pairsFile != "" {
+		kingpin.Fatalf("%s takes the source and destination from its input, not from --source, --destination or --pairs", command)
+	}
+
+	if *verify || *watchAlarm != "" || *idMap != "" || len(*tuneDestination) > 0 || *chunkSize > 0 || *expectCount >= 0 || *waitInFlight >= 0 || *probe {
+		kingpin.Fatalf("%s can't be combined with --verify, --watch-alarm, --id-map, --tune-destination, --chunk, --expect-count, --wait-for-inflight or --probe", command)
+	}
+
+	if *quarantineQueue != "" || *backupQueue != "" || *queueTemplate != "" || *deleteEmptyQueues {
+		kingpin.Fatalf("%s can't be combined with --quarantine-queue, --backup-queue, --queue-template or --delete-empty-queues", command)
+	}
+
+	if *approval != "" {
+		kingpin.Fatalf("%s can't be combined with --approval, its moves are requested through its input", command)
+	}
+
+	if *honorMaxReceive && command == serveCommand.FullCommand() {
+		kingpin.Fatalf("%s can't be combined with --honor-max-receive-count", command)
+	}
+}
+
+// checkFilterFlags exits when --invert or --delete-filtered are set without
+// filters, or --sample is out of range.
+func checkFilterFlags() {
+	if (*invert || *deleteFiltered) && len(messageFilters()) == 0 {
+		kingpin.Fatalf("--invert and --delete-filtered need --body-regex, --attribute, --eventbridge-error-code, --older-than or --min-receive-count")
+	}
+
+	if *sample < 0 || *sample > 100 {
+		kingpin.Fatalf("--sample must be a percentage between 0 and 100")
+	}
+
+	if *sample > 0 && *quarantineQueue != "" {
+		kingpin.Fatalf("--sample can't be combined with --quarantine-queue, the messages outside the sample would be quarantined")
+	}
+}
+
+// runMove moves messages from the source to the destination once.
+func runMove(sess *session.Session, openOptions rtksqs.Options) {
+	moved, ok := 0, false
+	if *expectCount >= 0 {
+		defer func() { checkExpectedCount(moved) }()
+	}
+
+	// Every return before ok is set is a failure, scripts tell by the exit
+	// code.
+	defer func() {
+		if !ok {
+			exitCode = exitFailed
+		}
+	}()
+
+	result := &moveResult{Status: resultFailed, RunID: openOptions.RunID, Source: *sourceQueue, Destination: *destinationQueue, Total: rtksqs.UnknownCount}
+	if jsonOutput() {
+		defer func() {
+			if ok {
+				result.Status = resultSucceeded
+			}
+			writeJSON("move result", result)
+		}()
+	}
+
+	source, err := rtksqs.OpenSource(sess, *sourceQueue, openOptions)
+
+	if err != nil {
+		logAwsError("Failed to resolve source queue", err)
+		result.Error = err.Error()
+		return
+	}
+	defer source.Close()
+
+	log.Info(color.New(color.FgCyan).Sprintf("Source queue URL: %s", source))
+
+	destination, err := rtksqs.OpenSink(sess, *destinationQueue, delayFifoDestination(openOptions))
+
+	if err != nil {
+		logAwsError("Failed to resolve destination queue", err)
+		result.Error = err.Error()
+		return
+	}
+
+	// The destination is closed before it is read back by --verify.
+	destinationClosed := false
+	defer func() {
+		if !destinationClosed {
+			destination.Close()
+		}
+	}()
+
+	log.Info(color.New(color.FgCyan).Sprintf("Destination queue URL: %s", destination))
+
+	if !checkAppliedPlan(source, destination) {
+		return
+	}
+
+	if requeueing() {
+		log.Info(color.New(color.FgCyan).Sprintf("Requeueing the messages of the source with a delay of %d seconds", *delaySeconds))
+	}
+
+	var queues moveQueues
+	if *quarantineQueue != "" {
+		var opened bool
+		if queues.quarantine, opened = openAuxiliaryQueue(sess, openOptions, *quarantineQueue, "quarantine"); !opened {
+			return
+		}
+		defer queues.quarantine.close(sess, openOptions)
+	}
+
+	if *backupQueue != "" {
+		var opened bool
+		if queues.backup, opened = openAuxiliaryQueue(sess, openOptions, *backupQueue, "backup"); !opened {
+			return
+		}
+		defer queues.backup.close(sess, openOptions)
+	}
+
+	issues, err := rtksqs.ValidateRedrive(source, destination)
+
+	if err != nil {
+		logAwsError("Failed to check redrive policies", err)
+		return
+	}
+
+	if !checkRedriveIssues(issues) {
+		return
+	}
+
+	encryptionIssues, err := rtksqs.ValidateEncryption(sess, source, destination)
+
+	if err != nil {
+		logAwsError("Failed to check queue encryption", err)
+		return
+	}
+
+	if !checkEncryptionIssues(encryptionIssues) {
+		return
+	}
+
+	var audit *rtksqs.MoveAudit
+	if *verify {
+		if err := rtksqs.Verifiable(sess, *destinationQueue, openOptions); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Not moving, %s", err))
+			return
+		}
+		audit = &rtksqs.MoveAudit{}
+	}
+
+	// Messages becoming visible while waiting are counted.
+	if !checkInFlight(source) {
+		return
+	}
+
+	numberOfMessages, err := source.ApproximateCount()
+
+	if err != nil {
+		logAwsError("Failed to resolve queue attributes", err)
+		return
+	}
+
+	if numberOfMessages == rtksqs.UnknownCount {
+		log.Info(color.New(color.FgCyan).Sprintf("Number of messages in the source is unknown, moving until it is exhausted"))
+	} else {
+		log.Info(color.New(color.FgCyan).Sprintf("Approximate number of messages in the source queue: %d", numberOfMessages))
+	}
+
+	if numberOfMessages == 0 {
+		summaryLog.Info("Looks like nothing to move. Done.")
+		result.Total, ok = 0, true
+		return
+	}
+
+	if *limit > 0 && (numberOfMessages == rtksqs.UnknownCount || numberOfMessages > *limit) {
+		numberOfMessages = *limit
+		log.Info(color.New(color.FgCyan).Sprintf("Limit is set, will only move %d messages", numberOfMessages))
+	}
+
+	numberOfMessages = plannedMessages(numberOfMessages)
+	result.Total = numberOfMessages
+
+	if (rtksqs.DeletesFromSource(*sourceQueue) || *approval != "") && !confirmPlan(movePlan(*sourceQueue, source.String(), destination.String(), numberOfMessages)) {
+		return
+	}
+
+	if *probe && !probeQueues(source, destination, openOptions.RunID) {
+		return
+	}
+
+	if len(*tuneDestination) > 0 {
+		restore, ok := tuneDestinationQueue(destination)
+		if !ok {
+			return
+		}
+		defer restore()
+	}
+
+	if moved, ok = moveMessages(source, destination, numberOfMessages, openOptions.RunID, audit, queues, result); !ok || audit == nil {
+		return
+	}
+
+	destinationClosed = true
+	if err := destination.Close(); err != nil {
+		logAwsError("Failed to close the destination", err)
+		result.Error = err.Error()
+		ok = false
+		return
+	}
+
+	ok = verifyMove(sess, openOptions, audit, result)
+}
+
+// verifyMove reads back the destination, logs whether every moved message
+// arrived and reports it, the verification is set in the result.
+func verifyMove(sess *session.Session, openOptions rtksqs.Options, audit *rtksqs.MoveAudit, result *moveResult) bool {
+	log.Info(color.New(color.FgCyan).Sprintf("Verifying %d moved messages against %s", audit.Count(), *destinationQueue))
+
+	verified, err := rtksqs.VerifyMove(sess, *destinationQueue, openOptions, audit)
+
+	if err != nil {
+		logAwsError("Failed to verify the move", err)
+		result.Error = err.Error()
+		return false
+	}
+
+	result.Verify = &verifyOutput{
+		Verified: verified.Verified(),
+		Moved:    verified.Moved,
+		Read:     verified.Read,
+		Missing:  verified.Missing,
+		Other:    verified.Other,
+	}
+
+	if !verified.Verified() {
+		summaryLog.Error(color.New(color.FgRed).Sprintf("Verification failed: %d of %d moved messages are missing from the destination, or arrived with a changed body",
+			verified.Missing, verified.Moved))
+		result.Error = fmt.Sprintf("verification failed, %d of %d moved messages are missing from the destination", verified.Missing, verified.Moved)
+		return false
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Verified: all %d moved messages are in the destination, %d of the %d messages read back were there before",
+		verified.Moved, verified.Other, verified.Read))
+	return true
+}
+
+// checkRedriveIssues logs the redrive policy issues of the move and reports
+// whether it may go ahead, conflicts stop it unless forced.
+func checkRedriveIssues(issues []rtksqs.RedriveIssue) bool {
+	conflicts := 0
+
+	for _, issue := range issues {
+		switch {
+		case issue.SameQueue && requeueing():
+			// Moving into the source is what requeueing does.
+		case issue.Conflict && !*force && !(issue.IntoDeadLetterQueue && *direction == directionToDLQ):
+			// Moving into the dead-letter queue is what --direction to-dlq
+			// asks for.
+			conflicts++
+			message := issue.Message
+			if issue.SameQueue {
+				message += ", pass --delay-seconds to requeue them instead"
+			}
+			log.Error(color.New(color.FgRed).Sprintf("Redrive policy conflict: %s", message))
+		default:
+			log.Warn(color.New(color.FgYellow).Sprintf("Redrive policy: %s", issue.Message))
+		}
+	}
+
+	if conflicts > 0 {
+		log.Error(color.New(color.FgRed).Sprintf("Not moving, use --force to move despite redrive policy conflicts"))
+		return false
+	}
+
+	return true
+}
+
+// checkEncryptionIssues logs the encryption issues of the move and reports
+// whether it may go ahead, fatal issues stop it.
+func checkEncryptionIssues(issues []rtksqs.EncryptionIssue) bool {
+	fatal := 0
+
+	for _, issue := range issues {
+		if issue.Fatal {
+			fatal++
+			log.Error(color.New(color.FgRed).Sprintf("Encryption: %s", issue.Message))
+		} else {
+			log.Warn(color.New(color.FgYellow).Sprintf("Encryption: %s", issue.Message))
+		}
+	}
+
+	if fatal > 0 {
+		log.Error(color.New(color.FgRed).Sprintf("Not moving, sending to the destination would fail"))
+		return false
+	}
+
+	return true
+}
+
+func logAwsError(message string, err error) {
+	if awsErr, ok := err.(awserr.Error); ok {
+		log.Error(color.New(color.FgRed).Sprintf("%s. Error: %s", message, awsErr.Message()))
+	} else {
+		log.Error(color.New(color.FgRed).Sprintf("%s. Error: %s", message, err.Error()))
+	}
+
+	if rtksqs.IsExpiredCredentials(err) {
+		log.Error(color.New(color.FgRed).Sprintf("The AWS credentials expired, renew them and move again, or use --refresh-credentials to refresh them from the credential chain"))
+	}
+}
+
+func logBatchError(message string, err error) {
+	var batchErr *rtksqs.BatchError
+
+	if !errors.As(err, &batchErr) {
+		logAwsError(message, err)
+		return
+	}
+
+	log.Error(color.New(color.FgRed).Sprintf("%s, see details below", batchErr))
+	for index, failed := range batchErr.Failures {
+		log.Error(color.New(color.FgRed).Sprintf("%d - %s (%s) %s", index, failed.ID, failed.Code, failed.Message))
+	}
+
+	if rtksqs.IsExpiredCredentials(err) {
+		log.Error(color.New(color.FgRed).Sprintf("The AWS credentials expired, renew them and move again, or use --refresh-credentials to refresh them from the credential chain"))
+	}
+}
+
+// messageCap returns the most messages a move may move, --limit or those of
+// the applied plan, 0 when uncapped.
+func messageCap() int {
+	max := *limit
+	if appliedPlan != nil && appliedPlan.Messages != rtksqs.UnknownCount && (max == 0 || appliedPlan.Messages < max) {
+		max = appliedPlan.Messages
+	}
+	return max
+}
+
+// moveMessages moves up to totalMessages from the source to the destination,
+// or until the source is exhausted when totalMessages is rtksqs.UnknownCount.
+// It returns the number of moved messages and reports whether the move
+// succeeded, the counts and error of the move are set in the result.
+func moveMessages(source rtksqs.Source, destination rtksqs.Sink, totalMessages int, runID string, audit *rtksqs.MoveAudit, queues moveQueues, result *moveResult) (int, bool) {
+	stale := 0
+	staleHook, retention, err := staleMessageHook(source, destination, log.Log, func() { stale++ })
+
+	if err != nil {
+		logAwsError("Failed to resolve the retention period of the destination", err)
+		return 0, false
+	}
+
+	receiveCountHook, err := maxReceiveCountHook(source, destination, log.Log)
+
+	if err != nil {
+		logAwsError("Failed to resolve the maxReceiveCount of the destination", err)
+		return 0, false
+	}
+
+	deduplicationIssue, err := contentDeduplicationIssue(destination)
+
+	if err != nil {
+		logAwsError("Failed to check the deduplication of the destination", err)
+		return 0, false
+	}
+
+	if deduplicationIssue != "" {
+		log.Warn(color.New(color.FgYellow).Sprintf("%s", deduplicationIssue))
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Starting to move messages..."))
+
+	b := progress.NewInt(totalMessages)
+	b.Width = 40
+	b.StartDelimiter = color.New(color.FgCyan).Sprint("|")
+	b.EndDelimiter = color.New(color.FgCyan).Sprint("|")
+	b.Filled = color.New(color.FgCyan).Sprint("█")
+	b.Empty = color.New(color.FgCyan).Sprint("░")
+	b.Template(`		{{.Bar}} {{.Text}}{{.Percent | printf "%3.0f"}}%`)
+
+	// The progress bar is drawn on stdout, so it is skipped when stdout is
+	// the destination or carries --output json, there is no total to measure
+	// progress against, --quiet asks for the summary only or
+	// --confirm-each-chunk prompts.
+	showProgress := !rtksqs.WritesToStdout(destination) && !jsonOutput() && totalMessages != rtksqs.UnknownCount && *logInterval == 0 && !*quiet && !*confirmEachChunk
+	if showProgress {
+		fmt.Fprintln(os.Stderr)
+		term.HideCursor()
+		defer term.ShowCursor()
+	}
+
+	render := term.Renderer()
+
+	var liveCount *rtksqs.LiveCount
+	if *countInterval > 0 && totalMessages != rtksqs.UnknownCount {
+		liveCount = rtksqs.StartLiveCount(source, totalMessages, messageCap(), *countInterval)
+		defer liveCount.Stop()
+	}
+
+	var consumers *consumerWatch
+	if *countInterval > 0 {
+		consumers = startConsumerWatch(source, *countInterval)
+		defer consumers.stop()
+	}
+
+	var throughput *throughputLogger
+	if *logInterval > 0 {
+		throughput = startThroughputLogger(source, totalMessages, liveCount, *logInterval)
+	}
+
+	var stats *rtksqs.MessageStats
+	if *showStats {
+		stats = &rtksqs.MessageStats{}
+	}
+
+	skipped, deleted := 0, 0
+	dimensions := newDimensionStats()
+
+	moveOptions := newMoveOptions(runID)
+	moveOptions.Stats = stats
+	moveOptions.Dimensions = dimensions
+	moveOptions.Audit = audit
+	moveOptions.Metrics = &rtksqs.MoveMetrics{}
+	moveOptions.LiveCount = liveCount
+	if *workerStats {
+		defer logWorkerMetrics("Worker 1", moveOptions.Metrics)
+	}
+	moveOptions.Skipped = func(message *sqs.Message, reason string) {
+		if queues.quarantine != nil {
+			skippedMessages.record(runID, source.String(), message, skipActionQuarantined, reason)
+			log.Warn(color.New(color.FgYellow).Sprintf("Quarantining message %s, it %s", aws.StringValue(message.MessageId), reason))
+			return
+		}
+		skippedMessages.record(runID, source.String(), message, skipActionSkipped, reason)
+		skipped++
+		consumers.skip()
+		log.Warn(color.New(color.FgYellow).Sprintf("Skipped message %s, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	if queues.quarantine != nil {
+		moveOptions.Quarantine = queues.quarantine
+	}
+	if queues.backup != nil {
+		moveOptions.Backup = queues.backup
+	}
+	failures := &rtksqs.FailureLog{}
+	moveOptions.Failed = failures.Add
+	moveOptions.Discarded = func(message *sqs.Message, reason string) {
+		skippedMessages.record(runID, source.String(), message, skipActionDeleted, reason)
+		deleted++
+		log.Warn(color.New(color.FgYellow).Sprintf("Deleting message %s from the source, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	if staleHook != nil {
+		moveOptions.Hooks = append(moveOptions.Hooks, staleHook)
+	}
+	if receiveCountHook != nil {
+		moveOptions.Hooks = append(moveOptions.Hooks, receiveCountHook)
+	}
+	if *chunkSize > 0 {
+		moveOptions.ChunkSize = *chunkSize
+		moveOptions.ConfirmChunk = chunkConfirmer(source, destination, totalMessages, runID)
+	}
+	moveOptions.Progress = func(moved int) {
+		log.Debugf("Moved %d messages", moved)
+
+		if throughput != nil {
+			throughput.update(moved)
+		}
+
+		if liveCount != nil {
+			b.Total = float64(liveCount.Total())
+		}
+
+		// Increase the total if the approximation was under - avoids exception
+		if float64(moved) > b.Total {
+			b.Total = float64(moved)
+		}
+
+		if showProgress {
+			b.ValueInt(moved)
+			render(b.String())
+		}
+	}
+
+	messagesProcessed, err := rtksqs.Move(source, destination, totalMessages, moveOptions)
+
+	result.Moved, result.Skipped, result.Deleted, result.Stale = messagesProcessed, skipped, deleted, stale
+	result.Dimensions = dimensions.Counts()
+	result.Failures, result.FailureCounts = failures.Failures(), failures.Counts()
+	if err != nil && !errors.Is(err, errChunkDeclined) {
+		result.Error = err.Error()
+	}
+
+	if throughput != nil {
+		throughput.stop()
+	}
+
+	if stats != nil {
+		defer logSizeSummary(stats)
+	}
+
+	if dimensions != nil {
+		defer logDimensionCounts("", dimensions)
+	}
+
+	var moveErr *rtksqs.MoveError
+	if errors.As(err, &moveErr) {
+		switch moveErr.Step {
+		case rtksqs.StepReceive:
+			logAwsError("Failed to receive messages", moveErr.Err)
+		case rtksqs.StepSend:
+			logBatchError("Failed to un-queue messages to the destination", moveErr.Err)
+		case rtksqs.StepDelete:
+			logBatchError("Failed to delete messages from source queue", moveErr.Err)
+		case rtksqs.StepBacklog:
+			logAwsError("Failed to check the destination backlog", moveErr.Err)
+		case rtksqs.StepQuarantine:
+			logBatchError("Failed to quarantine messages", moveErr.Err)
+		case rtksqs.StepBackup:
+			logBatchError("Failed to back up messages", moveErr.Err)
+		}
+		return messagesProcessed, false
+	}
+
+	if errors.Is(err, rtksqs.ErrAborted) {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Aborted after moving %d messages, the others stay in the source", messagesProcessed))
+		return messagesProcessed, false
+	}
+
+	declined := errors.Is(err, errChunkDeclined)
+
+	if err != nil && !declined {
+		logAwsError("Failed to move messages", err)
+		return messagesProcessed, false
+	}
+
+	if showProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if declined {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Stopped after moving %d messages, the next chunk was declined", messagesProcessed))
+	} else {
+		summaryLog.Info(color.New(color.FgCyan).Sprintf("Done. Moved %s messages", strconv.Itoa(messagesProcessed)))
+	}
+
+	if skipped > 0 {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Skipped %d messages, they were left in the source", skipped))
+	}
+
+	if deleted > 0 {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Deleted %d filtered messages from the source", deleted))
+	}
+
+	if queues.quarantine != nil && queues.quarantine.sent > 0 {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Quarantined %d messages in %s", queues.quarantine.sent, queues.quarantine))
+	}
+
+	if stale > 0 {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("%d moved messages were sent before the %s retention period of the destination", stale, retention))
+	}
+
+	return messagesProcessed, true
+}
+
+// messageFilters returns the filters set by flags.
+func messageFilters() []rtksqs.MessageFilter {
+	var filters []rtksqs.MessageFilter
+
+	if *bodyRegex != nil {
+		filters = append(filters, rtksqs.BodyFilter(*bodyRegex))
+	}
+
+	for _, attribute := range *attributes {
+		filters = append(filters, rtksqs.AttributeFilter(attribute))
+	}
+
+	if len(*eventsErrorCodes) > 0 {
+		filters = append(filters, rtksqs.EventBridgeErrorFilter(*eventsErrorCodes))
+	}
+
+	if *olderThan > 0 {
+		filters = append(filters, rtksqs.AgeFilter(*olderThan))
+	}
+
+	if *minReceiveCount > 0 {
+		filters = append(filters, rtksqs.ReceiveCountFilter(*minReceiveCount))
+	}
+
+	return filters
+}
+
+// receivedMessageAttributes returns the message attributes requested with
+// --receive-message-attribute, and those the filters and hooks set by flags
+// read, or nil for all.
+func receivedMessageAttributes() []string {
+	if len(*receiveAttributes) == 0 {
+		return nil
+	}
+
+	names := append([]string{}, *receiveAttributes...)
+
+	for _, attribute := range *attributes {
+		names = append(names, strings.SplitN(attribute, "=", 2)[0])
+	}
+
+	if *rewriteTimestamp != "" {
+		names = append(names, *rewriteTimestamp)
+	}
+
+	if len(*eventsErrorCodes) > 0 || *eventsFormat != rtksqs.EventBridgeFormatNone {
+		names = append(names, rtksqs.EventBridgeDeadLetterAttributes()...)
+	}
+
+	if *trackReplays || *maxReplays > 0 {
+		names = append(names, rtksqs.ReplayCountAttribute)
+	}
+
+	if *preserveTimeline {
+		names = append(names, rtksqs.SentTimestampAttribute, rtksqs.FirstReceiveTimestampAttribute, rtksqs.SequenceNumberAttribute)
+	}
+
+	return names
+}
+
+// receivedSystemAttributes returns the system attributes requested with
+// --receive-system-attribute and those --preserve-timestamps copies.
+func receivedSystemAttributes() []string {
+	names := append([]string{}, *receiveSystem...)
+
+	if *preserveTimeline {
+		names = append(names, rtksqs.PreservedSystemAttributes()...)
+	}
+
+	return names
+}
+
+// loadTransformer returns the transformer of --transform-plugin followed by
+// that of --transform-exec.
+func loadTransformer() (rtksqs.Transformer, error) {
+	var chain rtksqs.TransformChain
+
+	if *transformPlugin != "" {
+		plugin, err := rtksqs.LoadTransformerPlugin(*transformPlugin)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, plugin)
+	}
+
+	if *transformExec != "" {
+		exec, err := rtksqs.ExecTransformer(*transformExec, 0)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, exec)
+	}
+
+	return chain, nil
+}
+
+// newMoveOptions returns the options of a move set by flags.
+func newMoveOptions(runID string) rtksqs.MoveOptions {
+	var hooks, discards []rtksqs.MessageHook
+	if filters := messageFilters(); len(filters) > 0 && *deleteFiltered {
+		discards = append(discards, rtksqs.FilterHook(filters, *invert))
+	} else if len(filters) > 0 {
+		hooks = append(hooks, rtksqs.FilterHook(filters, *invert))
+	}
+
+	if *sample > 0 {
+		hooks = append(hooks, rtksqs.FilterHook([]rtksqs.MessageFilter{rtksqs.SampleFilter(*sample/100, *sampleSeed)}, false))
+	}
+
+	if *skipOlderThan > 0 {
+		hooks = append(hooks, rtksqs.MaxAgeHook(*skipOlderThan))
+	}
+
+	if *rewriteTimestamp != "" {
+		hooks = append(hooks, rtksqs.TimestampRewrite(*rewriteTimestamp))
+	}
+
+	if *preserveTimeline {
+		hooks = append(hooks, rtksqs.TimelinePreserver())
+	}
+
+	if *trackReplays || *maxReplays > 0 {
+		hooks = append(hooks, rtksqs.ReplayCounter(*maxReplays, runID))
+	}
+
+	// Canaries --probe didn't receive back aren't moved.
+	if *probe {
+		discards = append(discards, rtksqs.ProbeCanaryHook(runID))
+	}
+
+	// Requeued FIFO messages would be dropped as duplicates of themselves.
+	if requeueing() || *uniqueDedup {
+		hooks = append(hooks, rtksqs.DeduplicationRegenerator(runID))
+	}
+
+	switch *lambdaFormat {
+	case rtksqs.LambdaFormatUnwrap:
+		hooks = append(hooks, rtksqs.LambdaUnwrap())
+	case rtksqs.LambdaFormatWrap:
+		hooks = append(hooks, rtksqs.LambdaWrap(*lambdaFunctionArn))
+	}
+
+	switch *snsFormat {
+	case rtksqs.SnsFormatUnwrap:
+		hooks = append(hooks, rtksqs.SnsUnwrap())
+	case rtksqs.SnsFormatWrap:
+		hooks = append(hooks, rtksqs.SnsWrap(*snsTopicArn))
+	}
+
+	switch *eventsFormat {
+	case rtksqs.EventBridgeFormatUnwrap:
+		hooks = append(hooks, rtksqs.EventBridgeUnwrap())
+	case rtksqs.EventBridgeFormatWrap:
+		hooks = append(hooks, rtksqs.EventBridgeWrap(*eventsRuleArn, *eventsTargetArn))
+	}
+
+	// S3 event notifications are often fanned out through SNS, they are
+	// checked once unwrapped.
+	if s3Events != nil {
+		hooks = append(hooks, s3Events)
+	}
+
+	if transformer != nil {
+		hooks = append(hooks, rtksqs.TransformHook(transformer))
+	}
+
+	moveOptions := rtksqs.MoveOptions{
+		Hooks:              hooks,
+		Discards:           discards,
+		BatchSize:          *maxBatchSize,
+		BacklogThreshold:   *backlogThreshold,
+		BacklogInterval:    *backlogInterval,
+		HealthCheck:        *waitForHTTP,
+		HealthInterval:     *healthInterval,
+		SendErrorThreshold: *sendErrorLimit,
+		SendErrorWindow:    *sendErrorWindow,
+		BreakerBackoff:     *breakerBackoff,
+		BreakerTrips:       *breakerTrips,
+		Rate:               *workerRate,
+		Limiter:            rateLimiter,
+		SendBackoff:        sendBackoff,
+		SendSlots:          sendSlots,
+		RetryState:         retryState,
+		Journal:            sendJournal,
+		Control:            moveControl,
+	}
+
+	if decide != nil {
+		moveOptions.Decide, moveOptions.Routes, moveOptions.Routed = decide, routes.open, routes.add
+	}
+
+	return moveOptions
+}
+
+func buildVersion(version, commit, date, builtBy string) string {
+	var result = fmt.Sprintf("version: %s", version)
+	if commit != "" {
+		result = fmt.Sprintf("%s\ncommit: %s", result, commit)
+	}
+	if date != "" {
+		result = fmt.Sprintf("%s\nbuilt at: %s", result, date)
+	}
+	if builtBy != "" {
+		result = fmt.Sprintf("%s\nbuilt by: %s", result, builtBy)
+	}
+	return result
+}
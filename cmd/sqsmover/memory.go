@@ -0,0 +1,46 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// memoryCheckInterval is how often the heap is checked against
+// --memory-limit.
+const memoryCheckInterval = time.Second
+
+// limitMemory makes the garbage collector keep the heap below the limit and
+// aborts the moves, which leave the messages not moved yet in the source,
+// when the live heap still outgrows it, rather than being killed in the middle
+// of a batch by the container.
+func limitMemory(limit int64, control *rtksqs.MoveControl) {
+	debug.SetMemoryLimit(limit)
+
+	go func() {
+		var stats runtime.MemStats
+
+		for range time.Tick(memoryCheckInterval) {
+			runtime.ReadMemStats(&stats)
+			if int64(stats.HeapAlloc) < limit {
+				continue
+			}
+
+			// Garbage doesn't count, only what the moves still hold.
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			if int64(stats.HeapAlloc) < limit {
+				continue
+			}
+
+			log.Error(color.New(color.FgRed).Sprintf("The moves hold %d bytes, more than --memory-limit allows, aborting", stats.HeapAlloc))
+			exitCode = exitFailed
+			control.Abort()
+			return
+		}
+	}()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// migrateOutput is written to stdout when migrate ends with --output json.
+type migrateOutput struct {
+	Status string `json:"status"`
+	RunID  string `json:"runId"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Moved  int    `json:"moved"`
+	// CutOver reports that --from was drained and found empty.
+	CutOver bool   `json:"cutOver"`
+	Error   string `json:"error,omitempty"`
+}
+
+// checkMigrateFlags exits when migrate is combined with flags naming other
+// queues or moves it can't run.
+func checkMigrateFlags() {
+	checkFilterFlags()
+
+	if *sourceQueue != "" || *destinationQueue != "" || *pairsFile != "" {
+		kingpin.Fatalf("migrate takes the queues from --from and --to, not from --source, --destination or --pairs")
+	}
+
+	if *watchAlarm != "" || *chunkSize > 0 || *verify || *expectCount >= 0 || *limit > 0 {
+		kingpin.Fatalf("migrate can't be combined with --watch-alarm, --chunk, --verify, --expect-count or --limit, it moves every message sent to --from")
+	}
+
+	if *migrateInterval <= 0 || *migrateQuiet <= 0 {
+		kingpin.Fatalf("--interval and --quiet-period must be positive")
+	}
+}
+
+// migrateQueue mirrors --from to --to while its producers switch over to
+// --to, moving the messages sent to it every --interval, until interrupted.
+// With --cutover it stops mirroring once --from stayed empty for
+// --quiet-period, drains it a last time and checks it is empty. It reports
+// whether it succeeded.
+func migrateQueue(sess *session.Session, openOptions rtksqs.Options) bool {
+	output := &migrateOutput{Status: resultFailed, RunID: openOptions.RunID, From: *migrateFrom, To: *migrateTo}
+	if jsonOutput() {
+		defer func() { writeJSON("migrate result", output) }()
+	}
+
+	until := "interrupted"
+	if *migrateCutover {
+		until = "the old queue stayed empty for " + migrateQuiet.String() + ", then drained and checked empty"
+	}
+
+	if !confirmPlan([]planItem{
+		{"Old queue", *migrateFrom},
+		{"New queue", *migrateTo},
+		{"Mirrors", "every message sent to the old queue, every " + migrateInterval.String()},
+		{"Until", until},
+	}) {
+		return false
+	}
+
+	source, err := rtksqs.OpenSource(sess, *migrateFrom, openOptions)
+	if err != nil {
+		logAwsError("Failed to resolve the old queue", err)
+		output.Error = err.Error()
+		return false
+	}
+	defer source.Close()
+
+	destination, err := rtksqs.OpenSink(sess, *migrateTo, openOptions)
+	if err != nil {
+		logAwsError("Failed to resolve the new queue", err)
+		output.Error = err.Error()
+		return false
+	}
+	defer destination.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	go func() {
+		if _, ok := <-interrupted; ok {
+			cancel()
+		}
+	}()
+
+	moveOptions := newMoveOptions(openOptions.RunID)
+	moveOptions.Context = ctx
+
+	log.Info(color.New(color.FgCyan).Sprintf("Mirroring %s to %s every %s, switch the producers over to %s now", source, destination, *migrateInterval, destination))
+
+	quietSince := time.Now()
+
+	for {
+		moved, err := rtksqs.Move(source, destination, rtksqs.UnknownCount, moveOptions)
+		output.Moved += moved
+
+		if ctx.Err() != nil {
+			return stopMigrate(output)
+		}
+
+		if err != nil {
+			logBatchError("Failed to mirror "+*migrateFrom, err)
+			output.Error = err.Error()
+			return false
+		}
+
+		if moved > 0 {
+			log.Info(color.New(color.FgCyan).Sprintf("Mirrored %d messages, %d in total", moved, output.Moved))
+			quietSince = time.Now()
+		}
+
+		if *migrateCutover {
+			left, err := messagesLeft(sess, openOptions)
+			if err != nil {
+				logAwsError("Failed to resolve the attributes of the old queue", err)
+				output.Error = err.Error()
+				return false
+			}
+
+			if left > 0 {
+				quietSince = time.Now()
+			} else if time.Since(quietSince) >= *migrateQuiet {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return stopMigrate(output)
+		case <-time.After(*migrateInterval):
+		}
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("%s stayed empty for %s, draining it a last time", *migrateFrom, *migrateQuiet))
+
+	moved, err := rtksqs.Move(source, destination, rtksqs.UnknownCount, moveOptions)
+	output.Moved += moved
+
+	if ctx.Err() != nil {
+		return stopMigrate(output)
+	}
+
+	if err != nil {
+		logBatchError("Failed to drain "+*migrateFrom, err)
+		output.Error = err.Error()
+		return false
+	}
+
+	left, err := messagesLeft(sess, openOptions)
+	if err != nil {
+		logAwsError("Failed to resolve the attributes of the old queue", err)
+		output.Error = err.Error()
+		return false
+	}
+
+	if left > 0 {
+		summaryLog.Error(color.New(color.FgRed).Sprintf("%s still holds about %d messages after draining it, a producer or consumer still uses it. Moved %d messages", *migrateFrom, left, output.Moved))
+		output.Error = "the old queue isn't empty"
+		return false
+	}
+
+	output.Status, output.CutOver = resultSucceeded, true
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Done. Moved %d messages to %s, %s is empty and can be deleted", output.Moved, *migrateTo, *migrateFrom))
+	return true
+}
+
+// stopMigrate logs the migration stopped by an interrupt, which ends a mirror
+// without --cutover as asked, and reports whether it succeeded.
+func stopMigrate(output *migrateOutput) bool {
+	if *migrateCutover {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("Interrupted before the cutover. Moved %d messages, %s may still hold some", output.Moved, *migrateFrom))
+		output.Error = "interrupted before the cutover"
+		return false
+	}
+
+	output.Status = resultSucceeded
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Stopped mirroring. Moved %d messages, run migrate with --cutover once all producers send to %s", output.Moved, *migrateTo))
+	return true
+}
+
+// messagesLeft returns the approximate number of messages in --from, visible,
+// in flight or delayed.
+func messagesLeft(sess *session.Session, openOptions rtksqs.Options) (int, error) {
+	description, err := rtksqs.DescribeQueue(sess, *migrateFrom, openOptions)
+	if err != nil {
+		return 0, err
+	}
+
+	left := 0
+	for _, name := range []string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+	} {
+		count, _ := strconv.Atoi(description.Attributes[name])
+		left += count
+	}
+
+	return left, nil
+}
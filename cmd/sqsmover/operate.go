@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// queueMovesPath is the API path of the QueueMove resources, defined by
+// deploy/kubernetes/queuemove-crd.yaml.
+const queueMovesPath = "/apis/sqsmover.io/v1alpha1"
+
+// Phases of the status of a QueueMove. A QueueMove without a phase is yet to
+// be moved.
+const (
+	queueMoveRunning   = "Running"
+	queueMoveSucceeded = "Succeeded"
+	queueMoveFailed    = "Failed"
+)
+
+// queueMoveStatusInterval is how often the progress of a running QueueMove
+// is written to its status.
+const queueMoveStatusInterval = 10 * time.Second
+
+// operatorRetryInterval is how long the operator waits before listing the
+// QueueMoves again after the API failed.
+const operatorRetryInterval = 5 * time.Second
+
+// serviceAccountDir holds the credentials of the service account of a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// queueMove is a QueueMove resource. Its spec is the move, like the input of
+// a task.
+type queueMove struct {
+	Metadata struct {
+		Name              string     `json:"name"`
+		Namespace         string     `json:"namespace"`
+		UID               string     `json:"uid"`
+		DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+	} `json:"metadata"`
+	Spec   taskInput       `json:"spec"`
+	Status queueMoveStatus `json:"status"`
+}
+
+func (m *queueMove) String() string {
+	return m.Metadata.Namespace + "/" + m.Metadata.Name
+}
+
+// queueMoveStatus is the status the operator writes to a QueueMove.
+type queueMoveStatus struct {
+	Phase       string     `json:"phase,omitempty"`
+	RunID       string     `json:"runId,omitempty"`
+	Total       int        `json:"total"`
+	Moved       int        `json:"moved"`
+	Skipped     int        `json:"skipped"`
+	Deleted     int        `json:"deleted"`
+	Stale       int        `json:"stale"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// kubeClient calls the Kubernetes API, with the token of the service
+// account of the pod when running in a cluster.
+type kubeClient struct {
+	client  *http.Client
+	baseURL string
+	// tokenFile is re-read for every call, the token is rotated.
+	tokenFile string
+}
+
+// newKubeClient returns a client of the API at apiURL, or of the cluster
+// the pod runs in when apiURL is empty.
+func newKubeClient(apiURL string) (*kubeClient, error) {
+	if apiURL != "" {
+		return &kubeClient{client: &http.Client{}, baseURL: strings.TrimSuffix(apiURL, "/")}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, pass the API with --kube-api")
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA of the cluster: %s", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in the CA of the cluster")
+	}
+
+	return &kubeClient{
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}},
+		baseURL:   "https://" + strings.Trim(host, "[]") + ":" + port,
+		tokenFile: serviceAccountDir + "token",
+	}, nil
+}
+
+// request sends a request of path, with body as JSON of contentType unless
+// nil, and returns the response when it succeeded.
+func (k *kubeClient) request(ctx context.Context, method, path, contentType string, body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequest(method, k.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+
+	if body != nil {
+		request.Header.Set("Content-Type", contentType)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	if k.tokenFile != "" {
+		token, err := ioutil.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the service account token: %s", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(message))
+	}
+
+	return resp, nil
+}
+
+// operator runs the moves of QueueMoves. Moves are kept by the UID of their
+// QueueMove until it is deleted, so the updates of their status don't start
+// them again.
+type operator struct {
+	kube        *kubeClient
+	sess        *session.Session
+	openOptions rtksqs.Options
+	ctx         context.Context
+
+	mu      sync.Mutex
+	moves   map[string]context.CancelFunc
+	running sync.WaitGroup
+}
+
+// operateMoves runs the moves of the QueueMoves of --namespace until
+// interrupted, which stops the running moves after the batch being moved
+// and leaves them Running, for the next operator to continue.
+func operateMoves(sess *session.Session, openOptions rtksqs.Options) bool {
+	kube, err := newKubeClient(*kubeAPI)
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("%s", err))
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := &operator{kube: kube, sess: sess, openOptions: openOptions, ctx: ctx, moves: map[string]context.CancelFunc{}}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupted
+		signal.Stop(interrupted)
+
+		log.Warn(color.New(color.FgYellow).Sprintf("Interrupted, stopping the running moves"))
+		cancel()
+	}()
+
+	path := queueMovesPath + "/queuemoves"
+	if *operateNamespace != "" {
+		path = queueMovesPath + "/namespaces/" + url.PathEscape(*operateNamespace) + "/queuemoves"
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Running the moves of QueueMoves at %s%s", kube.baseURL, path))
+
+	for ctx.Err() == nil {
+		resourceVersion, err := o.list(path)
+
+		if err == nil {
+			err = o.watch(path, resourceVersion)
+		}
+
+		if err != nil && ctx.Err() == nil {
+			log.Warn(color.New(color.FgYellow).Sprintf("Failed to follow the QueueMoves, trying again. Error: %s", err))
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(operatorRetryInterval):
+			}
+		}
+	}
+
+	o.running.Wait()
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Stopped operating"))
+
+	return true
+}
+
+// list starts the moves of the listed QueueMoves, stops those of QueueMoves
+// which are gone and returns the resource version to watch from.
+func (o *operator) list(path string) (string, error) {
+	resp, err := o.kube.request(o.ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []*queueMove `json:"items"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("invalid list of QueueMoves: %s", err)
+	}
+
+	listed := map[string]bool{}
+	for _, move := range list.Items {
+		listed[move.Metadata.UID] = true
+		o.reconcile(move)
+	}
+
+	o.mu.Lock()
+	for uid, cancel := range o.moves {
+		if !listed[uid] {
+			cancel()
+			delete(o.moves, uid)
+		}
+	}
+	o.mu.Unlock()
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch follows the changes of the QueueMoves from resourceVersion until
+// the API ends the watch.
+func (o *operator) watch(path, resourceVersion string) error {
+	query := url.Values{"watch": {"true"}, "resourceVersion": {resourceVersion}}
+
+	resp, err := o.kube.request(o.ctx, http.MethodGet, path+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+
+		if err := decoder.Decode(&event); err != nil {
+			// The API ends watches after a while.
+			if err == io.EOF || o.ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("the watch ended: %s", err)
+		}
+
+		// An expired resource version ends the watch with an ERROR event,
+		// the QueueMoves are listed again.
+		if event.Type == "ERROR" {
+			return fmt.Errorf("the watch failed: %s", event.Object)
+		}
+
+		var move queueMove
+		if err := json.Unmarshal(event.Object, &move); err != nil {
+			return fmt.Errorf("invalid QueueMove in the watch: %s", err)
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			o.reconcile(&move)
+		case "DELETED":
+			o.forget(move.Metadata.UID)
+		}
+	}
+}
+
+// reconcile starts the move of a QueueMove which has none yet, and was
+// neither moved nor interrupted while Running.
+func (o *operator) reconcile(move *queueMove) {
+	if move.Metadata.DeletionTimestamp != nil {
+		o.forget(move.Metadata.UID)
+		return
+	}
+
+	if move.Status.Phase == queueMoveSucceeded || move.Status.Phase == queueMoveFailed {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.moves[move.Metadata.UID]; ok || o.ctx.Err() != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(o.ctx)
+	o.moves[move.Metadata.UID] = cancel
+	o.running.Add(1)
+
+	go o.run(ctx, move)
+}
+
+// forget stops the move of a deleted QueueMove.
+func (o *operator) forget(uid string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if cancel, ok := o.moves[uid]; ok {
+		cancel()
+		delete(o.moves, uid)
+	}
+}
+
+// run moves the messages of a QueueMove, writing its progress to the status
+// every queueMoveStatusInterval, and the outcome once done.
+func (o *operator) run(ctx context.Context, move *queueMove) {
+	defer o.running.Done()
+
+	logger := log.WithField("queuemove", move.String())
+	resultLog := summaryLog.WithField("queuemove", move.String())
+
+	openOptions := o.openOptions
+	openOptions.RunID = rtksqs.NewRunID()
+
+	startedAt := time.Now().UTC()
+	status := queueMoveStatus{Phase: queueMoveRunning, RunID: openOptions.RunID, StartedAt: &startedAt}
+
+	if err := checkMoveInput(move.Spec, "the spec"); err != nil {
+		resultLog.Error(color.New(color.FgRed).Sprintf("%s", err))
+		status.Phase, status.Error, status.CompletedAt = queueMoveFailed, err.Error(), &startedAt
+		o.updateStatus(ctx, move, status)
+		return
+	}
+
+	logger.Info(color.New(color.FgCyan).Sprintf("Moving from %s to %s", move.Spec.Source, move.Spec.Destination))
+	o.updateStatus(ctx, move, status)
+
+	pair := &pairMove{queuePair: queuePair{source: move.Spec.Source, destination: move.Spec.Destination}, limit: move.Spec.Limit, ctx: ctx}
+	if pair.limit == 0 {
+		pair.limit = *limit
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(queueMoveStatusInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				o.updateStatus(ctx, move, pair.progress(status))
+			}
+		}
+	}()
+
+	err := isolatePair(func() error { return movePair(o.sess, openOptions, pair, nil, nil) })
+	close(done)
+	<-stopped
+
+	// Moves stopped by the operator are left Running, to be continued by the
+	// next one. Those of deleted QueueMoves have no status to write.
+	if errors.Is(err, context.Canceled) || errors.Is(err, rtksqs.ErrAborted) {
+		resultLog.Warn(color.New(color.FgYellow).Sprintf("Stopped after moving %d messages", pair.moved))
+		return
+	}
+
+	completedAt := time.Now().UTC()
+	status = pair.progress(status)
+	status.Phase, status.CompletedAt = queueMoveSucceeded, &completedAt
+
+	if err != nil {
+		status.Phase, status.Error = queueMoveFailed, err.Error()
+		resultLog.Error(color.New(color.FgRed).Sprintf("Failed after moving %d messages: %s", status.Moved, err))
+	} else {
+		resultLog.Info(color.New(color.FgCyan).Sprintf("Done. Moved %d messages", status.Moved))
+	}
+
+	// The outcome is written even when the operator is stopping meanwhile.
+	o.updateStatus(context.Background(), move, status)
+
+	if jsonOutput() {
+		writeJSON("move result", pair.result(openOptions.RunID, err))
+	}
+}
+
+// progress returns base with the progress of the move.
+func (m *pairMove) progress(base queueMoveStatus) queueMoveStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	base.Total, base.Moved, base.Skipped, base.Deleted, base.Stale = m.total, m.moved, m.skipped, m.deleted, m.stale
+	return base
+}
+
+// updateStatus writes the status of a QueueMove, logging failures as
+// warnings, a move doesn't stop for them.
+func (o *operator) updateStatus(ctx context.Context, move *queueMove, status queueMoveStatus) {
+	path := fmt.Sprintf("%s/namespaces/%s/queuemoves/%s/status", queueMovesPath, url.PathEscape(move.Metadata.Namespace), url.PathEscape(move.Metadata.Name))
+
+	resp, err := o.kube.request(ctx, http.MethodPatch, path, "application/merge-patch+json", map[string]interface{}{"status": status})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.WithField("queuemove", move.String()).Warn(color.New(color.FgYellow).Sprintf("Failed to update the status. Error: %s", err))
+		}
+		return
+	}
+	resp.Body.Close()
+}
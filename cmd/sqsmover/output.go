@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Formats of --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// backupOutput is written to stdout when backup-and-purge ends with --output
+// json.
+type backupOutput struct {
+	Status   string   `json:"status"`
+	RunID    string   `json:"runId"`
+	Queue    string   `json:"queue"`
+	Backup   string   `json:"backup"`
+	Messages int      `json:"messages"`
+	Objects  []string `json:"objects"`
+	Purged   int      `json:"purged"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// jsonOutput reports whether results are printed as JSON to stdout.
+func jsonOutput() bool {
+	return *output == outputJSON
+}
+
+// checkOutputFlags exits when --output json conflicts with the command, which
+// must leave stdout to the results.
+func checkOutputFlags(command string) {
+	if !jsonOutput() {
+		return
+	}
+
+	if command == serveCommand.FullCommand() {
+		kingpin.Fatalf("--output json doesn't apply to serve, its results are served by the gRPC API")
+	}
+
+	if *destinationQueue == rtksqs.StdioSpec {
+		kingpin.Fatalf("--output json can't be combined with moving to stdout, which carries the messages")
+	}
+}
+
+// writeJSON writes v as a single line of JSON to stdout and reports whether
+// it was written. what names v in the error.
+func writeJSON(what string, v interface{}) bool {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to write the %s. Error: %s", what, err))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// defaultPairsProgressInterval is how often the progress of --pairs moves is
+// logged when --log-interval isn't set.
+const defaultPairsProgressInterval = 10 * time.Second
+
+// queuePair is a source and destination moved with --pairs.
+type queuePair struct {
+	source      string
+	destination string
+}
+
+func (p queuePair) String() string {
+	return fmt.Sprintf("%s -> %s", p.source, p.destination)
+}
+
+// confirmPairs asks to go ahead with moving the pairs when any of them
+// deletes from its source or --approval is set, see confirmPlan.
+func confirmPairs(pairs []queuePair) bool {
+	plan := []planItem{{"Pairs", fmt.Sprintf("%d from %s", len(pairs), *pairsFile)}}
+	destructive := false
+
+	for _, pair := range pairs {
+		plan = append(plan, planItem{"Move", pair.String()})
+		destructive = destructive || rtksqs.DeletesFromSource(pair.source)
+	}
+
+	if !destructive && *approval == "" {
+		return true
+	}
+
+	messages := "all of every pair"
+	if *limit > 0 {
+		messages = fmt.Sprintf("at most %d of every pair", *limit)
+	}
+
+	return confirmPlan(append(plan,
+		planItem{"Messages", messages},
+		planItem{"Filters", filterPlan()},
+		planItem{"Deletes from the sources", deletePlan()},
+	))
+}
+
+// readPairs reads a --pairs file, a source and destination separated by a
+// comma or whitespace per line. Blank lines and lines starting with # are
+// ignored.
+func readPairs(path string) ([]queuePair, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pairs []queuePair
+	scanner := bufio.NewScanner(f)
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a source and a destination, got %q", path, number, line)
+		}
+
+		pair := queuePair{source: fields[0], destination: fields[1]}
+
+		if pair.source == rtksqs.StdioSpec || pair.destination == rtksqs.StdioSpec {
+			return nil, fmt.Errorf("%s:%d: stdin and stdout can't be moved concurrently", path, number)
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%s holds no pairs", path)
+	}
+
+	return pairs, nil
+}
+
+// pairMove is the state of the move of one pair, shared with the progress
+// logger.
+type pairMove struct {
+	queuePair
+	limit int
+	// ctx cancels the move, nil for moves which aren't canceled.
+	ctx context.Context
+
+	mu      sync.Mutex
+	started bool
+	done    bool
+	total   int
+	moved   int
+	skipped int
+	deleted int
+	stale   int
+	err     error
+	// dimensions counts the messages by --stats-by when set.
+	dimensions *rtksqs.DimensionStats
+	failures   rtksqs.FailureLog
+}
+
+func (m *pairMove) update(f func(m *pairMove)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(m)
+}
+
+// status returns how the move of the pair ended: succeeded, partial when it
+// skipped messages or failed.
+func (m *pairMove) status() string {
+	switch {
+	case m.err != nil:
+		return "failed"
+	case m.skipped > 0:
+		return "partial"
+	default:
+		return "succeeded"
+	}
+}
+
+// result returns the result of the move of the pair, which failed with err.
+func (m *pairMove) result(runID string, err error) moveResult {
+	result := moveResult{
+		Status:        resultSucceeded,
+		RunID:         runID,
+		Source:        m.source,
+		Destination:   m.destination,
+		Total:         m.total,
+		Moved:         m.moved,
+		Skipped:       m.skipped,
+		Deleted:       m.deleted,
+		Stale:         m.stale,
+		Dimensions:    m.dimensions.Counts(),
+		Failures:      m.failures.Failures(),
+		FailureCounts: m.failures.Counts(),
+	}
+
+	if err != nil {
+		result.Status, result.Error = resultFailed, err.Error()
+	}
+
+	return result
+}
+
+// pairError is the error a pair failed with.
+type pairError struct {
+	pair queuePair
+	err  error
+}
+
+func (e pairError) Error() string {
+	return fmt.Sprintf("%s: %s", e.pair, e.err)
+}
+
+func (e pairError) Unwrap() error {
+	return e.err
+}
+
+// pairsError holds the errors of every failed pair of a --pairs run.
+type pairsError []pairError
+
+func (e pairsError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d pairs failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the error of every failed pair, for errors.Is and errors.As.
+func (e pairsError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// movePairs moves every pair, running up to --workers moves at a time, logs
+// the progress of running moves and a summary per pair when all are done. A
+// failing pair doesn't stop the others, the errors of all failed pairs are
+// returned as a pairsError.
+func movePairs(sess *session.Session, openOptions rtksqs.Options, pairs []queuePair) error {
+	log.Info(color.New(color.FgCyan).Sprintf("Moving %d pairs, %d at a time", len(pairs), *workers))
+
+	var stats *rtksqs.MessageStats
+	if *showStats {
+		stats = &rtksqs.MessageStats{}
+	}
+
+	moves := make([]*pairMove, len(pairs))
+	for i, pair := range pairs {
+		moves[i] = &pairMove{queuePair: pair, limit: *limit}
+	}
+
+	interval := *logInterval
+	if interval == 0 {
+		interval = defaultPairsProgressInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go logPairsProgress(moves, interval, done, stopped)
+
+	// Idle workers wait in the channel, every worker adds up the metrics of
+	// the pairs it moved.
+	idle := make(chan int, *workers)
+	metrics := make([]*rtksqs.MoveMetrics, *workers)
+	for worker := range metrics {
+		metrics[worker] = &rtksqs.MoveMetrics{}
+	}
+
+	var failures int32
+	rampUpWorkers(idle, *workers, *rampUp, &failures, done)
+	var wg sync.WaitGroup
+
+	for _, move := range moves {
+		worker := <-idle
+		wg.Add(1)
+
+		go func(move *pairMove, worker int) {
+			defer func() {
+				idle <- worker
+				wg.Done()
+			}()
+
+			err := isolatePair(func() error {
+				return movePair(sess, openOptions, move, stats, metrics[worker])
+			})
+			if err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
+			move.update(func(m *pairMove) {
+				m.done = true
+				m.err = err
+			})
+		}(move, worker)
+	}
+
+	wg.Wait()
+	close(done)
+	<-stopped
+
+	moved := 0
+	var failed pairsError
+
+	for _, move := range moves {
+		moved += move.moved
+		pairLog := summaryLog.WithField("status", move.status())
+
+		switch {
+		case move.err != nil:
+			failed = append(failed, pairError{move.queuePair, move.err})
+			pairLog.Error(color.New(color.FgRed).Sprintf("%s: failed after moving %d messages: %s", move.queuePair, move.moved, move.err))
+		case move.skipped > 0:
+			pairLog.Warn(color.New(color.FgYellow).Sprintf("%s: moved %d messages, skipped %d which were left in the source", move.queuePair, move.moved, move.skipped))
+		default:
+			pairLog.Info(color.New(color.FgCyan).Sprintf("%s: moved %d messages", move.queuePair, move.moved))
+		}
+
+		if move.deleted > 0 {
+			summaryLog.Warn(color.New(color.FgYellow).Sprintf("%s: deleted %d filtered messages from the source", move.queuePair, move.deleted))
+		}
+
+		if move.stale > 0 {
+			summaryLog.Warn(color.New(color.FgYellow).Sprintf("%s: %d moved messages were sent before the retention period of the destination", move.queuePair, move.stale))
+		}
+
+		if move.dimensions != nil {
+			logDimensionCounts(move.queuePair.String()+": ", move.dimensions)
+		}
+
+		if jsonOutput() {
+			writeJSON("move result", move.result(openOptions.RunID, move.err))
+		}
+	}
+
+	if *workerStats {
+		for worker, workerMetrics := range metrics {
+			logWorkerMetrics(fmt.Sprintf("Worker %d", worker+1), workerMetrics)
+		}
+	}
+
+	if stats != nil {
+		defer logSizeSummary(stats)
+	}
+
+	if *expectCount >= 0 {
+		defer checkExpectedCount(moved)
+	}
+
+	if len(failed) > 0 {
+		summaryLog.Error(color.New(color.FgRed).Sprintf("Moved %d messages, %d of %d pairs failed", moved, len(failed), len(pairs)))
+		return failed
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Done. Moved %d messages of %d pairs", moved, len(pairs)))
+	return nil
+}
+
+// isolatePair runs the move of a pair, turning a panic into its error so it
+// fails that pair only, not the moves of the others.
+func isolatePair(move func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the move panicked: %v", r)
+		}
+	}()
+
+	return move()
+}
+
+// movePair moves the messages of one pair, up to its limit.
+func movePair(sess *session.Session, openOptions rtksqs.Options, move *pairMove, stats *rtksqs.MessageStats, metrics *rtksqs.MoveMetrics) error {
+	logger := log.WithField("pair", move.queuePair.String())
+
+	source, err := rtksqs.OpenSource(sess, move.source, openOptions)
+
+	if err != nil {
+		return fmt.Errorf("failed to resolve source queue: %s", err)
+	}
+	defer source.Close()
+
+	destination, err := rtksqs.OpenSink(sess, move.destination, openOptions)
+
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination queue: %s", err)
+	}
+	defer destination.Close()
+
+	issues, err := rtksqs.ValidateRedrive(source, destination)
+
+	if err != nil {
+		return fmt.Errorf("failed to check redrive policies: %s", err)
+	}
+
+	for _, issue := range issues {
+		if issue.Conflict && !*force {
+			return fmt.Errorf("redrive policy conflict, use --force to move anyway: %s", issue.Message)
+		}
+		logger.Warn(color.New(color.FgYellow).Sprintf("Redrive policy: %s", issue.Message))
+	}
+
+	encryptionIssues, err := rtksqs.ValidateEncryption(sess, source, destination)
+
+	if err != nil {
+		return fmt.Errorf("failed to check queue encryption: %s", err)
+	}
+
+	for _, issue := range encryptionIssues {
+		if issue.Fatal {
+			return fmt.Errorf("sending to the destination would fail: %s", issue.Message)
+		}
+		logger.Warn(color.New(color.FgYellow).Sprintf("Encryption: %s", issue.Message))
+	}
+
+	staleHook, _, err := staleMessageHook(source, destination, logger, func() {
+		move.update(func(m *pairMove) { m.stale++ })
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to resolve the retention period of the destination: %s", err)
+	}
+
+	receiveCountHook, err := maxReceiveCountHook(source, destination, logger)
+
+	if err != nil {
+		return fmt.Errorf("failed to resolve the maxReceiveCount of the destination: %s", err)
+	}
+
+	deduplicationIssue, err := contentDeduplicationIssue(destination)
+
+	if err != nil {
+		return fmt.Errorf("failed to check the deduplication of the destination: %s", err)
+	}
+
+	if deduplicationIssue != "" {
+		logger.Warn(color.New(color.FgYellow).Sprintf("%s", deduplicationIssue))
+	}
+
+	total, err := source.ApproximateCount()
+
+	if err != nil {
+		return fmt.Errorf("failed to resolve queue attributes: %s", err)
+	}
+
+	if move.limit > 0 && (total == rtksqs.UnknownCount || total > move.limit) {
+		total = move.limit
+	}
+
+	move.update(func(m *pairMove) {
+		m.started = true
+		m.total = total
+	})
+
+	if total == 0 {
+		return nil
+	}
+
+	dimensions := newDimensionStats()
+	move.update(func(m *pairMove) { m.dimensions = dimensions })
+
+	moveOptions := newMoveOptions(openOptions.RunID)
+	moveOptions.Context = move.ctx
+	moveOptions.Stats = stats
+	moveOptions.Dimensions = dimensions
+	moveOptions.Metrics = metrics
+	if staleHook != nil {
+		moveOptions.Hooks = append(moveOptions.Hooks, staleHook)
+	}
+	if receiveCountHook != nil {
+		moveOptions.Hooks = append(moveOptions.Hooks, receiveCountHook)
+	}
+	moveOptions.Skipped = func(message *sqs.Message, reason string) {
+		skippedMessages.record(openOptions.RunID, move.source, message, skipActionSkipped, reason)
+		move.update(func(m *pairMove) { m.skipped++ })
+		logger.Warn(color.New(color.FgYellow).Sprintf("Skipped message %s, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	moveOptions.Discarded = func(message *sqs.Message, reason string) {
+		skippedMessages.record(openOptions.RunID, move.source, message, skipActionDeleted, reason)
+		move.update(func(m *pairMove) { m.deleted++ })
+		logger.Warn(color.New(color.FgYellow).Sprintf("Deleting message %s from the source, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	moveOptions.Failed = move.failures.Add
+	moveOptions.Progress = func(moved int) {
+		logger.Debugf("Moved %d messages", moved)
+		move.update(func(m *pairMove) { m.moved = moved })
+	}
+
+	moved, err := rtksqs.Move(source, destination, total, moveOptions)
+	move.update(func(m *pairMove) { m.moved = moved })
+
+	return err
+}
+
+// logPairsProgress logs the progress of every running move each interval
+// until done is closed.
+func logPairsProgress(moves []*pairMove, interval time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		finished := 0
+
+		for _, move := range moves {
+			move.update(func(m *pairMove) {
+				switch {
+				case m.done:
+					finished++
+				case !m.started:
+				case m.total == rtksqs.UnknownCount:
+					log.Info(color.New(color.FgCyan).Sprintf("%s: moved %d messages", m.queuePair, m.moved))
+				default:
+					log.Info(color.New(color.FgCyan).Sprintf("%s: moved %d of about %d messages", m.queuePair, m.moved, m.total))
+				}
+			})
+		}
+
+		log.Info(color.New(color.FgCyan).Sprintf("%d of %d pairs done", finished, len(moves)))
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// planVersion is the version of plan files apply reads.
+const planVersion = 1
+
+// SQS request prices in USD in us-east-1, the free tier isn't subtracted.
+const (
+	standardRequestPrice = 0.40 / 1e6
+	fifoRequestPrice     = 0.50 / 1e6
+)
+
+// unplannedFlags aren't recorded in plans: credentials, which are passed to
+// apply again, the flags of plan itself and of the output.
+var unplannedFlags = map[string]bool{
+	"access-key-id":     true,
+	"secret-access-key": true,
+	"session-token":     true,
+	"out":               true,
+	"output":            true,
+	"no-color":          true,
+	"log-file":          true,
+	"log-file-max-size": true,
+	"log-file-max-age":  true,
+	"log-file-keep":     true,
+}
+
+// applyFlags are the flags apply takes besides the plan. They choose the
+// credentials, logging and output, the move itself is fixed by the plan.
+var applyFlags = map[string]bool{
+	"profile":             true,
+	"access-key-id":       true,
+	"secret-access-key":   true,
+	"session-token":       true,
+	"role-arn":            true,
+	"refresh-credentials": true,
+	"refresh-timeout":     true,
+	"log-level":           true,
+	"log-interval":        true,
+	"quiet":               true,
+	"skip-report":         true,
+	"output":              true,
+	"no-color":            true,
+	"log-file":            true,
+	"log-file-max-size":   true,
+	"log-file-max-age":    true,
+	"log-file-keep":       true,
+}
+
+// appliedPlan is the plan apply executes, nil for other runs.
+var appliedPlan *movePlanFile
+
+// movePlanFile is the plan file of a move written by plan, reviewed and
+// executed by apply.
+type movePlanFile struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Source and Destination are the queue URLs, or the specs of other
+	// sources and sinks, apply moves between.
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Messages is the number of messages apply moves at most, -1 when the
+	// size of the source is unknown.
+	Messages int    `json:"messages"`
+	InFlight int    `json:"inFlight"`
+	Filters  string `json:"filters"`
+	Deletes  string `json:"deletes"`
+	// Requests, CostUSD and Duration are estimates for moving Messages.
+	Requests int      `json:"requests"`
+	CostUSD  float64  `json:"costUsd"`
+	Duration string   `json:"duration"`
+	Warnings []string `json:"warnings,omitempty"`
+	// Args are the flags of the move.
+	Args []string `json:"args"`
+}
+
+// checkPlanFlags exits when flags conflict with plan, which plans a single
+// move.
+func checkPlanFlags() {
+	if *pairsFile != "" || *watchAlarm != "" {
+		kingpin.Fatalf("plan can't be combined with --pairs or --watch-alarm, it plans a single move")
+	}
+
+	if *sourceQueue == rtksqs.StdioSpec {
+		kingpin.Fatalf("plan can't move from stdin, apply would read it again")
+	}
+}
+
+// flagArgs returns the flags given in args as --name=value, --name or
+// --no-name, leaving out commands, arguments and the excluded flags.
+func flagArgs(args []string, exclude map[string]bool) ([]string, error) {
+	context, err := kingpin.CommandLine.ParseContext(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []string
+	for _, element := range context.Elements {
+		flag, ok := element.Clause.(*kingpin.FlagClause)
+		if !ok || element.Value == nil {
+			continue
+		}
+
+		model := flag.Model()
+		switch {
+		case exclude[model.Name]:
+		case model.IsBoolFlag() && *element.Value == "false":
+			flags = append(flags, "--no-"+model.Name)
+		case model.IsBoolFlag():
+			flags = append(flags, "--"+model.Name)
+		default:
+			flags = append(flags, fmt.Sprintf("--%s=%s", model.Name, *element.Value))
+		}
+	}
+
+	return flags, nil
+}
+
+// flagName returns the name of a flag recorded by flagArgs.
+func flagName(arg string) string {
+	name := strings.TrimPrefix(arg, "--")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// hasFlag reports whether args recorded by flagArgs hold the flag.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if flagName(arg) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writePlan runs the checks of a move, estimates it and writes it to --out
+// for apply, without moving anything. It logs failures and reports whether
+// the plan was written.
+func writePlan(sess *session.Session, openOptions rtksqs.Options) bool {
+	args, err := flagArgs(os.Args[1:], unplannedFlags)
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to record the flags of the move. Error: %s", err))
+		return false
+	}
+
+	// apply samples the same messages.
+	if *sample > 0 && !hasFlag(args, "sample-seed") {
+		args = append(args, "--sample-seed="+*sampleSeed)
+	}
+
+	source, err := rtksqs.OpenSource(sess, *sourceQueue, openOptions)
+
+	if err != nil {
+		logAwsError("Failed to resolve source queue", err)
+		return false
+	}
+	defer source.Close()
+
+	destination, err := rtksqs.OpenSink(sess, *destinationQueue, delayFifoDestination(openOptions))
+
+	if err != nil {
+		logAwsError("Failed to resolve destination queue", err)
+		return false
+	}
+	defer destination.Close()
+
+	plan := &movePlanFile{
+		Version:     planVersion,
+		CreatedAt:   time.Now().UTC(),
+		Source:      source.String(),
+		Destination: destination.String(),
+		Filters:     filterPlan(),
+		Deletes:     sourceDeletePlan(*sourceQueue),
+		Args:        args,
+	}
+
+	issues, err := rtksqs.ValidateRedrive(source, destination)
+
+	if err != nil {
+		logAwsError("Failed to check redrive policies", err)
+		return false
+	}
+
+	if !checkRedriveIssues(issues) {
+		return false
+	}
+
+	for _, issue := range issues {
+		plan.Warnings = append(plan.Warnings, "Redrive policy: "+issue.Message)
+	}
+
+	encryptionIssues, err := rtksqs.ValidateEncryption(sess, source, destination)
+
+	if err != nil {
+		logAwsError("Failed to check queue encryption", err)
+		return false
+	}
+
+	if !checkEncryptionIssues(encryptionIssues) {
+		return false
+	}
+
+	for _, issue := range encryptionIssues {
+		plan.Warnings = append(plan.Warnings, "Encryption: "+issue.Message)
+	}
+
+	deduplicationIssue, err := contentDeduplicationIssue(destination)
+
+	if err != nil {
+		logAwsError("Failed to check the deduplication of the destination", err)
+		return false
+	}
+
+	if deduplicationIssue != "" {
+		plan.Warnings = append(plan.Warnings, "Deduplication: "+deduplicationIssue)
+	}
+
+	if *verify {
+		if err := rtksqs.Verifiable(sess, *destinationQueue, openOptions); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Not planning, %s", err))
+			return false
+		}
+	}
+
+	if inFlightSource, ok := source.(rtksqs.InFlightSource); ok {
+		if plan.InFlight, err = inFlightSource.ApproximateInFlight(); err != nil {
+			logAwsError("Failed to resolve queue attributes", err)
+			return false
+		}
+	}
+
+	// The latency of counting stands in for the calls of the move.
+	start := time.Now()
+	plan.Messages, err = source.ApproximateCount()
+	latency := time.Since(start)
+
+	if err != nil {
+		logAwsError("Failed to resolve queue attributes", err)
+		return false
+	}
+
+	if *limit > 0 && (plan.Messages == rtksqs.UnknownCount || plan.Messages > *limit) {
+		plan.Messages = *limit
+	}
+
+	plan.estimate(source, destination, latency)
+
+	printPlan(plan.items())
+
+	if err := plan.write(*planOut); err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to write the plan. Error: %s", err))
+		return false
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Wrote the plan to %s, move it with: sqsmover apply %s", *planOut, *planOut))
+
+	if jsonOutput() {
+		return writeJSON("plan", plan)
+	}
+	return true
+}
+
+// estimate sets the requests, cost and duration of moving the messages of
+// the plan. Every batch is received, sent and deleted in a call to SQS each,
+// taking about the latency of the calls, unless the rates are slower.
+func (p *movePlanFile) estimate(source rtksqs.Source, destination rtksqs.Sink, latency time.Duration) {
+	if p.Messages == rtksqs.UnknownCount {
+		p.Duration = "unknown"
+		return
+	}
+
+	batches := int(math.Ceil(float64(p.Messages) / float64(*maxBatchSize)))
+
+	// Receives and deletes go to queue sources, sends to queue destinations.
+	calls := 0
+	if !rtksqs.ReplaysArchive(source) {
+		p.Requests += 2 * batches
+		p.CostUSD += float64(2*batches) * requestPrice(p.Source)
+		calls += 2
+	}
+	if rtksqs.SendsToQueue(destination) {
+		p.Requests += batches
+		p.CostUSD += float64(batches) * requestPrice(p.Destination)
+		calls++
+	}
+
+	duration := time.Duration(batches*calls) * latency
+	for _, rate := range []float64{*rate, *workerRate} {
+		if rate > 0 {
+			if limited := time.Duration(float64(p.Messages) / rate * float64(time.Second)); limited > duration {
+				duration = limited
+			}
+		}
+	}
+
+	p.Duration = duration.Round(time.Second).String()
+}
+
+// requestPrice returns the price of a request to the queue.
+func requestPrice(queue string) float64 {
+	if strings.HasSuffix(queue, ".fifo") {
+		return fifoRequestPrice
+	}
+	return standardRequestPrice
+}
+
+// items returns the plan as printed for review.
+func (p *movePlanFile) items() []planItem {
+	items := movePlan(p.Source, p.Source, p.Destination, p.Messages)
+
+	if p.InFlight > 0 {
+		items = append(items, planItem{"In flight", fmt.Sprintf("about %d, not moved unless visible again", p.InFlight)})
+	}
+	if p.Requests > 0 {
+		items = append(items, planItem{"SQS requests", fmt.Sprintf("about %d, %.4f USD", p.Requests, p.CostUSD)})
+	}
+	items = append(items, planItem{"Duration", p.Duration})
+
+	for _, warning := range p.Warnings {
+		items = append(items, planItem{"Warning", warning})
+	}
+
+	return items
+}
+
+func (p *movePlanFile) write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// loadPlan reads the plan of apply and parses the flags of its move, along
+// with the credential and logging flags given to apply. It returns the move
+// command and exits when the plan can't be applied.
+func loadPlan() string {
+	data, err := os.ReadFile(*applyPlanArg)
+	if err != nil {
+		kingpin.Fatalf("failed to read the plan: %s", err)
+	}
+
+	var plan movePlanFile
+	if err := json.Unmarshal(data, &plan); err != nil {
+		kingpin.Fatalf("failed to read the plan %s: %s", *applyPlanArg, err)
+	}
+
+	if plan.Version != planVersion {
+		kingpin.Fatalf("%s is a plan of version %d, this sqsmover applies version %d", *applyPlanArg, plan.Version, planVersion)
+	}
+
+	given, err := flagArgs(os.Args[1:], nil)
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+
+	for _, arg := range given {
+		if !applyFlags[flagName(arg)] {
+			kingpin.Fatalf("apply only takes credential, logging and output flags, --%s is fixed by the plan", flagName(arg))
+		}
+	}
+
+	command, err := kingpin.CommandLine.Parse(append(append([]string{moveCommand.FullCommand()}, plan.Args...), given...))
+	if err != nil {
+		kingpin.Fatalf("the plan %s holds invalid flags: %s", *applyPlanArg, err)
+	}
+
+	appliedPlan = &plan
+	// The plan was reviewed, apply doesn't ask again, but still waits for an
+	// --approval of the plan.
+	planConfirmed = *approval == ""
+
+	return command
+}
+
+// checkAppliedPlan reports whether the source and destination are still the
+// queues of the applied plan. Names resolving to other queues, e.g. after
+// changing --region, fail the run.
+func checkAppliedPlan(source rtksqs.Source, destination rtksqs.Sink) bool {
+	if appliedPlan == nil {
+		return true
+	}
+
+	if source.String() != appliedPlan.Source || destination.String() != appliedPlan.Destination {
+		log.Error(color.New(color.FgRed).Sprintf("Not moving, the plan moves from %s to %s, but the queues resolve to %s and %s now",
+			appliedPlan.Source, appliedPlan.Destination, source, destination))
+		return false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Applying the plan of %s", appliedPlan.CreatedAt.Local().Format(time.RFC1123)))
+	return true
+}
+
+// plannedMessages caps the messages of the move at those of the applied
+// plan, messages sent since then are left for another plan.
+func plannedMessages(total int) int {
+	if appliedPlan == nil || appliedPlan.Messages == rtksqs.UnknownCount {
+		return total
+	}
+
+	if total == rtksqs.UnknownCount || total > appliedPlan.Messages {
+		log.Info(color.New(color.FgCyan).Sprintf("Moving the %d messages of the plan", appliedPlan.Messages))
+		return appliedPlan.Messages
+	}
+
+	return total
+}
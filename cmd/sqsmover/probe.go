@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// probeQueues probes the source and destination queues with a canary
+// message each for --probe and logs the latencies. It reports whether the
+// move may go ahead, which it may not when a step the move needs failed:
+// receiving from and deleting in the source, and sending to the destination.
+func probeQueues(source rtksqs.Source, destination rtksqs.Sink, runID string) bool {
+	var probeErr *rtksqs.ProbeError
+
+	result, err := rtksqs.ProbeSource(source, runID, *probeTimeout)
+
+	switch {
+	case errors.As(err, &probeErr) && probeErr.Step == rtksqs.ProbeSend:
+		log.Warn(color.New(color.FgYellow).Sprintf("Can't probe the source, sending the canary to it failed, which the move doesn't need. Error: %s", probeErr.Err))
+	case err != nil:
+		logAwsError("Probing the source failed", err)
+		return false
+	default:
+		logProbe("source", result)
+	}
+
+	result, err = rtksqs.ProbeSink(destination, runID, *probeTimeout)
+
+	switch {
+	case errors.As(err, &probeErr) && probeErr.Step != rtksqs.ProbeSend:
+		log.Warn(color.New(color.FgYellow).Sprintf("Sent the canary to the destination, but can't %s it, which the move doesn't need. "+
+			"Consumers of the destination receive it with the %s attribute. Error: %s", probeErr.Step, rtksqs.ProbeAttribute, probeErr.Err))
+	case err != nil:
+		logAwsError("Probing the destination failed", err)
+		return false
+	default:
+		logProbe("destination", result)
+	}
+
+	return true
+}
+
+// logProbe logs the latencies of probing the source or destination, named
+// by queue, nothing when it isn't a queue.
+func logProbe(queue string, result *rtksqs.ProbeResult) {
+	if result == nil {
+		return
+	}
+
+	if result.Lost {
+		log.Warn(color.New(color.FgYellow).Sprintf("Sent the canary to the %s in %s, but didn't receive it back within %s, another consumer may have received it first or it is behind a backlog",
+			queue, result.Send.Round(time.Millisecond), *probeTimeout))
+		return
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Probed the %s: sent the canary in %s, received it back after %s and deleted it in %s",
+		queue, result.Send.Round(time.Millisecond), result.RoundTrip.Round(time.Millisecond), result.Delete.Round(time.Millisecond)))
+}
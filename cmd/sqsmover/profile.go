@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// startProfiling serves the pprof endpoints and starts the CPU profile as
+// requested by the flags. The returned function stops the CPU profile and
+// writes the heap profile, it must be called before exiting.
+func startProfiling() (func(), error) {
+	if *pprofAddress != "" {
+		go func() {
+			if err := http.ListenAndServe(*pprofAddress, nil); err != nil {
+				log.Error(color.New(color.FgRed).Sprintf("Failed to serve pprof on %s. Error: %s", *pprofAddress, err))
+			}
+		}()
+		log.Info(color.New(color.FgCyan).Sprintf("Serving pprof on http://%s/debug/pprof/", *pprofAddress))
+	}
+
+	var cpuFile *os.File
+
+	if *cpuProfile != "" {
+		file, err := os.Create(*cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		cpuFile = file
+		log.Info(color.New(color.FgCyan).Sprintf("Writing CPU profile to %s", *cpuProfile))
+	}
+
+	return func() { stopProfiling(cpuFile) }, nil
+}
+
+func stopProfiling(cpuFile *os.File) {
+	if cpuFile != nil {
+		pprof.StopCPUProfile()
+		cpuFile.Close()
+	}
+
+	if *memProfile != "" {
+		file, err := os.Create(*memProfile)
+		if err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to write heap profile. Error: %s", err))
+			return
+		}
+		defer file.Close()
+
+		// Collect garbage first so the profile shows live memory only.
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(file); err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to write heap profile. Error: %s", err))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// moveQueues are the queues a move sends to besides the destination, unset
+// when not asked for.
+type moveQueues struct {
+	quarantine *auxiliaryQueue
+	backup     *auxiliaryQueue
+}
+
+// auxiliaryQueue is a --quarantine-queue or --backup-queue. It counts the
+// messages sent to it, so a queue the run created is only deleted by
+// --delete-empty-queues when nothing was sent to it.
+type auxiliaryQueue struct {
+	rtksqs.Sink
+	role    string
+	spec    string
+	created bool
+	sent    int
+}
+
+// openAuxiliaryQueue opens the sink spec, created from --queue-template when
+// it is a missing queue. It logs failures and reports false.
+func openAuxiliaryQueue(sess *session.Session, openOptions rtksqs.Options, spec, role string) (*auxiliaryQueue, bool) {
+	queue := &auxiliaryQueue{role: role, spec: spec}
+
+	if *queueTemplate != "" {
+		template, err := rtksqs.LoadQueueTemplate(*queueTemplate)
+		if err != nil {
+			log.Error(color.New(color.FgRed).Sprintf("Failed to load the queue template. Error: %s", err))
+			return nil, false
+		}
+
+		if queue.created, err = rtksqs.EnsureQueue(sess, spec, template, openOptions); err != nil {
+			logAwsError("Failed to create the "+role+" queue", err)
+			return nil, false
+		}
+
+		if queue.created {
+			log.Info(color.New(color.FgCyan).Sprintf("Created the %s queue %s from %s", role, spec, *queueTemplate))
+		}
+	}
+
+	// Only the destination is recorded in the --id-map.
+	openOptions.IDMap = ""
+
+	var err error
+	if queue.Sink, err = rtksqs.OpenSink(sess, spec, openOptions); err != nil {
+		logAwsError("Failed to resolve the "+role+" queue", err)
+		return nil, false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("URL of the %s queue: %s", role, queue))
+
+	return queue, true
+}
+
+func (q *auxiliaryQueue) Send(messages []*sqs.Message) error {
+	// Messages of failed sends are counted too, they may have arrived.
+	q.sent += len(messages)
+	return q.Sink.Send(messages)
+}
+
+// close closes the queue and deletes it with --delete-empty-queues when the
+// run created it and sent nothing to it.
+func (q *auxiliaryQueue) close(sess *session.Session, openOptions rtksqs.Options) {
+	if err := q.Sink.Close(); err != nil {
+		logAwsError("Failed to close the "+q.role+" queue", err)
+	}
+
+	if !*deleteEmptyQueues || !q.created || q.sent > 0 {
+		return
+	}
+
+	deleted, err := rtksqs.DeleteEmptyQueue(sess, q.spec, openOptions)
+
+	switch {
+	case err != nil:
+		logAwsError("Failed to delete the empty "+q.role+" queue", err)
+	case deleted:
+		log.Info(color.New(color.FgCyan).Sprintf("Deleted the empty %s queue %s", q.role, q.spec))
+	default:
+		log.Warn(color.New(color.FgYellow).Sprintf("Kept the %s queue %s, it isn't empty", q.role, q.spec))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// rampUpWorkers puts the first worker of workers in idle right away and
+// doubles the idle and running ones every interval, so a misconfigured
+// destination or missing permission fails the first pairs rather than all
+// workers at once. It holds the workers started so far once a pair failed,
+// and stops when done is closed. An interval of 0 starts every worker.
+func rampUpWorkers(idle chan<- int, workers int, interval time.Duration, failed *int32, done <-chan struct{}) {
+	if interval <= 0 {
+		for worker := 0; worker < workers; worker++ {
+			idle <- worker
+		}
+		return
+	}
+
+	idle <- 0
+	started := 1
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for started < workers {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if atomic.LoadInt32(failed) > 0 {
+				log.Warn(color.New(color.FgYellow).Sprintf("A pair failed while ramping up, keeping %d of %d workers", started, workers))
+				return
+			}
+
+			next := started * 2
+			if next > workers {
+				next = workers
+			}
+
+			for ; started < next; started++ {
+				idle <- started
+			}
+
+			log.Debugf("Ramped up to %d of %d workers", started, workers)
+		}
+	}()
+}
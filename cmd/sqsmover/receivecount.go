@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// maxReceiveCountHook returns a hook skipping the messages received more
+// often than the maxReceiveCount of the destination with
+// --honor-max-receive-count, when moving a dead-letter queue back into its
+// source queue. There is no hook for other moves.
+func maxReceiveCountHook(source rtksqs.Source, destination rtksqs.Sink, logger log.Interface) (rtksqs.MessageHook, error) {
+	if !*honorMaxReceive {
+		return nil, nil
+	}
+
+	max, ok, err := rtksqs.RedriveMaxReceiveCount(source, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		logger.Warn(color.New(color.FgYellow).Sprintf("%s doesn't dead-letter into %s, --honor-max-receive-count skips nothing", destination, source))
+		return nil, nil
+	}
+
+	logger.Info(color.New(color.FgCyan).Sprintf("Skipping messages received more than %d times, the maxReceiveCount of %s", max, destination))
+	return rtksqs.MaxReceiveCountHook(max), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/sqsvcr"
+)
+
+// recordingClient returns the SQS client recording the calls of the run to
+// --record-sqs, or replaying those of --replay-sqs, nil for neither. The
+// returned function writes the recording or reports calls which weren't
+// replayed, it must be called before exiting.
+func recordingClient(sess *session.Session) (sqsiface.SQSAPI, func(), error) {
+	switch {
+	case *recordSQS != "":
+		file, err := os.Create(*recordSQS)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		recorder := sqsvcr.NewRecorder(rtksqs.NewSQSClientWithAPI(sqs.New(sess)), file)
+		log.Info(color.New(color.FgCyan).Sprintf("Recording SQS calls to %s, it holds the message bodies", *recordSQS))
+
+		return recorder, func() {
+			if err := recorder.Flush(); err != nil {
+				log.Error(color.New(color.FgRed).Sprintf("Failed to record SQS calls to %s. Error: %s", *recordSQS, err))
+			}
+			file.Close()
+		}, nil
+
+	case *replaySQS != "":
+		file, err := os.Open(*replaySQS)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		replayer, err := sqsvcr.NewReplayer(file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Info(color.New(color.FgCyan).Sprintf("Replaying SQS calls of %s", *replaySQS))
+
+		return replayer, func() {
+			if unused := replayer.Unused(); len(unused) > 0 {
+				log.Warn(color.New(color.FgYellow).Sprintf("%d recorded SQS calls weren't replayed, the first is %s", len(unused), unused[0].Operation))
+			}
+		}, nil
+	}
+
+	return nil, func() {}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// redactingHandler masks the matches of --redact-pattern in the messages and
+// string fields of log entries before handing them on, so message content
+// quoted by errors doesn't leak into the logs.
+type redactingHandler struct {
+	handler log.Handler
+}
+
+// HandleLog implements log.Handler.
+func (h *redactingHandler) HandleLog(e *log.Entry) error {
+	redacted := *e
+	redacted.Message = redactLog(e.Message)
+	redacted.Fields = make(log.Fields, len(e.Fields))
+
+	for name, value := range e.Fields {
+		switch v := value.(type) {
+		case string:
+			value = redactLog(v)
+		case error:
+			value = redactLog(v.Error())
+		}
+		redacted.Fields[name] = value
+	}
+
+	return h.handler.HandleLog(&redacted)
+}
+
+// redactLog redacts a log message around its color codes, which patterns
+// matching digits would break otherwise.
+func redactLog(message string) string {
+	codes := colorCodes.FindAllStringIndex(message, -1)
+	if len(codes) == 0 {
+		return rtksqs.Redact(*redactPatterns, message)
+	}
+
+	var redacted strings.Builder
+	start := 0
+	for _, code := range codes {
+		redacted.WriteString(rtksqs.Redact(*redactPatterns, message[start:code[0]]))
+		redacted.WriteString(message[code[0]:code[1]])
+		start = code[1]
+	}
+	redacted.WriteString(rtksqs.Redact(*redactPatterns, message[start:]))
+
+	return redacted.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// requeueing reports whether the move requeues, moving the source back into
+// itself with --delay-seconds to defer its messages.
+func requeueing() bool {
+	return *delaySeconds > 0 && *sourceQueue != "" && *sourceQueue == *destinationQueue
+}
+
+// tunesDelay reports whether --tune-destination overrides the DelaySeconds of
+// the destination.
+func tunesDelay() bool {
+	for name := range *tuneDestination {
+		if name = strings.ToLower(name); name == "delay" || name == "delayseconds" {
+			return true
+		}
+	}
+	return false
+}
+
+// delayFifoDestination delays the messages sent to a FIFO destination with
+// --delay-seconds by tuning the DelaySeconds of the queue while moving, FIFO
+// queues don't delay single messages.
+func delayFifoDestination(openOptions rtksqs.Options) rtksqs.Options {
+	if openOptions.DelaySeconds == 0 || !strings.HasSuffix(*destinationQueue, ".fifo") {
+		return openOptions
+	}
+
+	if *tuneDestination == nil {
+		*tuneDestination = map[string]string{}
+	}
+	(*tuneDestination)["delay"] = strconv.FormatInt(openOptions.DelaySeconds, 10)
+
+	log.Warn(color.New(color.FgYellow).Sprintf("FIFO queues don't delay single messages, %s delays every message sent to it while moving, also those of other producers", *destinationQueue))
+
+	openOptions.DelaySeconds = 0
+	return openOptions
+}
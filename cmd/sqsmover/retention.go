@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// staleMessageHook returns a hook calling stale for every message replayed
+// from an archive which was sent before the retention period of the
+// destination queue, and that period. The first such message is logged as a
+// warning. There is no hook when moving from a queue, or to another sink.
+func staleMessageHook(source rtksqs.Source, destination rtksqs.Sink, logger log.Interface, stale func()) (rtksqs.MessageHook, time.Duration, error) {
+	if !rtksqs.ReplaysArchive(source) {
+		return nil, 0, nil
+	}
+
+	retention, ok, err := rtksqs.QueueRetention(destination)
+	if err != nil || !ok {
+		return nil, 0, err
+	}
+
+	warned := false
+
+	return rtksqs.RetentionHook(retention, func(message *sqs.Message, age time.Duration) {
+		stale()
+
+		if warned {
+			logger.Debugf("Message %s was sent %s ago, before the retention period of the destination", aws.StringValue(message.MessageId), age.Round(time.Second))
+			return
+		}
+
+		warned = true
+		logger.Warn(color.New(color.FgYellow).Sprintf("Message %s was sent %s ago, before the %s retention period of the destination, consumers checking its age may drop it. "+
+			"--skip-older-than skips such messages, --rewrite-timestamp renews a timestamp attribute", aws.StringValue(message.MessageId), age.Round(time.Second), retention))
+	}), retention, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/moverpb"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// finishedMoveRetention is how long the status of a move which ended can
+// still be asked for.
+const finishedMoveRetention = time.Hour
+
+// serverMove is a move started through the gRPC API.
+type serverMove struct {
+	mu      sync.Mutex
+	status  *moverpb.MoveStatus
+	changed chan struct{}
+	cancel  context.CancelFunc
+}
+
+// update changes the status of the move and wakes up its followers.
+func (m *serverMove) update(f func(status *moverpb.MoveStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f(m.status)
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// snapshot returns a copy of the status and a channel closed once it
+// changed.
+func (m *serverMove) snapshot() (*moverpb.MoveStatus, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return proto.Clone(m.status).(*moverpb.MoveStatus), m.changed
+}
+
+// moveServer implements the gRPC API of serve, moves are checked and opened
+// like by the move command and run in the background.
+type moveServer struct {
+	moverpb.UnimplementedMoverServer
+
+	sess        *session.Session
+	openOptions rtksqs.Options
+
+	mu      sync.Mutex
+	moves   map[string]*serverMove
+	stopped bool
+	running sync.WaitGroup
+}
+
+// serveMoves serves the gRPC API on --address until interrupted, which
+// cancels the running moves and waits for them to stop.
+func serveMoves(sess *session.Session, openOptions rtksqs.Options) {
+	listener, err := net.Listen("tcp", *serveAddress)
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to listen on %s. Error: %s", *serveAddress, err))
+		exitCode = exitFailed
+		return
+	}
+
+	server := &moveServer{sess: sess, openOptions: openOptions, moves: map[string]*serverMove{}}
+	grpcServer := grpc.NewServer()
+	moverpb.RegisterMoverServer(grpcServer, server)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupted
+		signal.Stop(interrupted)
+
+		log.Warn(color.New(color.FgYellow).Sprintf("Interrupted, canceling the running moves"))
+		server.stop()
+		grpcServer.GracefulStop()
+	}()
+
+	log.Info(color.New(color.FgCyan).Sprintf("Serving the gRPC API on %s", listener.Addr()))
+
+	if err := grpcServer.Serve(listener); err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("Failed to serve the gRPC API. Error: %s", err))
+		exitCode = exitFailed
+		return
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Stopped serving"))
+}
+
+// stop refuses new moves, cancels the running ones and waits for them to
+// stop.
+func (s *moveServer) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	for _, move := range s.moves {
+		move.cancel()
+	}
+	s.mu.Unlock()
+
+	s.running.Wait()
+}
+
+func (s *moveServer) move(id string) (*serverMove, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	move, ok := s.moves[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no move %s", id)
+	}
+
+	return move, nil
+}
+
+func (s *moveServer) StartMove(ctx context.Context, req *moverpb.StartMoveRequest) (*moverpb.StartMoveResponse, error) {
+	if req.Source == "" || req.Destination == "" {
+		return nil, status.Error(codes.InvalidArgument, "source and destination are required")
+	}
+
+	if req.Source == rtksqs.StdioSpec || req.Destination == rtksqs.StdioSpec {
+		return nil, status.Error(codes.InvalidArgument, "stdin and stdout can't be moved by the server")
+	}
+
+	if req.Limit < 0 || req.BatchSize < 0 || req.BatchSize > rtksqs.DefaultBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must not be negative and batch_size at most %d", rtksqs.DefaultBatchSize)
+	}
+
+	id := rtksqs.NewRunID()
+	openOptions := s.openOptions
+	openOptions.RunID = id
+
+	source, err := rtksqs.OpenSource(s.sess, req.Source, openOptions)
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve source queue: %s", err)
+	}
+
+	destination, err := rtksqs.OpenSink(s.sess, req.Destination, openOptions)
+
+	if err != nil {
+		source.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve destination queue: %s", err)
+	}
+
+	total, warnings, err := s.check(source, destination, req)
+
+	if err != nil {
+		source.Close()
+		destination.Close()
+		return nil, err
+	}
+
+	moveCtx, cancel := context.WithCancel(context.Background())
+	move := &serverMove{
+		status: &moverpb.MoveStatus{
+			MoveId:      id,
+			Source:      req.Source,
+			Destination: req.Destination,
+			State:       moverpb.MoveState_MOVE_STATE_RUNNING,
+			Total:       int64(total),
+			StartedAt:   timestamppb.Now(),
+		},
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		cancel()
+		source.Close()
+		destination.Close()
+		return nil, status.Error(codes.Unavailable, "the server is stopping")
+	}
+	s.moves[id] = move
+	s.running.Add(1)
+	s.mu.Unlock()
+
+	go s.run(moveCtx, move, source, destination, total, req.BatchSize)
+
+	return &moverpb.StartMoveResponse{MoveId: id, Warnings: warnings}, nil
+}
+
+// check validates the redrive policies and encryption of a move like the
+// move command and returns the number of messages to move, up to the limit
+// of the request.
+func (s *moveServer) check(source rtksqs.Source, destination rtksqs.Sink, req *moverpb.StartMoveRequest) (int, []string, error) {
+	var warnings []string
+
+	issues, err := rtksqs.ValidateRedrive(source, destination)
+
+	if err != nil {
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to check redrive policies: %s", err)
+	}
+
+	for _, issue := range issues {
+		if issue.Conflict && !req.Force && !*force {
+			return 0, nil, status.Errorf(codes.FailedPrecondition, "redrive policy conflict, set force to move anyway: %s", issue.Message)
+		}
+		warnings = append(warnings, "Redrive policy: "+issue.Message)
+	}
+
+	encryptionIssues, err := rtksqs.ValidateEncryption(s.sess, source, destination)
+
+	if err != nil {
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to check queue encryption: %s", err)
+	}
+
+	for _, issue := range encryptionIssues {
+		if issue.Fatal {
+			return 0, nil, status.Errorf(codes.FailedPrecondition, "sending to the destination would fail: %s", issue.Message)
+		}
+		warnings = append(warnings, "Encryption: "+issue.Message)
+	}
+
+	deduplicationIssue, err := contentDeduplicationIssue(destination)
+
+	if err != nil {
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to check the deduplication of the destination: %s", err)
+	}
+
+	if deduplicationIssue != "" {
+		warnings = append(warnings, "Deduplication: "+deduplicationIssue)
+	}
+
+	total, err := source.ApproximateCount()
+
+	if err != nil {
+		return 0, nil, status.Errorf(codes.Unavailable, "failed to resolve queue attributes: %s", err)
+	}
+
+	if req.Limit > 0 && (total == rtksqs.UnknownCount || total > int(req.Limit)) {
+		total = int(req.Limit)
+	}
+
+	return total, warnings, nil
+}
+
+// run moves the messages of a move and records its outcome.
+func (s *moveServer) run(ctx context.Context, move *serverMove, source rtksqs.Source, destination rtksqs.Sink, total int, batchSize int32) {
+	defer s.running.Done()
+	defer source.Close()
+
+	id := move.status.MoveId
+	logger := log.WithField("move_id", id)
+	logger.Info(color.New(color.FgCyan).Sprintf("Moving from %s to %s", source, destination))
+
+	moveOptions := newMoveOptions(id)
+	moveOptions.Context = ctx
+	moveOptions.Metrics = &rtksqs.MoveMetrics{}
+	if batchSize > 0 {
+		moveOptions.BatchSize = int64(batchSize)
+	}
+	moveOptions.Skipped = func(message *sqs.Message, reason string) {
+		skippedMessages.record(id, move.status.Source, message, skipActionSkipped, reason)
+		move.update(func(status *moverpb.MoveStatus) { status.Skipped++ })
+		logger.Warn(color.New(color.FgYellow).Sprintf("Skipped message %s, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	moveOptions.Discarded = func(message *sqs.Message, reason string) {
+		skippedMessages.record(id, move.status.Source, message, skipActionDeleted, reason)
+		move.update(func(status *moverpb.MoveStatus) { status.Discarded++ })
+		logger.Warn(color.New(color.FgYellow).Sprintf("Deleting message %s from the source, it %s", aws.StringValue(message.MessageId), reason))
+	}
+	moveOptions.Progress = func(moved int) {
+		logger.Debugf("Moved %d messages", moved)
+		move.update(func(status *moverpb.MoveStatus) {
+			status.Moved = int64(moved)
+			setStepMetrics(status, moveOptions.Metrics)
+		})
+	}
+
+	moved := 0
+	var err error
+
+	if total != 0 {
+		moved, err = rtksqs.Move(source, destination, total, moveOptions)
+	}
+
+	if closeErr := destination.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close the destination: %s", closeErr)
+	}
+
+	move.update(func(status *moverpb.MoveStatus) {
+		status.Moved = int64(moved)
+		status.FinishedAt = timestamppb.Now()
+		setStepMetrics(status, moveOptions.Metrics)
+
+		switch {
+		case errors.Is(err, context.Canceled), errors.Is(err, rtksqs.ErrAborted):
+			status.State = moverpb.MoveState_MOVE_STATE_CANCELED
+		case err != nil:
+			status.State = moverpb.MoveState_MOVE_STATE_FAILED
+			status.Error = err.Error()
+		default:
+			status.State = moverpb.MoveState_MOVE_STATE_SUCCEEDED
+		}
+	})
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, rtksqs.ErrAborted):
+		summaryLog.WithField("move_id", id).Warn(color.New(color.FgYellow).Sprintf("Canceled after moving %d messages", moved))
+	case err != nil:
+		summaryLog.WithField("move_id", id).Error(color.New(color.FgRed).Sprintf("Failed after moving %d messages: %s", moved, err))
+	default:
+		summaryLog.WithField("move_id", id).Info(color.New(color.FgCyan).Sprintf("Done. Moved %d messages", moved))
+	}
+
+	time.AfterFunc(finishedMoveRetention, func() {
+		s.mu.Lock()
+		delete(s.moves, id)
+		s.mu.Unlock()
+	})
+}
+
+func (s *moveServer) GetMoveStatus(req *moverpb.GetMoveStatusRequest, stream moverpb.Mover_GetMoveStatusServer) error {
+	move, err := s.move(req.MoveId)
+
+	if err != nil {
+		return err
+	}
+
+	// Only the latest status is sent, a slow follower skips the updates it
+	// missed.
+	for {
+		current, changed := move.snapshot()
+
+		if err := stream.Send(current); err != nil {
+			return err
+		}
+
+		if current.State != moverpb.MoveState_MOVE_STATE_RUNNING {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *moveServer) CancelMove(ctx context.Context, req *moverpb.CancelMoveRequest) (*moverpb.CancelMoveResponse, error) {
+	move, err := s.move(req.MoveId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	move.cancel()
+	current, _ := move.snapshot()
+
+	if current.State == moverpb.MoveState_MOVE_STATE_RUNNING {
+		log.WithField("move_id", req.MoveId).Warn(color.New(color.FgYellow).Sprintf("Canceling the move"))
+	}
+
+	return &moverpb.CancelMoveResponse{Status: current}, nil
+}
+
+// setStepMetrics sets the batches and step metrics of a move's status.
+func setStepMetrics(status *moverpb.MoveStatus, metrics *rtksqs.MoveMetrics) {
+	status.Batches = int64(metrics.Batches())
+	status.Steps = nil
+
+	for _, step := range metrics.Steps() {
+		status.Steps = append(status.Steps, &moverpb.StepMetrics{
+			Step:           step.Step,
+			Calls:          int64(step.Calls),
+			Errors:         int64(step.Errors),
+			AverageLatency: durationpb.New(step.Average()),
+		})
+	}
+}
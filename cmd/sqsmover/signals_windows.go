@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// Windows has no user signals, moves are paused with --control-file only.
+var pauseSignal, resumeSignal os.Signal
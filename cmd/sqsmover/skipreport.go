@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// What happened to a message listed in the --skip-report.
+const (
+	skipActionSkipped     = "skipped"
+	skipActionDeleted     = "deleted"
+	skipActionQuarantined = "quarantined"
+)
+
+// skippedMessage is a line of the --skip-report.
+type skippedMessage struct {
+	Time   time.Time `json:"time"`
+	RunID  string    `json:"runId"`
+	Source string    `json:"source"`
+	ID     string    `json:"id"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+}
+
+// skipReport appends a JSON line for every message a move didn't move to the
+// --skip-report, so none disappears unnoticed. It is safe for concurrent use
+// by the moves of --pairs and serve, a nil report records nothing.
+type skipReport struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	failed  bool
+}
+
+// openSkipReport opens the report at path, appending to an existing one.
+func openSkipReport(path string) (*skipReport, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &skipReport{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// record appends a message of the source which was skipped, deleted or
+// quarantined for reason. A failed write is logged once.
+func (r *skipReport) record(runID, source string, message *sqs.Message, action, reason string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.encoder.Encode(skippedMessage{
+		Time:   time.Now().UTC(),
+		RunID:  runID,
+		Source: source,
+		ID:     aws.StringValue(message.MessageId),
+		Action: action,
+		Reason: rtksqs.Redact(*redactPatterns, reason),
+	})
+
+	if err != nil && !r.failed {
+		r.failed = true
+		log.Error(color.New(color.FgRed).Sprintf("Failed to write the skip report. Error: %s", err))
+	}
+}
+
+func (r *skipReport) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
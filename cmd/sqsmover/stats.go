@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// throughputLogger logs a rollup of the move every interval: the rate over
+// the last interval, the total moved, the remaining estimate refreshed from
+// the source and the ETA.
+type throughputLogger struct {
+	source        rtksqs.Source
+	totalMessages int
+	liveCount     *rtksqs.LiveCount
+	moved         int64
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+func startThroughputLogger(source rtksqs.Source, totalMessages int, liveCount *rtksqs.LiveCount, interval time.Duration) *throughputLogger {
+	l := &throughputLogger{
+		source:        source,
+		totalMessages: totalMessages,
+		liveCount:     liveCount,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	go l.run(interval)
+
+	return l
+}
+
+// update records the number of messages moved so far.
+func (l *throughputLogger) update(moved int) {
+	atomic.StoreInt64(&l.moved, int64(moved))
+}
+
+func (l *throughputLogger) stop() {
+	close(l.done)
+	<-l.stopped
+}
+
+func (l *throughputLogger) run(interval time.Duration) {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastMoved := int64(0)
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			moved := atomic.LoadInt64(&l.moved)
+			rate := float64(moved-lastMoved) / now.Sub(last).Seconds()
+			last, lastMoved = now, moved
+
+			remaining := l.remaining(int(moved))
+
+			if remaining == rtksqs.UnknownCount {
+				log.Info(color.New(color.FgCyan).Sprintf("Moved %d messages, %.1f messages/s", moved, rate))
+				continue
+			}
+
+			eta := "unknown"
+			if rate > 0 {
+				eta = (time.Duration(float64(remaining)/rate) * time.Second).String()
+			}
+
+			log.Info(color.New(color.FgCyan).Sprintf("Moved %d messages, %.1f messages/s, about %d remaining, ETA %s", moved, rate, remaining, eta))
+		}
+	}
+}
+
+// remaining asks the source how many messages it still holds, capped by what
+// is left of the total, refreshed by --count-interval.
+func (l *throughputLogger) remaining(moved int) int {
+	total := l.totalMessages
+	if l.liveCount != nil {
+		total = l.liveCount.Total()
+	}
+
+	left := rtksqs.UnknownCount
+	if total != rtksqs.UnknownCount {
+		left = total - moved
+	}
+
+	count, err := l.source.ApproximateCount()
+
+	if err != nil || count == rtksqs.UnknownCount {
+		return left
+	}
+
+	if left != rtksqs.UnknownCount && left < count {
+		return left
+	}
+
+	return count
+}
+
+// formatSize renders a message size in bytes or KiB.
+func formatSize(size int) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.1fKiB", float64(size)/1024)
+}
+
+// logSizeSummary logs the size percentiles, histogram and attribute counts
+// of the moved messages.
+func logSizeSummary(stats *rtksqs.MessageStats) {
+	summary := stats.Summary()
+
+	if summary.Count == 0 {
+		return
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Message size: average %s, p50 %s, p90 %s, p99 %s, max %s",
+		formatSize(summary.AverageSize), formatSize(summary.P50), formatSize(summary.P90), formatSize(summary.P99), formatSize(summary.MaxSize)))
+
+	lower := 0
+	for i, count := range summary.Histogram {
+		label := fmt.Sprintf("above %s", formatSize(rtksqs.MaxMessageSize))
+		if i < len(rtksqs.HistogramBuckets) {
+			label = fmt.Sprintf("%s - %s", formatSize(lower), formatSize(rtksqs.HistogramBuckets[i]))
+			lower = rtksqs.HistogramBuckets[i]
+		}
+
+		bar := strings.Repeat("█", int(math.Ceil(40*float64(count)/float64(summary.Count))))
+		summaryLog.Info(color.New(color.FgCyan).Sprintf("  %-22s %8d %s", label, count, bar))
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Message attributes: p50 %d, max %d", summary.AttributesP50, summary.AttributesMax))
+
+	if summary.Oversized > 0 {
+		summaryLog.Warn(color.New(color.FgYellow).Sprintf("%d messages are larger than %s, batches of ten of them exceed the %s request limit, consider a smaller --batch-size",
+			summary.Oversized, formatSize(rtksqs.MaxMessageSize/rtksqs.DefaultBatchSize), formatSize(rtksqs.MaxMessageSize)))
+	}
+}
+
+// maxLoggedDimensionValues is the most values of --stats-by logged, the JSON
+// results list all of them.
+const maxLoggedDimensionValues = 20
+
+// newDimensionStats returns the counts of a move by --stats-by, nil without
+// it.
+func newDimensionStats() *rtksqs.DimensionStats {
+	if *statsBy == "" {
+		return nil
+	}
+
+	// The dimension was checked along with the flags.
+	dimensions, _ := rtksqs.NewDimensionStats(*statsBy)
+	return dimensions
+}
+
+// logDimensionCounts logs the moved, failed and skipped messages of every
+// value of --stats-by, the most moved first, prefixed by prefix.
+func logDimensionCounts(prefix string, dimensions *rtksqs.DimensionStats) {
+	counts := dimensions.Counts()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("%sMessages by %s:", prefix, dimensions.Dimension))
+
+	for i, count := range counts {
+		if i == maxLoggedDimensionValues {
+			summaryLog.Info(color.New(color.FgCyan).Sprintf("  and %d more values, listed by --output json", len(counts)-i))
+			break
+		}
+
+		line := fmt.Sprintf("  %-30s moved %d, failed %d, skipped %d", count.Value, count.Moved, count.Failed, count.Skipped)
+		if count.Failed > 0 {
+			summaryLog.Warn(color.New(color.FgYellow).Sprint(line))
+		} else {
+			summaryLog.Info(color.New(color.FgCyan).Sprint(line))
+		}
+	}
+}
+
+// logWorkerMetrics logs the batches a worker moved and the calls, errors and
+// average latency of its steps, e.g. "Worker 2: 118 batches, receive 120 calls
+// averaging 45ms with 0 errors, ...".
+func logWorkerMetrics(worker string, metrics *rtksqs.MoveMetrics) {
+	steps := []string{}
+	failed := false
+
+	for _, step := range metrics.Steps() {
+		steps = append(steps, fmt.Sprintf("%s %d calls averaging %s with %d errors", step.Step, step.Calls, step.Average().Round(time.Millisecond), step.Errors))
+		failed = failed || step.Errors > 0
+	}
+
+	line := fmt.Sprintf("%s: %d batches", worker, metrics.Batches())
+	if len(steps) > 0 {
+		line += ", " + strings.Join(steps, ", ")
+	}
+
+	if failed {
+		summaryLog.Warn(color.New(color.FgYellow).Sprint(line))
+	} else {
+		summaryLog.Info(color.New(color.FgCyan).Sprint(line))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// Statuses of the results written to stdout.
+const (
+	resultSucceeded = "SUCCEEDED"
+	resultFailed    = "FAILED"
+)
+
+// taskInput is the move a task performs. Other fields, e.g. of the state a
+// Step Functions Task state passes on, are ignored.
+type taskInput struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Limit       int    `json:"limit"`
+}
+
+// moveResult is written to stdout when a task ends, for the next state, and
+// when a move ends with --output json.
+type moveResult struct {
+	Status      string `json:"status"`
+	RunID       string `json:"runId"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Total is -1 when the size of the source is unknown.
+	Total   int `json:"total"`
+	Moved   int `json:"moved"`
+	Skipped int `json:"skipped"`
+	Deleted int `json:"deleted"`
+	// Stale counts messages sent before the retention period of the
+	// destination.
+	Stale int `json:"stale"`
+	// Dimensions break the messages down by --stats-by.
+	Dimensions []rtksqs.DimensionCount `json:"dimensions,omitempty"`
+	// Failures lists the first messages a step of the move failed on,
+	// FailureCounts counts all of them by error code.
+	Failures      []rtksqs.MessageFailure `json:"failures,omitempty"`
+	FailureCounts map[string]int          `json:"failureCounts,omitempty"`
+	// Verify is the read back of the destination with --verify.
+	Verify *verifyOutput `json:"verify,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// verifyOutput is the result of reading back the destination with --verify.
+type verifyOutput struct {
+	Verified bool `json:"verified"`
+	Moved    int  `json:"moved"`
+	Read     int  `json:"read"`
+	Missing  int  `json:"missing"`
+	Other    int  `json:"other"`
+}
+
+// readTaskInput reads the input of a task from the argument, or from stdin
+// when there is none.
+func readTaskInput() (taskInput, error) {
+	var r io.Reader = os.Stdin
+	if *taskInputArg != "" {
+		r = strings.NewReader(*taskInputArg)
+	}
+
+	var input taskInput
+	if err := json.NewDecoder(r).Decode(&input); err != nil {
+		return input, fmt.Errorf("failed to read the task input: %s", err)
+	}
+
+	return input, checkMoveInput(input, "the task input")
+}
+
+// checkMoveInput checks the source, destination and limit of a move
+// requested by input, named by what in the errors.
+func checkMoveInput(input taskInput, what string) error {
+	if input.Source == "" || input.Destination == "" {
+		return fmt.Errorf("%s needs a source and a destination", what)
+	}
+
+	if input.Source == rtksqs.StdioSpec || input.Destination == rtksqs.StdioSpec {
+		return fmt.Errorf("stdin and stdout can't be moved by %s, they carry its input and result", what)
+	}
+
+	if input.Limit < 0 {
+		return fmt.Errorf("the limit of %s must not be negative", what)
+	}
+
+	return nil
+}
+
+// runTask performs the move of the task input and writes its result to
+// stdout. It reports whether the move succeeded.
+func runTask(sess *session.Session, openOptions rtksqs.Options) bool {
+	result := moveResult{Status: resultFailed, RunID: openOptions.RunID}
+
+	input, err := readTaskInput()
+
+	if err != nil {
+		log.Error(color.New(color.FgRed).Sprintf("%s", err))
+		result.Error = err.Error()
+		writeTaskResult(result)
+		return false
+	}
+
+	move := &pairMove{queuePair: queuePair{source: input.Source, destination: input.Destination}, limit: input.Limit}
+	if move.limit == 0 {
+		move.limit = *limit
+	}
+
+	err = movePair(sess, openOptions, move, nil, nil)
+	result = move.result(openOptions.RunID, err)
+
+	if err != nil {
+		summaryLog.Error(color.New(color.FgRed).Sprintf("%s: failed after moving %d messages: %s", move.queuePair, move.moved, err))
+		writeTaskResult(result)
+		return false
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("%s: moved %d messages", move.queuePair, move.moved))
+
+	return writeTaskResult(result)
+}
+
+// writeTaskResult writes the result as a single line of JSON to stdout and
+// reports whether it was written.
+func writeTaskResult(result moveResult) bool {
+	return writeJSON("task result", result)
+}
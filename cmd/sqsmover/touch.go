@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// touchOutput is written to stdout when touch ends with --output json.
+type touchOutput struct {
+	Status     string `json:"status"`
+	RunID      string `json:"runId"`
+	Queue      string `json:"queue"`
+	Visibility int64  `json:"visibilitySeconds"`
+	Touched    int    `json:"touched"`
+	Error      string `json:"error,omitempty"`
+}
+
+// touchQueue changes the visibility of the visible messages of --queue to
+// --visibility, and reports whether it succeeded. A queue with a redrive
+// policy is only touched once the plan was confirmed, touching raises the
+// receive counts of its messages.
+func touchQueue(sess *session.Session, openOptions rtksqs.Options) bool {
+	output := &touchOutput{Status: resultFailed, RunID: openOptions.RunID, Queue: *touchQueueName, Visibility: int64(touchVisibility.Seconds())}
+	if jsonOutput() {
+		defer func() { writeJSON("touch result", output) }()
+	}
+
+	description, err := rtksqs.DescribeQueue(sess, *touchQueueName, openOptions)
+	if err != nil {
+		logAwsError("Failed to resolve queue attributes", err)
+		output.Error = err.Error()
+		return false
+	}
+
+	if redrive := description.Attributes[sqs.QueueAttributeNameRedrivePolicy]; redrive != "" && !confirmPlan([]planItem{
+		{"Queue", description.URL},
+		{"Messages", "about " + description.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]},
+		{"Visibility", "set to " + touchVisibility.String()},
+		{"Redrive policy", redrive},
+		{"Receive count", "raised by one, messages beyond maxReceiveCount move to the dead-letter queue"},
+	}) {
+		return false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Touching the visible messages of %s, setting their visibility to %s once all were received", description.URL, *touchVisibility))
+
+	touched, err := rtksqs.Touch(sess, *touchQueueName, openOptions, rtksqs.TouchOptions{
+		Visibility: *touchVisibility,
+		Hold:       *touchHold,
+		Limit:      *limit,
+		Progress: func(received int) {
+			log.Debugf("Received %d messages", received)
+		},
+	})
+	output.Touched = touched
+
+	if err != nil {
+		logBatchError("Failed to touch "+*touchQueueName, err)
+		output.Error = err.Error()
+		return false
+	}
+
+	output.Status = resultSucceeded
+
+	if touched == 0 {
+		summaryLog.Info("Looks like nothing to touch. Done.")
+		return true
+	}
+
+	summaryLog.Info(color.New(color.FgCyan).Sprintf("Done. Set the visibility of %d messages of %s to %s", touched, *touchQueueName, *touchVisibility))
+	return true
+}
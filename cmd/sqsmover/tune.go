@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// tuneDestinationQueue applies --tune-destination to the destination queue
+// and returns a function restoring its attributes. They are restored as well
+// when the run is interrupted, which then exits. It reports false when the
+// attributes couldn't be tuned.
+func tuneDestinationQueue(destination rtksqs.Sink) (func(), bool) {
+	tuning, err := rtksqs.TuneQueue(destination, *tuneDestination)
+
+	if err != nil {
+		logAwsError("Failed to tune the destination queue", err)
+		return nil, false
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Tuned the destination queue, restoring %s when done", tuning.Saved()))
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			signal.Stop(interrupted)
+			close(interrupted)
+
+			if err := tuning.Restore(); err != nil {
+				logAwsError("Failed to restore the destination queue attributes, restore "+tuning.Saved()+" manually", err)
+				return
+			}
+
+			log.Info(color.New(color.FgCyan).Sprintf("Restored the destination queue attributes"))
+		})
+	}
+
+	go func() {
+		if _, ok := <-interrupted; ok {
+			log.Warn(color.New(color.FgYellow).Sprintf("Interrupted, restoring the destination queue attributes"))
+			restore()
+			os.Exit(1)
+		}
+	}()
+
+	return restore, true
+}
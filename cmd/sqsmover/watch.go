@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/fatih/color"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+)
+
+// watchAlarmAndMove polls the --watch-alarm state and runs a move every time
+// the alarm goes into ALARM, until interrupted. An alarm staying in ALARM
+// after a move doesn't start another one, so messages which keep failing
+// aren't redriven in a loop, the alarm has to recover first.
+func watchAlarmAndMove(sess *session.Session, openOptions rtksqs.Options) {
+	plan := append(movePlan(*sourceQueue, *sourceQueue, *destinationQueue, *limit), planItem{"When", "every time alarm " + *watchAlarm + " goes into ALARM"})
+	if (rtksqs.DeletesFromSource(*sourceQueue) || *approval != "") && !confirmPlan(plan) {
+		return
+	}
+
+	svc := cloudwatch.New(sess)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	ticker := time.NewTicker(*watchInterval)
+	defer ticker.Stop()
+
+	log.Info(color.New(color.FgCyan).Sprintf("Watching alarm %s every %s, moving when it goes into ALARM", *watchAlarm, *watchInterval))
+
+	var handled time.Time
+
+	for {
+		resp, err := svc.DescribeAlarms(&cloudwatch.DescribeAlarmsInput{
+			AlarmNames: []*string{aws.String(*watchAlarm)},
+			AlarmTypes: aws.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm, cloudwatch.AlarmTypeCompositeAlarm}),
+		})
+
+		if err != nil {
+			logAwsError("Failed to describe alarm", err)
+		} else if state, updated, ok := alarmState(resp); !ok {
+			log.Error(color.New(color.FgRed).Sprintf("Alarm %s does not exist", *watchAlarm))
+			exitCode = exitFailed
+			return
+		} else if state == cloudwatch.StateValueAlarm && updated.After(handled) {
+			handled = updated
+			log.Info(color.New(color.FgCyan).Sprintf("Alarm %s is in ALARM since %s, moving messages", *watchAlarm, updated.Format(time.RFC3339)))
+			runMove(sess, openOptions)
+		}
+
+		select {
+		case <-interrupted:
+			log.Info(color.New(color.FgCyan).Sprintf("Stopped watching alarm %s", *watchAlarm))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// alarmState returns the state of the only alarm described, and when it last
+// changed.
+func alarmState(resp *cloudwatch.DescribeAlarmsOutput) (string, time.Time, bool) {
+	for _, alarm := range resp.MetricAlarms {
+		return aws.StringValue(alarm.StateValue), aws.TimeValue(alarm.StateUpdatedTimestamp), true
+	}
+
+	for _, alarm := range resp.CompositeAlarms {
+		return aws.StringValue(alarm.StateValue), aws.TimeValue(alarm.StateUpdatedTimestamp), true
+	}
+
+	return "", time.Time{}, false
+}
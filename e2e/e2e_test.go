@@ -0,0 +1,513 @@
+//go:build e2e
+// +build e2e
+
+// Package e2e runs sqsmover against LocalStack started with testcontainers-go
+// and checks the queues after every move. It needs Docker:
+//
+//	go test -tags e2e ./e2e
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const localstackImage = "localstack/localstack:3.0"
+
+const region = "us-east-1"
+
+type harness struct {
+	binary   string
+	endpoint string
+	svc      *sqs.SQS
+}
+
+// h is the harness of the LocalStack container TestMain starts.
+var h *harness
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run starts LocalStack, builds sqsmover and runs the tests, returning the
+// exit code. It's split from TestMain as deferred calls don't run on os.Exit.
+func run(m *testing.M) int {
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        localstackImage,
+			ExposedPorts: []string{"4566/tcp"},
+			Env:          map[string]string{"SERVICES": "sqs"},
+			WaitingFor:   wait.ForHTTP("/_localstack/health").WithPort("4566/tcp").WithStartupTimeout(2 * time.Minute),
+		},
+		Started: true,
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to start LocalStack: %s\n", err)
+		return 1
+	}
+	defer container.Terminate(ctx)
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to resolve LocalStack host: %s\n", err)
+		return 1
+	}
+
+	port, err := container.MappedPort(ctx, "4566/tcp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to resolve LocalStack port: %s\n", err)
+		return 1
+	}
+
+	h, err = newHarness(fmt.Sprintf("http://%s:%s", host, port.Port()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to build sqsmover: %s\n", err)
+		return 1
+	}
+	defer os.RemoveAll(filepath.Dir(h.binary))
+
+	return m.Run()
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(h *harness) error
+	}{
+		{"standard queue with attributes", moveStandard},
+		{"fifo queue keeps group order", moveFifo},
+		{"large bodies", moveLargeBodies},
+		{"partial send failure keeps the failed message in the source", movePartialFailure},
+		{"binary attributes survive dumps", roundTripBinaryAttributes},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.run(h); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// newHarness builds sqsmover and connects to the LocalStack endpoint with its
+// default test credentials.
+func newHarness(endpoint string) (*harness, error) {
+	dir, err := os.MkdirTemp("", "sqsmover-e2e")
+	if err != nil {
+		return nil, err
+	}
+
+	binary := filepath.Join(dir, "sqsmover")
+	// Tests run in the directory of their package.
+	build := exec.Command("go", "build", "-o", binary, "../cmd/sqsmover")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+
+	if err := build.Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	}))
+
+	return &harness{binary: binary, endpoint: endpoint, svc: sqs.New(sess)}, nil
+}
+
+// move runs sqsmover without asking for confirmation and returns its
+// combined output.
+func (h *harness) move(args ...string) (string, error) {
+	cmd := exec.Command(h.binary, append([]string{"-r", region, "-e", h.endpoint, "--yes"}, args...)...)
+	cmd.Env = append(os.Environ(), "AWS_ACCESS_KEY_ID=test", "AWS_SECRET_ACCESS_KEY=test")
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func (h *harness) createQueue(name string, attributes map[string]string) (string, error) {
+	resp, err := h.svc.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: aws.StringMap(attributes),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(resp.QueueUrl), nil
+}
+
+// drain receives every message left in a queue, deleting nothing. The
+// visibility timeout keeps received messages hidden until the drain is done.
+func (h *harness) drain(url string) ([]*sqs.Message, error) {
+	var messages []*sqs.Message
+
+	for {
+		resp, err := h.svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(url),
+			MaxNumberOfMessages:   aws.Int64(10),
+			VisibilityTimeout:     aws.Int64(60),
+			WaitTimeSeconds:       aws.Int64(1),
+			MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+			AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp.Messages) == 0 {
+			return messages, nil
+		}
+
+		messages = append(messages, resp.Messages...)
+	}
+}
+
+func bodies(messages []*sqs.Message) []string {
+	result := make([]string, len(messages))
+	for i, m := range messages {
+		result[i] = aws.StringValue(m.Body)
+	}
+	return result
+}
+
+func sendBodies(h *harness, url string, bodies []string, entry func(i int, e *sqs.SendMessageBatchRequestEntry)) error {
+	for start := 0; start < len(bodies); start += 10 {
+		end := start + 10
+		if end > len(bodies) {
+			end = len(bodies)
+		}
+
+		var entries []*sqs.SendMessageBatchRequestEntry
+		for i := start; i < end; i++ {
+			e := &sqs.SendMessageBatchRequestEntry{Id: aws.String(fmt.Sprint(i)), MessageBody: aws.String(bodies[i])}
+			if entry != nil {
+				entry(i, e)
+			}
+			entries = append(entries, e)
+		}
+
+		resp, err := h.svc.SendMessageBatch(&sqs.SendMessageBatchInput{QueueUrl: aws.String(url), Entries: entries})
+		if err != nil {
+			return err
+		}
+		if len(resp.Failed) > 0 {
+			return fmt.Errorf("seeding failed for %d messages: %s", len(resp.Failed), aws.StringValue(resp.Failed[0].Message))
+		}
+	}
+
+	return nil
+}
+
+func numbered(prefix string, n int) []string {
+	result := make([]string, n)
+	for i := range result {
+		result[i] = fmt.Sprintf("%s-%03d", prefix, i)
+	}
+	return result
+}
+
+func sameBodies(got []*sqs.Message, want []string, ordered bool) error {
+	gotBodies := bodies(got)
+
+	if !ordered {
+		sort.Strings(gotBodies)
+		want = append([]string(nil), want...)
+		sort.Strings(want)
+	}
+
+	if strings.Join(gotBodies, "\n") != strings.Join(want, "\n") {
+		return fmt.Errorf("got %d messages %v, want %d %v", len(gotBodies), gotBodies, len(want), want)
+	}
+
+	return nil
+}
+
+func moveStandard(h *harness) error {
+	source, err := h.createQueue("e2e-standard-source", nil)
+	if err != nil {
+		return err
+	}
+
+	destination, err := h.createQueue("e2e-standard-destination", nil)
+	if err != nil {
+		return err
+	}
+
+	want := numbered("standard", 25)
+	err = sendBodies(h, source, want, func(i int, e *sqs.SendMessageBatchRequestEntry) {
+		e.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			"tenant":  {DataType: aws.String("String"), StringValue: aws.String(fmt.Sprint("tenant-", i%3))},
+			"payload": {DataType: aws.String("Binary"), BinaryValue: []byte{byte(i), 0, 255}},
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if output, err := h.move("-s", "e2e-standard-source", "-d", "e2e-standard-destination"); err != nil {
+		return fmt.Errorf("%s: %s", err, output)
+	}
+
+	moved, err := h.drain(destination)
+	if err != nil {
+		return err
+	}
+
+	if err := sameBodies(moved, want, false); err != nil {
+		return err
+	}
+
+	for _, m := range moved {
+		var i int
+		fmt.Sscanf(aws.StringValue(m.Body), "standard-%d", &i)
+
+		if got := aws.StringValue(m.MessageAttributes["tenant"].StringValue); got != fmt.Sprint("tenant-", i%3) {
+			return fmt.Errorf("message %d has tenant %q", i, got)
+		}
+		if got := m.MessageAttributes["payload"].BinaryValue; len(got) != 3 || got[0] != byte(i) || got[2] != 255 {
+			return fmt.Errorf("message %d has payload %v", i, got)
+		}
+	}
+
+	left, err := h.drain(source)
+	if err != nil {
+		return err
+	}
+
+	return sameBodies(left, nil, false)
+}
+
+func moveFifo(h *harness) error {
+	attributes := map[string]string{sqs.QueueAttributeNameFifoQueue: "true"}
+
+	source, err := h.createQueue("e2e-source.fifo", attributes)
+	if err != nil {
+		return err
+	}
+
+	destination, err := h.createQueue("e2e-destination.fifo", attributes)
+	if err != nil {
+		return err
+	}
+
+	want := numbered("fifo", 30)
+	err = sendBodies(h, source, want, func(i int, e *sqs.SendMessageBatchRequestEntry) {
+		e.MessageGroupId = aws.String("orders")
+		e.MessageDeduplicationId = aws.String(fmt.Sprint("dedup-", i))
+	})
+	if err != nil {
+		return err
+	}
+
+	if output, err := h.move("-s", "e2e-source.fifo", "-d", "e2e-destination.fifo"); err != nil {
+		return fmt.Errorf("%s: %s", err, output)
+	}
+
+	moved, err := h.drain(destination)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range moved {
+		if group := aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]); group != "orders" {
+			return fmt.Errorf("message %d has group %q", i, group)
+		}
+		if dedup := aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]); !strings.HasPrefix(dedup, "dedup-") {
+			return fmt.Errorf("message %d has deduplication id %q", i, dedup)
+		}
+	}
+
+	return sameBodies(moved, want, true)
+}
+
+func moveLargeBodies(h *harness) error {
+	source, err := h.createQueue("e2e-large-source", nil)
+	if err != nil {
+		return err
+	}
+
+	destination, err := h.createQueue("e2e-large-destination", nil)
+	if err != nil {
+		return err
+	}
+
+	// Ten 25KB bodies fill a whole batch close to the 256KB request limit.
+	want := make([]string, 10)
+	for i := range want {
+		want[i] = fmt.Sprintf("%02d", i) + strings.Repeat("x", 25*1024-2)
+	}
+
+	for _, body := range want {
+		if _, err := h.svc.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(source), MessageBody: aws.String(body)}); err != nil {
+			return err
+		}
+	}
+
+	if output, err := h.move("-s", "e2e-large-source", "-d", "e2e-large-destination"); err != nil {
+		return fmt.Errorf("%s: %s", err, output)
+	}
+
+	moved, err := h.drain(destination)
+	if err != nil {
+		return err
+	}
+
+	if len(moved) != len(want) {
+		return fmt.Errorf("moved %d of %d large messages", len(moved), len(want))
+	}
+
+	got := bodies(moved)
+	sort.Strings(got)
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("large message %d was altered", i)
+		}
+	}
+
+	return nil
+}
+
+// roundTripBinaryAttributes dumps binary and custom typed attributes to every
+// dump format and loads them back, they must arrive byte for byte.
+func roundTripBinaryAttributes(h *harness) error {
+	source, err := h.createQueue("e2e-binary-source", nil)
+	if err != nil {
+		return err
+	}
+
+	attributes := func(i int) map[string]*sqs.MessageAttributeValue {
+		return map[string]*sqs.MessageAttributeValue{
+			"event":   {DataType: aws.String("Binary.protobuf"), BinaryValue: []byte{0x0a, byte(i), 0x00, 0xff, '\n', ','}},
+			"version": {DataType: aws.String("Number.int"), StringValue: aws.String(fmt.Sprint(i))},
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "sqsmover-e2e-dumps")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dumps := []string{
+		"file://" + filepath.Join(dir, "dump.ndjson"),
+		"csv://" + filepath.Join(dir, "dump.csv"),
+		"sqlite://" + filepath.Join(dir, "dump.db"),
+	}
+
+	want := numbered("binary", 12)
+
+	for _, dump := range dumps {
+		err = sendBodies(h, source, want, func(i int, e *sqs.SendMessageBatchRequestEntry) {
+			e.MessageAttributes = attributes(i)
+		})
+		if err != nil {
+			return err
+		}
+
+		if output, err := h.move("-s", "e2e-binary-source", "-d", dump, "--csv-columns", "id,body,attributes"); err != nil {
+			return fmt.Errorf("%s: %s", err, output)
+		}
+
+		if output, err := h.move("-s", dump, "-d", "e2e-binary-source"); err != nil {
+			return fmt.Errorf("%s: %s", err, output)
+		}
+
+		loaded, err := h.drain(source)
+		if err != nil {
+			return err
+		}
+
+		if err := sameBodies(loaded, want, false); err != nil {
+			return fmt.Errorf("%s: %s", dump, err)
+		}
+
+		for _, m := range loaded {
+			var i int
+			fmt.Sscanf(aws.StringValue(m.Body), "binary-%d", &i)
+
+			for name, expected := range attributes(i) {
+				got := m.MessageAttributes[name]
+				if got == nil || aws.StringValue(got.DataType) != aws.StringValue(expected.DataType) ||
+					string(got.BinaryValue) != string(expected.BinaryValue) || aws.StringValue(got.StringValue) != aws.StringValue(expected.StringValue) {
+					return fmt.Errorf("%s: message %d has %s %v", dump, i, name, got)
+				}
+			}
+
+			if _, err := h.svc.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(source), ReceiptHandle: m.ReceiptHandle}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// movePartialFailure moves into a queue rejecting large messages. The run
+// must fail and report it, the sent messages of the partially failed batch
+// are deleted from the source and only the rejected one stays there.
+func movePartialFailure(h *harness) error {
+	source, err := h.createQueue("e2e-partial-source", nil)
+	if err != nil {
+		return err
+	}
+
+	destination, err := h.createQueue("e2e-partial-destination", map[string]string{sqs.QueueAttributeNameMaximumMessageSize: "1024"})
+	if err != nil {
+		return err
+	}
+
+	large := strings.Repeat("y", 2048)
+	if err := sendBodies(h, source, []string{"small-1", large, "small-2"}, nil); err != nil {
+		return err
+	}
+
+	output, err := h.move("-s", "e2e-partial-source", "-d", "e2e-partial-destination")
+
+	if err == nil || !strings.Contains(output, "Failed to un-queue messages to the destination") {
+		return fmt.Errorf("send failure was not reported: %s", output)
+	}
+
+	moved, err := h.drain(destination)
+	if err != nil {
+		return err
+	}
+
+	if err := sameBodies(moved, []string{"small-1", "small-2"}, false); err != nil {
+		return fmt.Errorf("destination: %s", err)
+	}
+
+	// Received messages become visible again after the mover's timeout.
+	time.Sleep(3 * time.Second)
+
+	left, err := h.drain(source)
+	if err != nil {
+		return err
+	}
+
+	if err := sameBodies(left, []string{large}, false); err != nil {
+		return fmt.Errorf("source: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package moverpb is the generated gRPC API of sqsmover serve, defined in
+// proto/sqsmover/v1/mover.proto.
+package moverpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/mercury2269/sqsmover --go-grpc_out=../.. --go-grpc_opt=module=github.com/mercury2269/sqsmover sqsmover/v1/mover.proto
@@ -0,0 +1,223 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: sqsmover/v1/mover.proto
+
+package moverpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Mover_StartMove_FullMethodName     = "/sqsmover.v1.Mover/StartMove"
+	Mover_GetMoveStatus_FullMethodName = "/sqsmover.v1.Mover/GetMoveStatus"
+	Mover_CancelMove_FullMethodName    = "/sqsmover.v1.Mover/CancelMove"
+)
+
+// MoverClient is the client API for Mover service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MoverClient interface {
+	// StartMove checks and starts a move, it runs in the background.
+	StartMove(ctx context.Context, in *StartMoveRequest, opts ...grpc.CallOption) (*StartMoveResponse, error)
+	// GetMoveStatus streams the status of a move, first as it is and then
+	// whenever it changes, until the move ended.
+	GetMoveStatus(ctx context.Context, in *GetMoveStatusRequest, opts ...grpc.CallOption) (Mover_GetMoveStatusClient, error)
+	// CancelMove stops a running move after the batch being moved and returns
+	// its status as it is, GetMoveStatus follows it until it ended. Moved
+	// messages stay moved, the others stay in the source.
+	CancelMove(ctx context.Context, in *CancelMoveRequest, opts ...grpc.CallOption) (*CancelMoveResponse, error)
+}
+
+type moverClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMoverClient(cc grpc.ClientConnInterface) MoverClient {
+	return &moverClient{cc}
+}
+
+func (c *moverClient) StartMove(ctx context.Context, in *StartMoveRequest, opts ...grpc.CallOption) (*StartMoveResponse, error) {
+	out := new(StartMoveResponse)
+	err := c.cc.Invoke(ctx, Mover_StartMove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *moverClient) GetMoveStatus(ctx context.Context, in *GetMoveStatusRequest, opts ...grpc.CallOption) (Mover_GetMoveStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Mover_ServiceDesc.Streams[0], Mover_GetMoveStatus_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &moverGetMoveStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Mover_GetMoveStatusClient interface {
+	Recv() (*MoveStatus, error)
+	grpc.ClientStream
+}
+
+type moverGetMoveStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *moverGetMoveStatusClient) Recv() (*MoveStatus, error) {
+	m := new(MoveStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *moverClient) CancelMove(ctx context.Context, in *CancelMoveRequest, opts ...grpc.CallOption) (*CancelMoveResponse, error) {
+	out := new(CancelMoveResponse)
+	err := c.cc.Invoke(ctx, Mover_CancelMove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MoverServer is the server API for Mover service.
+// All implementations must embed UnimplementedMoverServer
+// for forward compatibility
+type MoverServer interface {
+	// StartMove checks and starts a move, it runs in the background.
+	StartMove(context.Context, *StartMoveRequest) (*StartMoveResponse, error)
+	// GetMoveStatus streams the status of a move, first as it is and then
+	// whenever it changes, until the move ended.
+	GetMoveStatus(*GetMoveStatusRequest, Mover_GetMoveStatusServer) error
+	// CancelMove stops a running move after the batch being moved and returns
+	// its status as it is, GetMoveStatus follows it until it ended. Moved
+	// messages stay moved, the others stay in the source.
+	CancelMove(context.Context, *CancelMoveRequest) (*CancelMoveResponse, error)
+	mustEmbedUnimplementedMoverServer()
+}
+
+// UnimplementedMoverServer must be embedded to have forward compatible implementations.
+type UnimplementedMoverServer struct {
+}
+
+func (UnimplementedMoverServer) StartMove(context.Context, *StartMoveRequest) (*StartMoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartMove not implemented")
+}
+func (UnimplementedMoverServer) GetMoveStatus(*GetMoveStatusRequest, Mover_GetMoveStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetMoveStatus not implemented")
+}
+func (UnimplementedMoverServer) CancelMove(context.Context, *CancelMoveRequest) (*CancelMoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelMove not implemented")
+}
+func (UnimplementedMoverServer) mustEmbedUnimplementedMoverServer() {}
+
+// UnsafeMoverServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MoverServer will
+// result in compilation errors.
+type UnsafeMoverServer interface {
+	mustEmbedUnimplementedMoverServer()
+}
+
+func RegisterMoverServer(s grpc.ServiceRegistrar, srv MoverServer) {
+	s.RegisterService(&Mover_ServiceDesc, srv)
+}
+
+func _Mover_StartMove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartMoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MoverServer).StartMove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mover_StartMove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MoverServer).StartMove(ctx, req.(*StartMoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mover_GetMoveStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetMoveStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MoverServer).GetMoveStatus(m, &moverGetMoveStatusServer{stream})
+}
+
+type Mover_GetMoveStatusServer interface {
+	Send(*MoveStatus) error
+	grpc.ServerStream
+}
+
+type moverGetMoveStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *moverGetMoveStatusServer) Send(m *MoveStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Mover_CancelMove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelMoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MoverServer).CancelMove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mover_CancelMove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MoverServer).CancelMove(ctx, req.(*CancelMoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Mover_ServiceDesc is the grpc.ServiceDesc for Mover service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Mover_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sqsmover.v1.Mover",
+	HandlerType: (*MoverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartMove",
+			Handler:    _Mover_StartMove_Handler,
+		},
+		{
+			MethodName: "CancelMove",
+			Handler:    _Mover_CancelMove_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetMoveStatus",
+			Handler:       _Mover_GetMoveStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sqsmover/v1/mover.proto",
+}
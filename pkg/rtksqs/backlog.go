@@ -0,0 +1,69 @@
+package rtksqs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// DefaultBacklogInterval is how often the destination backlog is checked
+// unless MoveOptions.BacklogInterval is set.
+const DefaultBacklogInterval = 10 * time.Second
+
+// backlogThrottle pauses a move while the sink's backlog is above the
+// threshold, so a redrive can't bury a struggling consumer.
+type backlogThrottle struct {
+	sink      BacklogSink
+	threshold int
+	interval  time.Duration
+	lastCheck time.Time
+}
+
+func newBacklogThrottle(sink Sink, threshold int, interval time.Duration) (*backlogThrottle, error) {
+	backlogSink, ok := sink.(BacklogSink)
+
+	if !ok {
+		return nil, fmt.Errorf("%s can not report its backlog", sink)
+	}
+
+	if interval == 0 {
+		interval = DefaultBacklogInterval
+	}
+
+	return &backlogThrottle{sink: backlogSink, threshold: threshold, interval: interval}, nil
+}
+
+// wait returns once the backlog is at most the threshold. The backlog is
+// checked at most once per interval.
+func (t *backlogThrottle) wait() error {
+	if time.Since(t.lastCheck) < t.interval {
+		return nil
+	}
+
+	paused := false
+
+	for {
+		backlog, err := t.sink.ApproximateBacklog()
+		t.lastCheck = time.Now()
+
+		if err != nil {
+			return err
+		}
+
+		if backlog <= t.threshold {
+			if paused {
+				log.Info(color.New(color.FgCyan).Sprintf("Destination backlog is down to %d messages, resuming", backlog))
+			}
+			return nil
+		}
+
+		if !paused {
+			log.Warn(color.New(color.FgYellow).Sprintf("Destination backlog of %d messages exceeds %d, pausing until it is consumed", backlog, t.threshold))
+			paused = true
+		}
+
+		time.Sleep(t.interval)
+	}
+}
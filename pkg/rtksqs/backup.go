@@ -0,0 +1,268 @@
+package rtksqs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const s3Scheme = "s3://"
+
+// DefaultBackupHold is how long BackupAndPurge hides the messages it backs
+// up by default.
+const DefaultBackupHold = time.Hour
+
+// maxBackupHold is the longest visibility timeout SQS accepts.
+const maxBackupHold = 12 * time.Hour
+
+// BackupOptions control BackupAndPurge.
+type BackupOptions struct {
+	// Hold hides the received messages until they are purged, they must be
+	// written, verified and uploaded within it. DefaultBackupHold when 0.
+	Hold time.Duration
+	// Progress is called after every batch with the number of messages
+	// received so far.
+	Progress func(received int)
+	// Uploaded is called with the s3:// URL of every uploaded object.
+	Uploaded func(url string)
+}
+
+// BackupResult tells how far BackupAndPurge got, also when it failed.
+type BackupResult struct {
+	// Messages is the number of messages backed up.
+	Messages int
+	// Objects are the s3:// URLs of the uploaded dump parts and manifest.
+	Objects []string
+	// Purged is the number of messages deleted from the queue.
+	Purged int
+}
+
+// heldSource receives messages without deleting them, they are deleted once
+// the backup was uploaded.
+type heldSource struct {
+	*queueSource
+	held []*sqs.Message
+	seen map[string]bool
+}
+
+func (s *heldSource) Receive(max int64) ([]*sqs.Message, error) {
+	messages, err := s.queueSource.Receive(max)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		id := aws.StringValue(message.MessageId)
+		if s.seen[id] {
+			return nil, fmt.Errorf("message %s was received twice, the backup took longer than the hold", id)
+		}
+		s.seen[id] = true
+	}
+
+	return messages, nil
+}
+
+func (s *heldSource) Delete(messages []*sqs.Message) error {
+	for _, message := range messages {
+		s.held = append(s.held, receiptOf(message))
+	}
+	return nil
+}
+
+// parseS3URL splits an s3://bucket/prefix URL.
+func parseS3URL(url string) (string, string, error) {
+	if !strings.HasPrefix(url, s3Scheme) {
+		return "", "", fmt.Errorf("%s is no %sbucket/prefix URL", url, s3Scheme)
+	}
+
+	bucket, prefix := strings.TrimPrefix(url, s3Scheme), ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, prefix = bucket[:i], strings.Trim(bucket[i+1:], "/")
+	}
+
+	if bucket == "" {
+		return "", "", fmt.Errorf("%s names no bucket", url)
+	}
+
+	return bucket, prefix, nil
+}
+
+// BackupAndPurge dumps every message of the queue to a file:// dump with a
+// manifest, written as options ask, e.g. compressed or encrypted. It reads the
+// dump back to verify it, uploads its parts and then its manifest below the
+// s3://bucket/prefix URL to, and only then purges the queue. Received messages
+// are hidden for the hold instead of deleted, the purge deletes exactly them,
+// so messages sent meanwhile stay in the queue. Nothing is purged when a step
+// failed, the messages are made visible again. FIFO queues can't be backed up, hiding their messages blocks their
+// message groups.
+func BackupAndPurge(sess *session.Session, queue, to string, options Options, backup BackupOptions) (*BackupResult, error) {
+	result := &BackupResult{}
+
+	hold := backup.Hold
+	if hold == 0 {
+		hold = DefaultBackupHold
+	}
+	if hold > maxBackupHold {
+		return result, fmt.Errorf("the hold can be at most %s", maxBackupHold)
+	}
+
+	bucket, prefix, err := parseS3URL(to)
+	if err != nil {
+		return result, err
+	}
+
+	if strings.HasSuffix(queue, ".fifo") {
+		return result, fmt.Errorf("FIFO queue %s can't be backed up without blocking its message groups", queue)
+	}
+
+	// The purged messages are only recoverable from an unredacted backup.
+	if len(options.Redact) > 0 {
+		return result, fmt.Errorf("a backup can't be redacted, it must restore the purged messages unchanged")
+	}
+
+	source, err := openQueueSource(options.sqsClient(sess), queue)
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	source.visibilityTimeout = int64(hold / time.Second)
+	held := &heldSource{queueSource: source, seen: map[string]bool{}}
+
+	// Messages which weren't purged are made visible again instead of staying
+	// hidden for the rest of the hold.
+	defer func() {
+		if rest := held.held[result.Purged:]; len(rest) > 0 {
+			source.release(rest)
+		}
+	}()
+
+	dir, err := os.MkdirTemp("", "sqsmover-backup-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(dir)
+
+	name := queue + "-" + start.UTC().Format("20060102T150405Z")
+	if options.RunID != "" {
+		name += "-" + options.RunID
+	}
+	dump := fileScheme + filepath.Join(dir, name+".ndjson")
+
+	// Bodies are dumped as received, decoding would change what is verified.
+	options.Decode = ""
+	options.OffloadLarge = ""
+	options.PrewarmGroups = false
+
+	sink, err := OpenSink(sess, dump, options)
+	if err != nil {
+		return result, err
+	}
+
+	audit := &MoveAudit{}
+	result.Messages, err = Move(held, sink, UnknownCount, MoveOptions{Audit: audit, Progress: backup.Progress})
+
+	if closeErr := sink.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return result, fmt.Errorf("failed to dump the messages: %s", err)
+	}
+
+	if result.Messages == 0 {
+		return result, nil
+	}
+
+	verified, err := VerifyMove(sess, dump, options, audit)
+	if err != nil {
+		return result, fmt.Errorf("failed to verify the dump: %s", err)
+	}
+	if !verified.Verified() {
+		return result, fmt.Errorf("%d of %d messages are missing from the dump", verified.Missing, verified.Moved)
+	}
+
+	dumpPath := strings.TrimPrefix(dump, fileScheme)
+	files, err := resolveDumpParts(dumpPath)
+	if err != nil {
+		return result, err
+	}
+
+	// The manifest goes last, a dump without one was not fully uploaded.
+	files = append(files, manifestPath(dumpPath))
+
+	uploader := s3manager.NewUploader(sess)
+	for _, file := range files {
+		url, err := uploadFile(uploader, bucket, prefix, file)
+		if err != nil {
+			return result, fmt.Errorf("failed to upload %s: %s", filepath.Base(file), err)
+		}
+
+		result.Objects = append(result.Objects, url)
+		if backup.Uploaded != nil {
+			backup.Uploaded(url)
+		}
+	}
+
+	// Deleting with expired receipt handles could delete messages received
+	// by a consumer meanwhile, after a minute to spare.
+	if time.Since(start) > hold-time.Minute {
+		return result, fmt.Errorf("the backup took longer than the hold of %s, the messages may be visible again", hold)
+	}
+
+	for i := 0; i < len(held.held); i += DefaultBatchSize {
+		end := i + DefaultBatchSize
+		if end > len(held.held) {
+			end = len(held.held)
+		}
+
+		if err := source.Delete(held.held[i:end]); err != nil {
+			return result, fmt.Errorf("failed to purge the queue: %w", err)
+		}
+		result.Purged = end
+	}
+
+	return result, nil
+}
+
+// uploadFile uploads a file below the prefix of the bucket and checks that
+// the object has the size of the file. It returns the s3:// URL of the
+// object.
+func uploadFile(uploader *s3manager.Uploader, bucket, prefix, file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := path.Join(prefix, filepath.Base(file))
+
+	if _, err := uploader.Upload(&s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: f}); err != nil {
+		return "", err
+	}
+
+	head, err := uploader.S3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", err
+	}
+
+	if aws.Int64Value(head.ContentLength) != info.Size() {
+		return "", fmt.Errorf("uploaded %d bytes but the object holds %d", info.Size(), aws.Int64Value(head.ContentLength))
+	}
+
+	return s3Scheme + bucket + "/" + key, nil
+}
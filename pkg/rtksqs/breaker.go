@@ -0,0 +1,112 @@
+package rtksqs
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// Defaults of the send circuit breaker, see MoveOptions.SendErrorThreshold.
+const (
+	DefaultSendErrorWindow = time.Minute
+	DefaultBreakerBackoff  = 10 * time.Second
+	DefaultBreakerTrips    = 3
+)
+
+// maxBreakerBackoff caps the doubling pause of a breaker tripping repeatedly.
+const maxBreakerBackoff = 5 * time.Minute
+
+// sendBreaker is a circuit breaker on failed sends. It trips when more sends
+// than the threshold failed within the window and pauses the move, so a
+// misconfigured destination isn't hammered. After a pause the next send
+// decides: a success closes the breaker, a failure trips it again with twice
+// the pause.
+type sendBreaker struct {
+	threshold int
+	window    time.Duration
+	backoff   time.Duration
+	maxTrips  int
+
+	failures []time.Time
+	trips    int
+	halfOpen bool
+}
+
+func newSendBreaker(options MoveOptions) *sendBreaker {
+	b := &sendBreaker{
+		threshold: options.SendErrorThreshold,
+		window:    options.SendErrorWindow,
+		backoff:   options.BreakerBackoff,
+		maxTrips:  options.BreakerTrips,
+	}
+
+	if b.window == 0 {
+		b.window = DefaultSendErrorWindow
+	}
+
+	if b.backoff == 0 {
+		b.backoff = DefaultBreakerBackoff
+	}
+
+	if b.maxTrips == 0 {
+		b.maxTrips = DefaultBreakerTrips
+	}
+
+	return b
+}
+
+// failed records a failed send and pauses when the breaker trips. It reports
+// false once sending still fails after maxTrips pauses in a row and the move
+// must stop.
+func (b *sendBreaker) failed() bool {
+	now := time.Now()
+
+	if !b.halfOpen {
+		b.failures = append(b.failures, now)
+
+		recent := b.failures[:0]
+		for _, failure := range b.failures {
+			if now.Sub(failure) < b.window {
+				recent = append(recent, failure)
+			}
+		}
+		b.failures = recent
+
+		if len(b.failures) <= b.threshold {
+			return true
+		}
+	}
+
+	b.trips++
+	b.failures = nil
+
+	if b.trips > b.maxTrips {
+		log.Error(color.New(color.FgRed).Sprintf("Sending still fails after %d pauses, stopping", b.maxTrips))
+		return false
+	}
+
+	pause := b.backoff << (b.trips - 1)
+	if pause > maxBreakerBackoff {
+		pause = maxBreakerBackoff
+	}
+
+	if b.halfOpen {
+		log.Warn(color.New(color.FgYellow).Sprintf("Sending still fails, pausing for %s", pause))
+	} else {
+		log.Warn(color.New(color.FgYellow).Sprintf("More than %d sends failed within %s, pausing for %s", b.threshold, b.window, pause))
+	}
+	time.Sleep(pause)
+	b.halfOpen = true
+
+	return true
+}
+
+// succeeded records a successful send, closing a tripped breaker.
+func (b *sendBreaker) succeeded() {
+	if b.halfOpen {
+		log.Info(color.New(color.FgCyan).Sprintf("Sending recovered"))
+		b.halfOpen = false
+		b.trips = 0
+	}
+}
@@ -0,0 +1,164 @@
+package rtksqs
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// chaosSQS injects throttling, network errors and partial batch failures into
+// the calls a move makes. Faults are injected before a request reaches SQS,
+// so a failed call or entry never took effect.
+type chaosSQS struct {
+	sqsiface.SQSAPI
+	rate float64
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// WithChaos wraps an SQS client so ReceiveMessage, SendMessage,
+// SendMessageBatch, DeleteMessageBatch and ChangeMessageVisibilityBatch
+// calls, and single entries of batches, fail with the given probability. It is meant for soak tests of retry and recovery logic.
+func WithChaos(api sqsiface.SQSAPI, rate float64, seed int64) sqsiface.SQSAPI {
+	return &chaosSQS{SQSAPI: api, rate: rate, random: rand.New(rand.NewSource(seed))}
+}
+
+func (c *chaosSQS) roll() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.random.Float64() < c.rate
+}
+
+// fault returns a throttling or network error for a whole call, or nil.
+func (c *chaosSQS) fault() error {
+	if !c.roll() {
+		return nil
+	}
+
+	if c.roll() {
+		return awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("chaos: connection reset by peer"))
+	}
+
+	return awserr.New("RequestThrottled", "chaos: request throttled", nil)
+}
+
+func chaosFailure(id *string) *sqs.BatchResultErrorEntry {
+	return &sqs.BatchResultErrorEntry{Id: id, Code: aws.String("InternalError"), Message: aws.String("chaos: entry failed"), SenderFault: aws.Bool(false)}
+}
+
+func (c *chaosSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+	return c.SQSAPI.ReceiveMessage(input)
+}
+
+func (c *chaosSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+	return c.SQSAPI.SendMessage(input)
+}
+
+func (c *chaosSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+
+	var failed []*sqs.BatchResultErrorEntry
+	var entries []*sqs.SendMessageBatchRequestEntry
+
+	for _, entry := range input.Entries {
+		if c.roll() {
+			failed = append(failed, chaosFailure(entry.Id))
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+
+	if len(entries) > 0 {
+		var err error
+		forwarded := *input
+		forwarded.Entries = entries
+
+		if output, err = c.SQSAPI.SendMessageBatch(&forwarded); err != nil {
+			return nil, err
+		}
+	}
+
+	output.Failed = append(output.Failed, failed...)
+	return output, nil
+}
+
+func (c *chaosSQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+
+	var failed []*sqs.BatchResultErrorEntry
+	var entries []*sqs.DeleteMessageBatchRequestEntry
+
+	for _, entry := range input.Entries {
+		if c.roll() {
+			failed = append(failed, chaosFailure(entry.Id))
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	output := &sqs.DeleteMessageBatchOutput{}
+
+	if len(entries) > 0 {
+		var err error
+		forwarded := *input
+		forwarded.Entries = entries
+
+		if output, err = c.SQSAPI.DeleteMessageBatch(&forwarded); err != nil {
+			return nil, err
+		}
+	}
+
+	output.Failed = append(output.Failed, failed...)
+	return output, nil
+}
+
+func (c *chaosSQS) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+
+	var failed []*sqs.BatchResultErrorEntry
+	var entries []*sqs.ChangeMessageVisibilityBatchRequestEntry
+
+	for _, entry := range input.Entries {
+		if c.roll() {
+			failed = append(failed, chaosFailure(entry.Id))
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+
+	if len(entries) > 0 {
+		var err error
+		forwarded := *input
+		forwarded.Entries = entries
+
+		if output, err = c.SQSAPI.ChangeMessageVisibilityBatch(&forwarded); err != nil {
+			return nil, err
+		}
+	}
+
+	output.Failed = append(output.Failed, failed...)
+	return output, nil
+}
@@ -0,0 +1,50 @@
+package rtksqs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// userAgentName identifies sqsmover in the user agent of SQS requests, e.g.
+// in CloudTrail.
+const userAgentName = "sqsmover"
+
+// NewSQSClientFromConfig returns an SQS client for Options.SQS built from
+// config on top of the environment and the shared config, e.g. with the
+// credentials of a custom provider, a region or an endpoint, so an
+// application configuring AWS itself doesn't need a session for the queues.
+func NewSQSClientFromConfig(config aws.Config) (sqsiface.SQSAPI, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{Config: config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQSClientWithAPI(sqs.New(sess)), nil
+}
+
+// NewSQSClientWithAPI returns an SQS client for Options.SQS making its calls
+// with api, e.g. the client of an existing session, instrumented with tracing
+// or metrics, or a fake. A copy of an *sqs.SQS identifies sqsmover in its
+// user agent, api itself isn't changed, other implementations are returned
+// as they are. Options.Chaos still injects faults into its calls.
+func NewSQSClientWithAPI(api sqsiface.SQSAPI) sqsiface.SQSAPI {
+	client, ok := api.(*sqs.SQS)
+	if !ok || client == nil || client.Client == nil {
+		return api
+	}
+
+	return withUserAgent(client)
+}
+
+// withUserAgent returns a copy of client identifying sqsmover in its user
+// agent, client itself isn't changed.
+func withUserAgent(client *sqs.SQS) *sqs.SQS {
+	copied := *client.Client
+	copied.Handlers = client.Handlers.Copy()
+	copied.Handlers.Build.PushBack(request.MakeAddToUserAgentHandler(userAgentName, Version))
+
+	return &sqs.SQS{Client: &copied}
+}
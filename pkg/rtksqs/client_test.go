@@ -0,0 +1,33 @@
+package rtksqs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+func TestNewSQSClientWithAPI(t *testing.T) {
+	fake := fakesqs.New()
+	if got := NewSQSClientWithAPI(fake); got != fake {
+		t.Errorf("got %T, want the fake as it is", got)
+	}
+
+	client := sqs.New(session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")})))
+	handlers := client.Handlers.Build.Len()
+
+	copied, ok := NewSQSClientWithAPI(client).(*sqs.SQS)
+	if !ok || copied == client {
+		t.Fatalf("got %T, want a copy of the client", copied)
+	}
+
+	if got := copied.Handlers.Build.Len(); got != handlers+1 {
+		t.Errorf("copy has %d build handlers, want %d with the user agent", got, handlers+1)
+	}
+
+	if got := client.Handlers.Build.Len(); got != handlers {
+		t.Errorf("client has %d build handlers, want it unchanged with %d", got, handlers)
+	}
+}
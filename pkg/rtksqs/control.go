@@ -0,0 +1,98 @@
+package rtksqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAborted is returned by moves aborted with MoveControl.Abort.
+var ErrAborted = errors.New("the move was aborted")
+
+// MoveControl pauses, resumes and aborts moves before they receive the next
+// batch, e.g. on command of an operator. It is safe for concurrent use and
+// may be shared by moves. The zero value is not usable, use NewMoveControl.
+type MoveControl struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+	aborted chan struct{}
+}
+
+// NewMoveControl returns a control of running moves.
+func NewMoveControl() *MoveControl {
+	return &MoveControl{aborted: make(chan struct{})}
+}
+
+// Pause stops the moves from receiving until they are resumed.
+func (c *MoveControl) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resumed == nil {
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets paused moves go on.
+func (c *MoveControl) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resumed != nil {
+		close(c.resumed)
+		c.resumed = nil
+	}
+}
+
+// Abort stops the moves, paused or not, they return ErrAborted.
+func (c *MoveControl) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.aborted:
+	default:
+		close(c.aborted)
+	}
+}
+
+// Paused reports whether the moves are paused.
+func (c *MoveControl) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.resumed != nil
+}
+
+// wait blocks while the moves are paused and returns ErrAborted once they
+// are aborted, or the error of ctx once it is done.
+func (c *MoveControl) wait(ctx context.Context) error {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	for {
+		c.mu.Lock()
+		resumed := c.resumed
+		c.mu.Unlock()
+
+		select {
+		case <-c.aborted:
+			return ErrAborted
+		default:
+		}
+
+		if resumed == nil {
+			return nil
+		}
+
+		select {
+		case <-resumed:
+		case <-c.aborted:
+			return ErrAborted
+		case <-done:
+			return ctx.Err()
+		}
+	}
+}
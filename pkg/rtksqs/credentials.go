@@ -0,0 +1,78 @@
+package rtksqs
+
+import (
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/fatih/color"
+)
+
+// refreshingRetryer keeps retrying calls which failed because the credentials
+// expired. The SDK expires the credentials before every retry, so they are
+// fetched again from the credential chain, e.g. by reading a credentials file
+// another tool renewed or running the credential_process of a profile again.
+// Other errors are retried as by the default retryer.
+type refreshingRetryer struct {
+	client.DefaultRetryer
+	interval time.Duration
+	attempts int
+}
+
+func (r refreshingRetryer) MaxRetries() int {
+	return r.DefaultRetryer.MaxRetries() + r.attempts
+}
+
+func (r refreshingRetryer) ShouldRetry(req *request.Request) bool {
+	if req.IsErrorExpired() {
+		return true
+	}
+
+	if req.RetryCount >= r.DefaultRetryer.MaxRetries() {
+		return false
+	}
+
+	return r.DefaultRetryer.ShouldRetry(req)
+}
+
+func (r refreshingRetryer) RetryRules(req *request.Request) time.Duration {
+	if !req.IsErrorExpired() {
+		return r.DefaultRetryer.RetryRules(req)
+	}
+
+	log.Warn(color.New(color.FgYellow).Sprintf("The AWS credentials expired, refreshing them in %s", r.interval))
+	return r.interval
+}
+
+// WithCredentialRefresh configures clients to wait for fresh credentials when
+// a call fails because they expired, refreshing them every interval for up
+// to timeout, and retry the call instead of failing, so a long move outlives
+// temporary credentials. Static credentials never refresh, their calls fail
+// once the timeout passed.
+func WithCredentialRefresh(cfg *aws.Config, interval, timeout time.Duration) *aws.Config {
+	return request.WithRetryer(cfg, refreshingRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries},
+		interval:       interval,
+		attempts:       int(timeout / interval),
+	})
+}
+
+// IsExpiredCredentials reports whether a call failed because the
+// credentials expired, or any message of a BatchError did.
+func IsExpiredCredentials(err error) bool {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		for _, failure := range batchErr.Failures {
+			if request.IsErrorExpiredCreds(awserr.New(failure.Code, failure.Message, nil)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return request.IsErrorExpiredCreds(err)
+}
@@ -0,0 +1,379 @@
+package rtksqs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const csvScheme = "csv://"
+
+// DefaultCsvColumns are the columns written to a csv:// destination unless
+// Options.CsvColumns is set.
+const DefaultCsvColumns = "id,body,sent_timestamp"
+
+// csvColumn reads and writes a single field of a message. Columns are
+// addressed by name: id, body, md5, sent_timestamp, group_id,
+// deduplication_id, attributes, attr:<message attribute> and sys:<system
+// attribute>.
+type csvColumn struct {
+	name string
+	get  func(*sqs.Message) string
+	set  func(*sqs.Message, string) error
+}
+
+func systemAttributeColumn(name, attribute string) csvColumn {
+	return csvColumn{
+		name: name,
+		get: func(m *sqs.Message) string {
+			return aws.StringValue(m.Attributes[attribute])
+		},
+		set: func(m *sqs.Message, value string) error {
+			if value == "" {
+				return nil
+			}
+			if m.Attributes == nil {
+				m.Attributes = map[string]*string{}
+			}
+			m.Attributes[attribute] = aws.String(value)
+			return nil
+		},
+	}
+}
+
+// messageAttributeColumn holds the value of a single message attribute,
+// binary values base64 encoded. Values are loaded as String attributes, the
+// attributes column keeps data types and binary values.
+func messageAttributeColumn(name, attribute string) csvColumn {
+	return csvColumn{
+		name: name,
+		get: func(m *sqs.Message) string {
+			value, ok := m.MessageAttributes[attribute]
+			if !ok {
+				return ""
+			}
+			if value.BinaryValue != nil {
+				return base64.StdEncoding.EncodeToString(value.BinaryValue)
+			}
+			return aws.StringValue(value.StringValue)
+		},
+		set: func(m *sqs.Message, value string) error {
+			if value == "" {
+				return nil
+			}
+			if m.MessageAttributes == nil {
+				m.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+			}
+			// A value loaded from the attributes column keeps its type.
+			if _, ok := m.MessageAttributes[attribute]; ok {
+				return nil
+			}
+			m.MessageAttributes[attribute] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+			return nil
+		},
+	}
+}
+
+// messageAttributesColumn holds every message attribute as a JSON object in
+// the NDJSON format, so data types and binary values survive a round trip.
+func messageAttributesColumn(name string) csvColumn {
+	return csvColumn{
+		name: name,
+		get: func(m *sqs.Message) string {
+			records := newAttributeRecords(m.MessageAttributes)
+			if records == nil {
+				return ""
+			}
+			encoded, _ := json.Marshal(records)
+			return string(encoded)
+		},
+		set: func(m *sqs.Message, value string) error {
+			if value == "" {
+				return nil
+			}
+			var records map[string]attributeRecord
+			if err := json.Unmarshal([]byte(value), &records); err != nil {
+				return fmt.Errorf("invalid %s column: %s", name, err)
+			}
+			if m.MessageAttributes == nil {
+				m.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+			}
+			for attribute, record := range records {
+				m.MessageAttributes[attribute] = record.value()
+			}
+			return nil
+		},
+	}
+}
+
+func parseCsvColumn(name string) (csvColumn, error) {
+	switch {
+	case name == "id":
+		return csvColumn{
+			name: name,
+			get:  func(m *sqs.Message) string { return aws.StringValue(m.MessageId) },
+			set: func(m *sqs.Message, value string) error {
+				m.MessageId = aws.String(value)
+				return nil
+			},
+		}, nil
+	case name == "body":
+		return csvColumn{
+			name: name,
+			get:  func(m *sqs.Message) string { return aws.StringValue(m.Body) },
+			set: func(m *sqs.Message, value string) error {
+				m.Body = aws.String(value)
+				return nil
+			},
+		}, nil
+	case name == "md5":
+		return csvColumn{
+			name: name,
+			get:  func(m *sqs.Message) string { return aws.StringValue(m.MD5OfBody) },
+			set: func(m *sqs.Message, value string) error {
+				if value != "" {
+					m.MD5OfBody = aws.String(value)
+				}
+				return nil
+			},
+		}, nil
+	case name == "sent_timestamp":
+		return systemAttributeColumn(name, sqs.MessageSystemAttributeNameSentTimestamp), nil
+	case name == "group_id":
+		return systemAttributeColumn(name, sqs.MessageSystemAttributeNameMessageGroupId), nil
+	case name == "deduplication_id":
+		return systemAttributeColumn(name, sqs.MessageSystemAttributeNameMessageDeduplicationId), nil
+	case name == "attributes":
+		return messageAttributesColumn(name), nil
+	case strings.HasPrefix(name, "sys:") && len(name) > len("sys:"):
+		return systemAttributeColumn(name, strings.TrimPrefix(name, "sys:")), nil
+	case strings.HasPrefix(name, "attr:") && len(name) > len("attr:"):
+		return messageAttributeColumn(name, strings.TrimPrefix(name, "attr:")), nil
+	default:
+		return csvColumn{}, fmt.Errorf("unknown csv column %q", name)
+	}
+}
+
+func parseCsvColumns(names []string) ([]csvColumn, error) {
+	columns := make([]csvColumn, len(names))
+	for i, name := range names {
+		column, err := parseCsvColumn(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+// csvSource reads messages from CSV files, the header row of every part
+// names its columns.
+type csvSource struct {
+	path    string
+	dump    *dumpReader
+	reader  *csv.Reader
+	columns []csvColumn
+}
+
+func openCsvSource(spec string, identities []age.Identity) (*csvSource, error) {
+	path := strings.TrimPrefix(spec, csvScheme)
+	dump, err := openDumpReader(path, identities)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source := &csvSource{path: path, dump: dump}
+
+	if err := source.nextPart(); err != nil {
+		dump.Close()
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// nextPart starts reading the next part of the dump, returning io.EOF once
+// there are no more parts.
+func (s *csvSource) nextPart() error {
+	part, err := s.dump.next()
+
+	if err != nil {
+		return err
+	}
+
+	s.reader = csv.NewReader(part)
+	header, err := s.reader.Read()
+
+	if err != nil {
+		return fmt.Errorf("unable to read csv header from %s: %s", s.path, err)
+	}
+
+	s.columns, err = parseCsvColumns(header)
+	return err
+}
+
+func (s *csvSource) String() string {
+	return csvScheme + s.path
+}
+
+func (s *csvSource) ApproximateCount() (int, error) {
+	return UnknownCount, nil
+}
+
+func (s *csvSource) Receive(max int64) ([]*sqs.Message, error) {
+	var messages []*sqs.Message
+
+	// The reader is nil once every part was read.
+	for s.reader != nil && int64(len(messages)) < max {
+		row, err := s.reader.Read()
+
+		if err == io.EOF {
+			if err := s.nextPart(); err == io.EOF {
+				s.reader = nil
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		message := &sqs.Message{}
+		for i, column := range s.columns {
+			if err := column.set(message, row[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		messages = append(messages, message)
+		s.dump.countMessage(len(aws.StringValue(message.Body)))
+	}
+
+	if len(messages) == 0 {
+		return nil, s.dump.verify()
+	}
+
+	return messages, nil
+}
+
+// Delete is a no-op, rows which were read are consumed.
+func (s *csvSource) Delete(messages []*sqs.Message) error {
+	return nil
+}
+
+func (s *csvSource) Close() error {
+	return s.dump.Close()
+}
+
+// csvSink writes messages to CSV files with the configured columns.
+type csvSink struct {
+	path    string
+	file    *dumpFileWriter
+	columns []csvColumn
+	// hasBody is set when the body is exported and counts towards the
+	// manifest's body bytes.
+	hasBody bool
+	// redact masks their matches in the written messages.
+	redact []*regexp.Regexp
+}
+
+func openCsvSink(spec, columnNames string, options dumpOptions) (*csvSink, error) {
+	path := strings.TrimPrefix(spec, csvScheme)
+
+	if columnNames == "" {
+		columnNames = DefaultCsvColumns
+	}
+
+	columns, err := parseCsvColumns(strings.Split(columnNames, ","))
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]string, len(columns))
+	hasBody := false
+	for i, column := range columns {
+		header[i] = column.name
+		hasBody = hasBody || column.name == "body"
+	}
+
+	headerRow, err := encodeCsvRow(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := newDumpFileWriter(path, options, headerRow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvSink{path: path, file: file, columns: columns, hasBody: hasBody}, nil
+}
+
+func encodeCsvRow(row []string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write(row); err != nil {
+		return nil, err
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func (s *csvSink) String() string {
+	return csvScheme + s.path
+}
+
+func (s *csvSink) Send(messages []*sqs.Message) error {
+	row := make([]string, len(s.columns))
+
+	for _, message := range messages {
+		message = redactMessage(s.redact, message)
+
+		for i, column := range s.columns {
+			row[i] = column.get(message)
+		}
+
+		encoded, err := encodeCsvRow(row)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.file.Write(encoded); err != nil {
+			return err
+		}
+
+		if s.hasBody {
+			s.file.countMessage(len(aws.StringValue(message.Body)))
+		} else {
+			s.file.countMessage(0)
+		}
+	}
+
+	return nil
+}
+
+func (s *csvSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,203 @@
+package rtksqs
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+// fillFakeQueue creates a queue of the fake holding the messages and returns
+// its URL.
+func fillFakeQueue(t *testing.T, fake *fakesqs.SQS, name string, messages ...*sqs.SendMessageInput) string {
+	t.Helper()
+
+	url := createFakeQueue(t, fake, name)
+	for _, message := range messages {
+		message.QueueUrl = aws.String(url)
+		if _, err := fake.SendMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return url
+}
+
+// receiveFakeQueue receives every message of a queue of the fake with all
+// message attributes.
+func receiveFakeQueue(t *testing.T, fake *fakesqs.SQS, url string) []*sqs.Message {
+	t.Helper()
+
+	var messages []*sqs.Message
+	for {
+		resp, err := fake.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(url),
+			MaxNumberOfMessages:   aws.Int64(DefaultBatchSize),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			VisibilityTimeout:     aws.Int64(60),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Messages) == 0 {
+			return messages
+		}
+		messages = append(messages, resp.Messages...)
+	}
+}
+
+// dumpFakeQueue moves every message of the queue to the sink and closes it.
+func dumpFakeQueue(t *testing.T, fake *fakesqs.SQS, name string, sink Sink) {
+	t.Helper()
+
+	source, err := openQueueSource(fake, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Move(source, sink, UnknownCount, MoveOptions{}); err != nil {
+		t.Fatalf("failed to dump %s: %s", name, err)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadFakeQueue moves every message of the source to a new queue of the fake
+// and returns its messages.
+func loadFakeQueue(t *testing.T, fake *fakesqs.SQS, name string, source Source) []*sqs.Message {
+	t.Helper()
+
+	url := createFakeQueue(t, fake, name)
+	sink, err := openQueueSink(fake, name, "", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Move(source, sink, UnknownCount, MoveOptions{}); err != nil {
+		t.Fatalf("failed to load %s: %s", name, err)
+	}
+
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return receiveFakeQueue(t, fake, url)
+}
+
+func TestCsvRoundTrip(t *testing.T) {
+	color := &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("blue")}
+	blob := &sqs.MessageAttributeValue{DataType: aws.String("Binary"), BinaryValue: []byte{0, 1, 2}}
+	count := &sqs.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String("3")}
+
+	tests := []struct {
+		name    string
+		columns string
+		// wantAttributes are the message attributes of the loaded message.
+		wantAttributes map[string]*sqs.MessageAttributeValue
+	}{
+		{
+			name: "default columns",
+		},
+		{
+			name:           "attributes keep their types",
+			columns:        "body,attributes",
+			wantAttributes: map[string]*sqs.MessageAttributeValue{"color": color, "blob": blob, "count": count},
+		},
+		{
+			name:           "attribute column loads a string",
+			columns:        "body,attr:count",
+			wantAttributes: map[string]*sqs.MessageAttributeValue{"count": {DataType: aws.String("String"), StringValue: aws.String("3")}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			fillFakeQueue(t, fake, "source", &sqs.SendMessageInput{
+				MessageBody:       aws.String("a,\"quoted\"\nbody"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{"color": color, "blob": blob, "count": count},
+			})
+
+			spec := csvScheme + filepath.Join(t.TempDir(), "dump.csv")
+			sink, err := openCsvSink(spec, test.columns, dumpOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dumpFakeQueue(t, fake, "source", sink)
+
+			source, err := openCsvSource(spec, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := loadFakeQueue(t, fake, "destination", source)
+
+			if len(loaded) != 1 {
+				t.Fatalf("loaded %d messages, want 1", len(loaded))
+			}
+			if got := aws.StringValue(loaded[0].Body); got != "a,\"quoted\"\nbody" {
+				t.Errorf("loaded body %q", got)
+			}
+			if got := loaded[0].MessageAttributes; !reflect.DeepEqual(got, test.wantAttributes) {
+				t.Errorf("loaded attributes %v, want %v", got, test.wantAttributes)
+			}
+		})
+	}
+}
+
+func TestCsvSinkHeader(t *testing.T) {
+	fake := fakesqs.New()
+	fillFakeQueue(t, fake, "source", &sqs.SendMessageInput{MessageBody: aws.String("a")})
+
+	path := filepath.Join(t.TempDir(), "dump.csv")
+	sink, err := openCsvSink(csvScheme+path, "", dumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpFakeQueue(t, fake, "source", sink)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want a header and a message", len(rows))
+	}
+	if want := []string{"id", "body", "sent_timestamp"}; !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("got header %v, want %v", rows[0], want)
+	}
+	if rows[1][1] != "a" || rows[1][2] == "" {
+		t.Errorf("got row %v, want the body and sent timestamp", rows[1])
+	}
+}
+
+func TestParseCsvColumns(t *testing.T) {
+	tests := []struct {
+		columns []string
+		wantErr bool
+	}{
+		{columns: []string{"id", " body ", "md5", "group_id", "deduplication_id", "attributes", "sys:SenderId", "attr:color"}},
+		{columns: []string{"body", "payload"}, wantErr: true},
+		{columns: []string{"attr:"}, wantErr: true},
+		{columns: []string{"sys:"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		_, err := parseCsvColumns(test.columns)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseCsvColumns(%q) got %v, want an error: %t", test.columns, err, test.wantErr)
+		}
+	}
+}
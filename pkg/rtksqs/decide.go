@@ -0,0 +1,179 @@
+package rtksqs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Actions of a Decision.
+const (
+	DecisionMove   = "move"
+	DecisionSkip   = "skip"
+	DecisionDelete = "delete"
+	DecisionRoute  = "route"
+)
+
+// Decision is what happens to a message: it is moved, skipped as by a hook,
+// deleted from the source as by a discard, or sent to the sink of Route and
+// then deleted from the source.
+type Decision struct {
+	Action string
+	// Route names the sink of DecisionRoute.
+	Route string
+	// Reason is logged for skipped and deleted messages.
+	Reason string
+}
+
+// ParseDecision parses move, skip, delete or route:<name>. Skip and delete
+// may be followed by a space and the reason.
+func ParseDecision(text string) (Decision, error) {
+	text = strings.TrimSpace(text)
+	action, reason := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		action, reason = text[:i], strings.TrimSpace(text[i+1:])
+	}
+
+	switch {
+	case action == DecisionMove && reason == "":
+		return Decision{Action: DecisionMove}, nil
+	case action == DecisionSkip, action == DecisionDelete:
+		return Decision{Action: action, Reason: reason}, nil
+	case strings.HasPrefix(text, DecisionRoute+":") && reason == "":
+		if route := strings.TrimPrefix(text, DecisionRoute+":"); route != "" {
+			return Decision{Action: DecisionRoute, Route: route}, nil
+		}
+	}
+
+	return Decision{}, fmt.Errorf("invalid decision %q, it must be move, skip, delete or route:<queue>", text)
+}
+
+// DecideExec returns a decider running command, a program and its arguments
+// separated by spaces, for every message, with the message as JSON on stdin,
+// in the format of file:// dumps. The command writes the decision to stdout,
+// see ParseDecision, only its first line counts. It fails the message when it
+// exits with an error or runs longer than timeout, DefaultExecTimeout when 0.
+func DecideExec(command string, timeout time.Duration) (func(message *sqs.Message) (Decision, error), error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no command to decide with")
+	}
+
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	return func(message *sqs.Message) (Decision, error) {
+		output, err := runExec(fields, timeout, message)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		line, _ := bufio.NewReader(bytes.NewReader(output)).ReadString('\n')
+		decision, err := ParseDecision(line)
+		if err != nil {
+			return Decision{}, fmt.Errorf("%s decided no action: %s", fields[0], err)
+		}
+
+		return decision, nil
+	}, nil
+}
+
+// decisions are the messages of a batch by the action decided for them,
+// besides those to move.
+type decisions struct {
+	skipped []*sqs.Message
+	deleted []*sqs.Message
+	// routes are the routes in the order they were first decided.
+	routes []string
+	routed map[string][]*sqs.Message
+}
+
+// applyDecisions decides what happens to messages which weren't skipped
+// before and returns those to move and the others. Messages the decider
+// failed on are skipped. It reports done when every message was skipped
+// before.
+func applyDecisions(messages []*sqs.Message, skipped map[string]bool, options MoveOptions) ([]*sqs.Message, decisions, bool) {
+	var result []*sqs.Message
+	decided := decisions{routed: map[string][]*sqs.Message{}}
+	seen := 0
+
+	for _, message := range messages {
+		id := aws.StringValue(message.MessageId)
+
+		if skipped[id] {
+			seen++
+			continue
+		}
+
+		decision, err := options.Decide(message)
+
+		switch {
+		case err != nil, decision.Action == DecisionSkip:
+			reason := "was decided to be skipped"
+			if err != nil {
+				reason = "failed to decide: " + err.Error()
+			} else if decision.Reason != "" {
+				reason += ": " + decision.Reason
+			}
+
+			skipped[id] = true
+			decided.skipped = append(decided.skipped, message)
+			if options.Skipped != nil {
+				options.Skipped(message, reason)
+			}
+		case decision.Action == DecisionDelete:
+			reason := "was decided to be deleted"
+			if decision.Reason != "" {
+				reason += ": " + decision.Reason
+			}
+
+			decided.deleted = append(decided.deleted, message)
+			if options.Discarded != nil {
+				options.Discarded(message, reason)
+			}
+		case decision.Action == DecisionRoute:
+			if _, ok := decided.routed[decision.Route]; !ok {
+				decided.routes = append(decided.routes, decision.Route)
+			}
+			decided.routed[decision.Route] = append(decided.routed[decision.Route], message)
+		default:
+			result = append(result, message)
+		}
+	}
+
+	return result, decided, seen == len(messages)
+}
+
+// route sends the messages decided for every route to its sink and deletes
+// those sent from the source.
+func route(source Source, decided decisions, options MoveOptions) error {
+	for _, name := range decided.routes {
+		if options.Routes == nil {
+			return &MoveError{Step: StepRoute, Err: fmt.Errorf("no sink to route messages to %s", name)}
+		}
+
+		sink, err := options.Routes(name)
+		if err != nil {
+			return &MoveError{Step: StepRoute, Err: err}
+		}
+
+		messages := decided.routed[name]
+		if err := divert(source, sink, messages, StepRoute, options); err != nil {
+			return err
+		}
+
+		if options.Routed != nil {
+			for _, message := range messages {
+				options.Routed(message, name)
+			}
+		}
+	}
+
+	return nil
+}
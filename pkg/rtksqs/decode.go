@@ -0,0 +1,152 @@
+package rtksqs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Body decoder schemes of Options.Decode.
+const (
+	decodeProto = "proto:"
+	decodeAvro  = "avro:"
+)
+
+// bodyDecoder renders binary message bodies as JSON for triage.
+type bodyDecoder interface {
+	decode(payload []byte) (json.RawMessage, error)
+}
+
+// parseBodyDecoder parses proto:<descriptor-set>:<message-name> or
+// avro:<schema-file>, an empty spec decodes nothing.
+func parseBodyDecoder(spec string) (bodyDecoder, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, decodeProto):
+		parts := strings.SplitN(strings.TrimPrefix(spec, decodeProto), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("protobuf decoding must be %s<descriptor-set>:<message-name>", decodeProto)
+		}
+		return newProtoDecoder(parts[0], parts[1])
+	case strings.HasPrefix(spec, decodeAvro):
+		return newAvroDecoder(strings.TrimPrefix(spec, decodeAvro))
+	default:
+		return nil, fmt.Errorf("unknown body decoding %q, must be %s or %s", spec, decodeProto, decodeAvro)
+	}
+}
+
+// decodeBody renders a body with the decoder. SQS bodies are text, so binary
+// payloads are usually base64 encoded, bodies which aren't are decoded as is.
+func decodeBody(decoder bodyDecoder, body string) (json.RawMessage, error) {
+	payload, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		payload = []byte(body)
+	}
+
+	return decoder.decode(payload)
+}
+
+// protoDecoder decodes bodies as a message type of a descriptor set, as
+// written by protoc --include_imports --descriptor_set_out.
+type protoDecoder struct {
+	descriptor protoreflect.MessageDescriptor
+}
+
+func newProtoDecoder(path, name string) (*protoDecoder, error) {
+	encoded, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(encoded, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %s", path, err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %s", path, err)
+	}
+
+	found, err := files.FindDescriptorByName(protoreflect.FullName(name))
+
+	if err != nil {
+		return nil, fmt.Errorf("%s not found in %s: %s", name, path, err)
+	}
+
+	descriptor, ok := found.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s in %s is not a message", name, path)
+	}
+
+	return &protoDecoder{descriptor: descriptor}, nil
+}
+
+func (d *protoDecoder) decode(payload []byte) (json.RawMessage, error) {
+	message := dynamicpb.NewMessage(d.descriptor)
+
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+
+	return protojson.Marshal(message)
+}
+
+// avroDecoder decodes bodies in the Avro binary, or single-object, encoding
+// of a schema.
+type avroDecoder struct {
+	codec *goavro.Codec
+}
+
+func newAvroDecoder(path string) (*avroDecoder, error) {
+	schema, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(string(schema))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema %s: %s", path, err)
+	}
+
+	return &avroDecoder{codec: codec}, nil
+}
+
+func (d *avroDecoder) decode(payload []byte) (json.RawMessage, error) {
+	var native interface{}
+	var rest []byte
+	var err error
+
+	// Single-object encoding starts with the C3 01 marker and the schema
+	// fingerprint.
+	if len(payload) > 2 && payload[0] == 0xc3 && payload[1] == 0x01 {
+		native, rest, err = d.codec.NativeFromSingle(payload)
+	} else {
+		native, rest, err = d.codec.NativeFromBinary(payload)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes left after decoding", len(rest))
+	}
+
+	return d.codec.TextualFromNative(nil, native)
+}
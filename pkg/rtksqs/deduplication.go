@@ -0,0 +1,32 @@
+package rtksqs
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ContentDeduplication reports whether a sink sends to a FIFO queue with
+// content-based deduplication, which drops a message without an error when
+// a message with the same body was sent within the 5 minute deduplication
+// interval, unless they carry different deduplication IDs. Other sinks
+// report false.
+func ContentDeduplication(sink Sink) (bool, error) {
+	// Standard queues don't know the attribute.
+	queue, ok := sink.(*queueSink)
+	if !ok || !strings.HasSuffix(queue.url, ".fifo") {
+		return false, nil
+	}
+
+	resp, err := queue.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queue.url),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameContentBasedDeduplication}),
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return aws.StringValue(resp.Attributes[sqs.QueueAttributeNameContentBasedDeduplication]) == "true", nil
+}
@@ -0,0 +1,68 @@
+package rtksqs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Failed deletes are retried after deleteRetryPause, doubled up to
+// maxDeleteRetryPause, as long as the messages stay hidden for
+// deleteRetryMargin after the retry.
+const (
+	deleteRetryPause    = 25 * time.Millisecond
+	maxDeleteRetryPause = 250 * time.Millisecond
+	deleteRetryMargin   = 500 * time.Millisecond
+)
+
+// deleteRetrySource retries the failed deletes of a queue source while the
+// visibility timeout of the last receive still hides the messages. A delete
+// failing after the messages were sent would otherwise move them again once
+// they are visible, duplicating them. Retries stop in time, a message deleted
+// after it became visible may already be received by another consumer.
+type deleteRetrySource struct {
+	Source
+	queue      *queueSource
+	visibility time.Duration
+	received   time.Time
+}
+
+// retryDeletes wraps queue sources of a move in a deleteRetrySource, other
+// sources aren't hidden while moving and are returned as they are.
+func retryDeletes(source Source) Source {
+	queue, ok := source.(*queueSource)
+	if !ok {
+		return source
+	}
+
+	return &deleteRetrySource{Source: source, queue: queue, visibility: time.Duration(queue.visibilityTimeout) * time.Second}
+}
+
+func (s *deleteRetrySource) Receive(max int64) ([]*sqs.Message, error) {
+	// The messages are hidden from the start of the receive on at the
+	// latest.
+	s.received = time.Now()
+	return s.Source.Receive(max)
+}
+
+// Delete deletes messages of the last receive, retrying those which failed.
+func (s *deleteRetrySource) Delete(messages []*sqs.Message) error {
+	deadline := s.received.Add(s.visibility - deleteRetryMargin)
+	pause := deleteRetryPause
+
+	for {
+		err := s.Source.Delete(messages)
+		if err == nil || time.Now().Add(pause).After(deadline) {
+			return err
+		}
+
+		time.Sleep(pause)
+		if pause *= 2; pause > maxDeleteRetryPause {
+			pause = maxDeleteRetryPause
+		}
+
+		// Only the entries which failed are deleted again, a failed request
+		// deleted none.
+		messages = unsentMessages(messages, sentMessages(messages, err))
+	}
+}
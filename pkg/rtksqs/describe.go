@@ -0,0 +1,37 @@
+package rtksqs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// QueueDescription holds the URL and every attribute of a queue, as SQS
+// returns them.
+type QueueDescription struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// DescribeQueue returns all attributes of the named queue.
+func DescribeQueue(sess *session.Session, name string, options Options) (*QueueDescription, error) {
+	svc := options.sqsClient(sess)
+
+	url, err := resolveQueueUrl(svc, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(url),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueueDescription{Name: name, URL: url, Attributes: aws.StringValueMap(resp.Attributes)}, nil
+}
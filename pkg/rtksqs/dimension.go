@@ -0,0 +1,175 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Values DimensionStats counts messages by besides those of the dimension.
+const (
+	// NoDimensionValue counts messages without the attribute or field.
+	NoDimensionValue = "(none)"
+	// OtherDimensionValue counts messages of values beyond MaxDimensionValues.
+	OtherDimensionValue = "(other)"
+)
+
+// MaxDimensionValues is the most values DimensionStats counts separately,
+// bounding its memory regardless of the number of messages.
+const MaxDimensionValues = 1000
+
+// DimensionCount counts the messages of a value of the dimension.
+type DimensionCount struct {
+	Value string `json:"value"`
+	Moved int    `json:"moved"`
+	// Failed counts failed sends, the messages stay in the source and
+	// count as moved too when a later send succeeds.
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// DimensionStats counts moved, failed and skipped messages by the value of a
+// message attribute or a field of their JSON body, e.g. the tenant of a
+// multi-tenant queue. It is safe for concurrent use, a nil DimensionStats
+// counts nothing.
+type DimensionStats struct {
+	// Dimension is the dimension as parsed, attribute:<name> or
+	// json:<field>.
+	Dimension string
+
+	attribute string
+	field     []string
+
+	mu     sync.Mutex
+	counts map[string]*DimensionCount
+}
+
+// NewDimensionStats counts messages by attribute:<name>, a message attribute
+// or else a system attribute like MessageGroupId, or by json:<field>, a field
+// of the body, nested fields separated by dots as in json:tenant.id.
+func NewDimensionStats(dimension string) (*DimensionStats, error) {
+	s := &DimensionStats{Dimension: dimension, counts: map[string]*DimensionCount{}}
+
+	kind, name := dimension, ""
+	if i := strings.Index(dimension, ":"); i >= 0 {
+		kind, name = dimension[:i], dimension[i+1:]
+	}
+
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("%s names no attribute or field, e.g. attribute:tenant_id or json:tenant.id", dimension)
+	case kind == "attribute":
+		s.attribute = name
+	case kind == "json":
+		s.field = strings.Split(name, ".")
+	default:
+		return nil, fmt.Errorf("%s is neither attribute:<name> nor json:<field>", dimension)
+	}
+
+	return s, nil
+}
+
+// value returns the value of the dimension of a message.
+func (s *DimensionStats) value(message *sqs.Message) string {
+	if s.attribute != "" {
+		if attribute, ok := message.MessageAttributes[s.attribute]; ok && attribute.StringValue != nil {
+			return aws.StringValue(attribute.StringValue)
+		}
+		if value, ok := message.Attributes[s.attribute]; ok {
+			return aws.StringValue(value)
+		}
+		return NoDimensionValue
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &body); err != nil {
+		return NoDimensionValue
+	}
+
+	for _, name := range s.field {
+		object, ok := body.(map[string]interface{})
+		if !ok {
+			return NoDimensionValue
+		}
+		if body, ok = object[name]; !ok || body == nil {
+			return NoDimensionValue
+		}
+	}
+
+	if value, ok := body.(string); ok {
+		return value
+	}
+
+	encoded, _ := json.Marshal(body)
+	return string(encoded)
+}
+
+// count adds the messages to the count of their values picked by field.
+func (s *DimensionStats) count(messages []*sqs.Message, field func(count *DimensionCount) *int) {
+	if s == nil {
+		return
+	}
+
+	values := make([]string, len(messages))
+	for i, message := range messages {
+		values[i] = s.value(message)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, value := range values {
+		count, ok := s.counts[value]
+		if !ok && len(s.counts) >= MaxDimensionValues {
+			value = OtherDimensionValue
+			count, ok = s.counts[value]
+		}
+		if !ok {
+			count = &DimensionCount{Value: value}
+			s.counts[value] = count
+		}
+		*field(count)++
+	}
+}
+
+func (s *DimensionStats) moved(messages []*sqs.Message) {
+	s.count(messages, func(count *DimensionCount) *int { return &count.Moved })
+}
+
+func (s *DimensionStats) failed(messages []*sqs.Message) {
+	s.count(messages, func(count *DimensionCount) *int { return &count.Failed })
+}
+
+func (s *DimensionStats) skipped(messages []*sqs.Message) {
+	s.count(messages, func(count *DimensionCount) *int { return &count.Skipped })
+}
+
+// Counts returns the counts of every value seen so far, the most moved
+// messages first, nil for a nil DimensionStats.
+func (s *DimensionStats) Counts() []DimensionCount {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make([]DimensionCount, 0, len(s.counts))
+	for _, count := range s.counts {
+		counts = append(counts, *count)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Moved != counts[j].Moved {
+			return counts[i].Moved > counts[j].Moved
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+	return counts
+}
@@ -0,0 +1,380 @@
+package rtksqs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+const fileScheme = "file://"
+
+// Compression of file:// and csv:// destinations.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+)
+
+const gzipExtension = ".gz"
+
+// dumpOptions control how dump files are written.
+type dumpOptions struct {
+	compress  string
+	splitSize int64
+	// recipient encrypts every part when set.
+	recipient age.Recipient
+	// runID is recorded in the manifest.
+	runID string
+}
+
+// dumpFileWriter writes a dump to one or more part files, optionally gzip
+// compressed and encrypted. Callers pass whole records to Write, a new part is
+// started before a record once the current part reached splitSize
+// uncompressed bytes.
+type dumpFileWriter struct {
+	path    string
+	options dumpOptions
+	// header is written at the start of every part, e.g. a CSV header row.
+	header []byte
+
+	part     int
+	written  int64
+	file     *os.File
+	hashing  *hashingWriter
+	encrypt  io.WriteCloser
+	gzip     *gzip.Writer
+	out      io.Writer
+	manifest dumpManifest
+}
+
+func newDumpFileWriter(path string, options dumpOptions, header []byte) (*dumpFileWriter, error) {
+	w := &dumpFileWriter{path: path, options: options, header: header}
+	w.manifest.CreatedAt = time.Now().UTC()
+	w.manifest.RunID = options.runID
+
+	if err := w.openPart(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// partPath returns dump.ndjson for unsplit dumps and dump-00001.ndjson for
+// the first part of a split dump, with .gz appended when compressing and .age
+// when encrypting.
+func (w *dumpFileWriter) partPath() string {
+	path := w.path
+
+	if w.options.splitSize > 0 {
+		ext := filepath.Ext(path)
+		path = fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(path, ext), w.part, ext)
+	}
+
+	if w.options.compress == CompressGzip && !strings.HasSuffix(path, gzipExtension) {
+		path += gzipExtension
+	}
+
+	if w.options.recipient != nil {
+		path += ageExtension
+	}
+
+	return path
+}
+
+func (w *dumpFileWriter) openPart() error {
+	w.part++
+	path := w.partPath()
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.hashing = newHashingWriter(file)
+	w.out = w.hashing
+	w.written = 0
+
+	// Compress before encrypting, encrypted data doesn't compress.
+	if w.options.recipient != nil {
+		w.encrypt, err = age.Encrypt(w.out, w.options.recipient)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.out = w.encrypt
+	}
+
+	if w.options.compress == CompressGzip {
+		w.gzip = gzip.NewWriter(w.out)
+		w.out = w.gzip
+	}
+
+	if len(w.header) > 0 {
+		if _, err := w.out.Write(w.header); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *dumpFileWriter) closePart() error {
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+		w.gzip = nil
+	}
+
+	if w.encrypt != nil {
+		if err := w.encrypt.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+		w.encrypt = nil
+	}
+
+	w.manifest.Files = append(w.manifest.Files, w.hashing.manifestFile(w.file.Name()))
+	return w.file.Close()
+}
+
+func (w *dumpFileWriter) Write(record []byte) (int, error) {
+	if w.options.splitSize > 0 && w.written >= w.options.splitSize {
+		if err := w.closePart(); err != nil {
+			return 0, err
+		}
+		if err := w.openPart(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.out.Write(record)
+	w.written += int64(n)
+	return n, err
+}
+
+// countMessage adds a written message to the manifest totals.
+func (w *dumpFileWriter) countMessage(bodyBytes int) {
+	w.manifest.MessageCount++
+	w.manifest.BodyBytes += int64(bodyBytes)
+}
+
+// Close finishes the last part and writes the manifest.
+func (w *dumpFileWriter) Close() error {
+	if err := w.closePart(); err != nil {
+		return err
+	}
+
+	return writeManifest(manifestPath(w.path), &w.manifest)
+}
+
+// finish finishes the last part without writing the manifest, the shards of
+// a dump share one.
+func (w *dumpFileWriter) finish() (*dumpManifest, error) {
+	if err := w.closePart(); err != nil {
+		return nil, err
+	}
+
+	return &w.manifest, nil
+}
+
+// dumpSuffixes are the extensions a dump file may carry after its own.
+var dumpSuffixes = []string{"", gzipExtension, ageExtension, gzipExtension + ageExtension}
+
+// resolveDumpParts finds the files making up a dump written to path: the
+// file itself, its .gz and .age variants, the numbered parts of a split dump,
+// or the numbered shards of a sharded dump, each possibly split too. Sorted
+// by name, shards and their parts are in order.
+func resolveDumpParts(path string) ([]string, error) {
+	for _, suffix := range dumpSuffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return []string{path + suffix}, nil
+		}
+	}
+
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	var parts []string
+
+	for _, pattern := range []string{stem + "-[0-9]*" + ext, stem + "-s[0-9]*" + ext} {
+		for _, suffix := range dumpSuffixes {
+			matches, err := filepath.Glob(pattern + suffix)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, matches...)
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no dump files found at %s", path)
+	}
+
+	sort.Strings(parts)
+	return parts, nil
+}
+
+// openDumpPart opens a dump file, transparently decrypting age files and
+// decompressing gzip files.
+func openDumpPart(path string, identities []age.Identity) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+
+	if magic, _ := buffered.Peek(len(ageMagic)); string(magic) == ageMagic {
+		decrypted, err := age.Decrypt(buffered, identities...)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("unable to decrypt %s: %s", path, err)
+		}
+		buffered = bufio.NewReader(decrypted)
+	}
+
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return &dumpPartReader{Reader: gz, closers: []io.Closer{gz, file}}, nil
+	}
+
+	return &dumpPartReader{Reader: buffered, closers: []io.Closer{file}}, nil
+}
+
+type dumpPartReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *dumpPartReader) Close() error {
+	var first error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// dumpReader reads all parts of a dump one after another. When the dump has
+// a manifest the parts are verified before reading and the message totals
+// once all parts were read.
+type dumpReader struct {
+	parts      []string
+	identities []age.Identity
+	current    io.ReadCloser
+	manifest   *dumpManifest
+
+	messageCount int64
+	bodyBytes    int64
+}
+
+func openDumpReader(path string, identities []age.Identity) (*dumpReader, error) {
+	parts, err := resolveDumpParts(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := readManifest(manifestPath(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		log.Warn(color.New(color.FgYellow).Sprintf("No manifest found for %s, the dump can not be verified", path))
+	} else {
+		if err := manifest.verifyFiles(parts); err != nil {
+			return nil, err
+		}
+		log.Info(color.New(color.FgCyan).Sprintf("Verified %d files against the manifest, expecting %d messages", len(parts), manifest.MessageCount))
+	}
+
+	return &dumpReader{parts: parts, identities: identities, manifest: manifest}, nil
+}
+
+// countMessage adds a read message to the totals checked by verify.
+func (r *dumpReader) countMessage(bodyBytes int) {
+	r.messageCount++
+	r.bodyBytes += int64(bodyBytes)
+}
+
+// verify checks the totals read against the manifest, it must only be called
+// once the dump was read completely.
+func (r *dumpReader) verify() error {
+	if r.manifest == nil {
+		return nil
+	}
+
+	return r.manifest.verifyTotals(r.messageCount, r.bodyBytes)
+}
+
+// next opens the following part, returning io.EOF once all parts were read.
+func (r *dumpReader) next() (io.Reader, error) {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+
+	if len(r.parts) == 0 {
+		return nil, io.EOF
+	}
+
+	part, err := openDumpPart(r.parts[0], r.identities)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.parts = r.parts[1:]
+	r.current = part
+	return part, nil
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if _, err := r.next(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.current.Read(p)
+
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (r *dumpReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package rtksqs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+const (
+	encryptKmsPrefix = "kms:"
+	encryptAgePrefix = "age:"
+)
+
+const ageExtension = ".age"
+
+// ageMagic starts every age encrypted file.
+const ageMagic = "age-encryption.org/"
+
+// kmsStanzaType marks age header stanzas holding a file key encrypted by KMS.
+const kmsStanzaType = "aws-kms"
+
+// kmsEncryptionContext is bound to every KMS encrypted file key, so keys can
+// only be decrypted for this purpose.
+var kmsEncryptionContext = map[string]*string{"purpose": aws.String("sqsmover-dump")}
+
+// kmsRecipient is an age recipient encrypting the file key with a KMS key.
+// Dumps use the age file format regardless of how the file key is protected.
+type kmsRecipient struct {
+	svc   *kms.KMS
+	keyID string
+}
+
+func (r *kmsRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	resp, err := r.svc.Encrypt(&kms.EncryptInput{
+		KeyId:             aws.String(r.keyID),
+		Plaintext:         fileKey,
+		EncryptionContext: kmsEncryptionContext,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt with %s: %s", r.keyID, err)
+	}
+
+	return []*age.Stanza{{Type: kmsStanzaType, Args: []string{r.keyID}, Body: resp.CiphertextBlob}}, nil
+}
+
+// kmsIdentity decrypts file keys encrypted by a kmsRecipient. The KMS key is
+// identified by the ciphertext itself.
+type kmsIdentity struct {
+	svc *kms.KMS
+}
+
+func (i *kmsIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, stanza := range stanzas {
+		if stanza.Type != kmsStanzaType {
+			continue
+		}
+
+		resp, err := i.svc.Decrypt(&kms.DecryptInput{
+			CiphertextBlob:    stanza.Body,
+			EncryptionContext: kmsEncryptionContext,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt with KMS: %s", err)
+		}
+
+		return resp.Plaintext, nil
+	}
+
+	return nil, age.ErrIncorrectIdentity
+}
+
+// parseEncryptRecipient parses --encrypt kms:<key-arn> or age:<recipient>.
+func parseEncryptRecipient(sess *session.Session, spec string) (age.Recipient, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, encryptKmsPrefix):
+		return &kmsRecipient{svc: kms.New(sess), keyID: strings.TrimPrefix(spec, encryptKmsPrefix)}, nil
+	case strings.HasPrefix(spec, encryptAgePrefix):
+		return age.ParseX25519Recipient(strings.TrimPrefix(spec, encryptAgePrefix))
+	default:
+		return nil, errors.New("encryption must be kms:<key-arn> or age:<recipient>")
+	}
+}
+
+// loadDecryptIdentities returns the identities tried when loading encrypted
+// dumps: KMS, plus the age identities in the given identity files.
+func loadDecryptIdentities(sess *session.Session, identityFiles []string) ([]age.Identity, error) {
+	identities := []age.Identity{&kmsIdentity{svc: kms.New(sess)}}
+
+	for _, path := range identityFiles {
+		file, err := os.Open(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := age.ParseIdentities(file)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+		identities = append(identities, parsed...)
+	}
+
+	return identities, nil
+}
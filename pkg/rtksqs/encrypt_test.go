@@ -0,0 +1,209 @@
+package rtksqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+// fakeKmsPrefix marks the file keys "encrypted" by newFakeKms.
+const fakeKmsPrefix = "kms-encrypted:"
+
+// newFakeKms returns a session talking to a KMS endpoint which encrypts by
+// prefixing the plaintext, and checks the encryption context.
+func newFakeKms(t *testing.T) *session.Session {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Plaintext         []byte
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if input.EncryptionContext["purpose"] != "sqsmover-dump" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "InvalidCiphertextException", "message": "wrong encryption context"})
+			return
+		}
+
+		var output interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			output = map[string][]byte{"CiphertextBlob": append([]byte(fakeKmsPrefix), input.Plaintext...)}
+		case "TrentService.Decrypt":
+			if !bytes.HasPrefix(input.CiphertextBlob, []byte(fakeKmsPrefix)) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"__type": "InvalidCiphertextException", "message": "not encrypted by this key"})
+				return
+			}
+			output = map[string][]byte{"Plaintext": bytes.TrimPrefix(input.CiphertextBlob, []byte(fakeKmsPrefix))}
+		default:
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(server.Close)
+
+	return session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+}
+
+func TestEncryptedDump(t *testing.T) {
+	sess := newFakeKms(t)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		encrypt  string
+		compress string
+	}{
+		{name: "kms", encrypt: "kms:arn:aws:kms:us-east-1:123456789012:key/dump"},
+		{name: "age", encrypt: "age:" + identity.Recipient().String()},
+		{name: "age compressed", encrypt: "age:" + identity.Recipient().String(), compress: CompressGzip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			fillFakeQueue(t, fake, "source",
+				&sqs.SendMessageInput{MessageBody: aws.String("secret-1")},
+				&sqs.SendMessageInput{MessageBody: aws.String("secret-2")})
+
+			options := Options{Encrypt: test.encrypt, Compress: test.compress}
+			dump, err := options.dumpOptions(sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "dump.ndjson")
+			sink, err := openNdjsonFileSink(fileScheme+path, dump)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dumpFakeQueue(t, fake, "source", sink)
+
+			parts, err := resolveDumpParts(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != 1 || !strings.HasSuffix(parts[0], ageExtension) {
+				t.Fatalf("wrote %v, want a single %s file", parts, ageExtension)
+			}
+
+			encrypted, err := os.ReadFile(parts[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(encrypted, []byte(ageMagic)) || bytes.Contains(encrypted, []byte("secret-")) {
+				t.Fatal("the dump isn't encrypted")
+			}
+
+			identities, err := loadDecryptIdentities(sess, []string{identityFile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			source, err := openNdjsonFileSource(fileScheme+path, identities)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var bodies []string
+			for _, message := range loadFakeQueue(t, fake, "destination", source) {
+				bodies = append(bodies, aws.StringValue(message.Body))
+			}
+			if want := []string{"secret-1", "secret-2"}; !reflect.DeepEqual(sorted(bodies), want) {
+				t.Errorf("loaded %v, want %v", bodies, want)
+			}
+		})
+	}
+}
+
+func TestEncryptedDumpWrongIdentity(t *testing.T) {
+	sess := newFakeKms(t)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := fakesqs.New()
+	fillFakeQueue(t, fake, "source", &sqs.SendMessageInput{MessageBody: aws.String("secret")})
+
+	path := filepath.Join(t.TempDir(), "dump.ndjson")
+	sink, err := openNdjsonFileSink(fileScheme+path, dumpOptions{recipient: identity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpFakeQueue(t, fake, "source", sink)
+
+	// Only KMS is tried without identity files.
+	identities, err := loadDecryptIdentities(sess, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := openNdjsonFileSource(fileScheme+path, identities)
+	if err == nil {
+		_, err = source.Receive(DefaultBatchSize)
+		source.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "unable to decrypt") {
+		t.Errorf("got %v, want the dump not to decrypt", err)
+	}
+}
+
+func TestParseEncryptRecipient(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantNil bool
+		wantErr bool
+	}{
+		{spec: "", wantNil: true},
+		{spec: "kms:alias/dumps"},
+		{spec: "age:not-a-recipient", wantErr: true},
+		{spec: "aes:key", wantErr: true},
+	}
+
+	for _, test := range tests {
+		recipient, err := parseEncryptRecipient(session.Must(session.NewSession()), test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseEncryptRecipient(%q) got %v, want an error: %t", test.spec, err, test.wantErr)
+		}
+		if !test.wantErr && (recipient == nil) != test.wantNil {
+			t.Errorf("parseEncryptRecipient(%q) got %v", test.spec, recipient)
+		}
+	}
+}
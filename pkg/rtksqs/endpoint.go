@@ -0,0 +1,311 @@
+// Package rtksqs moves messages between SQS queues, and from and to the other
+// sources and sinks sqsmover supports: SQLite archives, NDJSON and CSV dumps,
+// stdin and stdout, Pub/Sub, Service Bus, Kafka, NATS JetStream and Lambda
+// functions.
+package rtksqs
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Version is reported to services which identify their clients.
+var Version = "dev"
+
+// Options configure how sources and sinks are opened, the zero value opens
+// queues and writes uncompressed, unencrypted dumps with the default columns.
+type Options struct {
+	// CsvColumns are the comma separated columns written to csv:// sinks.
+	CsvColumns string
+	// Compress is CompressNone or CompressGzip for file:// and csv:// sinks.
+	Compress string
+	// SplitSize starts a new dump part once a part holds this many
+	// uncompressed bytes, 0 doesn't split.
+	SplitSize int64
+	// Encrypt is kms:<key-arn> or age:<recipient> to encrypt dump sinks.
+	Encrypt string
+	// DecryptIdentities are age identity files for encrypted dump sources.
+	DecryptIdentities []string
+	// IDMap is a CSV file recording the destination message ID of every
+	// message sent to a queue.
+	IDMap string
+	// SQS is used for queues instead of a client built from the session,
+	// e.g. one of NewSQSClientFromConfig or NewSQSClientWithAPI, or a fake. OpenSource and OpenSink need no session for queues then.
+	SQS sqsiface.SQSAPI
+	// Chaos is the probability of injected faults in SQS calls, see
+	// WithChaos. Faults are seeded with the current time.
+	Chaos float64
+	// RunID identifies the run in dump manifests and ID maps, see NewRunID.
+	RunID string
+	// Decode is proto:<descriptor-set>:<message-name> or avro:<schema-file>
+	// to add the decoded body to records written to stdout and file:// sinks.
+	Decode string
+	// LambdaRate limits invocations per second of lambda: sinks, 0 doesn't
+	// limit them.
+	LambdaRate float64
+	// ReceiveMessageAttributes are the message attribute names, or prefixes
+	// ending in .*, requested from queue sources. All when empty.
+	ReceiveMessageAttributes []string
+	// ReceiveSystemAttributes are requested from queue sources besides the
+	// system attributes moves need, e.g. SenderId, or All.
+	ReceiveSystemAttributes []string
+	// DumpShards writes file:// sinks in this many shard files at once when
+	// more than 1, up to MaxDumpShards.
+	DumpShards int
+	// ReceiveWait is the longest receives from queues long-poll, up to
+	// MaxReceiveWait. The wait shrinks while receives come back full and
+	// grows while they come back short, a queue is only taken for empty
+	// after a receive waited this long. 0 short-polls.
+	ReceiveWait time.Duration
+	// FinalSweep takes a queue for empty after this many short polls come
+	// back empty, instead of after a receive waited ReceiveWait. Every short
+	// poll samples a random subset of the SQS hosts, a few of them find the
+	// last messages of a distributed queue faster than long polls. 0 doesn't
+	// sweep.
+	FinalSweep int
+	// DelaySeconds delays the delivery of every message sent to a queue by up
+	// to 900 seconds. FIFO queues only support the DelaySeconds of the queue.
+	DelaySeconds int64
+	// Redact masks the matches of these patterns in the bodies and string
+	// message attributes written to stdout, sqlite://, file:// and csv://
+	// sinks, e.g. emails and tokens, see Redact.
+	Redact []*regexp.Regexp
+	// OffloadLarge is an s3://bucket/prefix URL the bodies of messages over
+	// MaxMessageSize are uploaded to, sending pointers to them in the format
+	// of the SQS Extended Client Library to queues instead of failing them.
+	OffloadLarge string
+	// PrewarmGroups sends a no-op marker carrying PrewarmAttribute to every
+	// message group of a FIFO queue before its first moved message, so the
+	// replay doesn't wait for SQS to set up the ordering of new groups.
+	PrewarmGroups bool
+}
+
+// sqsClient returns the SQS client queues are opened with.
+func (o Options) sqsClient(sess *session.Session) sqsiface.SQSAPI {
+	var api sqsiface.SQSAPI = o.SQS
+
+	if api == nil {
+		api = NewSQSClientWithAPI(sqs.New(sess))
+	}
+
+	if o.Chaos > 0 {
+		api = WithChaos(api, o.Chaos, time.Now().UnixNano())
+	}
+
+	return api
+}
+
+// dumpOptions returns how dump files are written.
+func (o Options) dumpOptions(sess *session.Session) (dumpOptions, error) {
+	recipient, err := parseEncryptRecipient(sess, o.Encrypt)
+	if err != nil {
+		return dumpOptions{}, err
+	}
+
+	return dumpOptions{compress: o.Compress, splitSize: o.SplitSize, recipient: recipient, runID: o.RunID}, nil
+}
+
+// openNdjsonSink opens stdout or a file:// dump.
+func openNdjsonSink(sess *session.Session, spec string, options Options) (*ndjsonSink, error) {
+	decoder, err := parseBodyDecoder(options.Decode)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec == StdioSpec {
+		sink := newNdjsonSink("stdout", os.Stdout)
+		sink.decoder = decoder
+		sink.redact = options.Redact
+		return sink, nil
+	}
+
+	dump, err := options.dumpOptions(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := openNdjsonFileSink(spec, dump)
+	if err != nil {
+		return nil, err
+	}
+
+	sink.decoder = decoder
+	sink.redact = options.Redact
+	return sink, nil
+}
+
+// Source is anything messages can be moved from. Messages are only deleted
+// from the source once they were successfully sent to the sink.
+type Source interface {
+	fmt.Stringer
+	ApproximateCount() (int, error)
+	Receive(max int64) ([]*sqs.Message, error)
+	Delete(messages []*sqs.Message) error
+	Close() error
+}
+
+// Sink is anything messages can be moved to.
+type Sink interface {
+	fmt.Stringer
+	Send(messages []*sqs.Message) error
+	Close() error
+}
+
+// BacklogSink is a sink which can tell how many messages wait to be
+// consumed from it.
+type BacklogSink interface {
+	Sink
+	ApproximateBacklog() (int, error)
+}
+
+// InFlightSource is a source which can tell how many of its messages are in
+// flight, received by a consumer but neither deleted nor visible again.
+type InFlightSource interface {
+	Source
+	ApproximateInFlight() (int, error)
+}
+
+// BatchFailure describes a single message a batch operation could not process.
+type BatchFailure struct {
+	ID      string
+	Code    string
+	Message string
+	// SenderFault reports that the message or the request was rejected, so
+	// processing it again as it is fails again.
+	SenderFault bool
+	// message is the failed message, when known it identifies the message
+	// even if its ID isn't unique, e.g. in a hand-written dump.
+	message *sqs.Message
+}
+
+// BatchError is returned when some of the messages in a batch failed. Every
+// message which was not processed is listed, the others were.
+type BatchError struct {
+	Operation string
+	Failures  []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d messages failed to %s", len(e.Failures), e.Operation)
+}
+
+// OpenSource opens the source described by spec: - for stdin, a sqlite://,
+// csv:// or file:// URL, or else the name of a queue.
+func OpenSource(sess *session.Session, spec string, options Options) (Source, error) {
+	switch {
+	case spec == StdioSpec:
+		return newNdjsonSource("stdin", os.Stdin), nil
+	case strings.HasPrefix(spec, sqliteScheme):
+		return openSqliteSource(spec)
+	case strings.HasPrefix(spec, csvScheme), strings.HasPrefix(spec, fileScheme):
+		identities, err := loadDecryptIdentities(sess, options.DecryptIdentities)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(spec, csvScheme) {
+			return openCsvSource(spec, identities)
+		}
+		return openNdjsonFileSource(spec, identities)
+	default:
+		source, err := openQueueSource(options.sqsClient(sess), spec)
+		if err != nil {
+			return nil, err
+		}
+		source.requestAttributes(options.ReceiveMessageAttributes, options.ReceiveSystemAttributes)
+		if options.ReceiveWait > 0 {
+			source.wait = newAdaptiveWait(options.ReceiveWait)
+		}
+		source.sweeps = options.FinalSweep
+		return source, nil
+	}
+}
+
+// DeletesFromSource reports whether moving messages from the source described
+// by spec deletes them from it, as from queues and sqlite:// archives, unlike
+// from dumps and stdin.
+func DeletesFromSource(spec string) bool {
+	return spec != StdioSpec && !strings.HasPrefix(spec, csvScheme) && !strings.HasPrefix(spec, fileScheme)
+}
+
+// OpenSink opens the sink described by spec: - for stdout, a sqlite://,
+// csv://, file://, pubsub://, servicebus://, kafka:// or nats:// URL, a
+// lambda:<function-name>, an events:<bus-name>, or else the name of a queue.
+func OpenSink(sess *session.Session, spec string, options Options) (Sink, error) {
+	if options.Decode != "" && spec != StdioSpec && !strings.HasPrefix(spec, fileScheme) {
+		return nil, fmt.Errorf("decoded bodies can only be written to stdout and %s dumps", fileScheme)
+	}
+
+	if options.OffloadLarge != "" && (spec == StdioSpec || strings.Contains(spec, "://") && !isQueueURL(spec) || strings.HasPrefix(spec, lambdaScheme) || strings.HasPrefix(spec, eventsScheme)) {
+		return nil, fmt.Errorf("bodies can only be offloaded for queues, not for %s", spec)
+	}
+
+	if options.PrewarmGroups && !strings.HasSuffix(spec, ".fifo") {
+		return nil, fmt.Errorf("message groups can only be pre-warmed for FIFO queues, not for %s", spec)
+	}
+
+	switch {
+	case strings.HasPrefix(spec, fileScheme) && options.DumpShards > 1:
+		return openShardedDumpSink(sess, spec, options)
+	case spec == StdioSpec, strings.HasPrefix(spec, fileScheme):
+		return openNdjsonSink(sess, spec, options)
+	case strings.HasPrefix(spec, sqliteScheme):
+		sink, err := openSqliteSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sink.redact = options.Redact
+		return sink, nil
+	case strings.HasPrefix(spec, csvScheme):
+		dump, err := options.dumpOptions(sess)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := openCsvSink(spec, options.CsvColumns, dump)
+		if err != nil {
+			return nil, err
+		}
+		sink.redact = options.Redact
+		return sink, nil
+	case strings.HasPrefix(spec, pubsubScheme):
+		return openPubsubSink(spec)
+	case strings.HasPrefix(spec, servicebusScheme):
+		return openServicebusSink(spec)
+	case strings.HasPrefix(spec, kafkaScheme):
+		return openKafkaSink(sess, spec)
+	case strings.HasPrefix(spec, natsScheme):
+		return openNatsSink(spec)
+	case strings.HasPrefix(spec, lambdaScheme):
+		return openLambdaSink(lambda.New(sess), spec, options.LambdaRate)
+	case strings.HasPrefix(spec, eventsScheme):
+		return openEventBridgeSink(eventbridge.New(sess), spec)
+	default:
+		if options.DelaySeconds > 0 && strings.HasSuffix(spec, ".fifo") {
+			return nil, fmt.Errorf("FIFO queue %s doesn't support delays of single messages, only the DelaySeconds of the queue", spec)
+		}
+
+		sink, err := openQueueSink(options.sqsClient(sess), spec, options.IDMap, options.RunID)
+		if err != nil {
+			return nil, err
+		}
+		sink.delaySeconds = options.DelaySeconds
+		if options.PrewarmGroups {
+			sink.prewarm = newGroupPrewarm(sink.svc, sink.url, options.RunID)
+		}
+		if options.OffloadLarge != "" {
+			if sink.offload, err = newS3Offload(s3.New(sess), options.OffloadLarge); err != nil {
+				return nil, err
+			}
+		}
+		return sink, nil
+	}
+}
@@ -0,0 +1,157 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// EventBridge formats of messages.
+const (
+	EventBridgeFormatNone   = "none"
+	EventBridgeFormatUnwrap = "unwrap"
+	EventBridgeFormatWrap   = "wrap"
+)
+
+// Message attributes EventBridge adds to the events it sends to the
+// dead-letter queue of a rule target, next to the event as the body.
+const (
+	EventBridgeRuleArnAttribute        = "RULE_ARN"
+	EventBridgeTargetArnAttribute      = "TARGET_ARN"
+	EventBridgeErrorCodeAttribute      = "ERROR_CODE"
+	EventBridgeErrorMessageAttribute   = "ERROR_MESSAGE"
+	EventBridgeRetryConditionAttribute = "EXHAUSTED_RETRY_CONDITION"
+	EventBridgeRetryAttemptsAttribute  = "RETRY_ATTEMPTS"
+)
+
+// eventBridgeWrapErrorCode is the ERROR_CODE of wrapped events which had
+// none, the code of targets failing on the request.
+const eventBridgeWrapErrorCode = "SDK_CLIENT_ERROR"
+
+// EventBridgeDeadLetterAttributes returns the message attributes of events in
+// the dead-letter queue of a rule target.
+func EventBridgeDeadLetterAttributes() []string {
+	return []string{
+		EventBridgeRuleArnAttribute,
+		EventBridgeTargetArnAttribute,
+		EventBridgeErrorCodeAttribute,
+		EventBridgeErrorMessageAttribute,
+		EventBridgeRetryConditionAttribute,
+		EventBridgeRetryAttemptsAttribute,
+	}
+}
+
+// eventBridgeEvent is an event as EventBridge delivers it to targets.
+type eventBridgeEvent struct {
+	Version    string          `json:"version"`
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Time       string          `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// parseEventBridgeEvent parses the body of a message as an EventBridge event.
+func parseEventBridgeEvent(message *sqs.Message) (*eventBridgeEvent, bool) {
+	var event eventBridgeEvent
+
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &event); err != nil || event.Source == "" || event.DetailType == "" || len(event.Detail) == 0 {
+		return nil, false
+	}
+
+	return &event, true
+}
+
+// EventBridgeErrorFilter matches events EventBridge sent to a dead-letter
+// queue with one of the ERROR_CODE codes, e.g. THROTTLING.
+func EventBridgeErrorFilter(codes []string) MessageFilter {
+	return MessageFilter{
+		Description: fmt.Sprintf("EventBridge error code %s", strings.Join(codes, " or ")),
+		Match: func(message *sqs.Message) bool {
+			attribute, ok := message.MessageAttributes[EventBridgeErrorCodeAttribute]
+			if !ok {
+				return false
+			}
+
+			for _, code := range codes {
+				if aws.StringValue(attribute.StringValue) == code {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// EventBridgeUnwrap returns a hook removing the error metadata EventBridge
+// adds to the events in the dead-letter queue of a rule target, so they can
+// be replayed to the target as EventBridge delivers them. Messages which
+// aren't such events are skipped.
+func EventBridgeUnwrap() MessageHook {
+	return func(message *sqs.Message) string {
+		if _, ok := message.MessageAttributes[EventBridgeErrorCodeAttribute]; !ok {
+			return "is not an EventBridge dead-letter event"
+		}
+		if _, ok := parseEventBridgeEvent(message); !ok {
+			return "is not an EventBridge dead-letter event"
+		}
+
+		attributes := make(map[string]*sqs.MessageAttributeValue, len(message.MessageAttributes))
+		for name, value := range message.MessageAttributes {
+			attributes[name] = value
+		}
+
+		for _, name := range EventBridgeDeadLetterAttributes() {
+			delete(attributes, name)
+		}
+
+		message.MessageAttributes = attributes
+		if len(attributes) == 0 {
+			message.MessageAttributes = nil
+		}
+
+		return ""
+	}
+}
+
+// EventBridgeWrap returns a hook adding the error metadata of a dead-letter
+// queue of a rule target to EventBridge events, for destinations consuming
+// that format. The rule and target ARNs are set when given, the error code
+// and message of the events are kept, events without one get
+// SDK_CLIENT_ERROR. Messages which aren't EventBridge events are skipped.
+func EventBridgeWrap(ruleArn, targetArn string) MessageHook {
+	return func(message *sqs.Message) string {
+		if _, ok := parseEventBridgeEvent(message); !ok {
+			return "is not an EventBridge event"
+		}
+
+		attributes := make(map[string]*sqs.MessageAttributeValue, len(message.MessageAttributes)+4)
+		for name, value := range message.MessageAttributes {
+			attributes[name] = value
+		}
+
+		set := func(name, value string) {
+			attributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+
+		if ruleArn != "" {
+			set(EventBridgeRuleArnAttribute, ruleArn)
+		}
+		if targetArn != "" {
+			set(EventBridgeTargetArnAttribute, targetArn)
+		}
+		if _, ok := attributes[EventBridgeErrorCodeAttribute]; !ok {
+			set(EventBridgeErrorCodeAttribute, eventBridgeWrapErrorCode)
+			set(EventBridgeErrorMessageAttribute, "Wrapped by sqsmover")
+		}
+
+		message.MessageAttributes = attributes
+		return ""
+	}
+}
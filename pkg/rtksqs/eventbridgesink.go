@@ -0,0 +1,113 @@
+package rtksqs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const eventsScheme = "events:"
+
+// eventBridgeSink puts every message body, an EventBridge event, on an event
+// bus.
+type eventBridgeSink struct {
+	svc eventbridgeiface.EventBridgeAPI
+	bus string
+}
+
+// openEventBridgeSink opens events:<bus-name>, the name may be an event bus
+// ARN.
+func openEventBridgeSink(svc eventbridgeiface.EventBridgeAPI, spec string) (*eventBridgeSink, error) {
+	bus := strings.TrimPrefix(spec, eventsScheme)
+
+	if bus == "" {
+		return nil, fmt.Errorf("event bus destination must be %s<bus-name>", eventsScheme)
+	}
+
+	// Fail before receiving anything when the bus doesn't exist or can't be
+	// seen with these credentials.
+	if _, err := svc.DescribeEventBus(&eventbridge.DescribeEventBusInput{Name: aws.String(bus)}); err != nil {
+		return nil, err
+	}
+
+	return &eventBridgeSink{svc: svc, bus: bus}, nil
+}
+
+func (s *eventBridgeSink) String() string {
+	return eventsScheme + s.bus
+}
+
+// entry returns the entry putting the event in the body of a message on the
+// bus again, with its original time. EventBridge assigns it a new ID.
+func (s *eventBridgeSink) entry(message *sqs.Message) (*eventbridge.PutEventsRequestEntry, error) {
+	event, ok := parseEventBridgeEvent(message)
+	if !ok {
+		return nil, fmt.Errorf("the body is not an EventBridge event")
+	}
+
+	entry := &eventbridge.PutEventsRequestEntry{
+		EventBusName: aws.String(s.bus),
+		Source:       aws.String(event.Source),
+		DetailType:   aws.String(event.DetailType),
+		Detail:       aws.String(string(event.Detail)),
+		Resources:    aws.StringSlice(event.Resources),
+	}
+
+	if sent, err := time.Parse(time.RFC3339, event.Time); err == nil {
+		entry.Time = aws.Time(sent)
+	}
+
+	return entry, nil
+}
+
+// Send puts the events of a batch of at most ten messages. Messages whose
+// body isn't an event, or whose event the bus didn't accept, are reported as
+// failures and stay in the source.
+func (s *eventBridgeSink) Send(messages []*sqs.Message) error {
+	var failures []BatchFailure
+	var entries []*eventbridge.PutEventsRequestEntry
+	var sent []*sqs.Message
+
+	for _, message := range messages {
+		entry, err := s.entry(message)
+		if err != nil {
+			failures = append(failures, BatchFailure{ID: aws.StringValue(message.MessageId), Code: "InvalidEvent", Message: err.Error(), message: message})
+			continue
+		}
+
+		entries = append(entries, entry)
+		sent = append(sent, message)
+	}
+
+	if len(entries) > 0 {
+		resp, err := s.svc.PutEvents(&eventbridge.PutEventsInput{Entries: entries})
+
+		switch {
+		case err != nil:
+			failures = append(failures, batchRequestFailures(sent, err)...)
+		case aws.Int64Value(resp.FailedEntryCount) > 0:
+			// Result entries are in the order of the request entries.
+			for i, result := range resp.Entries {
+				if i < len(sent) && result.ErrorCode != nil {
+					failures = append(failures, BatchFailure{ID: aws.StringValue(sent[i].MessageId), Code: aws.StringValue(result.ErrorCode),
+						Message: aws.StringValue(result.ErrorMessage), message: sent[i]})
+				}
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BatchError{Operation: "put events", Failures: failures}
+	}
+
+	return nil
+}
+
+func (s *eventBridgeSink) Close() error {
+	return nil
+}
@@ -0,0 +1,131 @@
+package rtksqs
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MessageFailure describes a message a step of a move failed on, so that a
+// consumer can decide whether to retry it, quarantine it or alert about it.
+type MessageFailure struct {
+	// MessageID is empty when no message is known, e.g. for a failed
+	// receive.
+	MessageID string `json:"messageId,omitempty"`
+	// Stage is the step which failed: StepReceive, StepSend, StepDelete, or
+	// StepQuarantine, StepRoute or StepBackup for the other sinks.
+	Stage string `json:"stage"`
+	Code  string `json:"code"`
+	// SenderFault reports that the message or the request itself was
+	// rejected, so trying again as it is fails again.
+	SenderFault bool   `json:"senderFault"`
+	Error       string `json:"error"`
+}
+
+// MaxListedFailures is the most failures a FailureLog lists, bounding its
+// memory regardless of the number of failed messages.
+const MaxListedFailures = 100
+
+// FailureLog lists the first MaxListedFailures failures of a move and counts
+// all of them by error code, so moves going past millions of failures don't
+// hold them all. It is safe for concurrent use.
+type FailureLog struct {
+	mu       sync.Mutex
+	failures []MessageFailure
+	counts   map[string]int
+}
+
+// Add records a failure.
+func (l *FailureLog) Add(failure MessageFailure) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts == nil {
+		l.counts = map[string]int{}
+	}
+
+	l.counts[failure.Code]++
+	if len(l.failures) < MaxListedFailures {
+		l.failures = append(l.failures, failure)
+	}
+}
+
+// Failures returns the first MaxListedFailures failures.
+func (l *FailureLog) Failures() []MessageFailure {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]MessageFailure(nil), l.failures...)
+}
+
+// Counts returns the number of failures by error code, nil when there were
+// none.
+func (l *FailureLog) Counts() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.counts) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int, len(l.counts))
+	for code, count := range l.counts {
+		counts[code] = count
+	}
+	return counts
+}
+
+// MessageFailures lists the messages err failed at stage: the failures of a
+// BatchError, else every message, or a single failure without a message
+// when there are none.
+func MessageFailures(stage string, messages []*sqs.Message, err error) []MessageFailure {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		result := make([]MessageFailure, len(batchErr.Failures))
+		for i, failure := range batchErr.Failures {
+			result[i] = MessageFailure{MessageID: failure.ID, Stage: stage, Code: failure.Code, SenderFault: failure.SenderFault, Error: failure.Message}
+		}
+		return result
+	}
+
+	code, senderFault := errorCode(err)
+	if len(messages) == 0 {
+		return []MessageFailure{{Stage: stage, Code: code, SenderFault: senderFault, Error: err.Error()}}
+	}
+
+	result := make([]MessageFailure, len(messages))
+	for i, message := range messages {
+		result[i] = MessageFailure{MessageID: aws.StringValue(message.MessageId), Stage: stage, Code: code, SenderFault: senderFault, Error: err.Error()}
+	}
+
+	return result
+}
+
+// errorCode returns the AWS error code of err, RequestFailed for other
+// errors, and whether a 4xx status put the fault on the sender.
+func errorCode(err error) (string, bool) {
+	code := "RequestFailed"
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		code = awsErr.Code()
+	}
+
+	var failure awserr.RequestFailure
+	senderFault := errors.As(err, &failure) && failure.StatusCode() >= http.StatusBadRequest && failure.StatusCode() < http.StatusInternalServerError
+
+	return code, senderFault
+}
+
+// reportFailures calls options.Failed for every message err failed at stage.
+func reportFailures(options MoveOptions, stage string, messages []*sqs.Message, err error) {
+	if options.Failed == nil {
+		return
+	}
+
+	for _, failure := range MessageFailures(stage, messages, err) {
+		options.Failed(failure)
+	}
+}
@@ -0,0 +1,1026 @@
+// Package fakesqs is an in-memory implementation of the SQS API for fast,
+// deterministic integration tests.
+//
+// It models the behaviour movers depend on: visibility timeouts, receive
+// counts, delays, batch limits, FIFO ordering and deduplication, and redrive
+// to dead-letter queues. Failures of whole calls or of single batch entries
+// can be injected. Operations that are not implemented panic.
+package fakesqs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Limits enforced by SQS.
+const (
+	MaxBatchEntries       = 10
+	MaxMessageSize        = 262144
+	MaxBatchSize          = 262144
+	DefaultVisibility     = 30 * time.Second
+	DeduplicationInterval = 5 * time.Minute
+)
+
+// CallFailure decides whether a whole call fails, operation is the API name,
+// e.g. "SendMessageBatch". Returning nil lets the call proceed.
+type CallFailure func(operation string, queueURL string) error
+
+// EntryFailure decides whether a single batch entry fails, e.g. to simulate
+// partial SendMessageBatch failures. Returning nil lets the entry proceed.
+type EntryFailure func(operation string, entryID string) *sqs.BatchResultErrorEntry
+
+// SQS is an in-memory SQS. The zero value is not usable, use New.
+type SQS struct {
+	// SQSAPI is embedded so SQS satisfies sqsiface.SQSAPI, it is nil and
+	// calling an operation which isn't implemented panics.
+	sqsiface.SQSAPI
+
+	// Region and AccountID are used to build queue URLs and ARNs.
+	Region    string
+	AccountID string
+
+	mu           sync.Mutex
+	queues       map[string]*queue
+	now          func() time.Time
+	sequence     int64
+	callFailure  CallFailure
+	entryFailure EntryFailure
+}
+
+var _ sqsiface.SQSAPI = (*SQS)(nil)
+
+type queue struct {
+	name       string
+	url        string
+	arn        string
+	accountID  string
+	attributes map[string]string
+	tags       map[string]string
+	created    time.Time
+	messages   []*message
+	// deduplicated holds FIFO deduplication IDs and when they were sent.
+	deduplicated map[string]time.Time
+}
+
+type message struct {
+	id                string
+	body              string
+	md5OfBody         string
+	attributes        map[string]*sqs.MessageAttributeValue
+	systemAttributes  map[string]string
+	sent              time.Time
+	visibleAt         time.Time
+	receiveCount      int
+	firstReceive      time.Time
+	receiptHandle     string
+	groupID           string
+	deduplicationID   string
+	sequenceNumber    string
+	inFlight          bool
+	receiptGeneration int
+}
+
+// New returns an empty in-memory SQS in us-east-1 for account 000000000000.
+func New() *SQS {
+	return &SQS{
+		Region:    "us-east-1",
+		AccountID: "000000000000",
+		queues:    map[string]*queue{},
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the clock, so tests can move time forward to expire
+// visibility timeouts and delays without sleeping.
+func (f *SQS) SetClock(now func() time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// SetCallFailure injects failures of whole calls, nil removes it.
+func (f *SQS) SetCallFailure(fn CallFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.callFailure = fn
+}
+
+// SetEntryFailure injects failures of single batch entries, nil removes it.
+func (f *SQS) SetEntryFailure(fn EntryFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entryFailure = fn
+}
+
+// Bodies returns the bodies of all messages in a queue, visible or not, in
+// the order they were sent. It is meant for assertions in tests.
+func (f *SQS) Bodies(queueURL string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, ok := f.queueByURL(queueURL)
+	if !ok {
+		return nil
+	}
+
+	bodies := make([]string, len(q.messages))
+	for i, m := range q.messages {
+		bodies[i] = m.body
+	}
+	return bodies
+}
+
+func errorf(code, format string, args ...interface{}) error {
+	return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
+
+func (f *SQS) queueByURL(url string) (*queue, bool) {
+	for _, q := range f.queues {
+		if q.url == url {
+			return q, true
+		}
+	}
+	return nil, false
+}
+
+func (f *SQS) queueByArn(arn string) (*queue, bool) {
+	for _, q := range f.queues {
+		if q.arn == arn {
+			return q, true
+		}
+	}
+	return nil, false
+}
+
+// lookup resolves a queue URL and applies injected call failures.
+func (f *SQS) lookup(operation string, url *string) (*queue, error) {
+	if f.callFailure != nil {
+		if err := f.callFailure(operation, aws.StringValue(url)); err != nil {
+			return nil, err
+		}
+	}
+
+	q, ok := f.queueByURL(aws.StringValue(url))
+	if !ok {
+		return nil, errorf(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist for this wsdl version.")
+	}
+
+	return q, nil
+}
+
+func (q *queue) fifo() bool {
+	return strings.HasSuffix(q.name, ".fifo")
+}
+
+func (q *queue) duration(attribute string, fallback time.Duration) time.Duration {
+	if value, ok := q.attributes[attribute]; ok {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return fallback
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+// newID returns a random UUID shaped message ID.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateQueue creates a queue, FIFO queues must end in .fifo.
+func (f *SQS) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.QueueName)
+	if name == "" {
+		return nil, errorf("InvalidParameterValue", "Queue name is required")
+	}
+
+	attributes := aws.StringValueMap(input.Attributes)
+	if attributes[sqs.QueueAttributeNameFifoQueue] == "true" && !strings.HasSuffix(name, ".fifo") {
+		return nil, errorf("InvalidParameterValue", "The name of a FIFO queue can only include alphanumeric characters, hyphens, or underscores, must end with .fifo suffix")
+	}
+
+	if q, ok := f.queues[name]; ok {
+		return &sqs.CreateQueueOutput{QueueUrl: aws.String(q.url)}, nil
+	}
+
+	q := &queue{
+		name:         name,
+		url:          fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", f.Region, f.AccountID, name),
+		arn:          fmt.Sprintf("arn:aws:sqs:%s:%s:%s", f.Region, f.AccountID, name),
+		accountID:    f.AccountID,
+		attributes:   attributes,
+		tags:         aws.StringValueMap(input.Tags),
+		created:      f.now(),
+		deduplicated: map[string]time.Time{},
+	}
+
+	if strings.HasSuffix(name, ".fifo") {
+		q.attributes[sqs.QueueAttributeNameFifoQueue] = "true"
+	}
+
+	f.queues[name] = q
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+func (f *SQS) CreateQueueWithContext(ctx aws.Context, input *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+	return f.CreateQueue(input)
+}
+
+func (f *SQS) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("DeleteQueue", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(f.queues, q.name)
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+func (f *SQS) DeleteQueueWithContext(ctx aws.Context, input *sqs.DeleteQueueInput, opts ...request.Option) (*sqs.DeleteQueueOutput, error) {
+	return f.DeleteQueue(input)
+}
+
+func (f *SQS) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.callFailure != nil {
+		if err := f.callFailure("GetQueueUrl", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	q, ok := f.queues[aws.StringValue(input.QueueName)]
+	if !ok || (input.QueueOwnerAWSAccountId != nil && aws.StringValue(input.QueueOwnerAWSAccountId) != q.accountID) {
+		return nil, errorf(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist for this wsdl version.")
+	}
+
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+func (f *SQS) GetQueueUrlWithContext(ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	return f.GetQueueUrl(input)
+}
+
+func (f *SQS) ListQueues(input *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var urls []string
+	for name, q := range f.queues {
+		if strings.HasPrefix(name, aws.StringValue(input.QueueNamePrefix)) {
+			urls = append(urls, q.url)
+		}
+	}
+	sort.Strings(urls)
+
+	return &sqs.ListQueuesOutput{QueueUrls: aws.StringSlice(urls)}, nil
+}
+
+func (f *SQS) ListQueuesWithContext(ctx aws.Context, input *sqs.ListQueuesInput, opts ...request.Option) (*sqs.ListQueuesOutput, error) {
+	return f.ListQueues(input)
+}
+
+func (f *SQS) ListQueuesPagesWithContext(ctx aws.Context, input *sqs.ListQueuesInput, fn func(*sqs.ListQueuesOutput, bool) bool, opts ...request.Option) error {
+	output, err := f.ListQueues(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *SQS) ListQueuesPages(input *sqs.ListQueuesInput, fn func(*sqs.ListQueuesOutput, bool) bool) error {
+	return f.ListQueuesPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (f *SQS) ListDeadLetterSourceQueues(input *sqs.ListDeadLetterSourceQueuesInput) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dlq, err := f.lookup("ListDeadLetterSourceQueues", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, q := range f.queues {
+		if target, _ := q.redrivePolicy(); target == dlq.arn {
+			urls = append(urls, q.url)
+		}
+	}
+	sort.Strings(urls)
+
+	return &sqs.ListDeadLetterSourceQueuesOutput{QueueUrls: aws.StringSlice(urls)}, nil
+}
+
+func (f *SQS) ListDeadLetterSourceQueuesWithContext(ctx aws.Context, input *sqs.ListDeadLetterSourceQueuesInput, opts ...request.Option) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	return f.ListDeadLetterSourceQueues(input)
+}
+
+func (f *SQS) ListQueueTags(input *sqs.ListQueueTagsInput) (*sqs.ListQueueTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("ListQueueTags", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqs.ListQueueTagsOutput{Tags: aws.StringMap(q.tags)}, nil
+}
+
+func (f *SQS) ListQueueTagsWithContext(ctx aws.Context, input *sqs.ListQueueTagsInput, opts ...request.Option) (*sqs.ListQueueTagsOutput, error) {
+	return f.ListQueueTags(input)
+}
+
+// redrivePolicy returns the dead-letter queue ARN and maxReceiveCount.
+func (q *queue) redrivePolicy() (string, int) {
+	policy, ok := q.attributes[sqs.QueueAttributeNameRedrivePolicy]
+	if !ok {
+		return "", 0
+	}
+
+	var parsed struct {
+		DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+		MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(policy), &parsed); err != nil {
+		return "", 0
+	}
+
+	count, _ := strconv.Atoi(parsed.MaxReceiveCount.String())
+	return parsed.DeadLetterTargetArn, count
+}
+
+func (f *SQS) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("GetQueueAttributes", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	now := f.now()
+	visible, notVisible, delayed := 0, 0, 0
+	for _, m := range q.messages {
+		switch {
+		case m.inFlight && now.Before(m.visibleAt):
+			notVisible++
+		case now.Before(m.visibleAt):
+			delayed++
+		default:
+			visible++
+		}
+	}
+
+	all := map[string]string{
+		sqs.QueueAttributeNameQueueArn:                              q.arn,
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           strconv.Itoa(visible),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: strconv.Itoa(notVisible),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    strconv.Itoa(delayed),
+		sqs.QueueAttributeNameCreatedTimestamp:                      strconv.FormatInt(q.created.Unix(), 10),
+		sqs.QueueAttributeNameVisibilityTimeout:                     strconv.Itoa(int(q.duration(sqs.QueueAttributeNameVisibilityTimeout, DefaultVisibility).Seconds())),
+		sqs.QueueAttributeNameMaximumMessageSize:                    strconv.Itoa(MaxMessageSize),
+		sqs.QueueAttributeNameMessageRetentionPeriod:                "345600",
+		sqs.QueueAttributeNameDelaySeconds:                          "0",
+		sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds:         "0",
+	}
+	for name, value := range q.attributes {
+		all[name] = value
+	}
+
+	requested := aws.StringValueSlice(input.AttributeNames)
+	result := map[string]string{}
+	for _, name := range requested {
+		if name == sqs.QueueAttributeNameAll {
+			result = all
+			break
+		}
+		if value, ok := all[name]; ok {
+			result[name] = value
+		}
+	}
+
+	return &sqs.GetQueueAttributesOutput{Attributes: aws.StringMap(result)}, nil
+}
+
+func (f *SQS) GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	return f.GetQueueAttributes(input)
+}
+
+func (f *SQS) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("SetQueueAttributes", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range input.Attributes {
+		if name == sqs.QueueAttributeNameFifoQueue {
+			return nil, errorf("InvalidAttributeName", "Unknown Attribute FifoQueue.")
+		}
+		q.attributes[name] = aws.StringValue(value)
+	}
+
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func (f *SQS) SetQueueAttributesWithContext(ctx aws.Context, input *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error) {
+	return f.SetQueueAttributes(input)
+}
+
+func (f *SQS) PurgeQueue(input *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("PurgeQueue", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	q.messages = nil
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+func (f *SQS) PurgeQueueWithContext(ctx aws.Context, input *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error) {
+	return f.PurgeQueue(input)
+}
+
+// sendEntry is the common shape of SendMessage and SendMessageBatch entries.
+type sendEntry struct {
+	body            *string
+	delaySeconds    *int64
+	attributes      map[string]*sqs.MessageAttributeValue
+	systemAttrs     map[string]*sqs.MessageSystemAttributeValue
+	groupID         *string
+	deduplicationID *string
+}
+
+func messageSize(e sendEntry) int {
+	size := len(aws.StringValue(e.body))
+	for name, value := range e.attributes {
+		size += len(name) + len(aws.StringValue(value.DataType)) + len(aws.StringValue(value.StringValue)) + len(value.BinaryValue)
+	}
+	return size
+}
+
+// send validates and enqueues a message, returning its ID, MD5 and sequence
+// number. A FIFO message within the deduplication interval is accepted but
+// not enqueued again.
+func (f *SQS) send(q *queue, e sendEntry) (*message, error) {
+	body := aws.StringValue(e.body)
+
+	if body == "" {
+		return nil, errorf("MissingParameter", "The request must contain the parameter MessageBody.")
+	}
+
+	if messageSize(e) > MaxMessageSize {
+		return nil, errorf("InvalidParameterValue", "One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", MaxMessageSize)
+	}
+
+	for name, value := range e.attributes {
+		if value == nil || value.DataType == nil {
+			return nil, errorf("InvalidParameterValue", "The message attribute '%s' must contain a non-empty attribute type.", name)
+		}
+	}
+
+	now := f.now()
+	m := &message{
+		id:               newID(),
+		body:             body,
+		md5OfBody:        md5Hex(body),
+		attributes:       e.attributes,
+		systemAttributes: map[string]string{},
+		sent:             now,
+		visibleAt:        now.Add(q.duration(sqs.QueueAttributeNameDelaySeconds, 0)),
+	}
+
+	if e.delaySeconds != nil {
+		if q.fifo() {
+			return nil, errorf("InvalidParameterValue", "Value %d for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type.", *e.delaySeconds)
+		}
+		m.visibleAt = now.Add(time.Duration(*e.delaySeconds) * time.Second)
+	}
+
+	if traceHeader, ok := e.systemAttrs[sqs.MessageSystemAttributeNameForSendsAwstraceHeader]; ok {
+		m.systemAttributes[sqs.MessageSystemAttributeNameAwstraceHeader] = aws.StringValue(traceHeader.StringValue)
+	}
+
+	if !q.fifo() {
+		if e.groupID != nil || e.deduplicationID != nil {
+			return nil, errorf("InvalidParameterValue", "The request include parameter that is not valid for this queue type")
+		}
+		q.messages = append(q.messages, m)
+		return m, nil
+	}
+
+	if aws.StringValue(e.groupID) == "" {
+		return nil, errorf("MissingParameter", "The request must contain the parameter MessageGroupId.")
+	}
+
+	deduplicationID := aws.StringValue(e.deduplicationID)
+	if deduplicationID == "" {
+		if q.attributes[sqs.QueueAttributeNameContentBasedDeduplication] != "true" {
+			return nil, errorf("InvalidParameterValue", "The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+		}
+		deduplicationID = hex.EncodeToString(sha256Sum(body))
+	}
+
+	f.sequence++
+	m.groupID = aws.StringValue(e.groupID)
+	m.deduplicationID = deduplicationID
+	m.sequenceNumber = fmt.Sprintf("%020d", f.sequence)
+
+	if sentAt, ok := q.deduplicated[deduplicationID]; ok && now.Sub(sentAt) < DeduplicationInterval {
+		return m, nil
+	}
+
+	q.deduplicated[deduplicationID] = now
+	q.messages = append(q.messages, m)
+	return m, nil
+}
+
+func (f *SQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("SendMessage", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := f.send(q, sendEntry{
+		body:            input.MessageBody,
+		delaySeconds:    input.DelaySeconds,
+		attributes:      input.MessageAttributes,
+		systemAttrs:     input.MessageSystemAttributes,
+		groupID:         input.MessageGroupId,
+		deduplicationID: input.MessageDeduplicationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := &sqs.SendMessageOutput{MessageId: aws.String(m.id), MD5OfMessageBody: aws.String(m.md5OfBody)}
+	if m.sequenceNumber != "" {
+		output.SequenceNumber = aws.String(m.sequenceNumber)
+	}
+	return output, nil
+}
+
+func (f *SQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	return f.SendMessage(input)
+}
+
+var batchEntryID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// validateBatch enforces the limits SQS applies to every batch request.
+func validateBatch(ids []string) error {
+	if len(ids) == 0 {
+		return errorf(sqs.ErrCodeEmptyBatchRequest, "There should be at least one entry in the request.")
+	}
+
+	if len(ids) > MaxBatchEntries {
+		return errorf(sqs.ErrCodeTooManyEntriesInBatchRequest, "Maximum number of entries per request are %d. You have sent %d.", MaxBatchEntries, len(ids))
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !batchEntryID.MatchString(id) {
+			return errorf(sqs.ErrCodeInvalidBatchEntryId, "A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long.")
+		}
+		if seen[id] {
+			return errorf(sqs.ErrCodeBatchEntryIdsNotDistinct, "Id %s repeated.", id)
+		}
+		seen[id] = true
+	}
+
+	return nil
+}
+
+func batchErrorEntry(id string, err error) *sqs.BatchResultErrorEntry {
+	code := "InternalError"
+	senderFault := false
+	if awsErr, ok := err.(awserr.Error); ok {
+		code = awsErr.Code()
+		senderFault = true
+	}
+	return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String(code), Message: aws.String(err.Error()), SenderFault: aws.Bool(senderFault)}
+}
+
+func (f *SQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("SendMessageBatch", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(input.Entries))
+	total := 0
+	for i, entry := range input.Entries {
+		ids[i] = aws.StringValue(entry.Id)
+		total += messageSize(sendEntry{body: entry.MessageBody, attributes: entry.MessageAttributes})
+	}
+
+	if err := validateBatch(ids); err != nil {
+		return nil, err
+	}
+
+	if total > MaxBatchSize {
+		return nil, errorf(sqs.ErrCodeBatchRequestTooLong, "Batch requests cannot be longer than %d bytes. You have sent %d bytes.", MaxBatchSize, total)
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+
+	for _, entry := range input.Entries {
+		id := aws.StringValue(entry.Id)
+
+		if f.entryFailure != nil {
+			if failed := f.entryFailure("SendMessageBatch", id); failed != nil {
+				output.Failed = append(output.Failed, failed)
+				continue
+			}
+		}
+
+		m, err := f.send(q, sendEntry{
+			body:            entry.MessageBody,
+			delaySeconds:    entry.DelaySeconds,
+			attributes:      entry.MessageAttributes,
+			systemAttrs:     entry.MessageSystemAttributes,
+			groupID:         entry.MessageGroupId,
+			deduplicationID: entry.MessageDeduplicationId,
+		})
+
+		if err != nil {
+			output.Failed = append(output.Failed, batchErrorEntry(id, err))
+			continue
+		}
+
+		result := &sqs.SendMessageBatchResultEntry{Id: aws.String(id), MessageId: aws.String(m.id), MD5OfMessageBody: aws.String(m.md5OfBody)}
+		if m.sequenceNumber != "" {
+			result.SequenceNumber = aws.String(m.sequenceNumber)
+		}
+		output.Successful = append(output.Successful, result)
+	}
+
+	return output, nil
+}
+
+func (f *SQS) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	return f.SendMessageBatch(input)
+}
+
+// redrive moves messages which exceeded the queue's maxReceiveCount to its
+// dead-letter queue, as SQS does when they are received again.
+func (f *SQS) redrive(q *queue, m *message) bool {
+	target, maxReceiveCount := q.redrivePolicy()
+	if maxReceiveCount == 0 || m.receiveCount < maxReceiveCount {
+		return false
+	}
+
+	dlq, ok := f.queueByArn(target)
+	if !ok {
+		return false
+	}
+
+	m.inFlight = false
+	m.visibleAt = f.now()
+	m.receiptHandle = ""
+	dlq.messages = append(dlq.messages, m)
+	return true
+}
+
+func includesName(requested []*string, name string) bool {
+	for _, r := range requested {
+		value := aws.StringValue(r)
+		if value == sqs.QueueAttributeNameAll || value == ".*" || value == name {
+			return true
+		}
+		if strings.HasSuffix(value, ".*") && strings.HasPrefix(name, strings.TrimSuffix(value, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *SQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("ReceiveMessage", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	max := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if input.MaxNumberOfMessages == nil {
+		max = 1
+	}
+	if max < 1 || max > MaxBatchEntries {
+		return nil, errorf("InvalidParameterValue", "Value %d for parameter MaxNumberOfMessages is invalid. Reason: Must be between 1 and 10, if provided.", max)
+	}
+
+	visibility := q.duration(sqs.QueueAttributeNameVisibilityTimeout, DefaultVisibility)
+	if input.VisibilityTimeout != nil {
+		visibility = time.Duration(*input.VisibilityTimeout) * time.Second
+	}
+
+	now := f.now()
+	output := &sqs.ReceiveMessageOutput{}
+	// FIFO groups with messages in flight, or already returned in this
+	// call, block later messages of the same group.
+	blockedGroups := map[string]bool{}
+
+	if q.fifo() {
+		for _, m := range q.messages {
+			if m.inFlight && now.Before(m.visibleAt) {
+				blockedGroups[m.groupID] = true
+			}
+		}
+	}
+
+	var remaining []*message
+	for _, m := range q.messages {
+		if len(output.Messages) >= max || now.Before(m.visibleAt) || blockedGroups[m.groupID] && q.fifo() {
+			remaining = append(remaining, m)
+			continue
+		}
+
+		if f.redrive(q, m) {
+			continue
+		}
+
+		m.receiveCount++
+		if m.firstReceive.IsZero() {
+			m.firstReceive = now
+		}
+		m.inFlight = true
+		m.visibleAt = now.Add(visibility)
+		m.receiptGeneration++
+		m.receiptHandle = fmt.Sprintf("%s#%d", m.id, m.receiptGeneration)
+		remaining = append(remaining, m)
+
+		output.Messages = append(output.Messages, f.receivedMessage(m, input))
+	}
+	q.messages = remaining
+
+	return output, nil
+}
+
+func (f *SQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	return f.ReceiveMessage(input)
+}
+
+func (f *SQS) receivedMessage(m *message, input *sqs.ReceiveMessageInput) *sqs.Message {
+	result := &sqs.Message{
+		MessageId:     aws.String(m.id),
+		Body:          aws.String(m.body),
+		MD5OfBody:     aws.String(m.md5OfBody),
+		ReceiptHandle: aws.String(m.receiptHandle),
+	}
+
+	system := map[string]string{
+		sqs.MessageSystemAttributeNameSenderId:                         f.AccountID,
+		sqs.MessageSystemAttributeNameSentTimestamp:                    strconv.FormatInt(m.sent.UnixNano()/int64(time.Millisecond), 10),
+		sqs.MessageSystemAttributeNameApproximateReceiveCount:          strconv.Itoa(m.receiveCount),
+		sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: strconv.FormatInt(m.firstReceive.UnixNano()/int64(time.Millisecond), 10),
+	}
+	for name, value := range m.systemAttributes {
+		system[name] = value
+	}
+	if m.groupID != "" {
+		system[sqs.MessageSystemAttributeNameMessageGroupId] = m.groupID
+		system[sqs.MessageSystemAttributeNameMessageDeduplicationId] = m.deduplicationID
+		system[sqs.MessageSystemAttributeNameSequenceNumber] = m.sequenceNumber
+	}
+
+	for name, value := range system {
+		if includesName(input.AttributeNames, name) {
+			if result.Attributes == nil {
+				result.Attributes = map[string]*string{}
+			}
+			result.Attributes[name] = aws.String(value)
+		}
+	}
+
+	for name, value := range m.attributes {
+		if includesName(input.MessageAttributeNames, name) {
+			if result.MessageAttributes == nil {
+				result.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+			}
+			result.MessageAttributes[name] = value
+		}
+	}
+
+	return result
+}
+
+// deleteByHandle removes the message currently holding the receipt handle.
+func (q *queue) deleteByHandle(handle string) error {
+	for i, m := range q.messages {
+		if m.receiptHandle != "" && m.receiptHandle == handle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+
+	if strings.Contains(handle, "#") {
+		// Stale handles of messages which were already deleted succeed.
+		return nil
+	}
+
+	return errorf(sqs.ErrCodeReceiptHandleIsInvalid, "The input receipt handle \"%s\" is not a valid receipt handle.", handle)
+}
+
+func (f *SQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("DeleteMessage", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.deleteByHandle(aws.StringValue(input.ReceiptHandle)); err != nil {
+		return nil, err
+	}
+
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *SQS) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	return f.DeleteMessage(input)
+}
+
+func (f *SQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("DeleteMessageBatch", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(input.Entries))
+	for i, entry := range input.Entries {
+		ids[i] = aws.StringValue(entry.Id)
+	}
+
+	if err := validateBatch(ids); err != nil {
+		return nil, err
+	}
+
+	output := &sqs.DeleteMessageBatchOutput{}
+
+	for _, entry := range input.Entries {
+		id := aws.StringValue(entry.Id)
+
+		if f.entryFailure != nil {
+			if failed := f.entryFailure("DeleteMessageBatch", id); failed != nil {
+				output.Failed = append(output.Failed, failed)
+				continue
+			}
+		}
+
+		if err := q.deleteByHandle(aws.StringValue(entry.ReceiptHandle)); err != nil {
+			output.Failed = append(output.Failed, batchErrorEntry(id, err))
+			continue
+		}
+
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: aws.String(id)})
+	}
+
+	return output, nil
+}
+
+func (f *SQS) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	return f.DeleteMessageBatch(input)
+}
+
+func (q *queue) changeVisibility(handle string, timeout int64, now time.Time) error {
+	if timeout < 0 || timeout > 43200 {
+		return errorf("InvalidParameterValue", "Value %d for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and 43200.", timeout)
+	}
+
+	for _, m := range q.messages {
+		if m.receiptHandle != "" && m.receiptHandle == handle {
+			if !m.inFlight {
+				return errorf(sqs.ErrCodeMessageNotInflight, "Message does not exist or is not available for visibility timeout change.")
+			}
+			m.visibleAt = now.Add(time.Duration(timeout) * time.Second)
+			if timeout == 0 {
+				m.inFlight = false
+			}
+			return nil
+		}
+	}
+
+	return errorf(sqs.ErrCodeReceiptHandleIsInvalid, "The input receipt handle \"%s\" is not a valid receipt handle.", handle)
+}
+
+func (f *SQS) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("ChangeMessageVisibility", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.changeVisibility(aws.StringValue(input.ReceiptHandle), aws.Int64Value(input.VisibilityTimeout), f.now()); err != nil {
+		return nil, err
+	}
+
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *SQS) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return f.ChangeMessageVisibility(input)
+}
+
+func (f *SQS) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup("ChangeMessageVisibilityBatch", input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(input.Entries))
+	for i, entry := range input.Entries {
+		ids[i] = aws.StringValue(entry.Id)
+	}
+
+	if err := validateBatch(ids); err != nil {
+		return nil, err
+	}
+
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	now := f.now()
+
+	for _, entry := range input.Entries {
+		id := aws.StringValue(entry.Id)
+
+		if f.entryFailure != nil {
+			if failed := f.entryFailure("ChangeMessageVisibilityBatch", id); failed != nil {
+				output.Failed = append(output.Failed, failed)
+				continue
+			}
+		}
+
+		if err := q.changeVisibility(aws.StringValue(entry.ReceiptHandle), aws.Int64Value(entry.VisibilityTimeout), now); err != nil {
+			output.Failed = append(output.Failed, batchErrorEntry(id, err))
+			continue
+		}
+
+		output.Successful = append(output.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: aws.String(id)})
+	}
+
+	return output, nil
+}
+
+func (f *SQS) ChangeMessageVisibilityBatchWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return f.ChangeMessageVisibilityBatch(input)
+}
@@ -0,0 +1,121 @@
+package rtksqs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MessageFilter selects messages by a condition.
+type MessageFilter struct {
+	// Description is the condition in skip reasons, e.g. "body =~ /poison/".
+	Description string
+	Match       func(message *sqs.Message) bool
+}
+
+// BodyFilter matches messages whose body matches pattern.
+func BodyFilter(pattern *regexp.Regexp) MessageFilter {
+	return MessageFilter{
+		Description: fmt.Sprintf("body =~ /%s/", pattern),
+		Match: func(message *sqs.Message) bool {
+			return pattern.MatchString(aws.StringValue(message.Body))
+		},
+	}
+}
+
+// AttributeFilter matches messages with a message attribute spec, name=value,
+// or name to match any value. Binary attributes never match a value.
+func AttributeFilter(spec string) MessageFilter {
+	name, value, hasValue := spec, "", false
+	if i := strings.Index(spec, "="); i >= 0 {
+		name, value, hasValue = spec[:i], spec[i+1:], true
+	}
+
+	return MessageFilter{
+		Description: fmt.Sprintf("attribute %s", strings.Replace(spec, "=", " = ", 1)),
+		Match: func(message *sqs.Message) bool {
+			attribute, ok := message.MessageAttributes[name]
+			if !ok {
+				return false
+			}
+			return !hasValue || (attribute.StringValue != nil && *attribute.StringValue == value)
+		},
+	}
+}
+
+// AgeFilter matches messages sent more than age ago. Messages without a
+// SentTimestamp never match.
+func AgeFilter(age time.Duration) MessageFilter {
+	return MessageFilter{
+		Description: fmt.Sprintf("age > %s", age),
+		Match: func(message *sqs.Message) bool {
+			sent, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64)
+			if err != nil {
+				return false
+			}
+			return time.Since(time.Unix(0, sent*int64(time.Millisecond))) > age
+		},
+	}
+}
+
+// ReceiveCountFilter matches messages received at least count times, as
+// counted by ApproximateReceiveCount, including the receive of the move.
+// Messages without a receive count never match.
+func ReceiveCountFilter(count int) MessageFilter {
+	return MessageFilter{
+		Description: fmt.Sprintf("receive count >= %d", count),
+		Match: func(message *sqs.Message) bool {
+			received, err := strconv.Atoi(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+			return err == nil && received >= count
+		},
+	}
+}
+
+// SampleFilter matches a pseudo-random fraction, between 0 and 1, of the
+// messages. Messages are chosen by hashing their message ID with the seed,
+// so the same seed selects the same messages on every run, e.g. of a dry run
+// into a file and the real move, whatever order they are received in.
+func SampleFilter(fraction float64, seed string) MessageFilter {
+	return MessageFilter{
+		Description: fmt.Sprintf("sample of %g%% with seed %s", fraction*100, seed),
+		Match: func(message *sqs.Message) bool {
+			hash := sha256.Sum256([]byte(seed + "\x00" + aws.StringValue(message.MessageId)))
+			return float64(binary.BigEndian.Uint64(hash[:8])) < fraction*math.MaxUint64
+		},
+	}
+}
+
+// FilterHook returns a hook moving only the messages every filter matches,
+// skipping the others. Inverted, it moves only the messages which at least
+// one filter doesn't match, e.g. to move everything but a known poison
+// payload.
+func FilterHook(filters []MessageFilter, invert bool) MessageHook {
+	return func(message *sqs.Message) string {
+		for _, filter := range filters {
+			if !filter.Match(message) {
+				if invert {
+					return ""
+				}
+				return fmt.Sprintf("doesn't match %s", filter.Description)
+			}
+		}
+
+		if !invert {
+			return ""
+		}
+
+		descriptions := make([]string, len(filters))
+		for i, filter := range filters {
+			descriptions[i] = filter.Description
+		}
+		return fmt.Sprintf("matches %s", strings.Join(descriptions, " and "))
+	}
+}
@@ -0,0 +1,100 @@
+package rtksqs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// DefaultHealthInterval is how often the health check of the consumer is
+// polled unless MoveOptions.HealthInterval is set.
+const DefaultHealthInterval = 10 * time.Second
+
+// healthGate pauses a move while the health check of the destination's
+// consumer fails, so messages are only redriven while it can process them.
+type healthGate struct {
+	url       string
+	interval  time.Duration
+	client    *http.Client
+	lastCheck time.Time
+}
+
+func newHealthGate(url string, interval time.Duration) *healthGate {
+	if interval == 0 {
+		interval = DefaultHealthInterval
+	}
+
+	return &healthGate{url: url, interval: interval, client: &http.Client{Timeout: interval}}
+}
+
+// check returns why the consumer is unhealthy, or "" when the health check
+// responded with a 2xx status.
+func (g *healthGate) check(ctx context.Context) string {
+	req, err := http.NewRequest(http.MethodGet, g.url, nil)
+	if err != nil {
+		return err.Error()
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Sprintf("responded with %s", resp.Status)
+	}
+
+	return ""
+}
+
+// wait returns once the consumer is healthy, ErrAborted once control aborts
+// the move, or the error of ctx once it is done. The health check is polled
+// at most once per interval.
+func (g *healthGate) wait(ctx context.Context, control *MoveControl) error {
+	if time.Since(g.lastCheck) < g.interval {
+		return nil
+	}
+
+	var done, aborted <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if control != nil {
+		aborted = control.aborted
+	}
+
+	paused := false
+
+	for {
+		reason := g.check(ctx)
+		g.lastCheck = time.Now()
+
+		if reason == "" {
+			if paused {
+				log.Info(color.New(color.FgCyan).Sprintf("The consumer is healthy again, resuming"))
+			}
+			return nil
+		}
+
+		if !paused {
+			log.Warn(color.New(color.FgYellow).Sprintf("The health check %s of the consumer failed, pausing until it is healthy: %s", g.url, reason))
+			paused = true
+		}
+
+		select {
+		case <-time.After(g.interval):
+		case <-done:
+			return ctx.Err()
+		case <-aborted:
+			return ErrAborted
+		}
+	}
+}
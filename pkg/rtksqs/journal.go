@@ -0,0 +1,446 @@
+package rtksqs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+)
+
+const dynamodbScheme = "dynamodb://"
+
+// SendJournal records the messages a move sent before deleting them from the
+// source, and forgets them once deleted. A move receiving a message the
+// journal still holds, because the process crashed between sending and
+// deleting it, deletes it without sending it again. Messages are keyed by the
+// source and their message ID, receipt handles change with every receive.
+// Implementations are safe for concurrent use by many moves.
+type SendJournal interface {
+	// Sent returns the messages of the source which were journaled as sent.
+	Sent(source string, messages []*sqs.Message) ([]*sqs.Message, error)
+	// Record journals the messages of the source as sent to destination.
+	Record(source, destination string, messages []*sqs.Message) error
+	// Forget drops the messages of the source deleted from it.
+	Forget(source string, messages []*sqs.Message) error
+	Close() error
+}
+
+// OpenSendJournal opens the journal in the DynamoDB table of a
+// dynamodb://<table> URL, or else in a local file.
+func OpenSendJournal(sess *session.Session, spec string) (SendJournal, error) {
+	if strings.HasPrefix(spec, dynamodbScheme) {
+		table := strings.TrimPrefix(spec, dynamodbScheme)
+		if table == "" {
+			return nil, fmt.Errorf("%s names no table", spec)
+		}
+		return &dynamodbJournal{svc: dynamodb.New(sess), table: table}, nil
+	}
+
+	return openFileJournal(spec)
+}
+
+// reconcileJournal deletes the messages the journal holds as sent from the
+// source without sending them again, and returns the others and those
+// deleted.
+func reconcileJournal(journal SendJournal, source Source, messages []*sqs.Message) ([]*sqs.Message, []*sqs.Message, error) {
+	sent, err := journal.Sent(source.String(), journaled(messages))
+	if err != nil {
+		return nil, nil, &MoveError{Step: StepJournal, Err: err}
+	}
+
+	if len(sent) == 0 {
+		return messages, nil, nil
+	}
+
+	if err := source.Delete(sent); err != nil {
+		return nil, nil, &MoveError{Step: StepDelete, Err: err}
+	}
+
+	log.Info(color.New(color.FgCyan).Sprintf("Deleted %d messages from %s without sending them again, the journal holds them as sent before", len(sent), source))
+	journalDeleted(journal, source, sent)
+
+	return unsentMessages(messages, sent), sent, nil
+}
+
+// journalSent records messages sent to sink before they are deleted from the
+// source. A failed record doesn't stop the move, it only leaves the messages
+// unprotected from a crash before they are deleted.
+func journalSent(journal SendJournal, source Source, sink Sink, messages []*sqs.Message) {
+	if messages = journaled(messages); journal == nil || len(messages) == 0 {
+		return
+	}
+
+	if err := journal.Record(source.String(), sink.String(), messages); err != nil {
+		log.Warn(color.New(color.FgYellow).Sprintf("Failed to journal %d sent messages, a crash before they are deleted from the source would send them again: %s", len(messages), err))
+	}
+}
+
+// journalDeleted drops messages deleted from the source from the journal. A
+// failure leaves stale entries, which never match a message again.
+func journalDeleted(journal SendJournal, source Source, messages []*sqs.Message) {
+	if messages = journaled(messages); journal == nil || len(messages) == 0 {
+		return
+	}
+
+	if err := journal.Forget(source.String(), messages); err != nil {
+		log.Warn(color.New(color.FgYellow).Sprintf("Failed to drop %d deleted messages from the journal: %s", len(messages), err))
+	}
+}
+
+// journaled returns the messages which can be journaled, those of dumps
+// without message IDs can't.
+func journaled(messages []*sqs.Message) []*sqs.Message {
+	var result []*sqs.Message
+	for _, message := range messages {
+		if aws.StringValue(message.MessageId) != "" {
+			result = append(result, message)
+		}
+	}
+
+	return result
+}
+
+// journalKey identifies a message of a source in a journal.
+func journalKey(source string, message *sqs.Message) string {
+	return source + "#" + aws.StringValue(message.MessageId)
+}
+
+// journalEntry is a line of a file journal, a message sent, or deleted when
+// Deleted.
+type journalEntry struct {
+	Source      string     `json:"source"`
+	MessageID   string     `json:"messageId"`
+	Destination string     `json:"destination,omitempty"`
+	SentAt      *time.Time `json:"sentAt,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// fileJournal appends entries to a file, synced before Record returns. The
+// file is rewritten with the messages still pending when opened and closed,
+// so it only grows while a run lasts.
+type fileJournal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	pending map[string]journalEntry
+}
+
+func openFileJournal(path string) (*fileJournal, error) {
+	j := &fileJournal{path: path, pending: map[string]journalEntry{}}
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry journalEntry
+			// A line torn by a crash was never acknowledged.
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				continue
+			}
+
+			key := entry.Source + "#" + entry.MessageID
+			if entry.Deleted {
+				delete(j.pending, key)
+			} else {
+				j.pending[key] = entry
+			}
+		}
+		file.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+
+	if j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// compact replaces the file by the pending entries, renaming a complete file
+// over the old one so a crash can't leave it torn.
+func (j *fileJournal) compact() error {
+	tmp := filepath.Join(filepath.Dir(j.path), "."+filepath.Base(j.path)+".tmp")
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range j.pending {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, j.path)
+}
+
+func (j *fileJournal) Sent(source string, messages []*sqs.Message) ([]*sqs.Message, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var sent []*sqs.Message
+	for _, message := range messages {
+		if _, ok := j.pending[journalKey(source, message)]; ok {
+			sent = append(sent, message)
+		}
+	}
+
+	return sent, nil
+}
+
+func (j *fileJournal) Record(source, destination string, messages []*sqs.Message) error {
+	now := time.Now().UTC()
+	entries := make([]journalEntry, len(messages))
+	for i, message := range messages {
+		entries[i] = journalEntry{Source: source, MessageID: aws.StringValue(message.MessageId), Destination: destination, SentAt: &now}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.append(entries); err != nil {
+		return err
+	}
+	for i, message := range messages {
+		j.pending[journalKey(source, message)] = entries[i]
+	}
+
+	return nil
+}
+
+func (j *fileJournal) Forget(source string, messages []*sqs.Message) error {
+	entries := make([]journalEntry, len(messages))
+	for i, message := range messages {
+		entries[i] = journalEntry{Source: source, MessageID: aws.StringValue(message.MessageId), Deleted: true}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, message := range messages {
+		delete(j.pending, journalKey(source, message))
+	}
+
+	return j.append(entries)
+}
+
+// append writes entries to the file and syncs it.
+func (j *fileJournal) append(entries []journalEntry) error {
+	var lines []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if _, err := j.file.Write(lines); err != nil {
+		return err
+	}
+
+	return j.file.Sync()
+}
+
+func (j *fileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+
+	return j.compact()
+}
+
+// Limits of DynamoDB batch requests.
+const (
+	dynamodbBatchGetSize   = 100
+	dynamodbBatchWriteSize = 25
+	dynamodbRetries        = 5
+)
+
+// journalTTL expires items of a DynamoDB journal after the longest retention
+// of SQS queues, their messages are gone from the source by then.
+const journalTTL = 14 * 24 * time.Hour
+
+// dynamodbJournal keeps the journal in a DynamoDB table with a string
+// partition key named id. Items carry an expiresAt epoch, which TTL of the
+// table may be enabled on to drop items of messages a crash left behind.
+type dynamodbJournal struct {
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+func (j *dynamodbJournal) Sent(source string, messages []*sqs.Message) ([]*sqs.Message, error) {
+	byKey := make(map[string]*sqs.Message, len(messages))
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, message := range messages {
+		key := journalKey(source, message)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(key)}})
+		}
+		byKey[key] = message
+	}
+
+	var sent []*sqs.Message
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > dynamodbBatchGetSize {
+			batch = batch[:dynamodbBatchGetSize]
+		}
+		keys = keys[len(batch):]
+
+		for retry := 0; len(batch) > 0; retry++ {
+			if retry == dynamodbRetries {
+				return nil, fmt.Errorf("DynamoDB left %d keys of %s unprocessed", len(batch), j.table)
+			}
+			if retry > 0 {
+				time.Sleep(time.Duration(retry) * 100 * time.Millisecond)
+			}
+
+			resp, err := j.svc.BatchGetItem(&dynamodb.BatchGetItemInput{
+				RequestItems: map[string]*dynamodb.KeysAndAttributes{
+					j.table: {Keys: batch, ConsistentRead: aws.Bool(true), ProjectionExpression: aws.String("id")},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, item := range resp.Responses[j.table] {
+				if message, ok := byKey[aws.StringValue(item["id"].S)]; ok {
+					sent = append(sent, message)
+				}
+			}
+
+			batch = nil
+			if unprocessed, ok := resp.UnprocessedKeys[j.table]; ok {
+				batch = unprocessed.Keys
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+func (j *dynamodbJournal) Record(source, destination string, messages []*sqs.Message) error {
+	now := time.Now().UTC()
+	expiresAt := fmt.Sprint(now.Add(journalTTL).Unix())
+
+	var requests []*dynamodb.WriteRequest
+	for _, message := range uniqueMessages(source, messages) {
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{
+			"id":          {S: aws.String(journalKey(source, message))},
+			"source":      {S: aws.String(source)},
+			"messageId":   {S: message.MessageId},
+			"destination": {S: aws.String(destination)},
+			"sentAt":      {S: aws.String(now.Format(time.RFC3339Nano))},
+			"expiresAt":   {N: aws.String(expiresAt)},
+		}}})
+	}
+
+	return j.write(requests)
+}
+
+func (j *dynamodbJournal) Forget(source string, messages []*sqs.Message) error {
+	var requests []*dynamodb.WriteRequest
+	for _, message := range uniqueMessages(source, messages) {
+		requests = append(requests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(journalKey(source, message))},
+		}}})
+	}
+
+	return j.write(requests)
+}
+
+// uniqueMessages drops messages received twice from the messages, a batch
+// write fails on duplicate keys.
+func uniqueMessages(source string, messages []*sqs.Message) []*sqs.Message {
+	seen := make(map[string]bool, len(messages))
+	var result []*sqs.Message
+	for _, message := range messages {
+		if key := journalKey(source, message); !seen[key] {
+			seen[key] = true
+			result = append(result, message)
+		}
+	}
+
+	return result
+}
+
+// write sends the requests in batches, retrying those DynamoDB left
+// unprocessed.
+func (j *dynamodbJournal) write(requests []*dynamodb.WriteRequest) error {
+	for len(requests) > 0 {
+		batch := requests
+		if len(batch) > dynamodbBatchWriteSize {
+			batch = batch[:dynamodbBatchWriteSize]
+		}
+		requests = requests[len(batch):]
+
+		for retry := 0; len(batch) > 0; retry++ {
+			if retry == dynamodbRetries {
+				return fmt.Errorf("DynamoDB left %d writes to %s unprocessed", len(batch), j.table)
+			}
+			if retry > 0 {
+				time.Sleep(time.Duration(retry) * 100 * time.Millisecond)
+			}
+
+			resp, err := j.svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{j.table: batch},
+			})
+			if err != nil {
+				return err
+			}
+
+			batch = resp.UnprocessedItems[j.table]
+		}
+	}
+
+	return nil
+}
+
+func (j *dynamodbJournal) Close() error {
+	return nil
+}
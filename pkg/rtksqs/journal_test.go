@@ -0,0 +1,298 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+// newFakeDynamodbJournal returns a journal in a table of a DynamoDB endpoint
+// keeping items in memory. The first batch write of more than one request
+// leaves its last request unprocessed.
+func newFakeDynamodbJournal(t *testing.T) *dynamodbJournal {
+	t.Helper()
+
+	type attributes map[string]*dynamodb.AttributeValue
+
+	var mu sync.Mutex
+	items := map[string]attributes{}
+	leftUnprocessed := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var output interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.BatchGetItem":
+			var input struct {
+				RequestItems map[string]struct{ Keys []attributes }
+			}
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			responses := map[string][]attributes{}
+			for table, request := range input.RequestItems {
+				for _, key := range request.Keys {
+					if item, ok := items[table+"/"+aws.StringValue(key["id"].S)]; ok {
+						responses[table] = append(responses[table], attributes{"id": item["id"]})
+					}
+				}
+			}
+			output = map[string]interface{}{"Responses": responses}
+		case "DynamoDB_20120810.BatchWriteItem":
+			var input struct {
+				RequestItems map[string][]struct {
+					PutRequest    *struct{ Item attributes }
+					DeleteRequest *struct{ Key attributes }
+				}
+			}
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			unprocessed := map[string]interface{}{}
+			for table, requests := range input.RequestItems {
+				if !leftUnprocessed && len(requests) > 1 {
+					leftUnprocessed = true
+					unprocessed[table] = requests[len(requests)-1:]
+					requests = requests[:len(requests)-1]
+				}
+				for _, request := range requests {
+					if request.PutRequest != nil {
+						items[table+"/"+aws.StringValue(request.PutRequest.Item["id"].S)] = request.PutRequest.Item
+					}
+					if request.DeleteRequest != nil {
+						delete(items, table+"/"+aws.StringValue(request.DeleteRequest.Key["id"].S))
+					}
+				}
+			}
+			output = map[string]interface{}{"UnprocessedItems": unprocessed}
+		default:
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(server.Close)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	journal, err := OpenSendJournal(sess, dynamodbScheme+"journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journal.(*dynamodbJournal)
+}
+
+func messageIDs(messages []*sqs.Message) []string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = aws.StringValue(message.MessageId)
+	}
+	return sorted(ids)
+}
+
+func TestSendJournal(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(t *testing.T) SendJournal
+	}{
+		{
+			name: "file",
+			open: func(t *testing.T) SendJournal {
+				journal, err := OpenSendJournal(nil, filepath.Join(t.TempDir(), "journal"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return journal
+			},
+		},
+		{
+			name: "dynamodb",
+			open: func(t *testing.T) SendJournal {
+				return newFakeDynamodbJournal(t)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			journal := test.open(t)
+			defer journal.Close()
+
+			messages := testMessages("a", "b", "c")
+
+			// Received twice, m0 is recorded once.
+			if err := journal.Record("source", "destination", append(messages[:2:2], messages[0])); err != nil {
+				t.Fatal(err)
+			}
+
+			sent, err := journal.Sent("source", messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := messageIDs(sent), []string{"m0", "m1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v sent, want %v", got, want)
+			}
+
+			// Messages are keyed by their source.
+			if sent, err := journal.Sent("other", messages); err != nil || len(sent) != 0 {
+				t.Errorf("got %d sent of another source and %v, want none", len(sent), err)
+			}
+
+			if err := journal.Forget("source", messages[:1]); err != nil {
+				t.Fatal(err)
+			}
+
+			sent, err = journal.Sent("source", messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := messageIDs(sent), []string{"m1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v sent after forgetting m0, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileJournalReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal, err := openFileJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := testMessages("a", "b", "c")
+	if err := journal.Record("source", "destination", messages); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Forget("source", messages[1:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash leaves the file as it is, possibly with a torn last line.
+	journal.file.Write([]byte(`{"source":"source","messageId":"m`))
+	journal.file.Close()
+
+	journal, err = openFileJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	sent, err := journal.Sent("source", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messageIDs(sent), []string{"m0", "m2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v sent after reopening, want %v", got, want)
+	}
+
+	// Opening compacts the file to the pending entries.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines int
+	for _, b := range data {
+		if b == '\n' {
+			lines++
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines after compacting, want 2", lines)
+	}
+}
+
+func TestMoveJournal(t *testing.T) {
+	fake := fakesqs.New()
+	now := time.Now()
+	fake.SetClock(func() time.Time { return now })
+
+	sourceURL := fillFakeQueue(t, fake, "source",
+		&sqs.SendMessageInput{MessageBody: aws.String("a")},
+		&sqs.SendMessageInput{MessageBody: aws.String("b")},
+		&sqs.SendMessageInput{MessageBody: aws.String("c")})
+	destinationURL := createFakeQueue(t, fake, "destination")
+
+	journal, err := openFileJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	source, err := openQueueSource(fake, "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := openQueueSink(fake, "destination", "", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The deletes failing stand in for a crash after sending.
+	fake.SetCallFailure(func(operation, queueURL string) error {
+		if operation == "DeleteMessageBatch" {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+
+	if _, err := Move(source, sink, UnknownCount, MoveOptions{Journal: journal}); err == nil {
+		t.Fatal("the move didn't fail to delete")
+	}
+
+	if got := fake.Bodies(sourceURL); len(got) != 3 {
+		t.Fatalf("the source holds %v, want all messages", got)
+	}
+
+	// The messages are received again once their visibility timeout expired.
+	fake.SetCallFailure(nil)
+	now = now.Add(time.Minute)
+
+	moved, err := Move(source, sink, UnknownCount, MoveOptions{Journal: journal})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The journaled messages count as moved, deleted without sending them
+	// again.
+	if moved != 3 {
+		t.Errorf("moved %d messages, want 3", moved)
+	}
+	if got := fake.Bodies(sourceURL); len(got) != 0 {
+		t.Errorf("the source holds %v, want none", got)
+	}
+	if got, want := sorted(fake.Bodies(destinationURL)), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the destination holds %v, want %v once", got, want)
+	}
+	if len(journal.pending) != 0 {
+		t.Errorf("the journal holds %d messages, want none once deleted", len(journal.pending))
+	}
+}
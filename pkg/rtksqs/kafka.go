@@ -0,0 +1,261 @@
+package rtksqs
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const kafkaScheme = "kafka://"
+
+const (
+	kafkaAuthNone      = "none"
+	kafkaAuthPlain     = "plain"
+	kafkaAuthScram256  = "scram-sha-256"
+	kafkaAuthScram512  = "scram-sha-512"
+	kafkaAuthAwsMskIam = "aws-msk-iam"
+)
+
+const (
+	kafkaKeyGroup      = "group"
+	kafkaKeyNone       = "none"
+	kafkaKeyAttrPrefix = "attr:"
+)
+
+const (
+	kafkaUsernameEnv = "KAFKA_USERNAME"
+	kafkaPasswordEnv = "KAFKA_PASSWORD"
+)
+
+const kafkaWriteTimeout = 30 * time.Second
+
+// kafkaBatchTimeout is how long the writer waits for a batch to fill, moves
+// write whole batches at once and wait for them to be written, so the
+// default of a second would pace them to a batch per second.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// kafkaSink produces messages to a Kafka topic. Message attributes become
+// record headers and the record key is taken from the FIFO message group or a
+// message attribute.
+type kafkaSink struct {
+	writer *kafka.Writer
+	name   string
+	// key selects the record key: group, attr:<name> or none.
+	key string
+}
+
+// openKafkaSink opens kafka://<broker>[,<broker>...]/<topic>?auth=&key=&tls=.
+// auth is one of none, plain, scram-sha-256, scram-sha-512 or aws-msk-iam,
+// username and password based mechanisms read KAFKA_USERNAME and
+// KAFKA_PASSWORD. TLS is enabled for every mechanism but none unless tls=false.
+func openKafkaSink(sess *session.Session, spec string) (*kafkaSink, error) {
+	parsed, err := url.Parse(spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	topic := strings.TrimPrefix(parsed.Path, "/")
+
+	if parsed.Host == "" || topic == "" {
+		return nil, fmt.Errorf("kafka destination must be %s<broker>[,<broker>...]/<topic>", kafkaScheme)
+	}
+
+	query := parsed.Query()
+	auth := query.Get("auth")
+	if auth == "" {
+		auth = kafkaAuthNone
+	}
+
+	key := query.Get("key")
+	if key == "" {
+		key = kafkaKeyGroup
+	}
+
+	if key != kafkaKeyGroup && key != kafkaKeyNone && !strings.HasPrefix(key, kafkaKeyAttrPrefix) {
+		return nil, fmt.Errorf("kafka key must be %s, %s<name> or %s", kafkaKeyGroup, kafkaKeyAttrPrefix, kafkaKeyNone)
+	}
+
+	mechanism, err := newKafkaMechanism(sess, auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &kafka.Transport{SASL: mechanism}
+
+	if (auth != kafkaAuthNone && query.Get("tls") != "false") || query.Get("tls") == "true" {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(parsed.Host, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+		WriteTimeout: kafkaWriteTimeout,
+		BatchSize:    DefaultBatchSize,
+		BatchTimeout: kafkaBatchTimeout,
+	}
+
+	return &kafkaSink{writer: writer, name: kafkaScheme + parsed.Host + "/" + topic, key: key}, nil
+}
+
+func newKafkaMechanism(sess *session.Session, auth string) (sasl.Mechanism, error) {
+	switch auth {
+	case kafkaAuthNone:
+		return nil, nil
+	case kafkaAuthPlain:
+		return plain.Mechanism{Username: os.Getenv(kafkaUsernameEnv), Password: os.Getenv(kafkaPasswordEnv)}, nil
+	case kafkaAuthScram256:
+		return scram.Mechanism(scram.SHA256, os.Getenv(kafkaUsernameEnv), os.Getenv(kafkaPasswordEnv))
+	case kafkaAuthScram512:
+		return scram.Mechanism(scram.SHA512, os.Getenv(kafkaUsernameEnv), os.Getenv(kafkaPasswordEnv))
+	case kafkaAuthAwsMskIam:
+		return &mskIamMechanism{signer: v4.NewSigner(sess.Config.Credentials), region: aws.StringValue(sess.Config.Region)}, nil
+	default:
+		return nil, fmt.Errorf("unknown kafka auth %q", auth)
+	}
+}
+
+func (s *kafkaSink) String() string {
+	return s.name
+}
+
+func (s *kafkaSink) Send(messages []*sqs.Message) error {
+	records := make([]kafka.Message, len(messages))
+
+	for i, message := range messages {
+		records[i] = s.convertToKafkaMessage(message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	defer cancel()
+
+	err := s.writer.WriteMessages(ctx, records...)
+
+	var writeErrors kafka.WriteErrors
+	if !errors.As(err, &writeErrors) {
+		return err
+	}
+
+	var failures []BatchFailure
+	for i, writeErr := range writeErrors {
+		if writeErr != nil {
+			failures = append(failures, BatchFailure{
+				ID:      aws.StringValue(messages[i].MessageId),
+				Code:    "ProduceFailed",
+				Message: writeErr.Error(),
+				message: messages[i],
+			})
+		}
+	}
+
+	return &BatchError{Operation: "produce", Failures: failures}
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+func (s *kafkaSink) convertToKafkaMessage(message *sqs.Message) kafka.Message {
+	record := kafka.Message{Value: []byte(aws.StringValue(message.Body))}
+
+	switch {
+	case s.key == kafkaKeyGroup:
+		if groupID, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+			record.Key = []byte(aws.StringValue(groupID))
+		}
+	case strings.HasPrefix(s.key, kafkaKeyAttrPrefix):
+		if value, ok := message.MessageAttributes[strings.TrimPrefix(s.key, kafkaKeyAttrPrefix)]; ok {
+			record.Key = attributeBytes(value)
+		}
+	}
+
+	for name, value := range message.MessageAttributes {
+		record.Headers = append(record.Headers, kafka.Header{Key: name, Value: attributeBytes(value)})
+	}
+
+	return record
+}
+
+// attributeBytes returns the raw value of a message attribute.
+func attributeBytes(value *sqs.MessageAttributeValue) []byte {
+	if value.BinaryValue != nil {
+		return value.BinaryValue
+	}
+	return []byte(aws.StringValue(value.StringValue))
+}
+
+// mskIamMechanism implements the AWS_MSK_IAM SASL mechanism, authenticating
+// with a SigV4 presigned kafka-cluster:Connect request.
+type mskIamMechanism struct {
+	signer *v4.Signer
+	region string
+}
+
+const mskIamExpiry = 15 * time.Minute
+
+func (m *mskIamMechanism) Name() string {
+	return "AWS_MSK_IAM"
+}
+
+func (m *mskIamMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	metadata := sasl.MetadataFromContext(ctx)
+
+	if metadata == nil {
+		return nil, nil, errors.New("missing broker host for AWS_MSK_IAM")
+	}
+
+	query := url.Values{"Action": {"kafka-cluster:Connect"}}
+	req, err := http.NewRequest(http.MethodGet, "kafka://"+metadata.Host+"/?"+query.Encode(), nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := m.signer.Presign(req, nil, "kafka-cluster", m.region, mskIamExpiry, time.Now().UTC()); err != nil {
+		return nil, nil, err
+	}
+
+	payload := map[string]string{
+		"version":    "2020_10_22",
+		"host":       metadata.Host,
+		"user-agent": "sqsmover/" + Version,
+		"action":     "kafka-cluster:Connect",
+	}
+
+	for name, values := range req.URL.Query() {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			payload[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	encoded, err := json.Marshal(payload)
+	return mskIamSession{}, encoded, err
+}
+
+type mskIamSession struct{}
+
+// Next completes the handshake, the broker replies once with its session
+// details when the presigned request was accepted.
+func (mskIamSession) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	return true, nil, nil
+}
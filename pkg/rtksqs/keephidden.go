@@ -0,0 +1,57 @@
+package rtksqs
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+)
+
+// keepHidden keeps received messages of a queue source hidden until stop is
+// called, while a step of the move may take longer than their visibility
+// timeout, e.g. a throttled send backing off. Other sources don't hide
+// messages, stop does nothing then.
+func keepHidden(source Source, messages []*sqs.Message) (stop func()) {
+	held, ok := source.(*deleteRetrySource)
+	if !ok || held.visibility <= 0 || len(messages) == 0 {
+		return func() {}
+	}
+
+	return held.keepHidden(messages)
+}
+
+// keepHidden extends the visibility timeout of messages of the last receive
+// every half of it until stop is called. Failed deletes are retried as long
+// as the last extension hides the messages.
+func (s *deleteRetrySource) keepHidden(messages []*sqs.Message) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.visibility / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			extended := time.Now()
+			if err := s.queue.changeVisibility(messages, s.queue.visibilityTimeout, "hide"); err != nil {
+				log.Warn(color.New(color.FgYellow).Sprintf("Failed to keep %d received messages hidden, they may be received again: %s", len(messages), err))
+				continue
+			}
+			s.received = extended
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
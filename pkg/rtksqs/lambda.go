@@ -0,0 +1,111 @@
+package rtksqs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Lambda formats of message bodies.
+const (
+	LambdaFormatNone   = "none"
+	LambdaFormatUnwrap = "unwrap"
+	LambdaFormatWrap   = "wrap"
+)
+
+// lambdaRecord is the record Lambda sends to an on-failure destination of an
+// asynchronous invocation, wrapping the original event.
+type lambdaRecord struct {
+	Version         string                `json:"version"`
+	Timestamp       string                `json:"timestamp"`
+	RequestContext  lambdaRequestContext  `json:"requestContext"`
+	RequestPayload  json.RawMessage       `json:"requestPayload"`
+	ResponseContext lambdaResponseContext `json:"responseContext"`
+	ResponsePayload json.RawMessage       `json:"responsePayload,omitempty"`
+}
+
+type lambdaRequestContext struct {
+	RequestID              string `json:"requestId"`
+	FunctionArn            string `json:"functionArn"`
+	Condition              string `json:"condition"`
+	ApproximateInvokeCount int    `json:"approximateInvokeCount"`
+}
+
+type lambdaResponseContext struct {
+	StatusCode      int    `json:"statusCode"`
+	ExecutedVersion string `json:"executedVersion"`
+	FunctionError   string `json:"functionError,omitempty"`
+}
+
+// setBody replaces the body of a message and its MD5.
+func setBody(message *sqs.Message, body string) {
+	sum := md5.Sum([]byte(body))
+	message.Body = aws.String(body)
+	message.MD5OfBody = aws.String(hex.EncodeToString(sum[:]))
+}
+
+// LambdaUnwrap returns a hook replacing Lambda failure records by the
+// original event, so they can be replayed to a plain queue. Messages which
+// aren't Lambda failure records are skipped.
+func LambdaUnwrap() MessageHook {
+	return func(message *sqs.Message) string {
+		var record lambdaRecord
+
+		if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &record); err != nil || record.RequestContext.RequestID == "" || len(record.RequestPayload) == 0 {
+			return "is not a Lambda failure record"
+		}
+
+		// Events which were strings are unquoted, anything else is replayed
+		// as the JSON Lambda received.
+		var event string
+		if err := json.Unmarshal(record.RequestPayload, &event); err != nil {
+			event = string(record.RequestPayload)
+		}
+
+		setBody(message, event)
+		return ""
+	}
+}
+
+// LambdaWrap returns a hook wrapping messages into Lambda failure records, for
+// destinations consuming the format of a Lambda on-failure destination.
+// Bodies which are valid JSON become the request payload as is, others as a
+// JSON string.
+func LambdaWrap(functionArn string) MessageHook {
+	return func(message *sqs.Message) string {
+		body := aws.StringValue(message.Body)
+		payload := json.RawMessage(body)
+
+		if !json.Valid(payload) {
+			payload, _ = json.Marshal(body)
+		}
+
+		record, err := json.Marshal(lambdaRecord{
+			Version:   "1.0",
+			Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+			RequestContext: lambdaRequestContext{
+				RequestID:              aws.StringValue(message.MessageId),
+				FunctionArn:            functionArn,
+				Condition:              "RetriesExhausted",
+				ApproximateInvokeCount: 1,
+			},
+			RequestPayload: payload,
+			ResponseContext: lambdaResponseContext{
+				StatusCode:      200,
+				ExecutedVersion: "$LATEST",
+				FunctionError:   "Unhandled",
+			},
+		})
+
+		if err != nil {
+			return "can not be wrapped: " + err.Error()
+		}
+
+		setBody(message, string(record))
+		return ""
+	}
+}
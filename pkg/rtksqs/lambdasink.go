@@ -0,0 +1,124 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const lambdaScheme = "lambda:"
+
+// lambdaSink invokes a Lambda function asynchronously with every message body
+// as the event, at most rate invocations per second.
+type lambdaSink struct {
+	svc      lambdaiface.LambdaAPI
+	function string
+	interval time.Duration
+	next     time.Time
+}
+
+// openLambdaSink opens lambda:<function-name>, the name may be a function ARN
+// and carry a version or alias qualifier. rate limits invocations per second,
+// 0 doesn't limit them.
+func openLambdaSink(svc lambdaiface.LambdaAPI, spec string, rate float64) (*lambdaSink, error) {
+	function := strings.TrimPrefix(spec, lambdaScheme)
+
+	if function == "" {
+		return nil, fmt.Errorf("lambda destination must be %s<function-name>", lambdaScheme)
+	}
+
+	if rate < 0 {
+		return nil, fmt.Errorf("invalid lambda invocation rate %g", rate)
+	}
+
+	// Fail before receiving anything when the function doesn't exist or can't
+	// be seen with these credentials.
+	if _, err := svc.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String(function)}); err != nil {
+		return nil, err
+	}
+
+	sink := &lambdaSink{svc: svc, function: function}
+	if rate > 0 {
+		sink.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	return sink, nil
+}
+
+func (s *lambdaSink) String() string {
+	return lambdaScheme + s.function
+}
+
+// throttle waits until the next invocation is allowed.
+func (s *lambdaSink) throttle() {
+	if s.interval == 0 {
+		return
+	}
+
+	now := time.Now()
+	if s.next.After(now) {
+		time.Sleep(s.next.Sub(now))
+		now = s.next
+	}
+
+	s.next = now.Add(s.interval)
+}
+
+// lambdaPayload returns the event a message is invoked with. Lambda only
+// accepts JSON, so other bodies are passed as a JSON string.
+func lambdaPayload(message *sqs.Message) []byte {
+	body := []byte(aws.StringValue(message.Body))
+
+	if json.Valid(body) {
+		return body
+	}
+
+	payload, _ := json.Marshal(string(body))
+	return payload
+}
+
+// Send invokes the function once per message. Messages whose invocation was
+// not accepted are reported as failures and stay in the source.
+func (s *lambdaSink) Send(messages []*sqs.Message) error {
+	var failures []BatchFailure
+
+	for _, message := range messages {
+		s.throttle()
+
+		resp, err := s.svc.Invoke(&lambda.InvokeInput{
+			FunctionName:   aws.String(s.function),
+			InvocationType: aws.String(lambda.InvocationTypeEvent),
+			Payload:        lambdaPayload(message),
+		})
+
+		switch {
+		case err != nil:
+			code := "InvokeFailed"
+			if awsErr, ok := err.(awserr.Error); ok {
+				code = awsErr.Code()
+			}
+			failures = append(failures, BatchFailure{ID: aws.StringValue(message.MessageId), Code: code, Message: err.Error(), message: message})
+		case aws.Int64Value(resp.StatusCode) != http.StatusAccepted:
+			failures = append(failures, BatchFailure{ID: aws.StringValue(message.MessageId), Code: "InvokeFailed",
+				Message: fmt.Sprintf("invocation returned status %d", aws.Int64Value(resp.StatusCode)), message: message})
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BatchError{Operation: "invoke", Failures: failures}
+	}
+
+	return nil
+}
+
+func (s *lambdaSink) Close() error {
+	return nil
+}
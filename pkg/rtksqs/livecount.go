@@ -0,0 +1,107 @@
+package rtksqs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// LiveCount refreshes the total of a move from the approximate count of its
+// source while it runs, so messages other producers send are moved along,
+// and the move stops once other consumers drained the source. It must be
+// used by a single move, see MoveOptions.LiveCount.
+type LiveCount struct {
+	source Source
+	max    int
+	// taken is the number of messages moved, and received to be moved, so
+	// far. The count of the source leaves out those received.
+	taken int64
+
+	mu    sync.Mutex
+	total int
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// StartLiveCount refreshes the total of a move of total messages from source
+// every interval, until Stop. A max above 0 caps the total, e.g. at a limit.
+func StartLiveCount(source Source, total, max int, interval time.Duration) *LiveCount {
+	c := &LiveCount{
+		source:  source,
+		max:     max,
+		total:   total,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go c.run(interval)
+
+	return c
+}
+
+// Total returns the total of the move as of the last refresh.
+func (c *LiveCount) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Stop stops refreshing the total.
+func (c *LiveCount) Stop() {
+	close(c.done)
+	<-c.stopped
+}
+
+// take records the messages moved, and received to be moved, so far.
+func (c *LiveCount) take(taken int) {
+	atomic.StoreInt64(&c.taken, int64(taken))
+}
+
+func (c *LiveCount) run(interval time.Duration) {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// refresh reconciles the total with the count of the source. The total is
+// kept when counting fails.
+func (c *LiveCount) refresh() {
+	taken := int(atomic.LoadInt64(&c.taken))
+	count, err := c.source.ApproximateCount()
+
+	if err != nil {
+		log.Debugf("Failed to refresh the count of %s, keeping the total: %s", c.source, err)
+		return
+	}
+
+	if count == UnknownCount {
+		return
+	}
+
+	total := taken + count
+	if c.max > 0 && total > c.max {
+		total = c.max
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if total != c.total {
+		log.Debugf("%s holds about %d more messages, the move now ends after %d instead of %d", c.source, count, total, c.total)
+	}
+
+	c.total = total
+}
@@ -0,0 +1,201 @@
+package rtksqs
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const manifestSuffix = ".manifest.json"
+
+// dumpManifest is written next to a dump so a later load can prove that
+// every file and every message arrived intact. Sharded dumps list the files
+// of all shards in one manifest.
+type dumpManifest struct {
+	CreatedAt    time.Time      `json:"createdAt"`
+	RunID        string         `json:"runId,omitempty"`
+	Shards       int            `json:"shards,omitempty"`
+	MessageCount int64          `json:"messageCount"`
+	BodyBytes    int64          `json:"bodyBytes"`
+	Files        []manifestFile `json:"files"`
+}
+
+// manifestFile is a single part of a dump, its path is relative to the
+// manifest.
+type manifestFile struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestPath(dumpPath string) string {
+	return dumpPath + manifestSuffix
+}
+
+// hashingWriter computes the SHA-256 and size of everything written to a file.
+type hashingWriter struct {
+	w     io.Writer
+	hash  hash.Hash
+	bytes int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.bytes += int64(n)
+	return n, err
+}
+
+func (h *hashingWriter) manifestFile(path string) manifestFile {
+	return manifestFile{Path: filepath.Base(path), Bytes: h.bytes, SHA256: hex.EncodeToString(h.hash.Sum(nil))}
+}
+
+func writeManifest(path string, manifest *dumpManifest) error {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(encoded, '\n'), 0644)
+}
+
+// readManifest returns nil without an error when the dump has no manifest.
+func readManifest(path string) (*dumpManifest, error) {
+	encoded, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest dumpManifest
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", path, err)
+	}
+
+	return &manifest, nil
+}
+
+// verifyFiles checks the dump parts found on disk against the manifest.
+func (m *dumpManifest) verifyFiles(parts []string) error {
+	if len(parts) != len(m.Files) {
+		return fmt.Errorf("manifest lists %d files but %d were found", len(m.Files), len(parts))
+	}
+
+	for i, part := range parts {
+		expected := m.Files[i]
+
+		if filepath.Base(part) != expected.Path {
+			return fmt.Errorf("manifest expects %s but found %s", expected.Path, filepath.Base(part))
+		}
+
+		actual, err := hashFile(part)
+
+		if err != nil {
+			return err
+		}
+
+		if actual != expected.SHA256 {
+			return fmt.Errorf("checksum mismatch for %s, the file was modified or corrupted", part)
+		}
+	}
+
+	return nil
+}
+
+// verifyTotals checks the messages read from a dump against the manifest.
+func (m *dumpManifest) verifyTotals(messageCount, bodyBytes int64) error {
+	if messageCount != m.MessageCount {
+		return fmt.Errorf("manifest lists %d messages but %d were read", m.MessageCount, messageCount)
+	}
+
+	if bodyBytes != m.BodyBytes {
+		return fmt.Errorf("manifest lists %d body bytes but %d were read", m.BodyBytes, bodyBytes)
+	}
+
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idMapWriter writes a CSV mapping of source to destination message IDs, so
+// a load can be traced message by message.
+type idMapWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	runID  string
+}
+
+func newIdMapWriter(path, runID string) (*idMapWriter, error) {
+	file, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"source_message_id", "destination_message_id", "run_id"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &idMapWriter{file: file, writer: writer, runID: runID}, nil
+}
+
+// write records sent entries of a batch of messages.
+func (w *idMapWriter) write(entries []*sqs.SendMessageBatchResultEntry, messages []*sqs.Message) error {
+	for _, entry := range entries {
+		sourceID := aws.StringValue(entry.Id)
+		if message := BatchEntryMessage(messages, entry.Id); message != nil {
+			sourceID = aws.StringValue(message.MessageId)
+		}
+
+		if err := w.writer.Write([]string{sourceID, aws.StringValue(entry.MessageId), w.runID}); err != nil {
+			return err
+		}
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *idMapWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
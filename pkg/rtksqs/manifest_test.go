@@ -0,0 +1,166 @@
+package rtksqs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+func TestDumpManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the dump at path after it was written.
+		tamper  func(t *testing.T, path string)
+		wantErr string
+	}{
+		{
+			name: "intact",
+		},
+		{
+			name: "without a manifest",
+			tamper: func(t *testing.T, path string) {
+				if err := os.Remove(manifestPath(path)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "modified part",
+			tamper: func(t *testing.T, path string) {
+				part := strings.TrimSuffix(path, ".ndjson") + "-00002.ndjson"
+				if err := os.WriteFile(part, []byte("{\"body\":\"forged\"}\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "checksum mismatch",
+		},
+		{
+			name: "missing part",
+			tamper: func(t *testing.T, path string) {
+				if err := os.Remove(strings.TrimSuffix(path, ".ndjson") + "-00002.ndjson"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "manifest lists 5 files but 4 were found",
+		},
+		{
+			name: "wrong message count",
+			tamper: func(t *testing.T, path string) {
+				manifest, err := readManifest(manifestPath(path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				manifest.MessageCount++
+				if err := writeManifest(manifestPath(path), manifest); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "manifest lists 6 messages but 5 were read",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			var messages []*sqs.SendMessageInput
+			for i := 0; i < 5; i++ {
+				messages = append(messages, &sqs.SendMessageInput{MessageBody: aws.String(fmt.Sprintf("message-%d", i))})
+			}
+			fillFakeQueue(t, fake, "source", messages...)
+
+			// Every message is written to a part of its own.
+			path := filepath.Join(t.TempDir(), "dump.ndjson")
+			sink, err := openNdjsonFileSink(fileScheme+path, dumpOptions{splitSize: 1, runID: "run"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dumpFakeQueue(t, fake, "source", sink)
+
+			if test.tamper != nil {
+				test.tamper(t, path)
+			}
+
+			source, err := openNdjsonFileSource(fileScheme+path, nil)
+			if err == nil {
+				destination := createFakeQueue(t, fake, "destination")
+				var sink Sink
+				if sink, err = openQueueSink(fake, "destination", "", "run"); err != nil {
+					t.Fatal(err)
+				}
+				_, err = Move(source, sink, UnknownCount, MoveOptions{})
+				source.Close()
+
+				if err == nil && len(fake.Bodies(destination)) != len(messages) {
+					t.Errorf("loaded %d messages, want %d", len(fake.Bodies(destination)), len(messages))
+				}
+			}
+
+			if test.wantErr == "" && err != nil {
+				t.Errorf("got %q, want the dump to load", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueueSinkIDMap(t *testing.T) {
+	fake := fakesqs.New()
+	url := createFakeQueue(t, fake, "destination")
+
+	path := filepath.Join(t.TempDir(), "ids.csv")
+	sink, err := openQueueSink(fake, "destination", path, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+		if id != "1" {
+			return nil
+		}
+		return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+	})
+
+	if err := sink.Send(testMessages("a", "b", "c")); err == nil {
+		t.Fatal("the send of m1 didn't fail")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := receiveFakeQueue(t, fake, url)
+	destinationIDs := map[string]string{}
+	for _, message := range received {
+		destinationIDs[aws.StringValue(message.Body)] = aws.StringValue(message.MessageId)
+	}
+
+	// Only the sent messages are mapped, to the IDs they have in the
+	// destination.
+	want := [][]string{
+		{"source_message_id", "destination_message_id", "run_id"},
+		{"m0", destinationIDs["a"], "run"},
+		{"m2", destinationIDs["c"], "run"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+}
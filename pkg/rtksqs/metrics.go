@@ -0,0 +1,99 @@
+package rtksqs
+
+import (
+	"sync"
+	"time"
+)
+
+// metricsSteps are the steps MoveMetrics times, in the order of a batch.
+var metricsSteps = []string{StepReceive, StepSend, StepDelete}
+
+// StepMetrics are the calls, failures and time taken of a step of moves.
+type StepMetrics struct {
+	Step  string
+	Calls int
+	// Errors counts calls which failed, also when only some messages of a
+	// batch did.
+	Errors int
+	Total  time.Duration
+}
+
+// Average returns the average time a call took.
+func (s StepMetrics) Average() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// MoveMetrics times the receive, send and delete steps of moves and counts
+// the batches moved, to tell which step holds a slow move up. It is safe for
+// concurrent use, moves sharing one are added up.
+type MoveMetrics struct {
+	mu      sync.Mutex
+	batches int
+	steps   map[string]*StepMetrics
+}
+
+// observe records a call of step which started at start, nil records
+// nothing.
+func (m *MoveMetrics) observe(step string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	elapsed := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.steps == nil {
+		m.steps = map[string]*StepMetrics{}
+	}
+
+	metrics, ok := m.steps[step]
+	if !ok {
+		metrics = &StepMetrics{Step: step}
+		m.steps[step] = metrics
+	}
+
+	metrics.Calls++
+	metrics.Total += elapsed
+	if err != nil {
+		metrics.Errors++
+	}
+}
+
+// batch counts a moved batch, nil counts nothing.
+func (m *MoveMetrics) batch() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+}
+
+// Batches returns the number of batches moved.
+func (m *MoveMetrics) Batches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.batches
+}
+
+// Steps returns the metrics of the steps which were called, receive, send
+// and delete in this order.
+func (m *MoveMetrics) Steps() []StepMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var steps []StepMetrics
+	for _, step := range metricsSteps {
+		if metrics, ok := m.steps[step]; ok {
+			steps = append(steps, *metrics)
+		}
+	}
+
+	return steps
+}
@@ -0,0 +1,119 @@
+package rtksqs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// MoveConfig is a move run by MoveAll. Only Source and Destination are
+// required.
+type MoveConfig struct {
+	// Source and Destination are queue names, URLs or ARNs, or any other
+	// spec OpenSource and OpenSink accept.
+	Source      string
+	Destination string
+	// Session is used for AWS when set, else one is built from Region,
+	// Profile and Endpoint.
+	Session *session.Session
+	// Region and Profile default to those of the environment and the shared
+	// config, as for the AWS CLI.
+	Region  string
+	Profile string
+	// Endpoint overrides the endpoint of the AWS services, e.g. of
+	// LocalStack.
+	Endpoint string
+	// Limit moves at most this many messages, 0 moves all.
+	Limit int
+	// Options open the source and the sink. A run ID is generated when
+	// Options.RunID is empty.
+	Options Options
+	// MoveOptions control the move, their Context is that of MoveAll.
+	MoveOptions MoveOptions
+}
+
+// MoveResult is the outcome of MoveAll.
+type MoveResult struct {
+	Moved int
+	// Failures lists the first MaxListedFailures messages a step of the move
+	// failed on, including those it stopped on. Messages which failed to
+	// send or to be deleted stay in the source.
+	Failures []MessageFailure
+	// FailureCounts counts every failure by error code.
+	FailureCounts map[string]int
+}
+
+// MoveAll opens the source and destination of config, moves the messages
+// the source holds, at most config.Limit of them, and closes both, so moving
+// a dead-letter queue back takes one call:
+//
+//	result, err := rtksqs.MoveAll(ctx, rtksqs.MoveConfig{Source: "orders_dlq", Destination: "orders"})
+//
+// The move stops before the next batch once ctx is done. The result is set
+// up to where the move stopped when it failed.
+func MoveAll(ctx context.Context, config MoveConfig) (MoveResult, error) {
+	var result MoveResult
+	sess := config.Session
+	if sess == nil {
+		options := session.Options{Profile: config.Profile, SharedConfigState: session.SharedConfigEnable}
+		if config.Region != "" {
+			options.Config.Region = aws.String(config.Region)
+		}
+		if config.Endpoint != "" {
+			options.Config.Endpoint = aws.String(config.Endpoint)
+		}
+
+		var err error
+		if sess, err = session.NewSessionWithOptions(options); err != nil {
+			return result, err
+		}
+	}
+
+	if config.Options.RunID == "" {
+		config.Options.RunID = NewRunID()
+	}
+
+	source, err := OpenSource(sess, config.Source, config.Options)
+	if err != nil {
+		return result, err
+	}
+	defer source.Close()
+
+	sink, err := OpenSink(sess, config.Destination, config.Options)
+	if err != nil {
+		return result, err
+	}
+
+	total, err := source.ApproximateCount()
+	if err != nil {
+		sink.Close()
+		return result, err
+	}
+
+	if config.Limit > 0 && (total == UnknownCount || total > config.Limit) {
+		total = config.Limit
+	}
+
+	if total != 0 {
+		var failures FailureLog
+		failed := config.MoveOptions.Failed
+		config.MoveOptions.Failed = func(failure MessageFailure) {
+			failures.Add(failure)
+			if failed != nil {
+				failed(failure)
+			}
+		}
+
+		config.MoveOptions.Context = ctx
+		result.Moved, err = Move(source, sink, total, config.MoveOptions)
+		result.Failures, result.FailureCounts = failures.Failures(), failures.Counts()
+	}
+
+	// Dumps are only complete once closed.
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+
+	return result, err
+}
@@ -0,0 +1,632 @@
+package rtksqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+)
+
+// DefaultBatchSize is the largest batch SQS accepts.
+const DefaultBatchSize = 10
+
+// Steps of a move, reported by MoveError.
+const (
+	StepReceive    = "receive"
+	StepSend       = "send"
+	StepDelete     = "delete"
+	StepBacklog    = "check the backlog before moving"
+	StepQuarantine = "quarantine"
+	StepRoute      = "route"
+	StepBackup     = "back up"
+	StepJournal    = "journal"
+)
+
+// MoveOptions control a move.
+type MoveOptions struct {
+	// BatchSize is the maximum number of messages received and sent at a
+	// time, up to DefaultBatchSize, which it is when 0.
+	BatchSize int64
+	// Progress is called after every batch with the number of messages
+	// moved so far.
+	Progress func(moved int)
+	// Stats records every moved message when set.
+	Stats *MessageStats
+	// Dimensions counts the moved, failed and skipped messages by the value
+	// of an attribute or field when set.
+	Dimensions *DimensionStats
+	// Audit records every moved message when set, see VerifyMove.
+	Audit *MoveAudit
+	// Metrics times the steps of the move when set.
+	Metrics *MoveMetrics
+	// BacklogThreshold pauses the move while the sink, which must be a
+	// BacklogSink, holds more messages. 0 doesn't throttle.
+	BacklogThreshold int
+	// BacklogInterval is how often the backlog is checked,
+	// DefaultBacklogInterval when 0.
+	BacklogInterval time.Duration
+	// HealthCheck is the URL of a health check of the sink's consumer. The
+	// move only receives while it responds with a 2xx status, and pauses
+	// while it doesn't. Not checked when empty.
+	HealthCheck string
+	// HealthInterval is how often the health check is polled,
+	// DefaultHealthInterval when 0.
+	HealthInterval time.Duration
+	// Hooks run on every received message in order, until one skips it.
+	Hooks []MessageHook
+	// Skipped is called for every message a hook skipped.
+	Skipped func(message *sqs.Message, reason string)
+	// Discards run on every received message before the hooks. Messages a
+	// discard skips are deleted from the source instead of moved.
+	Discards []MessageHook
+	// Discarded is called for every message a discard skipped, or a decision
+	// deleted, before it is deleted.
+	Discarded func(message *sqs.Message, reason string)
+	// Decide decides what happens to every message the hooks didn't skip when
+	// set, e.g. by asking an external command. Messages it fails on are
+	// skipped.
+	Decide func(message *sqs.Message) (Decision, error)
+	// Routes returns the sink of a route a decision named. Routed messages are
+	// deleted from the source once sent to it.
+	Routes func(route string) (Sink, error)
+	// Routed is called for every message sent to a route.
+	Routed func(message *sqs.Message, route string)
+	// Failed is called for every message a step failed on, see
+	// MessageFailures, also when the move keeps going past it.
+	Failed func(failure MessageFailure)
+	// SendErrorThreshold keeps moving past failed sends, leaving their
+	// messages in the source, until more than this many sends failed within
+	// SendErrorWindow. That trips a circuit breaker which pauses the move for
+	// BreakerBackoff, doubled with every trip in a row, and stops it when
+	// sending still fails after BreakerTrips pauses in a row. 0 stops at the
+	// first failed send.
+	SendErrorThreshold int
+	// SendErrorWindow is DefaultSendErrorWindow when 0.
+	SendErrorWindow time.Duration
+	// BreakerBackoff is DefaultBreakerBackoff when 0.
+	BreakerBackoff time.Duration
+	// BreakerTrips is DefaultBreakerTrips when 0.
+	BreakerTrips int
+	// Context stops the move before the next batch once it is done, Move
+	// then returns its error.
+	Context context.Context
+	// ChunkSize pauses the move every ChunkSize moved messages and calls
+	// ConfirmChunk before receiving more, no messages are in flight while it
+	// waits. An error stops the move, Move then returns it. 0 doesn't pause.
+	ChunkSize    int
+	ConfirmChunk func(moved int) error
+	// Rate paces the move to receive at most this many messages per second,
+	// e.g. per worker of concurrent moves. 0 doesn't pace.
+	Rate float64
+	// Limiter paces the receives of the move along with the other moves
+	// sharing it.
+	Limiter *RateLimiter
+	// SendBackoff paces the sends of the move along with the other moves
+	// sharing it while the sink throttles them, and sends throttled messages
+	// again. Throttled sends fail when nil.
+	SendBackoff *SendBackoff
+	// SendSlots limits the sends of the move outstanding along with those of
+	// the other moves sharing it, to the sink, Backup, Quarantine and routes
+	// alike. A move sends one batch at a time, so it only limits moves
+	// sharing it. A move takes a slot before it receives a batch and keeps
+	// it until the batch was sent, also while a throttled send backs off, so
+	// received messages don't wait for a slot. Sends aren't limited when nil.
+	SendSlots *SendSlots
+	// LiveCount refreshes the total of the move while it runs when set, the
+	// total given to Move then only counts until the first refresh.
+	LiveCount *LiveCount
+	// Control pauses, resumes and aborts the move when set.
+	Control *MoveControl
+	// Quarantine receives the messages a hook skipped when set, they are
+	// deleted from the source once sent instead of staying there.
+	Quarantine Sink
+	// Backup receives a copy of every batch before it is sent to the sink
+	// when set.
+	Backup Sink
+	// RetryState keeps the failed sends to the sink across restarts when set,
+	// the move waits until it may send again before receiving anything.
+	RetryState *RetryState
+	// Journal records the sent messages before they are deleted from the
+	// source when set. Received messages it holds as sent, left behind by a
+	// crash of an earlier move between sending and deleting them, are
+	// deleted without sending them again and count as moved.
+	Journal SendJournal
+}
+
+// MessageHook may modify a message before it is sent, and skips it by
+// returning why. Skipped messages stay in the source, or are quarantined.
+type MessageHook func(message *sqs.Message) (skipReason string)
+
+// MoveError is returned when a step of a move failed. Messages moved before
+// stay moved, messages which failed stay in the source.
+type MoveError struct {
+	Step string
+	Err  error
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("failed to %s messages: %s", e.Step, e.Err)
+}
+
+func (e *MoveError) Unwrap() error {
+	return e.Err
+}
+
+// Move moves up to total messages from the source to the sink, or until the
+// source is exhausted when total is UnknownCount. Messages are only deleted
+// from the source once they were sent. Messages skipped by a hook are
+// received again once their visibility timeout expired, the move stops when
+// a batch holds nothing but skipped messages, unless they are quarantined.
+// Messages skipped by a discard are deleted right away. Messages decided to be
+// deleted or routed elsewhere are deleted once that is done. Failed deletes
+// from a queue are retried while the messages are still hidden. It returns
+// the number of messages moved.
+func Move(source Source, sink Sink, total int, options MoveOptions) (int, error) {
+	batchSize := options.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	if batchSize < 0 || batchSize > DefaultBatchSize {
+		return 0, fmt.Errorf("the batch size must be between 1 and %d", DefaultBatchSize)
+	}
+
+	var throttle *backlogThrottle
+	if options.BacklogThreshold > 0 {
+		var err error
+		if throttle, err = newBacklogThrottle(sink, options.BacklogThreshold, options.BacklogInterval); err != nil {
+			return 0, err
+		}
+	}
+
+	var health *healthGate
+	if options.HealthCheck != "" {
+		health = newHealthGate(options.HealthCheck, options.HealthInterval)
+	}
+
+	var breaker *sendBreaker
+	if options.SendErrorThreshold > 0 {
+		breaker = newSendBreaker(options)
+	}
+
+	retryBackoff := options.BreakerBackoff
+	if retryBackoff == 0 {
+		retryBackoff = DefaultBreakerBackoff
+	}
+
+	if err := options.RetryState.wait(options.Context, sink.String()); err != nil {
+		return 0, err
+	}
+
+	var limiters []*RateLimiter
+	if options.Limiter != nil {
+		limiters = append(limiters, options.Limiter)
+	}
+	if options.Rate > 0 {
+		limiters = append(limiters, NewRateLimiter(options.Rate))
+	}
+
+	source = retryDeletes(source)
+
+	moved := 0
+	skipped := map[string]bool{}
+	chunkEnd := options.ChunkSize
+
+	// slotTaken tells whether the batch being moved holds a send slot.
+	slotTaken := false
+	releaseSlot := func() {
+		if slotTaken {
+			options.SendSlots.release()
+			slotTaken = false
+		}
+	}
+	defer releaseSlot()
+
+	for {
+		releaseSlot()
+
+		if options.LiveCount != nil {
+			options.LiveCount.take(moved)
+			total = options.LiveCount.Total()
+		}
+
+		if total != UnknownCount && moved >= total {
+			break
+		}
+
+		if options.Context != nil && options.Context.Err() != nil {
+			return moved, options.Context.Err()
+		}
+
+		if options.Control != nil {
+			if err := options.Control.wait(options.Context); err != nil {
+				return moved, err
+			}
+		}
+
+		receiveSize := batchSize
+		if options.ChunkSize > 0 {
+			if moved >= chunkEnd {
+				if err := options.ConfirmChunk(moved); err != nil {
+					return moved, err
+				}
+				chunkEnd = moved + options.ChunkSize
+			}
+
+			if left := int64(chunkEnd - moved); left < receiveSize {
+				receiveSize = left
+			}
+		}
+
+		if throttle != nil {
+			if err := throttle.wait(); err != nil {
+				return moved, &MoveError{Step: StepBacklog, Err: err}
+			}
+		}
+
+		if health != nil {
+			if err := health.wait(options.Context, options.Control); err != nil {
+				return moved, err
+			}
+		}
+
+		// The move waits for the rate before receiving, received messages
+		// would become visible again while it waits.
+		for _, limiter := range limiters {
+			limiter.Wait(int(receiveSize))
+		}
+
+		if err := options.SendSlots.acquire(options.Context); err != nil {
+			return moved, err
+		}
+		slotTaken = true
+
+		start := time.Now()
+		messages, err := source.Receive(receiveSize)
+		options.Metrics.observe(StepReceive, start, err)
+
+		for _, limiter := range limiters {
+			limiter.refund(int(receiveSize) - len(messages))
+		}
+
+		if err != nil {
+			reportFailures(options, StepReceive, nil, err)
+			return moved, &MoveError{Step: StepReceive, Err: err}
+		}
+
+		if len(messages) == 0 {
+			break
+		}
+
+		if options.LiveCount != nil {
+			options.LiveCount.take(moved + len(messages))
+		}
+
+		if len(options.Discards) > 0 {
+			var discarded []*sqs.Message
+			if messages, discarded = applyDiscards(messages, options); len(discarded) > 0 {
+				if err := source.Delete(discarded); err != nil {
+					reportFailures(options, StepDelete, discarded, err)
+					return moved, &MoveError{Step: StepDelete, Err: err}
+				}
+			}
+
+			if len(messages) == 0 {
+				continue
+			}
+		}
+
+		if options.Journal != nil {
+			var reconciled []*sqs.Message
+			if messages, reconciled, err = reconcileJournal(options.Journal, source, messages); err != nil {
+				return moved, err
+			}
+
+			if len(reconciled) > 0 {
+				moved += len(reconciled)
+				options.Dimensions.moved(reconciled)
+				if options.Progress != nil {
+					options.Progress(moved)
+				}
+			}
+
+			if len(messages) == 0 {
+				continue
+			}
+		}
+
+		if len(options.Hooks) > 0 {
+			var rejected []*sqs.Message
+			var done bool
+			// Hooks running commands, e.g. ExecTransformer, may take longer
+			// than the messages are hidden.
+			stopHiding := keepHidden(source, messages)
+			messages, rejected, done = applyHooks(messages, skipped, options)
+			stopHiding()
+			options.Dimensions.skipped(rejected)
+
+			if options.Quarantine != nil && len(rejected) > 0 {
+				if err := divert(source, options.Quarantine, rejected, StepQuarantine, options); err != nil {
+					return moved, err
+				}
+			}
+
+			if done {
+				break
+			}
+
+			if len(messages) == 0 {
+				continue
+			}
+		}
+
+		if options.Decide != nil {
+			var decided decisions
+			var done bool
+			// A decision may ask a command, e.g. DecideExec, taking longer
+			// than the messages are hidden.
+			stopHiding := keepHidden(source, messages)
+			messages, decided, done = applyDecisions(messages, skipped, options)
+			stopHiding()
+			options.Dimensions.skipped(decided.skipped)
+
+			if options.Quarantine != nil && len(decided.skipped) > 0 {
+				if err := divert(source, options.Quarantine, decided.skipped, StepQuarantine, options); err != nil {
+					return moved, err
+				}
+			}
+
+			if len(decided.deleted) > 0 {
+				if err := source.Delete(decided.deleted); err != nil {
+					reportFailures(options, StepDelete, decided.deleted, err)
+					return moved, &MoveError{Step: StepDelete, Err: err}
+				}
+			}
+
+			if err := route(source, decided, options); err != nil {
+				return moved, err
+			}
+
+			if done {
+				break
+			}
+
+			if len(messages) == 0 {
+				continue
+			}
+		}
+
+		if total != UnknownCount && len(messages)+moved > total {
+			messages = messages[0 : total-moved]
+		}
+
+		// The backup and the send share the slot of the batch, they are sent
+		// one after the other.
+		if options.Backup != nil {
+			if err := options.Backup.Send(messages); err != nil {
+				reportFailures(options, StepBackup, messages, err)
+				return moved, &MoveError{Step: StepBackup, Err: err}
+			}
+		}
+
+		// A throttled send backs off for longer than the messages are hidden.
+		start = time.Now()
+		stopHiding := keepHidden(source, messages)
+		err = sendWithBackoff(sink, messages, options.SendBackoff)
+		stopHiding()
+		releaseSlot()
+		options.Metrics.observe(StepSend, start, err)
+
+		if err != nil {
+			options.RetryState.failed(sink.String(), err, retryBackoff)
+			reportFailures(options, StepSend, messages, err)
+
+			// Messages of a partially failed batch which were sent are
+			// deleted, so moving again doesn't duplicate them.
+			sent := sentMessages(messages, err)
+			options.Dimensions.failed(unsentMessages(messages, sent))
+			if len(sent) > 0 {
+				journalSent(options.Journal, source, sink, sent)
+				if err := source.Delete(sent); err != nil {
+					reportFailures(options, StepDelete, sent, err)
+				} else {
+					journalDeleted(options.Journal, source, sent)
+					moved += len(sent)
+					if options.Stats != nil {
+						options.Stats.Add(sent)
+					}
+					options.Dimensions.moved(sent)
+					if options.Audit != nil {
+						options.Audit.Add(sent)
+					}
+					if options.Progress != nil {
+						options.Progress(moved)
+					}
+				}
+			}
+
+			if breaker != nil {
+				log.Warn(color.New(color.FgYellow).Sprintf("Failed to send %d messages, they stay in the source: %s", len(messages)-len(sent), err))
+				if breaker.failed() {
+					continue
+				}
+			}
+
+			return moved, &MoveError{Step: StepSend, Err: err}
+		}
+
+		if breaker != nil {
+			breaker.succeeded()
+		}
+		options.RetryState.succeeded(sink.String())
+		journalSent(options.Journal, source, sink, messages)
+
+		start = time.Now()
+		err = source.Delete(messages)
+		options.Metrics.observe(StepDelete, start, err)
+
+		if err != nil {
+			reportFailures(options, StepDelete, messages, err)
+			return moved, &MoveError{Step: StepDelete, Err: err}
+		}
+		journalDeleted(options.Journal, source, messages)
+
+		moved += len(messages)
+		options.Metrics.batch()
+
+		if options.Stats != nil {
+			options.Stats.Add(messages)
+		}
+		options.Dimensions.moved(messages)
+
+		if options.Audit != nil {
+			options.Audit.Add(messages)
+		}
+
+		if options.Progress != nil {
+			options.Progress(moved)
+		}
+	}
+
+	return moved, nil
+}
+
+// unsentMessages returns the messages which aren't among those sent.
+func unsentMessages(messages, sent []*sqs.Message) []*sqs.Message {
+	wasSent := make(map[*sqs.Message]bool, len(sent))
+	for _, message := range sent {
+		wasSent[message] = true
+	}
+
+	var unsent []*sqs.Message
+	for _, message := range messages {
+		if !wasSent[message] {
+			unsent = append(unsent, message)
+		}
+	}
+
+	return unsent
+}
+
+// applyDiscards runs the discards on messages and returns those to move and
+// those to delete.
+func applyDiscards(messages []*sqs.Message, options MoveOptions) ([]*sqs.Message, []*sqs.Message) {
+	var result, discarded []*sqs.Message
+
+	for _, message := range messages {
+		reason := ""
+		for _, discard := range options.Discards {
+			if reason = discard(message); reason != "" {
+				break
+			}
+		}
+
+		if reason == "" {
+			result = append(result, message)
+			continue
+		}
+
+		discarded = append(discarded, message)
+		if options.Discarded != nil {
+			options.Discarded(message, reason)
+		}
+	}
+
+	return result, discarded
+}
+
+// applyHooks runs the hooks on messages which weren't skipped before and
+// returns those to move and those skipped now. It reports done when every
+// message was skipped before.
+func applyHooks(messages []*sqs.Message, skipped map[string]bool, options MoveOptions) ([]*sqs.Message, []*sqs.Message, bool) {
+	var result, rejected []*sqs.Message
+	seen := 0
+
+	for _, message := range messages {
+		id := aws.StringValue(message.MessageId)
+
+		if skipped[id] {
+			seen++
+			continue
+		}
+
+		reason := ""
+		for _, hook := range options.Hooks {
+			if reason = hook(message); reason != "" {
+				break
+			}
+		}
+
+		if reason == "" {
+			result = append(result, message)
+			continue
+		}
+
+		skipped[id] = true
+		rejected = append(rejected, message)
+		if options.Skipped != nil {
+			options.Skipped(message, reason)
+		}
+	}
+
+	return result, rejected, seen == len(messages)
+}
+
+// divert sends messages to another sink than that of the move, e.g. skipped
+// messages to the quarantine sink, and deletes those sent from the source.
+// Failed sends are reported as the step. The send takes the slot the move
+// holds for the batch.
+func divert(source Source, sink Sink, messages []*sqs.Message, step string, options MoveOptions) error {
+	sendErr := sink.Send(messages)
+	if sendErr != nil {
+		reportFailures(options, step, messages, sendErr)
+		messages = sentMessages(messages, sendErr)
+	}
+
+	if len(messages) > 0 {
+		if err := source.Delete(messages); err != nil {
+			reportFailures(options, StepDelete, messages, err)
+			return &MoveError{Step: StepDelete, Err: err}
+		}
+	}
+
+	if sendErr != nil {
+		return &MoveError{Step: step, Err: sendErr}
+	}
+
+	return nil
+}
+
+// sentMessages returns the messages not listed as failed by a BatchError.
+// Failures are matched by message when the sink reports it, else by ID.
+func sentMessages(messages []*sqs.Message, err error) []*sqs.Message {
+	var batchErr *BatchError
+
+	if !errors.As(err, &batchErr) {
+		return nil
+	}
+
+	failedIDs := make(map[string]bool, len(batchErr.Failures))
+	failedMessages := make(map[*sqs.Message]bool, len(batchErr.Failures))
+	for _, failure := range batchErr.Failures {
+		if failure.message != nil {
+			failedMessages[failure.message] = true
+		} else {
+			failedIDs[failure.ID] = true
+		}
+	}
+
+	var sent []*sqs.Message
+	for _, message := range messages {
+		if !failedMessages[message] && !failedIDs[aws.StringValue(message.MessageId)] {
+			sent = append(sent, message)
+		}
+	}
+
+	return sent
+}
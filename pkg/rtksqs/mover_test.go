@@ -0,0 +1,325 @@
+package rtksqs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+func TestMove(t *testing.T) {
+	small := func(count int) []string {
+		bodies := make([]string, count)
+		for i := range bodies {
+			bodies[i] = fmt.Sprintf("message-%d", i)
+		}
+		return bodies
+	}
+	large := []string{strings.Repeat("a", 100*1024), strings.Repeat("b", 100*1024), strings.Repeat("c", 100*1024)}
+
+	tests := []struct {
+		name   string
+		bodies []string
+		// fail sets up the failures of the fake, given the URL of the
+		// source.
+		fail            func(fake *fakesqs.SQS, sourceURL string)
+		wantMoved       int
+		wantStep        string
+		wantSource      int
+		wantDestination int
+	}{
+		{
+			name:            "moves all",
+			bodies:          small(25),
+			wantMoved:       25,
+			wantDestination: 25,
+		},
+		{
+			name:            "single fallback",
+			bodies:          large,
+			wantMoved:       3,
+			wantDestination: 3,
+		},
+		{
+			name:   "partial send failure",
+			bodies: small(5),
+			fail: func(fake *fakesqs.SQS, _ string) {
+				fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+					if operation != "SendMessageBatch" || id != "1" {
+						return nil
+					}
+					return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+				})
+			},
+			wantMoved:       4,
+			wantStep:        StepSend,
+			wantSource:      1,
+			wantDestination: 4,
+		},
+		{
+			name:   "failed send request",
+			bodies: small(5),
+			fail: func(fake *fakesqs.SQS, _ string) {
+				fake.SetCallFailure(func(operation, _ string) error {
+					if operation == "SendMessageBatch" {
+						return errors.New("connection reset")
+					}
+					return nil
+				})
+			},
+			wantStep:   StepSend,
+			wantSource: 5,
+		},
+		{
+			name:   "delete failing once",
+			bodies: small(5),
+			fail: func(fake *fakesqs.SQS, _ string) {
+				failed := false
+				fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+					if operation != "DeleteMessageBatch" || failed {
+						return nil
+					}
+					failed = true
+					return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+				})
+			},
+			wantMoved:       5,
+			wantDestination: 5,
+		},
+		{
+			name:   "delete failing",
+			bodies: small(5),
+			fail: func(fake *fakesqs.SQS, sourceURL string) {
+				fake.SetCallFailure(func(operation, queueURL string) error {
+					if operation == "DeleteMessageBatch" && queueURL == sourceURL {
+						return errors.New("connection reset")
+					}
+					return nil
+				})
+			},
+			wantStep:        StepDelete,
+			wantSource:      5,
+			wantDestination: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			sourceURL := createFakeQueue(t, fake, "source")
+			destinationURL := createFakeQueue(t, fake, "destination")
+
+			for _, body := range test.bodies {
+				if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(sourceURL), MessageBody: aws.String(body)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			options := Options{SQS: fake}
+			source, err := OpenSource(nil, "source", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink, err := OpenSink(nil, "destination", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.fail != nil {
+				test.fail(fake, sourceURL)
+			}
+
+			var failures []MessageFailure
+			moved, err := Move(source, sink, UnknownCount, MoveOptions{Failed: func(failure MessageFailure) {
+				failures = append(failures, failure)
+			}})
+
+			var moveErr *MoveError
+			switch {
+			case test.wantStep == "" && err != nil:
+				t.Errorf("got %q, want no error", err)
+			case test.wantStep != "" && (!errors.As(err, &moveErr) || moveErr.Step != test.wantStep):
+				t.Errorf("got %v, want a MoveError of %s", err, test.wantStep)
+			}
+
+			if test.wantStep != "" && len(failures) == 0 {
+				t.Errorf("reported no failures")
+			}
+
+			if moved != test.wantMoved {
+				t.Errorf("moved %d messages, want %d", moved, test.wantMoved)
+			}
+
+			if got := fake.Bodies(sourceURL); len(got) != test.wantSource {
+				t.Errorf("source holds %d messages, want %d", len(got), test.wantSource)
+			}
+
+			if got := fake.Bodies(destinationURL); len(got) != test.wantDestination {
+				t.Errorf("destination holds %d messages, want %d", len(got), test.wantDestination)
+			}
+		})
+	}
+}
+
+// slowSink calls before ahead of every send, e.g. to stand for a send
+// backing off.
+type slowSink struct {
+	Sink
+	before func()
+}
+
+func (s *slowSink) Send(messages []*sqs.Message) error {
+	s.before()
+	return s.Sink.Send(messages)
+}
+
+func TestMoveKeepsMessagesHidden(t *testing.T) {
+	// slow outlasts the visibility timeout of received messages.
+	slow := func() { time.Sleep(time.Duration(defaultVisibilityTimeout)*time.Second + 500*time.Millisecond) }
+
+	tests := []struct {
+		name string
+		// slowDown makes a step of the move slow.
+		slowDown func(sink Sink, options *MoveOptions) Sink
+	}{
+		{
+			name: "slow send",
+			slowDown: func(sink Sink, _ *MoveOptions) Sink {
+				return &slowSink{Sink: sink, before: slow}
+			},
+		},
+		{
+			name: "slow hook",
+			slowDown: func(sink Sink, options *MoveOptions) Sink {
+				options.Hooks = []MessageHook{func(*sqs.Message) string {
+					slow()
+					return ""
+				}}
+				return sink
+			},
+		},
+		{
+			name: "slow decision",
+			slowDown: func(sink Sink, options *MoveOptions) Sink {
+				options.Decide = func(*sqs.Message) (Decision, error) {
+					slow()
+					return Decision{Action: DecisionMove}, nil
+				}
+				return sink
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fake := fakesqs.New()
+			sourceURL := createFakeQueue(t, fake, "source")
+			destinationURL := createFakeQueue(t, fake, "destination")
+
+			if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(sourceURL), MessageBody: aws.String("slow")}); err != nil {
+				t.Fatal(err)
+			}
+
+			options := Options{SQS: fake}
+			source, err := OpenSource(nil, "source", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink, err := OpenSink(nil, "destination", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Another consumer receiving right after the slow step, before
+			// the message is sent, must find it still hidden.
+			received := 0
+			sink = &slowSink{Sink: sink, before: func() {
+				resp, err := fake.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String(sourceURL)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				received += len(resp.Messages)
+			}}
+
+			var moveOptions MoveOptions
+			sink = test.slowDown(sink, &moveOptions)
+
+			if moved, err := Move(source, sink, UnknownCount, moveOptions); err != nil || moved != 1 {
+				t.Fatalf("moved %d messages and got %v, want 1 and no error", moved, err)
+			}
+
+			if received > 0 {
+				t.Errorf("another consumer received the message while it was moved")
+			}
+
+			if got := fake.Bodies(destinationURL); len(got) != 1 {
+				t.Errorf("destination holds %d messages, want 1", len(got))
+			}
+		})
+	}
+}
+
+func TestMoveSendSlots(t *testing.T) {
+	fake := fakesqs.New()
+	sourceURL := createFakeQueue(t, fake, "source")
+	destinationURL := createFakeQueue(t, fake, "destination")
+	quarantineURL := createFakeQueue(t, fake, "quarantine")
+
+	for _, body := range []string{"a", "skip", "b"} {
+		if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(sourceURL), MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options := Options{SQS: fake}
+	source, err := OpenSource(nil, "source", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := OpenSink(nil, "destination", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantine, err := OpenSink(nil, "quarantine", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The quarantine send takes the slot of the batch, a second slot would
+	// never be free.
+	slots := NewSendSlots(1)
+	moved, err := Move(source, sink, UnknownCount, MoveOptions{
+		SendSlots:  slots,
+		Quarantine: quarantine,
+		Hooks: []MessageHook{func(message *sqs.Message) string {
+			if aws.StringValue(message.Body) == "skip" {
+				return "skipped"
+			}
+			return ""
+		}},
+	})
+
+	if err != nil || moved != 2 {
+		t.Fatalf("moved %d messages and got %v, want 2 and no error", moved, err)
+	}
+
+	if got := len(fake.Bodies(destinationURL)) + len(fake.Bodies(quarantineURL)); got != 3 {
+		t.Errorf("destination and quarantine hold %d messages, want 3", got)
+	}
+
+	// The move gave its slot back.
+	select {
+	case slots.slots <- struct{}{}:
+	default:
+		t.Errorf("the move kept its send slot")
+	}
+}
@@ -0,0 +1,198 @@
+package rtksqs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/nats-io/nats.go"
+)
+
+const natsScheme = "nats://"
+
+// natsCredentialsEnv points to a NATS credentials file when set.
+const natsCredentialsEnv = "NATS_CREDS"
+
+const natsAckTimeout = 30 * time.Second
+
+// natsSubjectPlaceholder matches {group} and {attr:<name>} in subject templates.
+var natsSubjectPlaceholder = regexp.MustCompile(`\{(group|attr:[^}]+)\}`)
+
+// natsSubjectToken matches values which are a single token of a subject,
+// without the separator, wildcards or whitespace.
+var natsSubjectToken = regexp.MustCompile(`^[^.*>\s]+$`)
+
+// natsSink publishes messages to a JetStream stream. The subject is rendered
+// per message from a template, message attributes become headers.
+type natsSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	name    string
+	subject string
+	// closed is closed once the connection is, after draining it.
+	closed chan struct{}
+}
+
+// openNatsSink opens nats://[user:password@]<server>[,<server>...]/<subject>.
+// The subject may contain {group} and {attr:<name>} placeholders.
+func openNatsSink(spec string) (*natsSink, error) {
+	parsed, err := url.Parse(spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	subject := strings.TrimPrefix(parsed.Path, "/")
+
+	if parsed.Host == "" || subject == "" {
+		return nil, fmt.Errorf("nats destination must be %s<server>/<subject>", natsScheme)
+	}
+
+	var servers []string
+	for _, host := range strings.Split(parsed.Host, ",") {
+		server := url.URL{Scheme: "nats", User: parsed.User, Host: host}
+		servers = append(servers, server.String())
+	}
+
+	closed := make(chan struct{})
+	options := []nats.Option{nats.Name("sqsmover"), nats.ClosedHandler(func(*nats.Conn) { close(closed) })}
+	if credentials := os.Getenv(natsCredentialsEnv); credentials != "" {
+		options = append(options, nats.UserCredentials(credentials))
+	}
+
+	conn, err := nats.Connect(strings.Join(servers, ","), options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := conn.JetStream()
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &natsSink{conn: conn, js: js, name: natsScheme + parsed.Host + "/" + subject, subject: subject, closed: closed}, nil
+}
+
+func (s *natsSink) String() string {
+	return s.name
+}
+
+// renderSubject fills the subject template from the message. Values must be
+// a single token, a . would add tokens to the subject, and wildcards or
+// whitespace make it invalid for publishing.
+func (s *natsSink) renderSubject(message *sqs.Message) (string, error) {
+	var err error
+
+	subject := natsSubjectPlaceholder.ReplaceAllStringFunc(s.subject, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+
+		var value *string
+		if name == "group" {
+			value = message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]
+		} else if attribute, ok := message.MessageAttributes[strings.TrimPrefix(name, "attr:")]; ok {
+			value = attribute.StringValue
+		}
+
+		switch {
+		case err != nil:
+		case value == nil:
+			err = fmt.Errorf("message has no %s for subject %s", name, s.subject)
+		case !natsSubjectToken.MatchString(*value):
+			err = fmt.Errorf("%s %q of the message isn't a single subject token, it is empty or holds a ., *, > or whitespace", name, *value)
+		}
+
+		return aws.StringValue(value)
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return subject, nil
+}
+
+func (s *natsSink) Send(messages []*sqs.Message) error {
+	var failures []BatchFailure
+	futures := make(map[int]nats.PubAckFuture, len(messages))
+
+	for i, message := range messages {
+		msg, err := s.convertToNatsMessage(message)
+
+		if err == nil {
+			futures[i], err = s.js.PublishMsgAsync(msg)
+		}
+
+		if err != nil {
+			failures = append(failures, BatchFailure{ID: aws.StringValue(message.MessageId), Code: "PublishFailed", Message: err.Error(), message: message})
+		}
+	}
+
+	timeout := time.After(natsAckTimeout)
+
+	for i, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			failures = append(failures, BatchFailure{ID: aws.StringValue(messages[i].MessageId), Code: "PublishFailed", Message: err.Error(), message: messages[i]})
+		case <-timeout:
+			failures = append(failures, BatchFailure{ID: aws.StringValue(messages[i].MessageId), Code: "AckTimeout", Message: "no acknowledgement from JetStream", message: messages[i]})
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BatchError{Operation: "publish", Failures: failures}
+	}
+
+	return nil
+}
+
+// Close drains the connection, publishing what is pending, and waits until
+// it is closed.
+func (s *natsSink) Close() error {
+	if err := s.conn.Drain(); err != nil {
+		s.conn.Close()
+		return err
+	}
+
+	<-s.closed
+	return nil
+}
+
+// convertToNatsMessage maps message attributes to headers, binary values
+// base64 encoded, and the deduplication ID, or the source message ID, to the
+// JetStream message ID used for deduplication.
+func (s *natsSink) convertToNatsMessage(message *sqs.Message) (*nats.Msg, error) {
+	subject, err := s.renderSubject(message)
+
+	if err != nil {
+		return nil, err
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = []byte(aws.StringValue(message.Body))
+
+	for name, value := range message.MessageAttributes {
+		if value.BinaryValue != nil {
+			msg.Header.Set(name, base64.StdEncoding.EncodeToString(value.BinaryValue))
+		} else {
+			msg.Header.Set(name, aws.StringValue(value.StringValue))
+		}
+	}
+
+	msgID := aws.StringValue(message.MessageId)
+	if deduplicationID, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]; ok {
+		msgID = aws.StringValue(deduplicationID)
+	}
+	msg.Header.Set(nats.MsgIdHdr, msgID)
+
+	return msg, nil
+}
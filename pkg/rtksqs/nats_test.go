@@ -0,0 +1,50 @@
+package rtksqs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestNatsSinkRenderSubject(t *testing.T) {
+	sink := &natsSink{subject: "orders.{attr:tenant}.{group}"}
+
+	tests := []struct {
+		name    string
+		tenant  string
+		group   string
+		want    string
+		wantErr string
+	}{
+		{name: "tokens", tenant: "acme", group: "customer-1", want: "orders.acme.customer-1"},
+		{name: "missing", group: "customer-1", wantErr: "has no attr:tenant"},
+		{name: "separator", tenant: "acme.eu", group: "customer-1", wantErr: "single subject token"},
+		{name: "wildcard", tenant: "*", group: "customer-1", wantErr: "single subject token"},
+		{name: "full wildcard", tenant: "acme", group: ">", wantErr: "single subject token"},
+		{name: "whitespace", tenant: "acme corp", group: "customer-1", wantErr: "single subject token"},
+		{name: "empty", tenant: "", group: "customer-1", wantErr: "single subject token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := &sqs.Message{
+				Attributes:        map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: aws.String(test.group)},
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+			}
+			if test.name != "missing" {
+				message.MessageAttributes["tenant"] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(test.tenant)}
+			}
+
+			got, err := sink.renderSubject(message)
+
+			switch {
+			case test.wantErr == "" && (err != nil || got != test.want):
+				t.Errorf("got %q and %v, want %q", got, err, test.want)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got %q and %v, want an error about %s", got, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package rtksqs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// StdioSpec reads messages from stdin when used as the source and writes them
+// to stdout when used as the destination.
+const StdioSpec = "-"
+
+// UnknownCount is returned by sources which can not tell upfront how many
+// messages they hold.
+const UnknownCount = -1
+
+// ndjsonSource reads one JSON encoded messageRecord per line.
+type ndjsonSource struct {
+	name    string
+	r       io.Reader
+	scanner *bufio.Scanner
+	line    int
+	// dump is set when reading from dump files.
+	dump *dumpReader
+}
+
+func newNdjsonSource(name string, r io.Reader) *ndjsonSource {
+	scanner := bufio.NewScanner(r)
+	// SQS messages are at most 256KB, leave plenty of room for encoding overhead.
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	return &ndjsonSource{name: name, r: r, scanner: scanner}
+}
+
+func openNdjsonFileSource(spec string, identities []age.Identity) (*ndjsonSource, error) {
+	path := strings.TrimPrefix(spec, fileScheme)
+	r, err := openDumpReader(path, identities)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source := newNdjsonSource(spec, r)
+	source.dump = r
+	return source, nil
+}
+
+func (s *ndjsonSource) String() string {
+	return s.name
+}
+
+func (s *ndjsonSource) ApproximateCount() (int, error) {
+	return UnknownCount, nil
+}
+
+func (s *ndjsonSource) Receive(max int64) ([]*sqs.Message, error) {
+	var messages []*sqs.Message
+
+	for int64(len(messages)) < max && s.scanner.Scan() {
+		s.line++
+		// The scanner's buffer is decoded in place, only the record is
+		// allocated.
+		line := bytes.TrimSpace(s.scanner.Bytes())
+
+		if len(line) == 0 {
+			continue
+		}
+
+		var record messageRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", s.name, s.line, err)
+		}
+
+		messages = append(messages, record.message())
+
+		if s.dump != nil {
+			s.dump.countMessage(len(record.Body))
+		}
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 && s.dump != nil {
+		return nil, s.dump.verify()
+	}
+
+	return messages, nil
+}
+
+// Delete is a no-op, lines which were read are consumed.
+func (s *ndjsonSource) Delete(messages []*sqs.Message) error {
+	return nil
+}
+
+func (s *ndjsonSource) Close() error {
+	if closer, ok := s.r.(io.Closer); ok && s.r != os.Stdin {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ndjsonSink writes one JSON encoded messageRecord per line, each line with
+// a single Write so a dumpFileWriter can split between records.
+type ndjsonSink struct {
+	name   string
+	w      io.Writer
+	stdout bool
+	// dump is set when writing to dump files.
+	dump *dumpFileWriter
+	// decoder adds the decoded body to every record when set.
+	decoder bodyDecoder
+	// redact masks their matches in the written messages.
+	redact []*regexp.Regexp
+	// line is reused for every record, it grows to the largest record
+	// instead of allocating a buffer per batch.
+	line    bytes.Buffer
+	encoder *json.Encoder
+}
+
+func newNdjsonSink(name string, w io.Writer) *ndjsonSink {
+	return &ndjsonSink{name: name, w: w, stdout: w == os.Stdout}
+}
+
+func openNdjsonFileSink(spec string, options dumpOptions) (*ndjsonSink, error) {
+	path := strings.TrimPrefix(spec, fileScheme)
+	w, err := newDumpFileWriter(path, options, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sink := newNdjsonSink(spec, w)
+	sink.dump = w
+	return sink, nil
+}
+
+func (s *ndjsonSink) String() string {
+	return s.name
+}
+
+func (s *ndjsonSink) Send(messages []*sqs.Message) error {
+	if s.encoder == nil {
+		s.encoder = json.NewEncoder(&s.line)
+		s.encoder.SetEscapeHTML(false)
+	}
+
+	for _, message := range messages {
+		s.line.Reset()
+
+		// The body is decoded before it is redacted, which could break
+		// binary bodies.
+		record := newMessageRecord(redactMessage(s.redact, message))
+
+		if s.decoder != nil {
+			decoded, err := decodeBody(s.decoder, aws.StringValue(message.Body))
+			if err != nil {
+				record.DecodeError = Redact(s.redact, err.Error())
+			} else {
+				record.DecodedBody = redactJSON(s.redact, decoded)
+			}
+		}
+
+		if err := s.encoder.Encode(record); err != nil {
+			return err
+		}
+
+		if _, err := s.w.Write(s.line.Bytes()); err != nil {
+			return err
+		}
+
+		if s.dump != nil {
+			s.dump.countMessage(len(record.Body))
+		}
+	}
+
+	return nil
+}
+
+func (s *ndjsonSink) Close() error {
+	if closer, ok := s.w.(io.Closer); ok && !s.stdout {
+		return closer.Close()
+	}
+	return nil
+}
+
+// WritesToStdout reports whether the sink owns stdout, in which case nothing
+// else may be printed there.
+func WritesToStdout(sink Sink) bool {
+	ndjson, ok := sink.(*ndjsonSink)
+	return ok && ndjson.stdout
+}
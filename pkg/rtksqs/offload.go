@@ -0,0 +1,113 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ExtendedPayloadSizeAttribute holds the size of a body offloaded to S3, it
+// marks the message as a pointer for the SQS Extended Client Library.
+const ExtendedPayloadSizeAttribute = "ExtendedPayloadSize"
+
+// extendedPointerClass names the pointer in the bodies of offloaded messages,
+// as the extended clients write it.
+const extendedPointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// s3Pointer locates an offloaded body.
+type s3Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// s3Offload uploads the bodies of messages SQS would reject for their size
+// below an s3://bucket/prefix URL.
+type s3Offload struct {
+	svc    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+func newS3Offload(svc s3iface.S3API, url string) (*s3Offload, error) {
+	bucket, prefix, err := parseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Offload{svc: svc, bucket: bucket, prefix: prefix}, nil
+}
+
+// offloadLarge replaces the messages larger than MaxMessageSize by copies
+// pointing to their uploaded bodies in the extended client format, mapping
+// every copy to its original. Messages whose bodies failed to upload are
+// returned as failures instead.
+func (o *s3Offload) offloadLarge(messages []*sqs.Message) ([]*sqs.Message, map[*sqs.Message]*sqs.Message, []BatchFailure) {
+	var sent []*sqs.Message
+	var failures []BatchFailure
+	originals := map[*sqs.Message]*sqs.Message{}
+
+	for _, message := range messages {
+		if MessageSize(message) <= MaxMessageSize {
+			sent = append(sent, message)
+			continue
+		}
+
+		offloaded, err := o.offload(message)
+		if err != nil {
+			failures = append(failures, BatchFailure{
+				ID:      aws.StringValue(message.MessageId),
+				Code:    "OffloadFailed",
+				Message: fmt.Sprintf("failed to offload the body to %s%s: %s", s3Scheme, o.bucket, err),
+				message: message,
+			})
+			continue
+		}
+
+		originals[offloaded] = message
+		sent = append(sent, offloaded)
+	}
+
+	return sent, originals, failures
+}
+
+// offload uploads the body of a message under a random key and returns a copy
+// of it carrying a pointer to the body instead.
+func (o *s3Offload) offload(message *sqs.Message) (*sqs.Message, error) {
+	body := aws.StringValue(message.Body)
+
+	key := NewRunID()
+	if o.prefix != "" {
+		key = o.prefix + "/" + key
+	}
+
+	if _, err := o.svc.PutObject(&s3.PutObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(key), Body: strings.NewReader(body)}); err != nil {
+		return nil, err
+	}
+
+	pointer, err := json.Marshal([]interface{}{extendedPointerClass, s3Pointer{Bucket: o.bucket, Key: key}})
+	if err != nil {
+		return nil, err
+	}
+
+	offloaded := *message
+	setBody(&offloaded, string(pointer))
+
+	offloaded.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(message.MessageAttributes)+1)
+	for name, value := range message.MessageAttributes {
+		offloaded.MessageAttributes[name] = value
+	}
+	offloaded.MessageAttributes[ExtendedPayloadSizeAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(fmt.Sprint(len(body))),
+	}
+
+	log.Debugf("Offloaded the %d byte body of message %s to %s%s/%s", len(body), aws.StringValue(message.MessageId), s3Scheme, o.bucket, key)
+
+	return &offloaded, nil
+}
@@ -0,0 +1,40 @@
+package rtksqs
+
+import "time"
+
+// MaxReceiveWait is the longest SQS long-polls a receive.
+const MaxReceiveWait = 20 * time.Second
+
+// maxSweepPause is the longest random pause between the short polls of a
+// final sweep, see Options.FinalSweep.
+const maxSweepPause = 200 * time.Millisecond
+
+// adaptiveWait is the long polling wait of queue receives. Full receives
+// shrink it, deep queues are drained fastest without waiting. Receives
+// coming back short extend it, on sparse queues a longer wait collects more
+// messages in fewer calls.
+type adaptiveWait struct {
+	// max is the longest wait in seconds, 0 always short-polls.
+	max     int64
+	current int64
+}
+
+func newAdaptiveWait(max time.Duration) *adaptiveWait {
+	return &adaptiveWait{max: int64(max / time.Second)}
+}
+
+// adapt adjusts the wait to a receive which got received of max messages.
+func (w *adaptiveWait) adapt(received, max int) {
+	switch {
+	case received >= max:
+		w.current /= 2
+	case w.current == 0:
+		w.current = 1
+	default:
+		w.current *= 2
+	}
+
+	if w.current > w.max {
+		w.current = w.max
+	}
+}
@@ -0,0 +1,87 @@
+package rtksqs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/fatih/color"
+)
+
+// PrewarmAttribute marks the no-op markers sent to pre-warm the message
+// groups of a FIFO queue with the run ID, so consumers can filter them.
+const PrewarmAttribute = "sqsmover.prewarm"
+
+// groupPrewarm sends a marker to every message group of a FIFO queue before
+// the first message moved to it, so SQS sets up the ordering of the group
+// ahead of the replay instead of delaying its first message.
+type groupPrewarm struct {
+	svc   sqsiface.SQSAPI
+	url   string
+	runID string
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newGroupPrewarm(svc sqsiface.SQSAPI, url, runID string) *groupPrewarm {
+	return &groupPrewarm{svc: svc, url: url, runID: runID, seen: map[string]bool{}}
+}
+
+// warm sends the markers of the groups of messages not seen before, all at
+// once, and returns once they were sent. A failed marker is logged and its
+// group moved regardless, the marker only saves latency.
+func (p *groupPrewarm) warm(messages []*sqs.Message) {
+	var groups []string
+
+	p.mu.Lock()
+	for _, message := range messages {
+		group := aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+		if group != "" && !p.seen[group] {
+			p.seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+
+			if err := p.send(group); err != nil {
+				log.Warn(color.New(color.FgYellow).Sprintf("Failed to pre-warm message group %s of %s, moving it regardless: %s", group, p.url, err))
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	if len(groups) > 0 {
+		log.Debugf("Pre-warmed %d message groups of %s", len(groups), p.url)
+	}
+}
+
+// send sends the marker of a group, deduplicated by the run and group. FIFO
+// queues don't take delays of single messages, the marker is only delayed by
+// the DelaySeconds of the queue, like the moved messages.
+func (p *groupPrewarm) send(group string) error {
+	deduplication := sha256.Sum256([]byte(p.runID + "\x00" + group))
+
+	_, err := p.svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.url),
+		MessageBody: aws.String(fmt.Sprintf(`{"sqsmover":"prewarm","runId":%q}`, p.runID)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			PrewarmAttribute: {DataType: aws.String("String"), StringValue: aws.String(p.runID)},
+		},
+		MessageGroupId:         aws.String(group),
+		MessageDeduplicationId: aws.String(hex.EncodeToString(deduplication[:])),
+	})
+
+	return err
+}
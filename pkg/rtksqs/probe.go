@@ -0,0 +1,176 @@
+package rtksqs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// ProbeAttribute marks canary messages sent by a probe with the run ID, so
+// moves and consumers can tell them apart.
+const ProbeAttribute = "sqsmover.probe"
+
+// Steps of a probe failing with a ProbeError.
+const (
+	ProbeSend    = "send"
+	ProbeReceive = "receive"
+	ProbeDelete  = "delete"
+)
+
+// ProbeError is returned when a step of a probe failed, e.g. denied by a
+// missing permission.
+type ProbeError struct {
+	Step string
+	Err  error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("failed to %s the canary: %s", e.Step, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeResult is the outcome of probing a queue with a canary message.
+type ProbeResult struct {
+	// Queue is the URL of the probed queue.
+	Queue string
+	// Send is the latency of sending the canary.
+	Send time.Duration
+	// RoundTrip is the time from sending the canary until it was received
+	// back, unless Lost.
+	RoundTrip time.Duration
+	// Delete is the latency of deleting the canary, unless Lost.
+	Delete time.Duration
+	// Lost reports that the canary wasn't received back within the timeout,
+	// e.g. because another consumer received it first or it is buried in a
+	// backlog.
+	Lost bool
+}
+
+// ProbeSource probes the queue of a source, see probeQueue. Other sources
+// aren't probed and return nil.
+func ProbeSource(source Source, runID string, timeout time.Duration) (*ProbeResult, error) {
+	queue, ok := source.(*queueSource)
+	if !ok {
+		return nil, nil
+	}
+
+	return probeQueue(queue.svc, queue.url, runID, timeout)
+}
+
+// ProbeSink probes the queue of a sink, see probeQueue. Other sinks aren't
+// probed and return nil.
+func ProbeSink(sink Sink, runID string, timeout time.Duration) (*ProbeResult, error) {
+	queue, ok := sink.(*queueSink)
+	if !ok {
+		return nil, nil
+	}
+
+	return probeQueue(queue.svc, queue.url, runID, timeout)
+}
+
+// probeQueue sends a canary message marked with ProbeAttribute to a queue,
+// receives it back and deletes it, timing every step. Other messages
+// received meanwhile stay hidden while looking for the canary, for the
+// timeout at most, and are made visible again once it was found. A failed
+// step returns a *ProbeError.
+func probeQueue(svc sqsiface.SQSAPI, url, runID string, timeout time.Duration) (result *ProbeResult, err error) {
+	result = &ProbeResult{Queue: url}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(url),
+		MessageBody: aws.String(fmt.Sprintf(`{"sqsmover":"probe","runId":%q}`, runID)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			ProbeAttribute: {DataType: aws.String("String"), StringValue: aws.String(runID)},
+		},
+	}
+	if strings.HasSuffix(url, ".fifo") {
+		input.MessageGroupId = aws.String(ProbeAttribute + "-" + runID)
+		input.MessageDeduplicationId = aws.String(ProbeAttribute + "-" + runID)
+	}
+
+	start := time.Now()
+	sent, err := svc.SendMessage(input)
+	if err != nil {
+		return nil, &ProbeError{Step: ProbeSend, Err: err}
+	}
+	result.Send = time.Since(start)
+
+	var others []*sqs.ChangeMessageVisibilityBatchRequestEntry
+
+	defer func() {
+		for len(others) > 0 {
+			batch := others
+			if len(batch) > DefaultBatchSize {
+				batch = batch[:DefaultBatchSize]
+			}
+			others = others[len(batch):]
+
+			if _, releaseErr := svc.ChangeMessageVisibilityBatch(&sqs.ChangeMessageVisibilityBatchInput{QueueUrl: aws.String(url), Entries: batch}); releaseErr != nil && err == nil {
+				result, err = nil, &ProbeError{Step: ProbeReceive, Err: fmt.Errorf("failed to release the messages received with it: %s", releaseErr)}
+			}
+		}
+	}()
+
+	hidden := int64(timeout/time.Second) + 1
+
+	for deadline := start.Add(timeout); time.Now().Before(deadline); {
+		resp, err := svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(url),
+			MaxNumberOfMessages:   aws.Int64(DefaultBatchSize),
+			MessageAttributeNames: aws.StringSlice([]string{ProbeAttribute}),
+			VisibilityTimeout:     aws.Int64(hidden),
+			WaitTimeSeconds:       aws.Int64(1),
+		})
+		if err != nil {
+			return nil, &ProbeError{Step: ProbeReceive, Err: err}
+		}
+
+		var canary *sqs.Message
+
+		for _, message := range resp.Messages {
+			if aws.StringValue(message.MessageId) == aws.StringValue(sent.MessageId) {
+				canary = message
+				continue
+			}
+			others = append(others, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(fmt.Sprint(len(others) % DefaultBatchSize)),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(0),
+			})
+		}
+
+		if canary == nil {
+			continue
+		}
+		result.RoundTrip = time.Since(start)
+
+		deleteStart := time.Now()
+		if _, err := svc.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(url), ReceiptHandle: canary.ReceiptHandle}); err != nil {
+			return nil, &ProbeError{Step: ProbeDelete, Err: err}
+		}
+		result.Delete = time.Since(deleteStart)
+
+		return result, nil
+	}
+
+	result.Lost = true
+	return result, nil
+}
+
+// ProbeCanaryHook returns a hook discarding the canary messages a probe of
+// the run left in the source, so they aren't moved.
+func ProbeCanaryHook(runID string) MessageHook {
+	return func(message *sqs.Message) string {
+		if attribute, ok := message.MessageAttributes[ProbeAttribute]; ok && aws.StringValue(attribute.StringValue) == runID {
+			return "is a canary of the probe"
+		}
+		return ""
+	}
+}
@@ -0,0 +1,120 @@
+package rtksqs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const pubsubScheme = "pubsub://"
+
+// pubsubSink publishes messages to a Google Cloud Pub/Sub topic, credentials
+// are taken from the Application Default Credentials.
+type pubsubSink struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+	name   string
+}
+
+// openPubsubSink opens pubsub://<project>/<topic>.
+func openPubsubSink(spec string) (*pubsubSink, error) {
+	parts := strings.SplitN(strings.TrimPrefix(spec, pubsubScheme), "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("pub/sub destination must be %s<project>/<topic>", pubsubScheme)
+	}
+
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, parts[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	topic := client.Topic(parts[1])
+	// Ordering keys are only honoured by topics publishing in order.
+	topic.EnableMessageOrdering = true
+
+	exists, err := topic.Exists(ctx)
+
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if !exists {
+		client.Close()
+		return nil, fmt.Errorf("pub/sub topic %s does not exist", topic)
+	}
+
+	return &pubsubSink{client: client, topic: topic, name: spec}, nil
+}
+
+func (s *pubsubSink) String() string {
+	return s.name
+}
+
+func (s *pubsubSink) Send(messages []*sqs.Message) error {
+	ctx := context.Background()
+	results := make([]*pubsub.PublishResult, len(messages))
+
+	for i, message := range messages {
+		results[i] = s.topic.Publish(ctx, convertToPubsubMessage(message))
+	}
+
+	var failures []BatchFailure
+
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			failures = append(failures, BatchFailure{
+				ID:      aws.StringValue(messages[i].MessageId),
+				Code:    "PublishFailed",
+				Message: err.Error(),
+				message: messages[i],
+			})
+
+			// A failed ordering key stops publishing for that key until resumed.
+			if key := aws.StringValue(messages[i].Attributes[sqs.MessageSystemAttributeNameMessageGroupId]); key != "" {
+				s.topic.ResumePublish(key)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BatchError{Operation: "publish", Failures: failures}
+	}
+
+	return nil
+}
+
+func (s *pubsubSink) Close() error {
+	s.topic.Stop()
+	return s.client.Close()
+}
+
+// convertToPubsubMessage maps message attributes to Pub/Sub attributes, binary
+// values are base64 encoded, and uses the FIFO message group as ordering key.
+func convertToPubsubMessage(message *sqs.Message) *pubsub.Message {
+	result := &pubsub.Message{
+		Data:        []byte(aws.StringValue(message.Body)),
+		OrderingKey: aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]),
+	}
+
+	if len(message.MessageAttributes) > 0 {
+		result.Attributes = make(map[string]string, len(message.MessageAttributes))
+		for name, value := range message.MessageAttributes {
+			if value.BinaryValue != nil {
+				result.Attributes[name] = base64.StdEncoding.EncodeToString(value.BinaryValue)
+			} else {
+				result.Attributes[name] = aws.StringValue(value.StringValue)
+			}
+		}
+	}
+
+	return result
+}
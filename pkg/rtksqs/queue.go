@@ -0,0 +1,595 @@
+package rtksqs
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/fatih/color"
+)
+
+// defaultVisibilityTimeout hides received messages just long enough to send
+// and delete them, a failed move makes them visible again quickly.
+const defaultVisibilityTimeout = 2
+
+// receivedSystemAttributes are the system attributes moves need: to keep the
+// order and deduplication of FIFO messages, and for the age and receive count
+// filters.
+var receivedSystemAttributes = []string{
+	sqs.MessageSystemAttributeNameMessageGroupId,
+	sqs.MessageSystemAttributeNameMessageDeduplicationId,
+	sqs.MessageSystemAttributeNameSentTimestamp,
+	sqs.MessageSystemAttributeNameApproximateReceiveCount,
+}
+
+type queueSource struct {
+	svc               sqsiface.SQSAPI
+	url               string
+	visibilityTimeout int64
+	// messageAttributeNames and attributeNames are requested on receive.
+	messageAttributeNames []*string
+	attributeNames        []*string
+	// wait long-polls receives, nil short-polls them.
+	wait *adaptiveWait
+	// sweeps is the number of empty short polls the queue is taken for
+	// empty after, see Options.FinalSweep.
+	sweeps int
+}
+
+func openQueueSource(svc sqsiface.SQSAPI, queueName string) (*queueSource, error) {
+	url, err := resolveQueueUrl(svc, queueName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &queueSource{
+		svc:                   svc,
+		url:                   url,
+		visibilityTimeout:     defaultVisibilityTimeout,
+		messageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		attributeNames:        aws.StringSlice(receivedSystemAttributes),
+	}, nil
+}
+
+// requestAttributes sets the message attribute names, or prefixes ending in
+// .*, and the system attribute names requested on receive. Message
+// attributes are all requested when none are named, the system attributes
+// moves need always are.
+func (q *queueSource) requestAttributes(messageAttributes, systemAttributes []string) {
+	if len(messageAttributes) > 0 {
+		q.messageAttributeNames = aws.StringSlice(messageAttributes)
+	}
+
+	names := append([]string{}, receivedSystemAttributes...)
+	requested := map[string]bool{}
+	for _, name := range names {
+		requested[name] = true
+	}
+
+	for _, name := range systemAttributes {
+		if name == sqs.QueueAttributeNameAll {
+			names = []string{sqs.QueueAttributeNameAll}
+			break
+		}
+		if !requested[name] {
+			requested[name] = true
+			names = append(names, name)
+		}
+	}
+
+	q.attributeNames = aws.StringSlice(names)
+}
+
+func (q *queueSource) String() string {
+	return q.url
+}
+
+func (q *queueSource) ApproximateCount() (int, error) {
+	queueAttributes, err := q.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.url),
+		AttributeNames: []*string{aws.String("All")},
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	numberOfMessages, _ := strconv.Atoi(*queueAttributes.Attributes["ApproximateNumberOfMessages"])
+
+	return numberOfMessages, nil
+}
+
+func (q *queueSource) ApproximateInFlight() (int, error) {
+	queueAttributes, err := q.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.url),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible)},
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	inFlight, _ := strconv.Atoi(aws.StringValue(queueAttributes.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible]))
+
+	return inFlight, nil
+}
+
+func (q *queueSource) Receive(max int64) ([]*sqs.Message, error) {
+	if q.wait == nil {
+		messages, err := q.receive(max, 0)
+		if err == nil && len(messages) == 0 && q.sweeps > 0 {
+			return q.sweep(max)
+		}
+		return messages, err
+	}
+
+	messages, err := q.receive(max, q.wait.current)
+
+	// An empty receive ends the move, the queue only looks empty until a
+	// receive waited as long as it may, or the final sweep found nothing.
+	if err == nil && len(messages) == 0 {
+		switch {
+		case q.sweeps > 0:
+			messages, err = q.sweep(max)
+		case q.wait.current < q.wait.max:
+			messages, err = q.receive(max, q.wait.max)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	q.wait.adapt(len(messages), int(max))
+
+	return messages, nil
+}
+
+// sweep short-polls the queue until a receive returns messages, up to sweeps
+// times, pausing for a random moment in between so the polls sample
+// different hosts.
+func (q *queueSource) sweep(max int64) ([]*sqs.Message, error) {
+	for i := 0; i < q.sweeps; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(maxSweepPause))))
+		}
+
+		messages, err := q.receive(max, 0)
+		if err != nil || len(messages) > 0 {
+			return messages, err
+		}
+	}
+
+	return nil, nil
+}
+
+// receive receives up to max messages, waiting up to wait seconds for them.
+func (q *queueSource) receive(max, wait int64) ([]*sqs.Message, error) {
+	resp, err := q.svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(q.url),
+		VisibilityTimeout:     aws.Int64(q.visibilityTimeout),
+		WaitTimeSeconds:       aws.Int64(wait),
+		MaxNumberOfMessages:   aws.Int64(max),
+		MessageAttributeNames: q.messageAttributeNames,
+		AttributeNames:        q.attributeNames,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Messages, nil
+}
+
+func (q *queueSource) Delete(messages []*sqs.Message) error {
+	deleteResp, err := q.svc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		Entries:  DeleteBatchEntries(messages),
+		QueueUrl: aws.String(q.url),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(deleteResp.Failed) > 0 {
+		return &BatchError{Operation: "delete", Failures: BatchResultFailures(deleteResp.Failed, messages)}
+	}
+
+	return nil
+}
+
+// receiptOf returns a copy of a received message holding only what deleting
+// and releasing it takes, so moves remembering many messages don't keep their
+// bodies and attributes.
+func receiptOf(message *sqs.Message) *sqs.Message {
+	return &sqs.Message{MessageId: message.MessageId, ReceiptHandle: message.ReceiptHandle}
+}
+
+// release makes received messages visible again.
+func (q *queueSource) release(messages []*sqs.Message) error {
+	return q.changeVisibility(messages, 0, "release")
+}
+
+// changeVisibility hides received messages for timeout seconds from now, 0
+// makes them visible. operation names the change in a BatchError.
+func (q *queueSource) changeVisibility(messages []*sqs.Message, timeout int64, operation string) error {
+	for start := 0; start < len(messages); start += DefaultBatchSize {
+		end := start + DefaultBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		batch := messages[start:end]
+		entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, len(batch))
+		for i, message := range batch {
+			entries[i] = &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(batchEntryID(i)),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(timeout),
+			}
+		}
+
+		resp, err := q.svc.ChangeMessageVisibilityBatch(&sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(q.url),
+			Entries:  entries,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(resp.Failed) > 0 {
+			return &BatchError{Operation: operation, Failures: BatchResultFailures(resp.Failed, batch)}
+		}
+	}
+
+	return nil
+}
+
+func (q *queueSource) Close() error {
+	return nil
+}
+
+type queueSink struct {
+	svc sqsiface.SQSAPI
+	url string
+	// idMap records the message ID assigned by the destination for every
+	// source message ID when set.
+	idMap *idMapWriter
+	// delaySeconds delays the delivery of every sent message when set.
+	delaySeconds int64
+	// offload uploads the bodies of messages over MaxMessageSize to S3 and
+	// sends pointers to them instead when set.
+	offload *s3Offload
+	// prewarm sends a marker to every message group before its first
+	// message when set.
+	prewarm *groupPrewarm
+}
+
+func openQueueSink(svc sqsiface.SQSAPI, queueName, idMapPath, runID string) (*queueSink, error) {
+	url, err := resolveQueueUrl(svc, queueName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &queueSink{svc: svc, url: url}
+
+	if idMapPath != "" {
+		sink.idMap, err = newIdMapWriter(idMapPath, runID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+func (q *queueSink) String() string {
+	return q.url
+}
+
+func (q *queueSink) ApproximateBacklog() (int, error) {
+	queueAttributes, err := q.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.url),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages)},
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	backlog, _ := strconv.Atoi(aws.StringValue(queueAttributes.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]))
+
+	return backlog, nil
+}
+
+// SendRequest is a request sending messages, see PackBatches.
+type SendRequest struct {
+	Messages []*sqs.Message
+	// Single sends the only message with SendMessage, it would have pushed
+	// the batch before it over MaxMessageSize.
+	Single bool
+}
+
+// PackBatches splits at most DefaultBatchSize messages into the requests
+// sending them in order, and those larger than MaxMessageSize themselves,
+// which SQS rejects. Messages are sent in SendMessageBatch requests, a message
+// which would push a request over MaxMessageSize is sent alone with
+// SendMessage after it, and the messages after it in a new request, so the
+// order within FIFO message groups is kept. Sizes are those of MessageSize,
+// including the message attributes SQS counts against the limits too.
+func PackBatches(messages []*sqs.Message) (requests []SendRequest, oversized []*sqs.Message) {
+	var batch []*sqs.Message
+	size := 0
+
+	for _, message := range messages {
+		messageSize := MessageSize(message)
+
+		switch {
+		case messageSize > MaxMessageSize:
+			oversized = append(oversized, message)
+		case size+messageSize > MaxMessageSize:
+			requests = append(requests, SendRequest{Messages: batch}, SendRequest{Messages: []*sqs.Message{message}, Single: true})
+			batch, size = nil, 0
+		default:
+			size += messageSize
+			batch = append(batch, message)
+		}
+	}
+
+	if len(batch) > 0 {
+		requests = append(requests, SendRequest{Messages: batch})
+	}
+
+	return requests, oversized
+}
+
+// oversizedFailures lists messages SQS would reject for their size without
+// sending them.
+func oversizedFailures(messages []*sqs.Message) []BatchFailure {
+	result := make([]BatchFailure, len(messages))
+	for i, message := range messages {
+		result[i] = BatchFailure{
+			ID:          aws.StringValue(message.MessageId),
+			Code:        "MessageTooLong",
+			Message:     fmt.Sprintf("the message is %d bytes with its attributes, SQS accepts at most %d", MessageSize(message), MaxMessageSize),
+			SenderFault: true,
+			message:     message,
+		}
+	}
+
+	return result
+}
+
+func (q *queueSink) Send(messages []*sqs.Message) error {
+	var failures []BatchFailure
+	var originals map[*sqs.Message]*sqs.Message
+
+	if q.offload != nil {
+		messages, originals, failures = q.offload.offloadLarge(messages)
+	}
+
+	if q.prewarm != nil {
+		q.prewarm.warm(messages)
+	}
+
+	requests, oversized := PackBatches(messages)
+
+	failures = append(failures, oversizedFailures(oversized)...)
+
+	for _, request := range requests {
+		// Messages which can't be batched are sent one by one instead of
+		// failing the whole batch.
+		if request.Single {
+			if err := q.sendSingle(request.Messages[0]); err != nil {
+				failures = append(failures, batchRequestFailures(request.Messages, err)...)
+			}
+			continue
+		}
+
+		sendResp, err := q.svc.SendMessageBatch(&sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(q.url),
+			Entries:  q.entries(request.Messages),
+		})
+
+		if err != nil {
+			failures = append(failures, batchRequestFailures(request.Messages, err)...)
+		} else {
+			q.recordIDs(sendResp.Successful, request.Messages)
+			failures = append(failures, BatchResultFailures(sendResp.Failed, request.Messages)...)
+		}
+	}
+
+	if len(failures) > 0 {
+		// Failed pointers leave the messages they replaced in the source.
+		for i, failure := range failures {
+			if original, ok := originals[failure.message]; ok {
+				failures[i].message = original
+			}
+		}
+		return &BatchError{Operation: "enqueue", Failures: failures}
+	}
+
+	return nil
+}
+
+// sendSingle sends a message with SendMessage.
+func (q *queueSink) sendSingle(message *sqs.Message) error {
+	entry := q.entries([]*sqs.Message{message})[0]
+
+	sendResp, err := q.svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:               aws.String(q.url),
+		MessageBody:            entry.MessageBody,
+		MessageAttributes:      entry.MessageAttributes,
+		MessageGroupId:         entry.MessageGroupId,
+		MessageDeduplicationId: entry.MessageDeduplicationId,
+		DelaySeconds:           entry.DelaySeconds,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	q.recordIDs([]*sqs.SendMessageBatchResultEntry{{Id: aws.String(batchEntryID(0)), MessageId: sendResp.MessageId}}, []*sqs.Message{message})
+	return nil
+}
+
+// recordIDs writes the sent entries of a batch of messages to the ID map when
+// set. The messages were sent, so failing to record them is only logged, they
+// must still be deleted from the source.
+func (q *queueSink) recordIDs(entries []*sqs.SendMessageBatchResultEntry, messages []*sqs.Message) {
+	if q.idMap == nil {
+		return
+	}
+
+	if err := q.idMap.write(entries, messages); err != nil {
+		log.Warn(color.New(color.FgYellow).Sprintf("Failed to write %d sent messages to the ID map: %s", len(entries), err))
+	}
+}
+
+// entries returns the batch entries of messages, delayed when the sink is.
+func (q *queueSink) entries(messages []*sqs.Message) []*sqs.SendMessageBatchRequestEntry {
+	entries := SendBatchEntries(messages)
+
+	if q.delaySeconds > 0 {
+		for _, entry := range entries {
+			entry.DelaySeconds = aws.Int64(q.delaySeconds)
+		}
+	}
+
+	return entries
+}
+
+func (q *queueSink) Close() error {
+	if q.idMap != nil {
+		return q.idMap.Close()
+	}
+	return nil
+}
+
+// resolveQueueUrl returns the URL of a queue given by name, URL, ARN or
+// <account>/<name>, see parseQueueRef.
+func resolveQueueUrl(svc sqsiface.SQSAPI, queueName string) (string, error) {
+	ref, err := parseQueueRef(queueName)
+	if err != nil {
+		return "", err
+	}
+
+	params := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(ref.name),
+	}
+	if ref.account != "" {
+		params.QueueOwnerAWSAccountId = aws.String(ref.account)
+	}
+	resp, err := svc.GetQueueUrl(params)
+
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.QueueUrl, nil
+}
+
+// batchEntryID returns the ID of the batch entry of the message at index.
+// Message IDs aren't used, they needn't be valid entry IDs when loaded from a
+// dump.
+func batchEntryID(index int) string {
+	return strconv.Itoa(index)
+}
+
+// BatchEntryMessage returns the message of the entry of a batch request or
+// result built by SendBatchEntries or DeleteBatchEntries from messages, or nil
+// when the entry ID is unknown.
+func BatchEntryMessage(messages []*sqs.Message, entryID *string) *sqs.Message {
+	index, err := strconv.Atoi(aws.StringValue(entryID))
+
+	if err != nil || index < 0 || index >= len(messages) {
+		return nil
+	}
+
+	return messages[index]
+}
+
+// SendBatchEntries returns the SendMessageBatch entries sending messages as
+// received: with their body and message attributes and, of FIFO messages,
+// the MessageGroupId and MessageDeduplicationId of their system attributes.
+// Entry IDs are the indexes of the messages, see BatchEntryMessage.
+func SendBatchEntries(messages []*sqs.Message) []*sqs.SendMessageBatchRequestEntry {
+	result := make([]*sqs.SendMessageBatchRequestEntry, len(messages))
+	for i, message := range messages {
+		requestEntry := &sqs.SendMessageBatchRequestEntry{
+			MessageBody:       message.Body,
+			Id:                aws.String(batchEntryID(i)),
+			MessageAttributes: message.MessageAttributes,
+		}
+
+		if messageGroupId, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+			requestEntry.MessageGroupId = messageGroupId
+		}
+
+		if messageDeduplicationId, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]; ok {
+			requestEntry.MessageDeduplicationId = messageDeduplicationId
+		}
+
+		result[i] = requestEntry
+	}
+
+	return result
+}
+
+// DeleteBatchEntries returns the DeleteMessageBatch entries deleting received
+// messages by their receipt handles. Entry IDs are the indexes of the
+// messages, see BatchEntryMessage.
+func DeleteBatchEntries(messages []*sqs.Message) []*sqs.DeleteMessageBatchRequestEntry {
+	result := make([]*sqs.DeleteMessageBatchRequestEntry, len(messages))
+	for i, message := range messages {
+		result[i] = &sqs.DeleteMessageBatchRequestEntry{
+			ReceiptHandle: message.ReceiptHandle,
+			Id:            aws.String(batchEntryID(i)),
+		}
+	}
+
+	return result
+}
+
+// batchRequestFailures lists every message of a request which failed as a
+// whole.
+func batchRequestFailures(messages []*sqs.Message, err error) []BatchFailure {
+	code, senderFault := errorCode(err)
+
+	result := make([]BatchFailure, len(messages))
+	for i, message := range messages {
+		result[i] = BatchFailure{ID: aws.StringValue(message.MessageId), Code: code, Message: err.Error(), SenderFault: senderFault, message: message}
+	}
+
+	return result
+}
+
+// BatchResultFailures maps the failed entries of the result of a batch
+// request built from messages back to the messages, e.g. to send or delete
+// them again.
+func BatchResultFailures(entries []*sqs.BatchResultErrorEntry, messages []*sqs.Message) []BatchFailure {
+	result := make([]BatchFailure, len(entries))
+	for i, entry := range entries {
+		message := BatchEntryMessage(messages, entry.Id)
+		result[i] = BatchFailure{
+			ID:          aws.StringValue(entry.Id),
+			Code:        aws.StringValue(entry.Code),
+			Message:     aws.StringValue(entry.Message),
+			SenderFault: aws.BoolValue(entry.SenderFault),
+			message:     message,
+		}
+		if message != nil {
+			result[i].ID = aws.StringValue(message.MessageId)
+		}
+	}
+
+	return result
+}
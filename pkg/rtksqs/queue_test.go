@@ -0,0 +1,387 @@
+package rtksqs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+// createFakeQueue creates a queue of the fake and returns its URL.
+func createFakeQueue(t *testing.T, fake *fakesqs.SQS, name string) string {
+	t.Helper()
+
+	resp, err := fake.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)})
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", name, err)
+	}
+	return aws.StringValue(resp.QueueUrl)
+}
+
+// testMessages returns messages m0, m1, ... with the bodies.
+func testMessages(bodies ...string) []*sqs.Message {
+	messages := make([]*sqs.Message, len(bodies))
+	for i, body := range bodies {
+		messages[i] = &sqs.Message{MessageId: aws.String(fmt.Sprintf("m%d", i)), Body: aws.String(body)}
+	}
+	return messages
+}
+
+// failedIDs returns the sorted message IDs of the failures of a BatchError,
+// nil when err is nil.
+func failedIDs(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %T %q, want a *BatchError", err, err)
+	}
+
+	ids := make([]string, len(batchErr.Failures))
+	for i, failure := range batchErr.Failures {
+		ids[i] = failure.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sorted(values []string) []string {
+	result := append([]string(nil), values...)
+	sort.Strings(result)
+	return result
+}
+
+func TestQueueSinkSend(t *testing.T) {
+	large := strings.Repeat("l", 100*1024)
+	oversized := strings.Repeat("o", MaxMessageSize+1)
+
+	failCall := func(operation string) fakesqs.CallFailure {
+		return func(op, _ string) error {
+			if op == operation {
+				return fmt.Errorf("%s failed", op)
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name         string
+		bodies       []string
+		callFailure  fakesqs.CallFailure
+		entryFailure fakesqs.EntryFailure
+		wantFailed   []string
+		wantSent     []string
+	}{
+		{
+			name:     "batch",
+			bodies:   []string{"a", "b", "c"},
+			wantSent: []string{"a", "b", "c"},
+		},
+		{
+			name:   "partial batch failure",
+			bodies: []string{"a", "b", "c"},
+			entryFailure: func(operation, id string) *sqs.BatchResultErrorEntry {
+				if id != "1" {
+					return nil
+				}
+				return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+			},
+			wantFailed: []string{"m1"},
+			wantSent:   []string{"a", "c"},
+		},
+		{
+			name:        "failed batch request",
+			bodies:      []string{"a", "b", "c"},
+			callFailure: failCall("SendMessageBatch"),
+			wantFailed:  []string{"m0", "m1", "m2"},
+		},
+		{
+			name:       "oversized",
+			bodies:     []string{"a", oversized, "c"},
+			wantFailed: []string{"m1"},
+			wantSent:   []string{"a", "c"},
+		},
+		{
+			name:     "single fallback",
+			bodies:   []string{large, large, large},
+			wantSent: []string{large, large, large},
+		},
+		{
+			name:        "failed single fallback",
+			bodies:      []string{large, large, large},
+			callFailure: failCall("SendMessage"),
+			wantFailed:  []string{"m2"},
+			wantSent:    []string{large, large},
+		},
+		{
+			name:        "failed batch request with single fallback",
+			bodies:      []string{large, large, large},
+			callFailure: failCall("SendMessageBatch"),
+			wantFailed:  []string{"m0", "m1"},
+			wantSent:    []string{large},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			url := createFakeQueue(t, fake, "destination")
+
+			sink, err := openQueueSink(fake, "destination", "", "run")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fake.SetCallFailure(test.callFailure)
+			fake.SetEntryFailure(test.entryFailure)
+
+			err = sink.Send(testMessages(test.bodies...))
+
+			if got := failedIDs(t, err); !reflect.DeepEqual(got, test.wantFailed) {
+				t.Errorf("failed %v, want %v", got, test.wantFailed)
+			}
+
+			if got := fake.Bodies(url); !reflect.DeepEqual(sorted(got), sorted(test.wantSent)) {
+				t.Errorf("sent %d messages, want %d", len(got), len(test.wantSent))
+			}
+		})
+	}
+}
+
+func TestPackBatches(t *testing.T) {
+	large := strings.Repeat("l", 100*1024)
+	oversized := strings.Repeat("o", MaxMessageSize+1)
+
+	// ids returns the IDs of the messages of every request, single ones
+	// marked with a !.
+	ids := func(requests []SendRequest) []string {
+		var result []string
+		for _, request := range requests {
+			var messageIDs []string
+			for _, message := range request.Messages {
+				messageIDs = append(messageIDs, aws.StringValue(message.MessageId))
+			}
+			id := strings.Join(messageIDs, ",")
+			if request.Single {
+				id += "!"
+			}
+			result = append(result, id)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name          string
+		bodies        []string
+		wantRequests  []string
+		wantOversized int
+	}{
+		{
+			name:         "one batch",
+			bodies:       []string{"a", "b", "c"},
+			wantRequests: []string{"m0,m1,m2"},
+		},
+		{
+			name:         "keeps the order",
+			bodies:       []string{large, large, large, "d", large},
+			wantRequests: []string{"m0,m1", "m2!", "m3,m4"},
+		},
+		{
+			name:          "oversized",
+			bodies:        []string{"a", oversized, "c"},
+			wantRequests:  []string{"m0,m2"},
+			wantOversized: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests, oversized := PackBatches(testMessages(test.bodies...))
+
+			if got := ids(requests); !reflect.DeepEqual(got, test.wantRequests) {
+				t.Errorf("got requests %v, want %v", got, test.wantRequests)
+			}
+
+			if len(oversized) != test.wantOversized {
+				t.Errorf("got %d oversized messages, want %d", len(oversized), test.wantOversized)
+			}
+		})
+	}
+}
+
+func TestQueueSinkSendIDMapFailure(t *testing.T) {
+	fake := fakesqs.New()
+	url := createFakeQueue(t, fake, "destination")
+
+	sink, err := openQueueSink(fake, "destination", filepath.Join(t.TempDir(), "ids.csv"), "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the ID map fails once its file is closed.
+	sink.idMap.file.Close()
+
+	if err := sink.Send(testMessages("a", "b")); err != nil {
+		t.Fatalf("got %q, the messages were sent", err)
+	}
+
+	if got := fake.Bodies(url); len(got) != 2 {
+		t.Errorf("sent %d messages, want 2", len(got))
+	}
+}
+
+func TestQueueSinkSendEntryIDs(t *testing.T) {
+	fake := fakesqs.New()
+	url := createFakeQueue(t, fake, "destination")
+
+	sink, err := openQueueSink(fake, "destination", "", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dumps and other sources may hold message IDs SQS doesn't accept as
+	// batch entry IDs, or the same ID twice.
+	messages := []*sqs.Message{
+		{MessageId: aws.String("pubsub:projects/p/subscriptions/s:1"), Body: aws.String("a")},
+		{MessageId: aws.String(strings.Repeat("x", 100)), Body: aws.String("b")},
+		{MessageId: aws.String("same"), Body: aws.String("c")},
+		{MessageId: aws.String("same"), Body: aws.String("d")},
+	}
+
+	fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+		if id != "3" {
+			return nil
+		}
+		return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+	})
+
+	err = sink.Send(messages)
+
+	// The failure maps back to the message of its entry, not the first
+	// message with its ID.
+	if got := sentMessages(messages, err); !reflect.DeepEqual(got, messages[:3]) {
+		t.Errorf("got %d sent messages, want the first 3", len(got))
+	}
+
+	if got := fake.Bodies(url); !reflect.DeepEqual(sorted(got), []string{"a", "b", "c"}) {
+		t.Errorf("sent %v, want a, b and c", got)
+	}
+}
+
+func TestQueueSourceDeleteEntryIDs(t *testing.T) {
+	fake := fakesqs.New()
+	url := createFakeQueue(t, fake, "source")
+
+	for _, body := range []string{"a", "b", "c"} {
+		if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(url), MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, err := openQueueSource(fake, "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := source.Receive(DefaultBatchSize)
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("received %d messages and got %v, want 3", len(messages), err)
+	}
+
+	fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+		if operation != "DeleteMessageBatch" || id != "1" {
+			return nil
+		}
+		return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("failed")}
+	})
+
+	err = source.Delete(messages)
+
+	if got, want := failedIDs(t, err), []string{aws.StringValue(messages[1].MessageId)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failed %v, want %v", got, want)
+	}
+
+	if got := fake.Bodies(url); !reflect.DeepEqual(got, []string{aws.StringValue(messages[1].Body)}) {
+		t.Errorf("source holds %v, want only the message which failed to delete", got)
+	}
+}
+
+func TestBatchResultFailures(t *testing.T) {
+	messages := testMessages("a", "b")
+
+	tests := []struct {
+		name        string
+		entryID     string
+		wantID      string
+		wantMessage *sqs.Message
+	}{
+		{name: "known entry", entryID: "1", wantID: "m1", wantMessage: messages[1]},
+		{name: "unknown entry", entryID: "7", wantID: "7"},
+		{name: "invalid entry", entryID: "x", wantID: "x"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures := BatchResultFailures([]*sqs.BatchResultErrorEntry{{
+				Id:          aws.String(test.entryID),
+				Code:        aws.String("InvalidParameterValue"),
+				Message:     aws.String("invalid"),
+				SenderFault: aws.Bool(true),
+			}}, messages)
+
+			want := BatchFailure{ID: test.wantID, Code: "InvalidParameterValue", Message: "invalid", SenderFault: true, message: test.wantMessage}
+			if len(failures) != 1 || failures[0] != want {
+				t.Errorf("got %+v, want %+v", failures, want)
+			}
+		})
+	}
+}
+
+func TestSentMessages(t *testing.T) {
+	messages := testMessages("a", "b", "c")
+
+	tests := []struct {
+		name string
+		err  error
+		want []*sqs.Message
+	}{
+		{
+			name: "failed by message",
+			err:  &BatchError{Operation: "enqueue", Failures: []BatchFailure{{ID: "m1", message: messages[1]}}},
+			want: []*sqs.Message{messages[0], messages[2]},
+		},
+		{
+			name: "failed by ID",
+			err:  &BatchError{Operation: "enqueue", Failures: []BatchFailure{{ID: "m0"}, {ID: "m2"}}},
+			want: []*sqs.Message{messages[1]},
+		},
+		{
+			name: "wrapped",
+			err:  &MoveError{Step: StepSend, Err: &BatchError{Operation: "enqueue", Failures: []BatchFailure{{ID: "m0"}}}},
+			want: []*sqs.Message{messages[1], messages[2]},
+		},
+		{
+			name: "failed request",
+			err:  errors.New("connection reset"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sentMessages(messages, test.err); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %d sent messages, want %d", len(got), len(test.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package rtksqs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// queueRef is a queue named by a move: the queue name and the account which
+// owns it, empty for the account of the credentials.
+type queueRef struct {
+	name    string
+	account string
+}
+
+// parseQueueRef parses a queue given by name, by URL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/orders, by ARN, e.g.
+// arn:aws:sqs:us-east-1:123456789012:orders, or as 123456789012/orders. Queues
+// of other accounts are looked up with the account as queue owner, so queues
+// shared by a resource policy can be moved without assuming a role there.
+func parseQueueRef(spec string) (queueRef, error) {
+	switch {
+	case arn.IsARN(spec):
+		queueArn, err := arn.Parse(spec)
+		if err != nil {
+			return queueRef{}, fmt.Errorf("invalid queue ARN %s: %s", spec, err)
+		}
+		if queueArn.Service != "sqs" {
+			return queueRef{}, fmt.Errorf("%s is not the ARN of a queue", spec)
+		}
+		return newQueueRef(spec, queueArn.AccountID, queueArn.Resource)
+	case isQueueURL(spec):
+		queueURL, err := url.Parse(spec)
+		if err != nil {
+			return queueRef{}, fmt.Errorf("invalid queue URL %s: %s", spec, err)
+		}
+		parts := strings.Split(strings.Trim(queueURL.Path, "/"), "/")
+		if len(parts) != 2 {
+			return queueRef{}, fmt.Errorf("%s is not a queue URL, expected an account ID and a queue name in the path", spec)
+		}
+		return newQueueRef(spec, parts[0], parts[1])
+	case strings.Contains(spec, "/"):
+		parts := strings.SplitN(spec, "/", 2)
+		return newQueueRef(spec, parts[0], parts[1])
+	default:
+		return queueRef{name: spec}, nil
+	}
+}
+
+// isQueueURL reports whether spec is an http:// or https:// queue URL.
+func isQueueURL(spec string) bool {
+	return strings.HasPrefix(spec, "https://") || strings.HasPrefix(spec, "http://")
+}
+
+func newQueueRef(spec, account, name string) (queueRef, error) {
+	if !isAccountID(account) {
+		return queueRef{}, fmt.Errorf("%s names no valid account ID, expected 12 digits, got %q", spec, account)
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return queueRef{}, fmt.Errorf("%s names no valid queue name", spec)
+	}
+	return queueRef{name: name, account: account}, nil
+}
+
+func isAccountID(account string) bool {
+	if len(account) != 12 {
+		return false
+	}
+	for _, r := range account {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
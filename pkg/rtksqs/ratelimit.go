@@ -0,0 +1,42 @@
+package rtksqs
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter paces messages to a steady rate. It is safe for concurrent
+// use, so moves sharing it are paced together.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter returns a limiter letting through rate messages per second.
+func NewRateLimiter(rate float64) *RateLimiter {
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Wait blocks until n more messages may go. A batch goes at once and delays
+// the next one by the time its messages take at the rate.
+func (l *RateLimiter) Wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(n) * l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// refund gives back n messages a Wait let through which didn't go, e.g. as
+// fewer were received than waited for.
+func (l *RateLimiter) refund(n int) {
+	l.mu.Lock()
+	l.next = l.next.Add(-time.Duration(n) * l.interval)
+	l.mu.Unlock()
+}
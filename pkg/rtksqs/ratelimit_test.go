@@ -0,0 +1,21 @@
+package rtksqs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefund(t *testing.T) {
+	limiter := NewRateLimiter(10)
+
+	// A receive of 10 messages returning 1 only delays the next by the
+	// time of 1 message at the rate.
+	limiter.Wait(10)
+	limiter.refund(9)
+
+	start := time.Now()
+	limiter.Wait(1)
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("waited %s, want about 100ms", waited)
+	}
+}
@@ -0,0 +1,111 @@
+package rtksqs
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// messageRecord is the on-disk representation of a message used by the
+// NDJSON format. Binary attribute values, including those of binary lists,
+// are base64 encoded by encoding/json, so they survive a dump and load byte
+// for byte.
+type messageRecord struct {
+	MessageID         string                     `json:"messageId"`
+	Body              string                     `json:"body"`
+	MD5OfBody         string                     `json:"md5OfBody,omitempty"`
+	Attributes        map[string]string          `json:"attributes,omitempty"`
+	MessageAttributes map[string]attributeRecord `json:"messageAttributes,omitempty"`
+	// DecodedBody and DecodeError are written for triage when bodies are
+	// decoded, loading ignores them.
+	DecodedBody json.RawMessage `json:"decodedBody,omitempty"`
+	DecodeError string          `json:"decodeError,omitempty"`
+}
+
+type attributeRecord struct {
+	DataType         string   `json:"dataType"`
+	StringValue      *string  `json:"stringValue,omitempty"`
+	BinaryValue      []byte   `json:"binaryValue,omitempty"`
+	StringListValues []string `json:"stringListValues,omitempty"`
+	BinaryListValues [][]byte `json:"binaryListValues,omitempty"`
+}
+
+func newMessageRecord(message *sqs.Message) messageRecord {
+	record := messageRecord{
+		MessageID: aws.StringValue(message.MessageId),
+		Body:      aws.StringValue(message.Body),
+		MD5OfBody: aws.StringValue(message.MD5OfBody),
+	}
+
+	if len(message.Attributes) > 0 {
+		record.Attributes = aws.StringValueMap(message.Attributes)
+	}
+
+	record.MessageAttributes = newAttributeRecords(message.MessageAttributes)
+
+	return record
+}
+
+// newAttributeRecords returns the on-disk representation of message
+// attributes, nil when there are none.
+func newAttributeRecords(attributes map[string]*sqs.MessageAttributeValue) map[string]attributeRecord {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	records := make(map[string]attributeRecord, len(attributes))
+	for name, value := range attributes {
+		records[name] = attributeRecord{
+			DataType:         aws.StringValue(value.DataType),
+			StringValue:      value.StringValue,
+			BinaryValue:      value.BinaryValue,
+			StringListValues: aws.StringValueSlice(value.StringListValues),
+			BinaryListValues: value.BinaryListValues,
+		}
+	}
+
+	return records
+}
+
+func (r attributeRecord) value() *sqs.MessageAttributeValue {
+	value := &sqs.MessageAttributeValue{
+		DataType:    aws.String(r.DataType),
+		StringValue: r.StringValue,
+		BinaryValue: r.BinaryValue,
+	}
+
+	if len(r.StringListValues) > 0 {
+		value.StringListValues = aws.StringSlice(r.StringListValues)
+	}
+
+	if len(r.BinaryListValues) > 0 {
+		value.BinaryListValues = r.BinaryListValues
+	}
+
+	return value
+}
+
+func (r messageRecord) message() *sqs.Message {
+	message := &sqs.Message{
+		MessageId: aws.String(r.MessageID),
+		Body:      aws.String(r.Body),
+	}
+
+	if r.MD5OfBody != "" {
+		message.MD5OfBody = aws.String(r.MD5OfBody)
+	}
+
+	if len(r.Attributes) > 0 {
+		message.Attributes = aws.StringMap(r.Attributes)
+	}
+
+	if len(r.MessageAttributes) > 0 {
+		message.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(r.MessageAttributes))
+		for name, value := range r.MessageAttributes {
+			message.MessageAttributes[name] = value.value()
+		}
+	}
+
+	return message
+}
@@ -0,0 +1,68 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Redacted replaces the matches of redaction patterns.
+const Redacted = "[REDACTED]"
+
+// Redact replaces the matches of every pattern in text by Redacted.
+func Redact(patterns []*regexp.Regexp, text string) string {
+	for _, pattern := range patterns {
+		text = pattern.ReplaceAllLiteralString(text, Redacted)
+	}
+	return text
+}
+
+// redactMessage returns a copy of a message with the matches of patterns in
+// its body and string message attributes redacted, and the MD5 of the
+// redacted body. Without patterns the message itself is returned.
+func redactMessage(patterns []*regexp.Regexp, message *sqs.Message) *sqs.Message {
+	if len(patterns) == 0 {
+		return message
+	}
+
+	redacted := *message
+	setBody(&redacted, Redact(patterns, aws.StringValue(message.Body)))
+
+	if len(message.MessageAttributes) > 0 {
+		redacted.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(message.MessageAttributes))
+
+		for name, value := range message.MessageAttributes {
+			attribute := *value
+			if value.StringValue != nil {
+				attribute.StringValue = aws.String(Redact(patterns, *value.StringValue))
+			}
+			if len(value.StringListValues) > 0 {
+				attribute.StringListValues = make([]*string, len(value.StringListValues))
+				for i, item := range value.StringListValues {
+					attribute.StringListValues[i] = aws.String(Redact(patterns, aws.StringValue(item)))
+				}
+			}
+			redacted.MessageAttributes[name] = &attribute
+		}
+	}
+
+	return &redacted
+}
+
+// redactJSON redacts a decoded body. A match spanning the JSON syntax would
+// break the document, which is then kept as a string.
+func redactJSON(patterns []*regexp.Regexp, decoded json.RawMessage) json.RawMessage {
+	if len(patterns) == 0 {
+		return decoded
+	}
+
+	redacted := Redact(patterns, string(decoded))
+	if json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+
+	quoted, _ := json.Marshal(redacted)
+	return quoted
+}
@@ -0,0 +1,252 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Redrive permissions of a RedriveAllowPolicy.
+const (
+	redriveDenyAll = "denyAll"
+	redriveByQueue = "byQueue"
+)
+
+// redrivePolicy is a queue's RedrivePolicy attribute, naming its dead-letter
+// queue.
+type redrivePolicy struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+}
+
+// redriveAllowPolicy is a dead-letter queue's RedriveAllowPolicy attribute,
+// naming the queues allowed to use it.
+type redriveAllowPolicy struct {
+	RedrivePermission string   `json:"redrivePermission"`
+	SourceQueueArns   []string `json:"sourceQueueArns"`
+}
+
+// allows reports whether the queue may use the dead-letter queue, allowAll
+// and no policy allow every queue.
+func (p *redriveAllowPolicy) allows(arn string) bool {
+	switch p.RedrivePermission {
+	case redriveDenyAll:
+		return false
+	case redriveByQueue:
+		for _, allowed := range p.SourceQueueArns {
+			if allowed == arn {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// RedriveIssue is a problem ValidateRedrive found. Conflicts break a
+// configured redrive allow policy or would bounce messages in a loop, the
+// others are worth a warning.
+type RedriveIssue struct {
+	Conflict bool
+	Message  string
+	// IntoDeadLetterQueue is set when the destination is the dead-letter
+	// queue of the source, which moves meant to dead-letter messages expect.
+	IntoDeadLetterQueue bool
+	// SameQueue is set when the source is the destination, which requeueing
+	// with a delay expects.
+	SameQueue bool
+}
+
+// queueRedrive holds the redrive configuration of a queue.
+type queueRedrive struct {
+	arn    string
+	policy *redrivePolicy
+	allow  *redriveAllowPolicy
+}
+
+func loadQueueRedrive(svc sqsiface.SQSAPI, url string) (*queueRedrive, error) {
+	resp, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(url),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.QueueAttributeNameQueueArn,
+			sqs.QueueAttributeNameRedrivePolicy,
+			sqs.QueueAttributeNameRedriveAllowPolicy,
+		}),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	redrive := &queueRedrive{arn: aws.StringValue(resp.Attributes[sqs.QueueAttributeNameQueueArn])}
+
+	if value, ok := resp.Attributes[sqs.QueueAttributeNameRedrivePolicy]; ok && aws.StringValue(value) != "" {
+		redrive.policy = &redrivePolicy{}
+		if err := json.Unmarshal([]byte(aws.StringValue(value)), redrive.policy); err != nil {
+			return nil, fmt.Errorf("invalid redrive policy of %s: %w", url, err)
+		}
+	}
+
+	if value, ok := resp.Attributes[sqs.QueueAttributeNameRedriveAllowPolicy]; ok && aws.StringValue(value) != "" {
+		redrive.allow = &redriveAllowPolicy{}
+		if err := json.Unmarshal([]byte(aws.StringValue(value)), redrive.allow); err != nil {
+			return nil, fmt.Errorf("invalid redrive allow policy of %s: %w", url, err)
+		}
+	}
+
+	return redrive, nil
+}
+
+// DeadLetterQueue returns the name of the dead-letter queue the RedrivePolicy
+// of the named queue sends failed messages to, or its ARN when it's in
+// another account.
+func DeadLetterQueue(sess *session.Session, name string, options Options) (string, error) {
+	svc := options.sqsClient(sess)
+
+	url, err := resolveQueueUrl(svc, name)
+	if err != nil {
+		return "", err
+	}
+
+	redrive, err := loadQueueRedrive(svc, url)
+	if err != nil {
+		return "", err
+	}
+
+	if redrive.policy == nil || redrive.policy.DeadLetterTargetArn == "" {
+		return "", fmt.Errorf("%s has no redrive policy naming a dead-letter queue", name)
+	}
+
+	queueArn, err := arn.Parse(redrive.arn)
+	if err != nil {
+		return "", fmt.Errorf("invalid ARN of %s: %s", name, err)
+	}
+
+	deadLetterArn, err := arn.Parse(redrive.policy.DeadLetterTargetArn)
+	if err != nil {
+		return "", fmt.Errorf("invalid dead-letter queue ARN in the redrive policy of %s: %s", name, err)
+	}
+
+	// Dead-letter queues of queues named with their account are returned by
+	// ARN, so they're looked up in that account as well.
+	if ref, _ := parseQueueRef(name); ref.account != "" || deadLetterArn.AccountID != queueArn.AccountID {
+		return deadLetterArn.String(), nil
+	}
+
+	return strings.TrimPrefix(deadLetterArn.Resource, "/"), nil
+}
+
+// ValidateRedrive checks a move between two queues against their redrive
+// policies. Moves which don't go from a queue to a queue have no issues.
+func ValidateRedrive(source Source, sink Sink) ([]RedriveIssue, error) {
+	queueSource, ok := source.(*queueSource)
+	if !ok {
+		return nil, nil
+	}
+
+	queueSink, ok := sink.(*queueSink)
+	if !ok {
+		return nil, nil
+	}
+
+	from, err := loadQueueRedrive(queueSource.svc, queueSource.url)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := loadQueueRedrive(queueSink.svc, queueSink.url)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []RedriveIssue
+
+	// Moving into a dead-letter queue must respect which queues may use it.
+	if to.allow != nil && !to.allow.allows(from.arn) {
+		issues = append(issues, RedriveIssue{Conflict: true, Message: fmt.Sprintf(
+			"%s only accepts dead letters from queues its redrive allow policy (%s) permits, and %s is not one of them",
+			to.arn, to.allow.RedrivePermission, from.arn)})
+	}
+
+	// Bounce loops: the messages would come straight back to the source, or
+	// dead-letter back and forth between the queues.
+	switch {
+	case from.arn == to.arn:
+		issues = append(issues, RedriveIssue{Conflict: true, SameQueue: true, Message: fmt.Sprintf(
+			"%s is both source and destination, the moved messages would be received again", from.arn)})
+	case from.policy != nil && from.policy.DeadLetterTargetArn == to.arn && to.policy != nil && to.policy.DeadLetterTargetArn == from.arn:
+		issues = append(issues, RedriveIssue{Conflict: true, Message: fmt.Sprintf(
+			"%s and %s are each other's dead-letter queue, failing messages would bounce between them", from.arn, to.arn)})
+	case from.policy != nil && from.policy.DeadLetterTargetArn == to.arn:
+		issues = append(issues, RedriveIssue{Conflict: true, IntoDeadLetterQueue: true, Message: fmt.Sprintf(
+			"%s is the dead-letter queue of %s, messages would be dead-lettered without having failed and bounce back with the next redrive", to.arn, from.arn)})
+	}
+
+	// Redriving back to the queue the messages dead-lettered from: failing
+	// again, they can only return if the dead-letter queue still allows it.
+	if to.policy != nil && to.policy.DeadLetterTargetArn == from.arn && from.allow != nil && !from.allow.allows(to.arn) {
+		issues = append(issues, RedriveIssue{Conflict: true, Message: fmt.Sprintf(
+			"%s dead-letters into %s, but the redrive allow policy (%s) of %s doesn't permit it, messages failing again can't return",
+			to.arn, from.arn, from.allow.RedrivePermission, from.arn)})
+	}
+
+	return issues, nil
+}
+
+// RedriveMaxReceiveCount returns the maxReceiveCount of the destination queue
+// when the source queue is its dead-letter queue, and reports false for other
+// moves.
+func RedriveMaxReceiveCount(source Source, sink Sink) (int, bool, error) {
+	queueSource, ok := source.(*queueSource)
+	if !ok {
+		return 0, false, nil
+	}
+
+	queueSink, ok := sink.(*queueSink)
+	if !ok {
+		return 0, false, nil
+	}
+
+	from, err := loadQueueRedrive(queueSource.svc, queueSource.url)
+	if err != nil {
+		return 0, false, err
+	}
+
+	to, err := loadQueueRedrive(queueSink.svc, queueSink.url)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if to.policy == nil || to.policy.DeadLetterTargetArn != from.arn {
+		return 0, false, nil
+	}
+
+	count, err := strconv.Atoi(to.policy.MaxReceiveCount.String())
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid maxReceiveCount in the redrive policy of %s: %s", to.arn, err)
+	}
+
+	return count, true, nil
+}
+
+// MaxReceiveCountHook returns a hook skipping messages received more often
+// than max, the maxReceiveCount of the queue they are redriven to, which
+// would dead-letter them again right away. Messages without a receive count
+// are moved.
+func MaxReceiveCountHook(max int) MessageHook {
+	return func(message *sqs.Message) string {
+		received, err := strconv.Atoi(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+		if err == nil && received > max {
+			return fmt.Sprintf("was received %d times, more than the maxReceiveCount %d of the destination", received, max)
+		}
+		return ""
+	}
+}
@@ -0,0 +1,157 @@
+package rtksqs
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ReplayCountAttribute is the message attribute counting how often a message
+// was moved with replay tracking.
+const ReplayCountAttribute = "sqsmover.replay-count"
+
+// RunIDAttribute is the message attribute naming the run which last moved a
+// message with replay tracking.
+const RunIDAttribute = "sqsmover.run-id"
+
+// maxMessageAttributes is the number of message attributes SQS accepts.
+const maxMessageAttributes = 10
+
+// NewRunID returns a random UUID identifying a run in logs and provenance
+// records.
+func NewRunID() string {
+	var id [16]byte
+
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+
+	// Version 4, variant RFC 4122.
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// ReplayCounter returns a hook incrementing the replay count of every message,
+// and recording runID in RunIDAttribute when set. Messages which were already
+// replayed maxReplays times are skipped, 0 doesn't limit replays.
+func ReplayCounter(maxReplays int, runID string) MessageHook {
+	return func(message *sqs.Message) string {
+		count := 0
+
+		added := []string{ReplayCountAttribute}
+		if runID != "" {
+			added = append(added, RunIDAttribute)
+		}
+
+		missing := 0
+		for _, name := range added {
+			if _, ok := message.MessageAttributes[name]; !ok {
+				missing++
+			}
+		}
+
+		if len(message.MessageAttributes)+missing > maxMessageAttributes {
+			return fmt.Sprintf("has %d message attributes, no room for %s", len(message.MessageAttributes), strings.Join(added, " and "))
+		}
+
+		if value, ok := message.MessageAttributes[ReplayCountAttribute]; ok {
+			count, _ = strconv.Atoi(aws.StringValue(value.StringValue))
+		}
+
+		if maxReplays > 0 && count >= maxReplays {
+			return fmt.Sprintf("was replayed %d times, the maximum is %d", count, maxReplays)
+		}
+
+		if message.MessageAttributes == nil {
+			message.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		}
+
+		message.MessageAttributes[ReplayCountAttribute] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.Itoa(count + 1)),
+		}
+
+		if runID != "" {
+			message.MessageAttributes[RunIDAttribute] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(runID),
+			}
+		}
+
+		return ""
+	}
+}
+
+// Message attributes keeping the system attributes of a message which the
+// destination assigns anew, see TimelinePreserver.
+const (
+	SentTimestampAttribute         = "sqsmover.sent-timestamp"
+	FirstReceiveTimestampAttribute = "sqsmover.first-receive-timestamp"
+	SequenceNumberAttribute        = "sqsmover.sequence-number"
+)
+
+// preservedAttributes maps the system attributes TimelinePreserver keeps to
+// their message attributes and data types.
+var preservedAttributes = []struct {
+	system, attribute, dataType string
+}{
+	{sqs.MessageSystemAttributeNameSentTimestamp, SentTimestampAttribute, "Number"},
+	{sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp, FirstReceiveTimestampAttribute, "Number"},
+	// Sequence numbers have more digits than consumers parse as numbers.
+	{sqs.MessageSystemAttributeNameSequenceNumber, SequenceNumberAttribute, "String"},
+}
+
+// PreservedSystemAttributes are the system attributes TimelinePreserver
+// needs to be received.
+func PreservedSystemAttributes() []string {
+	names := make([]string, len(preservedAttributes))
+	for i, preserved := range preservedAttributes {
+		names[i] = preserved.system
+	}
+	return names
+}
+
+// TimelinePreserver returns a hook copying the SentTimestamp,
+// ApproximateFirstReceiveTimestamp and, of FIFO messages, SequenceNumber
+// into message attributes, so the original timeline survives the move.
+// Attributes a previous move set are kept. Messages without room for them
+// are skipped.
+func TimelinePreserver() MessageHook {
+	return func(message *sqs.Message) string {
+		added := map[string]*sqs.MessageAttributeValue{}
+		var names []string
+
+		for _, preserved := range preservedAttributes {
+			value, ok := message.Attributes[preserved.system]
+			if _, exists := message.MessageAttributes[preserved.attribute]; exists || !ok {
+				continue
+			}
+
+			added[preserved.attribute] = &sqs.MessageAttributeValue{
+				DataType:    aws.String(preserved.dataType),
+				StringValue: aws.String(aws.StringValue(value)),
+			}
+			names = append(names, preserved.attribute)
+		}
+
+		if len(message.MessageAttributes)+len(added) > maxMessageAttributes {
+			return fmt.Sprintf("has %d message attributes, no room for %s", len(message.MessageAttributes), strings.Join(names, " and "))
+		}
+
+		if len(added) > 0 && message.MessageAttributes == nil {
+			message.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		}
+
+		for name, value := range added {
+			message.MessageAttributes[name] = value
+		}
+
+		return ""
+	}
+}
@@ -0,0 +1,26 @@
+package rtksqs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MaxDelaySeconds is the longest delay SQS accepts for a message.
+const MaxDelaySeconds = 900
+
+// DeduplicationRegenerator returns a hook giving FIFO messages a new
+// deduplication ID, made of the run ID and the message ID. A message sent back
+// to the FIFO queue it was received from would otherwise be dropped as a
+// duplicate of itself within the 5 minute deduplication interval. Resends of
+// the same message in the run keep the same ID, so they are still deduplicated.
+func DeduplicationRegenerator(runID string) MessageHook {
+	return func(message *sqs.Message) string {
+		if _, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; !ok {
+			return ""
+		}
+
+		message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId] = aws.String(runID + "-" + aws.StringValue(message.MessageId))
+
+		return ""
+	}
+}
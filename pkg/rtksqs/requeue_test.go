@@ -0,0 +1,116 @@
+package rtksqs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+func TestRequeue(t *testing.T) {
+	large := []string{strings.Repeat("a", 100*1024), strings.Repeat("b", 100*1024), strings.Repeat("c", 100*1024)}
+
+	tests := []struct {
+		name   string
+		bodies []string
+	}{
+		{name: "batch", bodies: []string{"a", "b", "c"}},
+		{name: "single fallback", bodies: large},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := fakesqs.New()
+			now := time.Now()
+			fake.SetClock(func() time.Time { return now })
+
+			var messages []*sqs.SendMessageInput
+			for _, body := range test.bodies {
+				messages = append(messages, &sqs.SendMessageInput{MessageBody: aws.String(body)})
+			}
+			url := fillFakeQueue(t, fake, "queue", messages...)
+
+			options := Options{SQS: fake, DelaySeconds: 60}
+			source, err := OpenSource(nil, "queue", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink, err := OpenSink(nil, "queue", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			issues, err := ValidateRedrive(source, sink)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != 1 || !issues[0].SameQueue {
+				t.Errorf("got issues %v, want the queue to be both source and destination", issues)
+			}
+
+			// The requeued messages are delayed, so the move doesn't receive
+			// them again.
+			moved, err := Move(source, sink, UnknownCount, MoveOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved != len(test.bodies) {
+				t.Errorf("moved %d messages, want %d", moved, len(test.bodies))
+			}
+
+			if got := fake.Bodies(url); !reflect.DeepEqual(sorted(got), test.bodies) {
+				t.Errorf("the queue holds %d messages, want each once", len(got))
+			}
+
+			now = now.Add(59 * time.Second)
+			if got := receiveFakeQueue(t, fake, url); len(got) != 0 {
+				t.Errorf("received %d messages before the delay passed", len(got))
+			}
+
+			now = now.Add(time.Second)
+			if got := receiveFakeQueue(t, fake, url); len(got) != len(test.bodies) {
+				t.Errorf("received %d messages once the delay passed, want %d", len(got), len(test.bodies))
+			}
+		})
+	}
+}
+
+func TestOpenSinkDelayFifo(t *testing.T) {
+	fake := fakesqs.New()
+	if _, err := fake.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String("queue.fifo"),
+		Attributes: map[string]*string{sqs.QueueAttributeNameFifoQueue: aws.String("true")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSink(nil, "queue.fifo", Options{SQS: fake, DelaySeconds: 60}); err == nil {
+		t.Error("opened a FIFO queue delaying single messages")
+	}
+}
+
+func TestDeduplicationRegenerator(t *testing.T) {
+	fifo := &sqs.Message{MessageId: aws.String("m0"), Attributes: map[string]*string{
+		sqs.MessageSystemAttributeNameMessageGroupId:         aws.String("group"),
+		sqs.MessageSystemAttributeNameMessageDeduplicationId: aws.String("original"),
+	}}
+	standard := &sqs.Message{MessageId: aws.String("m1"), Attributes: map[string]*string{}}
+
+	hook := DeduplicationRegenerator("run")
+	for _, message := range []*sqs.Message{fifo, standard, fifo} {
+		if reason := hook(message); reason != "" {
+			t.Errorf("skipped %s: %s", aws.StringValue(message.MessageId), reason)
+		}
+	}
+
+	if got := aws.StringValue(fifo.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]); got != "run-m0" {
+		t.Errorf("got deduplication ID %q, want run-m0", got)
+	}
+	if _, ok := standard.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]; ok {
+		t.Error("gave a standard message a deduplication ID")
+	}
+}
@@ -0,0 +1,126 @@
+package rtksqs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ReplaysArchive reports whether the messages of a source were archived, in
+// a dump, archive or on stdin, instead of waiting in a queue.
+func ReplaysArchive(source Source) bool {
+	_, ok := source.(*queueSource)
+	return !ok
+}
+
+// SendsToQueue reports whether a sink sends to an SQS queue.
+func SendsToQueue(sink Sink) bool {
+	_, ok := sink.(*queueSink)
+	return ok
+}
+
+// QueueRetention returns the message retention period of the queue a sink
+// sends to, and reports false for other sinks.
+func QueueRetention(sink Sink) (time.Duration, bool, error) {
+	queue, ok := sink.(*queueSink)
+	if !ok {
+		return 0, false, nil
+	}
+
+	resp, err := queue.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queue.url),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameMessageRetentionPeriod}),
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	seconds, err := strconv.Atoi(aws.StringValue(resp.Attributes[sqs.QueueAttributeNameMessageRetentionPeriod]))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid retention period of %s: %s", queue.url, err)
+	}
+
+	return time.Duration(seconds) * time.Second, true, nil
+}
+
+// messageAge returns how long ago a message was sent, and reports false when
+// it has no SentTimestamp.
+func messageAge(message *sqs.Message) (time.Duration, bool) {
+	sent, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Since(time.Unix(0, sent*int64(time.Millisecond))), true
+}
+
+// MaxAgeHook returns a hook skipping messages sent longer ago than age, e.g.
+// archived messages the consumer would consider expired. Messages without a
+// SentTimestamp are moved.
+func MaxAgeHook(age time.Duration) MessageHook {
+	return func(message *sqs.Message) string {
+		if sent, ok := messageAge(message); ok && sent > age {
+			return fmt.Sprintf("was sent %s ago, longer ago than %s", sent.Round(time.Second), age)
+		}
+		return ""
+	}
+}
+
+// RetentionHook returns a hook calling stale for every message sent longer
+// ago than the retention period of the destination. The destination keeps
+// them, they are sent anew, but consumers comparing their age with the
+// retention period may drop them. It never skips messages.
+func RetentionHook(retention time.Duration, stale func(message *sqs.Message, age time.Duration)) MessageHook {
+	return func(message *sqs.Message) string {
+		if sent, ok := messageAge(message); ok && sent > retention {
+			stale(message, sent)
+		}
+		return ""
+	}
+}
+
+// TimestampRewrite returns a hook setting the message attribute name, a
+// timestamp, to the time of the move, keeping its format: Unix milliseconds,
+// Unix seconds or RFC 3339. Replayed messages then don't look stale to
+// consumers. Messages without the attribute, or with another format, are
+// moved unchanged.
+func TimestampRewrite(name string) MessageHook {
+	return func(message *sqs.Message) string {
+		attribute, ok := message.MessageAttributes[name]
+		if !ok || attribute.StringValue == nil {
+			return ""
+		}
+
+		value, now := *attribute.StringValue, time.Now()
+		rewritten := ""
+
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			// Unix seconds have 10 digits until 2286, milliseconds 13.
+			if len(strings.TrimPrefix(value, "-")) > 11 {
+				rewritten = strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+			} else {
+				rewritten = strconv.FormatInt(now.Unix(), 10)
+			}
+		} else if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			layout := time.RFC3339
+			if strings.Contains(value, ".") {
+				layout = time.RFC3339Nano
+			}
+			rewritten = now.UTC().Format(layout)
+		}
+
+		if rewritten != "" {
+			message.MessageAttributes[name] = &sqs.MessageAttributeValue{
+				DataType:    attribute.DataType,
+				StringValue: aws.String(rewritten),
+			}
+		}
+
+		return ""
+	}
+}
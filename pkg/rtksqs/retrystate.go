@@ -0,0 +1,163 @@
+package rtksqs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/fatih/color"
+)
+
+// SendRetry is the state of failed sends to a destination.
+type SendRetry struct {
+	// Failures is the number of failed sends in a row.
+	Failures int `json:"failures"`
+	// LastError is the error of the last failed send.
+	LastError string `json:"lastError"`
+	// FailedAt is when the last send failed.
+	FailedAt time.Time `json:"failedAt"`
+	// RetryAt is when moves may send to the destination again.
+	RetryAt time.Time `json:"retryAt"`
+}
+
+// RetryState keeps the failed sends of moves to every destination in a file,
+// so a process restarted after a crash, e.g. a crash-looping container, backs
+// off as long as it would have instead of sending to a failing destination
+// right away. Every failed send in a row doubles the backoff, starting at the
+// BreakerBackoff of the move, up to 5 minutes. A successful send forgets the
+// failures. It is safe for concurrent use by many moves, a nil state keeps
+// nothing.
+type RetryState struct {
+	mu           sync.Mutex
+	path         string
+	Destinations map[string]*SendRetry `json:"destinations"`
+}
+
+// OpenRetryState reads the retry state at path, which is created once a send
+// failed.
+func OpenRetryState(path string) (*RetryState, error) {
+	state := &RetryState{path: path, Destinations: map[string]*SendRetry{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Destinations == nil {
+		state.Destinations = map[string]*SendRetry{}
+	}
+
+	return state, nil
+}
+
+// wait blocks until moves may send to the destination again, or ctx is done.
+func (s *RetryState) wait(ctx context.Context, destination string) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	retry, ok := s.Destinations[destination]
+	var until time.Duration
+	if ok {
+		until = time.Until(retry.RetryAt)
+	}
+	s.mu.Unlock()
+
+	if until <= 0 {
+		return nil
+	}
+
+	log.Warn(color.New(color.FgYellow).Sprintf("Sending to %s failed %d times in a row, the last at %s, waiting %s before sending again: %s",
+		destination, retry.Failures, retry.FailedAt.Format(time.RFC3339), until.Round(time.Second), retry.LastError))
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	timer := time.NewTimer(until)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+// failed records a failed send to the destination and when it may be tried
+// again, backing off from backoff.
+func (s *RetryState) failed(destination string, err error, backoff time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retry, ok := s.Destinations[destination]
+	if !ok {
+		retry = &SendRetry{}
+		s.Destinations[destination] = retry
+	}
+
+	pause := maxBreakerBackoff
+	if retry.Failures < 16 && backoff<<retry.Failures < maxBreakerBackoff {
+		pause = backoff << retry.Failures
+	}
+
+	retry.Failures++
+	retry.LastError = err.Error()
+	retry.FailedAt = time.Now().UTC()
+	retry.RetryAt = retry.FailedAt.Add(pause)
+
+	s.save()
+}
+
+// succeeded forgets the failed sends to the destination.
+func (s *RetryState) succeeded(destination string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Destinations[destination]; !ok {
+		return
+	}
+
+	delete(s.Destinations, destination)
+	s.save()
+}
+
+// save replaces the file by the state, a failed write is logged and the
+// state kept in memory.
+func (s *RetryState) save() {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		// A complete file is renamed over the old one, so a crash can't
+		// leave it torn.
+		tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+
+	if err != nil {
+		log.Warn(color.New(color.FgYellow).Sprintf("Failed to write the retry state to %s, it is lost on restart: %s", s.path, err))
+	}
+}
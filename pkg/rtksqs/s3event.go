@@ -0,0 +1,155 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// s3EventRecord is a record of an S3 event notification, the object key is
+// URL encoded.
+type s3EventRecord struct {
+	EventSource string `json:"eventSource"`
+	EventName   string `json:"eventName"`
+	S3          struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// s3Location is a bucket and an object key or key prefix.
+type s3Location struct {
+	bucket string
+	key    string
+}
+
+func (l s3Location) String() string {
+	return s3Scheme + l.bucket + "/" + l.key
+}
+
+// S3EventHook returns a hook for S3 event notifications, as S3 sends them to
+// queues, dropping the records of objects outside the s3://bucket/prefix URLs
+// of prefixes, any object without prefixes. With svc, it drops the records of
+// created objects which no longer exist too, checked with HeadObject, so
+// consumers aren't sent events of objects deleted since. Messages left
+// without records are skipped, as are messages which aren't S3 event
+// notifications, e.g. the s3:TestEvent S3 sends when notifications are set
+// up, and messages whose objects couldn't be checked.
+func S3EventHook(prefixes []string, svc s3iface.S3API) (MessageHook, error) {
+	var locations []s3Location
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, s3Scheme) {
+			return nil, fmt.Errorf("%s is no %sbucket/prefix URL", prefix, s3Scheme)
+		}
+
+		// Unlike parseS3URL, the prefix keeps its slashes, s3://bucket/logs/
+		// doesn't match logs2/.
+		location := s3Location{bucket: strings.TrimPrefix(prefix, s3Scheme)}
+		if i := strings.Index(location.bucket, "/"); i >= 0 {
+			location.bucket, location.key = location.bucket[:i], location.bucket[i+1:]
+		}
+
+		if location.bucket == "" {
+			return nil, fmt.Errorf("%s names no bucket", prefix)
+		}
+		locations = append(locations, location)
+	}
+
+	return func(message *sqs.Message) string {
+		var event map[string]json.RawMessage
+		var records []json.RawMessage
+
+		if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &event); err != nil || json.Unmarshal(event["Records"], &records) != nil || len(records) == 0 {
+			return "is not an S3 event notification"
+		}
+
+		var kept []json.RawMessage
+		var reason string
+
+		for _, raw := range records {
+			var record s3EventRecord
+			if err := json.Unmarshal(raw, &record); err != nil || record.EventSource != "aws:s3" {
+				return "is not an S3 event notification"
+			}
+
+			key, err := url.QueryUnescape(record.S3.Object.Key)
+			if err != nil {
+				return fmt.Sprintf("has an S3 object key %s which isn't URL encoded", record.S3.Object.Key)
+			}
+			object := s3Location{bucket: record.S3.Bucket.Name, key: key}
+
+			if !withinPrefixes(object, locations) {
+				reason = fmt.Sprintf("is an event of %s outside the prefixes", object)
+				continue
+			}
+
+			// Removed objects are gone by design, only created ones are
+			// expected to exist.
+			if svc != nil && strings.HasPrefix(record.EventName, "ObjectCreated:") {
+				exists, err := objectExists(svc, object)
+				if err != nil {
+					return fmt.Sprintf("is an event of %s which couldn't be checked: %s", object, err)
+				}
+				if !exists {
+					reason = fmt.Sprintf("is an event of %s which no longer exists", object)
+					continue
+				}
+			}
+
+			kept = append(kept, raw)
+		}
+
+		if len(kept) == 0 {
+			return reason
+		}
+
+		if len(kept) < len(records) {
+			event["Records"], _ = json.Marshal(kept)
+			body, err := json.Marshal(event)
+			if err != nil {
+				return "can not be rewritten: " + err.Error()
+			}
+			setBody(message, string(body))
+		}
+
+		return ""
+	}, nil
+}
+
+// withinPrefixes reports whether an object is below one of the prefixes, or
+// there are none.
+func withinPrefixes(object s3Location, prefixes []s3Location) bool {
+	for _, prefix := range prefixes {
+		if object.bucket == prefix.bucket && strings.HasPrefix(object.key, prefix.key) {
+			return true
+		}
+	}
+	return len(prefixes) == 0
+}
+
+// objectExists reports whether an object exists.
+func objectExists(svc s3iface.S3API, object s3Location) (bool, error) {
+	_, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(object.bucket), Key: aws.String(object.key)})
+	if err == nil {
+		return true, nil
+	}
+
+	// HeadObject responses have no body, missing objects only show in the
+	// status code.
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return false, err
+}
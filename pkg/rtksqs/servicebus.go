@@ -0,0 +1,163 @@
+package rtksqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const servicebusScheme = "servicebus://"
+
+// servicebusConnectionStringEnv takes precedence over Azure AD credentials.
+const servicebusConnectionStringEnv = "AZURE_SERVICEBUS_CONNECTION_STRING"
+
+const servicebusDomain = ".servicebus.windows.net"
+
+// servicebusSink sends messages to an Azure Service Bus queue or topic.
+type servicebusSink struct {
+	client *azservicebus.Client
+	sender *azservicebus.Sender
+	name   string
+}
+
+// openServicebusSink opens servicebus://<namespace>/<queue-or-topic>. The
+// namespace may be given without the .servicebus.windows.net suffix.
+func openServicebusSink(spec string) (*servicebusSink, error) {
+	parts := strings.SplitN(strings.TrimPrefix(spec, servicebusScheme), "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("service bus destination must be %s<namespace>/<queue-or-topic>", servicebusScheme)
+	}
+
+	namespace, entity := parts[0], parts[1]
+
+	if !strings.Contains(namespace, ".") {
+		namespace += servicebusDomain
+	}
+
+	var client *azservicebus.Client
+	var err error
+
+	if connectionString := os.Getenv(servicebusConnectionStringEnv); connectionString != "" {
+		client, err = azservicebus.NewClientFromConnectionString(connectionString, nil)
+	} else {
+		var credential *azidentity.DefaultAzureCredential
+		credential, err = azidentity.NewDefaultAzureCredential(nil)
+		if err == nil {
+			client, err = azservicebus.NewClient(namespace, credential, nil)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := client.NewSender(entity, nil)
+
+	if err != nil {
+		client.Close(context.Background())
+		return nil, err
+	}
+
+	return &servicebusSink{client: client, sender: sender, name: spec}, nil
+}
+
+func (s *servicebusSink) String() string {
+	return s.name
+}
+
+// Send sends the messages in as few Service Bus batches as their size allows.
+func (s *servicebusSink) Send(messages []*sqs.Message) error {
+	ctx := context.Background()
+	batch, err := s.sender.NewMessageBatch(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	// batchStart is the first message of the batch being filled, messages
+	// before it were sent.
+	batchStart := 0
+
+	for i, message := range messages {
+		converted := convertToServicebusMessage(message)
+		err := batch.AddMessage(converted, nil)
+
+		if errors.Is(err, azservicebus.ErrMessageTooLarge) && batch.NumMessages() > 0 {
+			if err := s.sender.SendMessageBatch(ctx, batch, nil); err != nil {
+				return unsentServicebusMessages(messages[batchStart:], "SendFailed", err)
+			}
+			batchStart = i
+
+			if batch, err = s.sender.NewMessageBatch(ctx, nil); err != nil {
+				return unsentServicebusMessages(messages[batchStart:], "SendFailed", err)
+			}
+
+			err = batch.AddMessage(converted, nil)
+		}
+
+		if err != nil {
+			return unsentServicebusMessages(messages[batchStart:], "MessageRejected", fmt.Errorf("message %s: %w", aws.StringValue(message.MessageId), err))
+		}
+	}
+
+	if err := s.sender.SendMessageBatch(ctx, batch, nil); err != nil {
+		return unsentServicebusMessages(messages[batchStart:], "SendFailed", err)
+	}
+
+	return nil
+}
+
+// unsentServicebusMessages reports every message of a batch which was not sent.
+func unsentServicebusMessages(messages []*sqs.Message, code string, err error) error {
+	failures := make([]BatchFailure, len(messages))
+	for i, message := range messages {
+		failures[i] = BatchFailure{ID: aws.StringValue(message.MessageId), Code: code, Message: err.Error(), message: message}
+	}
+	return &BatchError{Operation: "send", Failures: failures}
+}
+
+func (s *servicebusSink) Close() error {
+	ctx := context.Background()
+	s.sender.Close(ctx)
+	return s.client.Close(ctx)
+}
+
+// convertToServicebusMessage maps message attributes to application
+// properties, the FIFO message group to the session and the deduplication ID,
+// or the source message ID, to the Service Bus message ID used by duplicate
+// detection.
+func convertToServicebusMessage(message *sqs.Message) *azservicebus.Message {
+	result := &azservicebus.Message{
+		Body:      []byte(aws.StringValue(message.Body)),
+		MessageID: message.MessageId,
+	}
+
+	if groupID, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		result.SessionID = groupID
+	}
+
+	if deduplicationID, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]; ok {
+		result.MessageID = deduplicationID
+	}
+
+	if len(message.MessageAttributes) > 0 {
+		result.ApplicationProperties = make(map[string]interface{}, len(message.MessageAttributes))
+		for name, value := range message.MessageAttributes {
+			if value.BinaryValue != nil {
+				result.ApplicationProperties[name] = value.BinaryValue
+			} else {
+				result.ApplicationProperties[name] = aws.StringValue(value.StringValue)
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,161 @@
+package rtksqs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MaxDumpShards is the most shards a file:// dump is written in.
+const MaxDumpShards = 99
+
+// shardedDumpSink writes a file:// dump to several shard files at once,
+// dump-s01.ndjson, dump-s02.ndjson and so on, each split into parts like an
+// unsharded dump. Every batch is spread over the shards, which are written
+// concurrently, so compressing and encrypting use as many cores as there are
+// shards. Messages of a FIFO message group go to the same shard, which keeps
+// their order. The shards share one manifest, the index listing every shard
+// file in order, which file:// sources read them by.
+type shardedDumpSink struct {
+	name   string
+	path   string
+	runID  string
+	shards []*ndjsonSink
+
+	mu   sync.Mutex
+	next int
+}
+
+// shardPath returns the path of a shard, dump-s01.ndjson for the first shard
+// of dump.ndjson.
+func shardPath(path string, shard int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-s%02d%s", strings.TrimSuffix(path, ext), shard, ext)
+}
+
+func openShardedDumpSink(sess *session.Session, spec string, options Options) (*shardedDumpSink, error) {
+	if options.DumpShards > MaxDumpShards {
+		return nil, fmt.Errorf("a dump can be written in at most %d shards", MaxDumpShards)
+	}
+
+	decoder, err := parseBodyDecoder(options.Decode)
+	if err != nil {
+		return nil, err
+	}
+
+	dump, err := options.dumpOptions(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.TrimPrefix(spec, fileScheme)
+	sink := &shardedDumpSink{name: spec, path: path, runID: options.RunID}
+
+	for shard := 1; shard <= options.DumpShards; shard++ {
+		writer, err := openNdjsonFileSink(fileScheme+shardPath(path, shard), dump)
+		if err != nil {
+			sink.closeShards()
+			return nil, err
+		}
+
+		writer.decoder = decoder
+		writer.redact = options.Redact
+		sink.shards = append(sink.shards, writer)
+	}
+
+	return sink, nil
+}
+
+func (s *shardedDumpSink) String() string {
+	return s.name
+}
+
+// shard returns the shard of a message, by its message group or else in turn.
+func (s *shardedDumpSink) shard(message *sqs.Message) int {
+	if group, ok := message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		h := fnv.New32a()
+		h.Write([]byte(aws.StringValue(group)))
+		return int(h.Sum32() % uint32(len(s.shards)))
+	}
+
+	shard := s.next
+	s.next = (s.next + 1) % len(s.shards)
+	return shard
+}
+
+func (s *shardedDumpSink) Send(messages []*sqs.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := make([][]*sqs.Message, len(s.shards))
+	for _, message := range messages {
+		shard := s.shard(message)
+		batches[shard] = append(batches[shard], message)
+	}
+
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+
+	for shard, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(shard int, batch []*sqs.Message) {
+			defer wg.Done()
+			errs[shard] = s.shards[shard].Send(batch)
+		}(shard, batch)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeShards finishes every shard and returns their manifests.
+func (s *shardedDumpSink) closeShards() ([]*dumpManifest, error) {
+	var manifests []*dumpManifest
+	var first error
+
+	for _, shard := range s.shards {
+		manifest, err := shard.dump.finish()
+		if err != nil && first == nil {
+			first = err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	return manifests, first
+}
+
+// Close finishes the shards and writes the manifest of the dump, listing the
+// files of every shard in order.
+func (s *shardedDumpSink) Close() error {
+	manifests, err := s.closeShards()
+	if err != nil {
+		return err
+	}
+
+	index := &dumpManifest{CreatedAt: time.Now().UTC(), RunID: s.runID, Shards: len(s.shards)}
+	for _, manifest := range manifests {
+		index.MessageCount += manifest.MessageCount
+		index.BodyBytes += manifest.BodyBytes
+		index.Files = append(index.Files, manifest.Files...)
+	}
+
+	return writeManifest(manifestPath(s.path), index)
+}
@@ -0,0 +1,135 @@
+package rtksqs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// SNS formats of message bodies.
+const (
+	SnsFormatNone   = "none"
+	SnsFormatUnwrap = "unwrap"
+	SnsFormatWrap   = "wrap"
+)
+
+// snsEnvelope is the JSON body SNS delivers to queues subscribed without raw
+// message delivery, wrapping the published message and its attributes.
+type snsEnvelope struct {
+	Type              string                  `json:"Type"`
+	MessageID         string                  `json:"MessageId"`
+	TopicArn          string                  `json:"TopicArn,omitempty"`
+	Subject           string                  `json:"Subject,omitempty"`
+	Message           *string                 `json:"Message"`
+	Timestamp         string                  `json:"Timestamp"`
+	MessageAttributes map[string]snsAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// snsAttribute is a message attribute in an SNS envelope, binary values are
+// base64 encoded.
+type snsAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// SnsUnwrap returns a hook replacing SNS envelopes by the published message,
+// with the attributes of the envelope as message attributes, as SNS delivers
+// to queues subscribed with raw message delivery. Messages which aren't SNS
+// notifications are skipped.
+func SnsUnwrap() MessageHook {
+	return func(message *sqs.Message) string {
+		var envelope snsEnvelope
+
+		if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &envelope); err != nil || envelope.Type != "Notification" || envelope.Message == nil {
+			return "is not an SNS notification"
+		}
+
+		attributes := make(map[string]*sqs.MessageAttributeValue, len(message.MessageAttributes)+len(envelope.MessageAttributes))
+		for name, value := range message.MessageAttributes {
+			attributes[name] = value
+		}
+
+		for name, attribute := range envelope.MessageAttributes {
+			value := &sqs.MessageAttributeValue{DataType: aws.String(attribute.Type)}
+
+			if strings.HasPrefix(attribute.Type, "Binary") {
+				decoded, err := base64.StdEncoding.DecodeString(attribute.Value)
+				if err != nil {
+					return "has an SNS message attribute " + name + " which isn't base64"
+				}
+				value.BinaryValue = decoded
+			} else {
+				value.StringValue = aws.String(attribute.Value)
+			}
+
+			attributes[name] = value
+		}
+
+		if len(attributes) > 0 {
+			message.MessageAttributes = attributes
+		}
+
+		setBody(message, *envelope.Message)
+		return ""
+	}
+}
+
+// SnsWrap returns a hook wrapping messages into SNS envelopes published to
+// topicArn, as SNS delivers to queues subscribed without raw message
+// delivery. The message attributes move into the envelope, the message ID
+// and sent timestamp of the message are kept. Envelopes aren't signed.
+func SnsWrap(topicArn string) MessageHook {
+	return func(message *sqs.Message) string {
+		body := aws.StringValue(message.Body)
+
+		timestamp := time.Now().UTC()
+		if sent, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64); err == nil {
+			timestamp = time.Unix(0, sent*int64(time.Millisecond)).UTC()
+		}
+
+		envelope := snsEnvelope{
+			Type:      "Notification",
+			MessageID: aws.StringValue(message.MessageId),
+			TopicArn:  topicArn,
+			Message:   &body,
+			Timestamp: timestamp.Format("2006-01-02T15:04:05.000Z"),
+		}
+
+		if len(message.MessageAttributes) > 0 {
+			envelope.MessageAttributes = make(map[string]snsAttribute, len(message.MessageAttributes))
+		}
+
+		for name, value := range message.MessageAttributes {
+			dataType := aws.StringValue(value.DataType)
+
+			// SNS only knows these types, custom types of SQS lose their
+			// suffix.
+			switch {
+			case dataType == "String.Array":
+			case strings.HasPrefix(dataType, "Binary"):
+				envelope.MessageAttributes[name] = snsAttribute{Type: "Binary", Value: base64.StdEncoding.EncodeToString(value.BinaryValue)}
+				continue
+			case strings.HasPrefix(dataType, "Number"):
+				dataType = "Number"
+			default:
+				dataType = "String"
+			}
+
+			envelope.MessageAttributes[name] = snsAttribute{Type: dataType, Value: aws.StringValue(value.StringValue)}
+		}
+
+		wrapped, err := json.Marshal(envelope)
+		if err != nil {
+			return "can not be wrapped: " + err.Error()
+		}
+
+		message.MessageAttributes = nil
+		setBody(message, string(wrapped))
+		return ""
+	}
+}
@@ -0,0 +1,338 @@
+package rtksqs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteScheme = "sqlite://"
+
+// The archive keeps one row per message plus one row per message attribute,
+// so archived messages can be analysed with plain SQL before replaying them.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id                       INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id               TEXT NOT NULL,
+	body                     TEXT NOT NULL,
+	md5_of_body              TEXT,
+	message_group_id         TEXT,
+	message_deduplication_id TEXT,
+	sent_timestamp           INTEGER,
+	archived_at              INTEGER NOT NULL,
+	attributes               TEXT
+);
+CREATE INDEX IF NOT EXISTS messages_message_id ON messages (message_id);
+CREATE INDEX IF NOT EXISTS messages_sent_timestamp ON messages (sent_timestamp);
+CREATE INDEX IF NOT EXISTS messages_archived_at ON messages (archived_at);
+CREATE TABLE IF NOT EXISTS message_attributes (
+	message_rowid INTEGER NOT NULL,
+	name          TEXT NOT NULL,
+	data_type     TEXT NOT NULL,
+	string_value  TEXT,
+	binary_value  BLOB,
+	PRIMARY KEY (message_rowid, name)
+);
+CREATE INDEX IF NOT EXISTS message_attributes_name_value ON message_attributes (name, string_value);
+`
+
+type sqliteArchive struct {
+	db    *sql.DB
+	path  string
+	where string
+	// lastID is the highest row handed out by Receive, so rows that are
+	// received but not deleted are not returned again.
+	lastID int64
+	// redact masks their matches in the archived messages.
+	redact []*regexp.Regexp
+}
+
+// parseSqliteSpec splits sqlite://path?where=... into the database path and
+// an optional SQL filter.
+func parseSqliteSpec(spec string) (string, string, error) {
+	path := strings.TrimPrefix(spec, sqliteScheme)
+	where := ""
+
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, err := url.ParseQuery(path[i+1:])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid sqlite options %q: %s", path[i+1:], err)
+		}
+		where = query.Get("where")
+		path = path[:i]
+	}
+
+	if path == "" {
+		return "", "", fmt.Errorf("missing database path in %q", spec)
+	}
+
+	return path, where, nil
+}
+
+func openSqliteArchive(spec string) (*sqliteArchive, error) {
+	path, where, err := parseSqliteSpec(spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialise %s: %s", path, err)
+	}
+
+	return &sqliteArchive{db: db, path: path, where: where}, nil
+}
+
+func openSqliteSource(spec string) (*sqliteArchive, error) {
+	return openSqliteArchive(spec)
+}
+
+func openSqliteSink(spec string) (*sqliteArchive, error) {
+	archive, err := openSqliteArchive(spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if archive.where != "" {
+		archive.Close()
+		return nil, fmt.Errorf("where is only supported when reading from %s", archive.path)
+	}
+
+	return archive, nil
+}
+
+func (a *sqliteArchive) String() string {
+	if a.where != "" {
+		return fmt.Sprintf("%s%s (where %s)", sqliteScheme, a.path, a.where)
+	}
+	return sqliteScheme + a.path
+}
+
+func (a *sqliteArchive) filter() string {
+	if a.where == "" {
+		return "1 = 1"
+	}
+	return "(" + a.where + ")"
+}
+
+func (a *sqliteArchive) ApproximateCount() (int, error) {
+	var count int
+	err := a.db.QueryRow("SELECT COUNT(*) FROM messages WHERE " + a.filter()).Scan(&count)
+	return count, err
+}
+
+func (a *sqliteArchive) Receive(max int64) ([]*sqs.Message, error) {
+	rows, err := a.db.Query(
+		"SELECT id, message_id, body, md5_of_body, attributes FROM messages WHERE "+a.filter()+" AND id > ? ORDER BY id LIMIT ?",
+		a.lastID, max)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*sqs.Message
+	byRowID := map[int64]*sqs.Message{}
+
+	for rows.Next() {
+		var id int64
+		var messageID, body string
+		var md5OfBody, attributes sql.NullString
+
+		if err := rows.Scan(&id, &messageID, &body, &md5OfBody, &attributes); err != nil {
+			return nil, err
+		}
+
+		message := &sqs.Message{
+			MessageId:     aws.String(messageID),
+			Body:          aws.String(body),
+			ReceiptHandle: aws.String(strconv.FormatInt(id, 10)),
+		}
+
+		if md5OfBody.Valid {
+			message.MD5OfBody = aws.String(md5OfBody.String)
+		}
+
+		if attributes.Valid && attributes.String != "" {
+			if err := json.Unmarshal([]byte(attributes.String), &message.Attributes); err != nil {
+				return nil, fmt.Errorf("corrupt attributes for row %d: %s", id, err)
+			}
+		}
+
+		messages = append(messages, message)
+		byRowID[id] = message
+		a.lastID = id
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	if err := a.loadMessageAttributes(byRowID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (a *sqliteArchive) loadMessageAttributes(byRowID map[int64]*sqs.Message) error {
+	ids := make([]string, 0, len(byRowID))
+	for id := range byRowID {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+
+	rows, err := a.db.Query(
+		"SELECT message_rowid, name, data_type, string_value, binary_value FROM message_attributes WHERE message_rowid IN (" + strings.Join(ids, ",") + ")")
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowID int64
+		var name, dataType string
+		var stringValue sql.NullString
+		var binaryValue []byte
+
+		if err := rows.Scan(&rowID, &name, &dataType, &stringValue, &binaryValue); err != nil {
+			return err
+		}
+
+		value := &sqs.MessageAttributeValue{DataType: aws.String(dataType)}
+		if stringValue.Valid {
+			value.StringValue = aws.String(stringValue.String)
+		}
+		if binaryValue != nil {
+			value.BinaryValue = binaryValue
+		}
+
+		message := byRowID[rowID]
+		if message.MessageAttributes == nil {
+			message.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		}
+		message.MessageAttributes[name] = value
+	}
+
+	return rows.Err()
+}
+
+func (a *sqliteArchive) Delete(messages []*sqs.Message) error {
+	tx, err := a.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		id := aws.StringValue(message.ReceiptHandle)
+
+		if _, err := tx.Exec("DELETE FROM message_attributes WHERE message_rowid = ?", id); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM messages WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (a *sqliteArchive) Send(messages []*sqs.Message) error {
+	tx, err := a.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	archivedAt := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for _, message := range messages {
+		if err := insertSqliteMessage(tx, redactMessage(a.redact, message), archivedAt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertSqliteMessage(tx *sql.Tx, message *sqs.Message, archivedAt int64) error {
+	var attributes interface{}
+	if len(message.Attributes) > 0 {
+		encoded, err := json.Marshal(message.Attributes)
+		if err != nil {
+			return err
+		}
+		attributes = string(encoded)
+	}
+
+	var sentTimestamp interface{}
+	if value, ok := message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]; ok {
+		if parsed, err := strconv.ParseInt(aws.StringValue(value), 10, 64); err == nil {
+			sentTimestamp = parsed
+		}
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO messages (message_id, body, md5_of_body, message_group_id, message_deduplication_id, sent_timestamp, archived_at, attributes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		aws.StringValue(message.MessageId),
+		aws.StringValue(message.Body),
+		message.MD5OfBody,
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId],
+		message.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId],
+		sentTimestamp,
+		archivedAt,
+		attributes)
+
+	if err != nil {
+		return err
+	}
+
+	rowID, err := result.LastInsertId()
+
+	if err != nil {
+		return err
+	}
+
+	for name, value := range message.MessageAttributes {
+		_, err := tx.Exec(
+			"INSERT INTO message_attributes (message_rowid, name, data_type, string_value, binary_value) VALUES (?, ?, ?, ?, ?)",
+			rowID, name, aws.StringValue(value.DataType), value.StringValue, value.BinaryValue)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *sqliteArchive) Close() error {
+	return a.db.Close()
+}
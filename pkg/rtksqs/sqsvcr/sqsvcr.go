@@ -0,0 +1,386 @@
+// Package sqsvcr records the SQS calls of a move with their responses to a
+// fixture and replays them, so a failure seen against real queues can be
+// reproduced without them, e.g. in a regression test.
+//
+// Fixtures are NDJSON, an Interaction per line. Replaying answers every call
+// with the first unanswered recorded call of the operation with an equal
+// input, or else the first unanswered one of the operation, since some
+// inputs, e.g. deduplication IDs derived from a run ID, differ between runs.
+// Operations that are not recorded panic when replayed.
+package sqsvcr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Interaction is a recorded call: its operation, input and the output or
+// error it returned.
+type Interaction struct {
+	Operation string          `json:"operation"`
+	Input     json.RawMessage `json:"input"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	Error     *Error          `json:"error,omitempty"`
+}
+
+// Error is a recorded error, an awserr.Error or awserr.RequestFailure when
+// StatusCode is set.
+type Error struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	RequestID  string `json:"requestId,omitempty"`
+}
+
+func newError(err error) *Error {
+	recorded := &Error{Code: "Unknown", Message: err.Error()}
+
+	if awsErr, ok := err.(awserr.Error); ok {
+		recorded.Code, recorded.Message = awsErr.Code(), awsErr.Message()
+	}
+
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		recorded.StatusCode, recorded.RequestID = failure.StatusCode(), failure.RequestID()
+	}
+
+	return recorded
+}
+
+func (e *Error) err() error {
+	err := awserr.New(e.Code, e.Message, nil)
+	if e.StatusCode > 0 {
+		return awserr.NewRequestFailure(err, e.StatusCode, e.RequestID)
+	}
+	return err
+}
+
+// Recorder wraps an SQS client and writes every call of the operations
+// movers use to a fixture. Other operations are passed on unrecorded. It is
+// safe for concurrent use.
+type Recorder struct {
+	sqsiface.SQSAPI
+
+	mu  sync.Mutex
+	w   *bufio.Writer
+	err error
+}
+
+// NewRecorder returns a Recorder of the calls to api, writing them to w.
+// Flush must be called once done.
+func NewRecorder(api sqsiface.SQSAPI, w io.Writer) *Recorder {
+	return &Recorder{SQSAPI: api, w: bufio.NewWriter(w)}
+}
+
+func (r *Recorder) record(operation string, input, output interface{}, err error) {
+	interaction := Interaction{Operation: operation}
+
+	var marshalErr error
+	if interaction.Input, marshalErr = json.Marshal(input); marshalErr == nil && err == nil {
+		interaction.Output, marshalErr = json.Marshal(output)
+	}
+
+	if err != nil {
+		interaction.Error = newError(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	if marshalErr != nil {
+		r.err = marshalErr
+		return
+	}
+
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		r.err = err
+	}
+}
+
+// Flush writes the buffered calls and returns the first error recording
+// failed with.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	return r.w.Flush()
+}
+
+func (r *Recorder) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	output, err := r.SQSAPI.GetQueueUrl(input)
+	r.record("GetQueueUrl", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	output, err := r.SQSAPI.GetQueueAttributes(input)
+	r.record("GetQueueAttributes", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	output, err := r.SQSAPI.SetQueueAttributes(input)
+	r.record("SetQueueAttributes", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	output, err := r.SQSAPI.CreateQueue(input)
+	r.record("CreateQueue", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	output, err := r.SQSAPI.DeleteQueue(input)
+	r.record("DeleteQueue", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	output, err := r.SQSAPI.ReceiveMessage(input)
+	r.record("ReceiveMessage", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	output, err := r.SQSAPI.SendMessage(input)
+	r.record("SendMessage", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	output, err := r.SQSAPI.SendMessageBatch(input)
+	r.record("SendMessageBatch", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	output, err := r.SQSAPI.DeleteMessage(input)
+	r.record("DeleteMessage", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	output, err := r.SQSAPI.DeleteMessageBatch(input)
+	r.record("DeleteMessageBatch", input, output, err)
+	return output, err
+}
+
+func (r *Recorder) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	output, err := r.SQSAPI.ChangeMessageVisibilityBatch(input)
+	r.record("ChangeMessageVisibilityBatch", input, output, err)
+	return output, err
+}
+
+// Replayer answers SQS calls from a fixture written by a Recorder. It is safe
+// for concurrent use.
+type Replayer struct {
+	// Operations that are not recorded panic on the nil interface.
+	sqsiface.SQSAPI
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer reads the fixture of a Recorder.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("invalid interaction on line %d: %s", line, err)
+		}
+
+		var input bytes.Buffer
+		if err := json.Compact(&input, interaction.Input); err != nil {
+			return nil, fmt.Errorf("invalid input on line %d: %s", line, err)
+		}
+		interaction.Input = input.Bytes()
+
+		p.interactions = append(p.interactions, interaction)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	p.used = make([]bool, len(p.interactions))
+	return p, nil
+}
+
+// Unused returns the recorded calls which weren't replayed, a replay which
+// diverged from the recording leaves some.
+func (p *Replayer) Unused() []Interaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var unused []Interaction
+	for i, interaction := range p.interactions {
+		if !p.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// next returns the recorded call answering a call of operation with input.
+func (p *Replayer) next(operation string, input interface{}) (*Interaction, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	match := -1
+	for i, interaction := range p.interactions {
+		if p.used[i] || interaction.Operation != operation {
+			continue
+		}
+		if bytes.Equal(interaction.Input, data) {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return nil, fmt.Errorf("sqsvcr: no recorded %s call left to replay", operation)
+	}
+
+	p.used[match] = true
+	return &p.interactions[match], nil
+}
+
+func (p *Replayer) replay(operation string, input, output interface{}) error {
+	interaction, err := p.next(operation, input)
+	if err != nil {
+		return err
+	}
+
+	if interaction.Error != nil {
+		return interaction.Error.err()
+	}
+
+	return json.Unmarshal(interaction.Output, output)
+}
+
+func (p *Replayer) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	output := &sqs.GetQueueUrlOutput{}
+	if err := p.replay("GetQueueUrl", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	output := &sqs.GetQueueAttributesOutput{}
+	if err := p.replay("GetQueueAttributes", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	output := &sqs.SetQueueAttributesOutput{}
+	if err := p.replay("SetQueueAttributes", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	output := &sqs.CreateQueueOutput{}
+	if err := p.replay("CreateQueue", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	output := &sqs.DeleteQueueOutput{}
+	if err := p.replay("DeleteQueue", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	output := &sqs.ReceiveMessageOutput{}
+	if err := p.replay("ReceiveMessage", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	output := &sqs.SendMessageOutput{}
+	if err := p.replay("SendMessage", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	output := &sqs.SendMessageBatchOutput{}
+	if err := p.replay("SendMessageBatch", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	output := &sqs.DeleteMessageOutput{}
+	if err := p.replay("DeleteMessage", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	output := &sqs.DeleteMessageBatchOutput{}
+	if err := p.replay("DeleteMessageBatch", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (p *Replayer) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	if err := p.replay("ChangeMessageVisibilityBatch", input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,164 @@
+package sqsvcr_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/sqsvcr"
+)
+
+var update = flag.Bool("update", false, "record the fixtures in testdata again")
+
+// partialSendFailure is a fixture of a move of 12 messages: the fourth
+// message of the first batch fails to send and the first delete is
+// throttled.
+var partialSendFailure = filepath.Join("testdata", "partial-send-failure.ndjson")
+
+// fakeQueues returns a fake with a source of count messages and an empty
+// destination, failing like partialSendFailure.
+func fakeQueues(t *testing.T, count int) *fakesqs.SQS {
+	t.Helper()
+
+	fake := fakesqs.New()
+	for _, name := range []string{"source", "destination"} {
+		if _, err := fake.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	url, err := fake.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("source")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: url.QueueUrl, MessageBody: aws.String(fmt.Sprintf("message-%d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake.SetEntryFailure(func(operation, id string) *sqs.BatchResultErrorEntry {
+		if operation != "SendMessageBatch" || id != "3" {
+			return nil
+		}
+		return &sqs.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError"), Message: aws.String("internal error"), SenderFault: aws.Bool(false)}
+	})
+
+	throttled := false
+	fake.SetCallFailure(func(operation, _ string) error {
+		if operation != "DeleteMessageBatch" || throttled {
+			return nil
+		}
+		throttled = true
+		return awserr.New("RequestThrottled", "request throttled", nil)
+	})
+
+	return fake
+}
+
+// move moves from source to destination with api until the first failure.
+func move(t *testing.T, api sqsiface.SQSAPI) (int, error) {
+	t.Helper()
+
+	options := rtksqs.Options{SQS: api}
+	source, err := rtksqs.OpenSource(nil, "source", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := rtksqs.OpenSink(nil, "destination", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rtksqs.Move(source, sink, rtksqs.UnknownCount, rtksqs.MoveOptions{})
+}
+
+func checkPartialSendFailure(t *testing.T, moved int, err error) {
+	t.Helper()
+
+	var moveErr *rtksqs.MoveError
+	if !errors.As(err, &moveErr) || moveErr.Step != rtksqs.StepSend {
+		t.Fatalf("got %v, want a MoveError of %s", err, rtksqs.StepSend)
+	}
+
+	var batchErr *rtksqs.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Failures) != 1 || batchErr.Failures[0].Code != "InternalError" {
+		t.Errorf("got %v, want the failure of a single message", err)
+	}
+
+	if moved != 9 {
+		t.Errorf("moved %d messages, want the 9 sent", moved)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	if *update {
+		file, err := os.Create(partialSendFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := sqsvcr.NewRecorder(fakeQueues(t, 12), file)
+		moved, err := move(t, recorder)
+		checkPartialSendFailure(t, moved, err)
+
+		if err := recorder.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	file, err := os.Open(partialSendFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	replayer, err := sqsvcr.NewReplayer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := move(t, replayer)
+	checkPartialSendFailure(t, moved, err)
+
+	if unused := replayer.Unused(); len(unused) > 0 {
+		t.Errorf("%d recorded calls weren't replayed, the first is %s", len(unused), unused[0].Operation)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+
+	recorder := sqsvcr.NewRecorder(fakeQueues(t, 12), &recording)
+	recordedMoved, recordedErr := move(t, recorder)
+	if err := recorder.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := sqsvcr.NewReplayer(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := move(t, replayer)
+
+	if moved != recordedMoved || fmt.Sprint(err) != fmt.Sprint(recordedErr) {
+		t.Errorf("replayed %d moved messages and %v, recorded %d and %v", moved, err, recordedMoved, recordedErr)
+	}
+
+	if unused := replayer.Unused(); len(unused) > 0 {
+		t.Errorf("%d recorded calls weren't replayed, the first is %s", len(unused), unused[0].Operation)
+	}
+}
@@ -0,0 +1,114 @@
+package rtksqs
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Server-side encryption levels of a queue, from weakest to strongest.
+const (
+	sseNone = iota
+	sseSqs
+	sseKms
+)
+
+var sseNames = []string{"no encryption", "SSE-SQS", "SSE-KMS"}
+
+// EncryptionIssue is a problem ValidateEncryption found. Fatal issues would
+// fail every send, the others are worth a warning.
+type EncryptionIssue struct {
+	Fatal   bool
+	Message string
+}
+
+// queueEncryption is the server-side encryption of a queue.
+type queueEncryption struct {
+	level int
+	keyID string
+}
+
+func (e queueEncryption) String() string {
+	if e.level == sseKms {
+		return fmt.Sprintf("%s with %s", sseNames[e.level], e.keyID)
+	}
+	return sseNames[e.level]
+}
+
+func loadQueueEncryption(svc sqsiface.SQSAPI, url string) (queueEncryption, error) {
+	resp, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(url),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.QueueAttributeNameKmsMasterKeyId,
+			sqs.QueueAttributeNameSqsManagedSseEnabled,
+		}),
+	})
+
+	if err != nil {
+		return queueEncryption{}, err
+	}
+
+	if keyID := aws.StringValue(resp.Attributes[sqs.QueueAttributeNameKmsMasterKeyId]); keyID != "" {
+		return queueEncryption{level: sseKms, keyID: keyID}, nil
+	}
+
+	if aws.StringValue(resp.Attributes[sqs.QueueAttributeNameSqsManagedSseEnabled]) == "true" {
+		return queueEncryption{level: sseSqs}, nil
+	}
+
+	return queueEncryption{level: sseNone}, nil
+}
+
+// ValidateEncryption checks a move to a queue against its server-side
+// encryption: the caller must be able to generate data keys with the KMS key
+// of the destination, or every send fails, and moving from a queue should not
+// weaken the encryption of its messages. Moves to other sinks have no issues.
+func ValidateEncryption(sess *session.Session, source Source, sink Sink) ([]EncryptionIssue, error) {
+	queueSink, ok := sink.(*queueSink)
+	if !ok {
+		return nil, nil
+	}
+
+	to, err := loadQueueEncryption(queueSink.svc, queueSink.url)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []EncryptionIssue
+
+	if queueSource, ok := source.(*queueSource); ok {
+		from, err := loadQueueEncryption(queueSource.svc, queueSource.url)
+		if err != nil {
+			return nil, err
+		}
+
+		if to.level < from.level {
+			issues = append(issues, EncryptionIssue{Message: fmt.Sprintf(
+				"%s uses %s, weaker than %s of %s", queueSink.url, to, from, queueSource.url)})
+		}
+	}
+
+	// SQS generates data keys with the caller's permissions when a message
+	// is sent, a denied kms:GenerateDataKey fails every send.
+	if to.level == sseKms {
+		_, err := kms.New(sess).GenerateDataKey(&kms.GenerateDataKeyInput{
+			KeyId:   aws.String(to.keyID),
+			KeySpec: aws.String(kms.DataKeySpecAes256),
+		})
+
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() != request.ErrCodeRequestError {
+			issues = append(issues, EncryptionIssue{Fatal: true, Message: fmt.Sprintf(
+				"%s is encrypted with %s, which can't be used to send to it: %s", queueSink.url, to.keyID, awsErr.Message())})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return issues, nil
+}
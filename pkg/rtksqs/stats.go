@@ -0,0 +1,158 @@
+package rtksqs
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MaxMessageSize is the largest message, and batch request, SQS accepts.
+const MaxMessageSize = 262144
+
+// sizeResolution is the width of the buckets sizes are counted in, bounding
+// the memory of MessageStats regardless of the number of messages.
+const sizeResolution = 1024
+
+// HistogramBuckets are the upper bounds of the size histogram, the last bucket
+// holds everything above MaxMessageSize.
+var HistogramBuckets = []int{1024, 4096, 16384, 65536, 131072, MaxMessageSize}
+
+// MessageStats collects the sizes and attribute counts of moved messages.
+// Sizes are counted the way SQS does: the body plus the name, type and value
+// of every message attribute.
+type MessageStats struct {
+	mu         sync.Mutex
+	count      int
+	totalBytes int64
+	maxSize    int
+	// sizes counts messages per sizeResolution bucket, the last bucket holds
+	// messages above MaxMessageSize.
+	sizes      [MaxMessageSize/sizeResolution + 2]int
+	attributes [11]int
+	maxAttrs   int
+	oversized  int
+}
+
+// SizeSummary describes the collected messages.
+type SizeSummary struct {
+	Count         int
+	AverageSize   int
+	MaxSize       int
+	P50, P90, P99 int
+	// Histogram counts messages per HistogramBuckets entry, plus one for
+	// messages above MaxMessageSize.
+	Histogram     []int
+	AttributesP50 int
+	AttributesMax int
+	// Oversized messages are larger than a tenth of MaxMessageSize, so ten
+	// of them don't fit one batch request.
+	Oversized int
+}
+
+// MessageSize returns the size SQS accounts for a message against
+// MaxMessageSize: its body and the names, types and values of its message
+// attributes.
+func MessageSize(message *sqs.Message) int {
+	size := len(aws.StringValue(message.Body))
+
+	for name, value := range message.MessageAttributes {
+		size += len(name) + len(aws.StringValue(value.DataType)) + len(aws.StringValue(value.StringValue)) + len(value.BinaryValue)
+	}
+
+	return size
+}
+
+// Add records messages.
+func (s *MessageStats) Add(messages []*sqs.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range messages {
+		size := MessageSize(message)
+		s.count++
+		s.totalBytes += int64(size)
+
+		if size > s.maxSize {
+			s.maxSize = size
+		}
+
+		if size > MaxMessageSize/DefaultBatchSize {
+			s.oversized++
+		}
+
+		bucket := (size + sizeResolution - 1) / sizeResolution
+		if size > MaxMessageSize {
+			bucket = len(s.sizes) - 1
+		}
+		s.sizes[bucket]++
+
+		attributes := len(message.MessageAttributes)
+		if attributes >= len(s.attributes) {
+			attributes = len(s.attributes) - 1
+		}
+		s.attributes[attributes]++
+
+		if len(message.MessageAttributes) > s.maxAttrs {
+			s.maxAttrs = len(message.MessageAttributes)
+		}
+	}
+}
+
+// percentile returns the smallest bucket holding at least p of the counts.
+func percentile(counts []int, total int, p float64) int {
+	threshold := int(p * float64(total))
+	seen := 0
+
+	for bucket, count := range counts {
+		seen += count
+		if seen > threshold || (seen == total && count > 0) {
+			return bucket
+		}
+	}
+
+	return len(counts) - 1
+}
+
+// Summary returns percentiles, the histogram and attribute counts of the
+// messages recorded so far. Size percentiles are rounded up to the next KiB.
+func (s *MessageStats) Summary() SizeSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := SizeSummary{Count: s.count, MaxSize: s.maxSize, AttributesMax: s.maxAttrs, Oversized: s.oversized}
+
+	if s.count == 0 {
+		return summary
+	}
+
+	summary.AverageSize = int(s.totalBytes / int64(s.count))
+
+	sizeAt := func(p float64) int {
+		size := percentile(s.sizes[:], s.count, p) * sizeResolution
+		if size > s.maxSize {
+			return s.maxSize
+		}
+		return size
+	}
+	summary.P50, summary.P90, summary.P99 = sizeAt(0.5), sizeAt(0.9), sizeAt(0.99)
+	summary.AttributesP50 = percentile(s.attributes[:], s.count, 0.5)
+
+	summary.Histogram = make([]int, len(HistogramBuckets)+1)
+	for bucket, count := range s.sizes {
+		upper := bucket * sizeResolution
+		index := len(HistogramBuckets)
+		for i, bound := range HistogramBuckets {
+			if upper <= bound {
+				index = i
+				break
+			}
+		}
+		if bucket == len(s.sizes)-1 {
+			index = len(HistogramBuckets)
+		}
+		summary.Histogram[index] += count
+	}
+
+	return summary
+}
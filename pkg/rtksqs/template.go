@@ -0,0 +1,138 @@
+package rtksqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// QueueTemplate holds the attributes and tags of queues created by
+// EnsureQueue, e.g.
+//
+//	{"attributes": {"retention": "1209600", "KmsMasterKeyId": "alias/aws/sqs"}, "tags": {"team": "payments"}}
+//
+// Attributes are named as in SQS or by the short names TuneQueue accepts.
+type QueueTemplate struct {
+	Attributes map[string]string `json:"attributes"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// LoadQueueTemplate reads a QueueTemplate from a JSON file.
+func LoadQueueTemplate(path string) (*QueueTemplate, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	template := &QueueTemplate{}
+	if err := decoder.Decode(template); err != nil {
+		return nil, fmt.Errorf("invalid queue template %s: %s", path, err)
+	}
+
+	return template, nil
+}
+
+// isQueueSpec reports whether OpenSink opens spec as the name of a queue.
+func isQueueSpec(spec string) bool {
+	return spec != StdioSpec && (isQueueURL(spec) || !strings.Contains(spec, "://")) && !strings.HasPrefix(spec, lambdaScheme) && !strings.HasPrefix(spec, eventsScheme)
+}
+
+// EnsureQueue creates the queue named by spec from the template when it
+// doesn't exist, and reports whether it did. Queues ending in .fifo are
+// created as FIFO queues. Specs of other sinks are left alone.
+func EnsureQueue(sess *session.Session, spec string, template *QueueTemplate, options Options) (bool, error) {
+	if !isQueueSpec(spec) {
+		return false, nil
+	}
+
+	svc := options.sqsClient(sess)
+
+	_, err := resolveQueueUrl(svc, spec)
+	if err == nil {
+		return false, nil
+	}
+
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		return false, err
+	}
+
+	ref, err := parseQueueRef(spec)
+	if err != nil {
+		return false, err
+	}
+	if ref.account != "" {
+		return false, fmt.Errorf("queue %s doesn't exist, queues named with an account aren't created", spec)
+	}
+
+	attributes := make(map[string]*string, len(template.Attributes)+1)
+	for name, value := range template.Attributes {
+		if alias, ok := tuneAliases[strings.ToLower(name)]; ok {
+			name = alias
+		}
+		attributes[name] = aws.String(value)
+	}
+
+	if strings.HasSuffix(spec, ".fifo") {
+		attributes[sqs.QueueAttributeNameFifoQueue] = aws.String("true")
+	}
+
+	input := &sqs.CreateQueueInput{QueueName: aws.String(spec), Attributes: attributes}
+	if len(template.Tags) > 0 {
+		input.Tags = aws.StringMap(template.Tags)
+	}
+
+	if _, err := svc.CreateQueue(input); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteEmptyQueue deletes the named queue when it holds no messages, neither
+// visible, in flight nor delayed, and reports whether it did. SQS counts
+// messages approximately, a queue which was sent to moments ago may look
+// empty, so callers should only delete queues they know nothing was sent to.
+func DeleteEmptyQueue(sess *session.Session, name string, options Options) (bool, error) {
+	svc := options.sqsClient(sess)
+
+	url, err := resolveQueueUrl(svc, name)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(url),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.QueueAttributeNameApproximateNumberOfMessages,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		}),
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, count := range resp.Attributes {
+		if n, _ := strconv.Atoi(aws.StringValue(count)); n > 0 {
+			return false, nil
+		}
+	}
+
+	if _, err := svc.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(url)}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
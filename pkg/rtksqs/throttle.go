@@ -0,0 +1,231 @@
+package rtksqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/fatih/color"
+)
+
+// DefaultMaxThrottleBackoff is the longest pause between sends of a
+// SendBackoff by default.
+const DefaultMaxThrottleBackoff = 30 * time.Second
+
+// minThrottleBackoff is the pause between sends after the first throttled
+// send, doubled with every further one.
+const minThrottleBackoff = 100 * time.Millisecond
+
+// maxThrottleRetries is how often throttled messages of a batch are sent
+// again before the send fails. Moves keep the messages hidden meanwhile.
+const maxThrottleRetries = 5
+
+// throttleCodes are the error codes of SQS refusing calls over its quotas,
+// e.g. of FIFO queues, or over the KMS quotas of encrypted queues.
+var throttleCodes = map[string]bool{
+	"RequestThrottled":    true,
+	"ThrottlingException": true,
+	"Throttling":          true,
+	"KmsThrottled":        true,
+}
+
+// IsThrottled reports whether err, or every failure of a BatchError, is a
+// throttled call.
+func IsThrottled(err error) bool {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		for _, failure := range batchErr.Failures {
+			if !throttleCodes[failure.Code] {
+				return false
+			}
+		}
+		return len(batchErr.Failures) > 0
+	}
+
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && throttleCodes[awsErr.Code()]
+}
+
+// SendBackoff paces the sends of all moves sharing it while the destination
+// throttles them. The SDK retries a throttled call by itself, but concurrent
+// moves keep calling and are throttled again, so every throttled send doubles
+// a pause all moves keep between their sends, up to the maximum, and every
+// successful one halves it until sends go unpaced again. It is safe for
+// concurrent use.
+type SendBackoff struct {
+	mu    sync.Mutex
+	max   time.Duration
+	pause time.Duration
+	next  time.Time
+}
+
+// NewSendBackoff returns a backoff pausing sends for up to max,
+// DefaultMaxThrottleBackoff when 0.
+func NewSendBackoff(max time.Duration) *SendBackoff {
+	if max == 0 {
+		max = DefaultMaxThrottleBackoff
+	}
+	return &SendBackoff{max: max}
+}
+
+// wait blocks until the next send may go, nil doesn't block.
+func (b *SendBackoff) wait() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if b.pause == 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	at := b.next
+	b.next = b.next.Add(b.pause)
+	b.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// throttled doubles the pause after a throttled send.
+func (b *SendBackoff) throttled(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pause := b.pause * 2
+	if pause < minThrottleBackoff {
+		pause = minThrottleBackoff
+	}
+	if pause > b.max {
+		pause = b.max
+	}
+
+	if pause != b.pause {
+		log.Warn(color.New(color.FgYellow).Sprintf("Sends are throttled, pausing %s between sends: %s", pause, err))
+	}
+
+	b.pause = pause
+	b.next = time.Now().Add(pause)
+}
+
+// succeeded halves the pause after a successful send.
+func (b *SendBackoff) succeeded() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pause == 0 {
+		return
+	}
+
+	b.pause /= 2
+	if b.pause < minThrottleBackoff {
+		b.pause = 0
+		log.Info(color.New(color.FgCyan).Sprintf("Sends are no longer throttled"))
+	}
+}
+
+// SendSlots limits how many sends of the moves sharing it are outstanding at
+// a time, independently of how many moves there are. A move waits for a free
+// slot before receiving a batch and keeps it until the batch was sent, so
+// slow or throttled sends hold back the receives, and received messages never
+// wait for a slot while their visibility timeout runs out. It is safe for
+// concurrent use.
+type SendSlots struct {
+	slots chan struct{}
+}
+
+// NewSendSlots returns slots letting n sends go at a time.
+func NewSendSlots(n int) *SendSlots {
+	return &SendSlots{slots: make(chan struct{}, n)}
+}
+
+// acquire blocks until a send may go or ctx is done, nil doesn't block.
+func (s *SendSlots) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	log.Debugf("Waiting for one of %d outstanding sends to finish", cap(s.slots))
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a finished send.
+func (s *SendSlots) release() {
+	if s != nil {
+		<-s.slots
+	}
+}
+
+// sendWithBackoff sends the messages to the sink, pacing the send with the
+// backoff. Messages which were throttled are sent again after backing off,
+// up to maxThrottleRetries times. A send failing after some messages were
+// sent returns a BatchError of the others. A nil backoff sends once.
+func sendWithBackoff(sink Sink, messages []*sqs.Message, backoff *SendBackoff) error {
+	pending := messages
+
+	for retry := 0; ; retry++ {
+		backoff.wait()
+
+		err := sink.Send(pending)
+		if err == nil {
+			backoff.succeeded()
+			return nil
+		}
+
+		if backoff == nil || !IsThrottled(err) || retry == maxThrottleRetries {
+			if len(pending) < len(messages) && !errors.As(err, new(*BatchError)) {
+				return &BatchError{Operation: "enqueue", Failures: batchRequestFailures(pending, err)}
+			}
+			return err
+		}
+
+		backoff.throttled(err)
+		pending = failedMessages(pending, err)
+	}
+}
+
+// failedMessages returns the messages a failed send didn't send.
+func failedMessages(messages []*sqs.Message, err error) []*sqs.Message {
+	sent := map[*sqs.Message]bool{}
+	for _, message := range sentMessages(messages, err) {
+		sent[message] = true
+	}
+
+	var failed []*sqs.Message
+	for _, message := range messages {
+		if !sent[message] {
+			failed = append(failed, message)
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,111 @@
+package rtksqs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// DefaultTouchHold is how long Touch hides the messages it received until
+// it touches them by default.
+const DefaultTouchHold = 5 * time.Minute
+
+// TouchOptions control Touch.
+type TouchOptions struct {
+	// Visibility is the visibility timeout touched messages get, 0 makes
+	// them visible right away. At most 12 hours.
+	Visibility time.Duration
+	// Hold hides received messages until every message was received once,
+	// so none is received twice, the pass must end within it.
+	// DefaultTouchHold when 0.
+	Hold time.Duration
+	// Limit touches at most this many messages, 0 touches all.
+	Limit int
+	// Progress is called after every batch with the number of messages
+	// received so far.
+	Progress func(received int)
+}
+
+// Touch receives the visible messages of a queue once and then changes their
+// visibility timeout to touch.Visibility, without sending or deleting any,
+// e.g. to hold back the backlog from its consumers for a while, or to make
+// it visible right away again after a pass. Only the receiver of a message
+// can change its visibility, messages in flight at other consumers and
+// delayed messages aren't received and stay as they are. Every touch counts
+// as a receive: it raises the receive count of the messages, and SQS moves
+// messages beyond the maxReceiveCount of a redrive policy to the dead-letter
+// queue instead of returning them. The messages received before a failure
+// are touched too. It returns the number of messages touched.
+func Touch(sess *session.Session, queue string, options Options, touch TouchOptions) (int, error) {
+	hold := touch.Hold
+	if hold == 0 {
+		hold = DefaultTouchHold
+	}
+	if hold > maxBackupHold || touch.Visibility > maxBackupHold {
+		return 0, fmt.Errorf("the hold and the visibility can be at most %s", maxBackupHold)
+	}
+
+	source, err := openQueueSource(options.sqsClient(sess), queue)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the receipt handles are needed.
+	source.visibilityTimeout = int64(hold / time.Second)
+	source.messageAttributeNames, source.attributeNames = nil, nil
+	if options.ReceiveWait > 0 {
+		source.wait = newAdaptiveWait(options.ReceiveWait)
+	}
+	source.sweeps = options.FinalSweep
+
+	received, err := receiveOnce(source, touch)
+
+	// Messages are only touched once all were received, touched messages
+	// made visible would be received again.
+	if touchErr := source.changeVisibility(received, int64(touch.Visibility/time.Second), "touch"); touchErr != nil {
+		return 0, touchErr
+	}
+
+	return len(received), err
+}
+
+// receiveOnce receives every visible message of the source once, up to the
+// limit of touch, and returns their receipts.
+func receiveOnce(source *queueSource, touch TouchOptions) ([]*sqs.Message, error) {
+	var received []*sqs.Message
+	seen := map[string]bool{}
+
+	for touch.Limit == 0 || len(received) < touch.Limit {
+		max := int64(DefaultBatchSize)
+		if left := touch.Limit - len(received); touch.Limit > 0 && int64(left) < max {
+			max = int64(left)
+		}
+
+		messages, err := source.Receive(max)
+		if err != nil {
+			return received, err
+		}
+
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, message := range messages {
+			id := aws.StringValue(message.MessageId)
+			if seen[id] {
+				return received, fmt.Errorf("message %s was received twice, the pass took longer than the hold", id)
+			}
+			seen[id] = true
+			received = append(received, receiptOf(message))
+		}
+
+		if touch.Progress != nil {
+			touch.Progress(len(received))
+		}
+	}
+
+	return received, nil
+}
@@ -0,0 +1,144 @@
+package rtksqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// DefaultExecTimeout is how long an external command may take for a message
+// by default.
+const DefaultExecTimeout = 30 * time.Second
+
+// Transformer rewrites messages before they are moved, e.g. a site-specific
+// rewrite loaded from a plugin. TransformMessage returns the message to move
+// instead, which may be the message itself, or reports that the message is
+// skipped. Messages it fails on are skipped too.
+type Transformer interface {
+	TransformMessage(message *sqs.Message) (transformed *sqs.Message, skip bool, err error)
+}
+
+// TransformChain runs its transformers in order, each on the message the one
+// before returned, until one skips the message or fails.
+type TransformChain []Transformer
+
+func (c TransformChain) TransformMessage(message *sqs.Message) (*sqs.Message, bool, error) {
+	for _, transformer := range c {
+		var skip bool
+		var err error
+
+		if message, skip, err = transformer.TransformMessage(message); err != nil || skip {
+			return nil, skip, err
+		}
+	}
+
+	return message, false, nil
+}
+
+// TransformHook returns a hook replacing messages by those the transformer
+// returns. The message ID and receipt handle are kept, so the original is
+// deleted from the source, and so are the system attributes when the
+// transformed message has none.
+func TransformHook(transformer Transformer) MessageHook {
+	return func(message *sqs.Message) string {
+		transformed, skip, err := transformer.TransformMessage(message)
+
+		switch {
+		case err != nil:
+			return "failed to transform: " + err.Error()
+		case skip:
+			return "was skipped by the transform"
+		case transformed == nil:
+			return "failed to transform: no message returned"
+		}
+
+		id, receipt, attributes := message.MessageId, message.ReceiptHandle, message.Attributes
+		*message = *transformed
+		message.MessageId, message.ReceiptHandle = id, receipt
+
+		if len(message.Attributes) == 0 {
+			message.Attributes = attributes
+		}
+
+		setBody(message, aws.StringValue(message.Body))
+		return ""
+	}
+}
+
+// execTransformer runs an external command for every message.
+type execTransformer struct {
+	command []string
+	timeout time.Duration
+}
+
+// ExecTransformer returns a transformer running command, a program and its
+// arguments separated by spaces, for every message, with the message as JSON
+// on stdin, in the format of file:// dumps. The command writes the message
+// to move instead in the same format to stdout, or nothing to skip it. It
+// fails the message when it exits with an error or runs longer than timeout,
+// DefaultExecTimeout when 0.
+func ExecTransformer(command string, timeout time.Duration) (Transformer, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no command to transform messages with")
+	}
+
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	return &execTransformer{command: fields, timeout: timeout}, nil
+}
+
+func (t *execTransformer) TransformMessage(message *sqs.Message) (*sqs.Message, bool, error) {
+	output, err := runExec(t.command, t.timeout, message)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, true, nil
+	}
+
+	var record messageRecord
+	if err := json.Unmarshal(output, &record); err != nil {
+		return nil, false, fmt.Errorf("%s wrote no message: %s", t.command[0], err)
+	}
+
+	return record.message(), false, nil
+}
+
+// runExec runs command with message as JSON on stdin and returns its stdout.
+// Errors carry what the command wrote to stderr.
+func runExec(command []string, timeout time.Duration, message *sqs.Message) ([]byte, error) {
+	input, err := json.Marshal(newMessageRecord(message))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			err = fmt.Errorf("%s: %s", err, output)
+		}
+		return nil, fmt.Errorf("%s failed: %s", command[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
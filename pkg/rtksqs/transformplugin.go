@@ -0,0 +1,38 @@
+package rtksqs
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// TransformerSymbol is the symbol LoadTransformerPlugin looks up.
+const TransformerSymbol = "Transformer"
+
+// LoadTransformerPlugin loads the Transformer exported as TransformerSymbol,
+// a variable of type Transformer or a value implementing it, by a Go plugin
+// built with go build -buildmode=plugin. The plugin must be built with the
+// Go version and the version of this package the loading binary was built
+// with, and plugins are only supported on Linux, FreeBSD and macOS.
+func LoadTransformerPlugin(path string) (Transformer, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	symbol, err := p.Lookup(TransformerSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	switch transformer := symbol.(type) {
+	case *Transformer:
+		if *transformer == nil {
+			return nil, fmt.Errorf("%s of plugin %s is nil", TransformerSymbol, path)
+		}
+		return *transformer, nil
+	case Transformer:
+		return transformer, nil
+	default:
+		return nil, fmt.Errorf("%s of plugin %s is a %T, not a Transformer", TransformerSymbol, path, symbol)
+	}
+}
@@ -0,0 +1,96 @@
+package rtksqs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// tuneAliases are the short names of queue attributes TuneQueue accepts.
+var tuneAliases = map[string]string{
+	"visibility": sqs.QueueAttributeNameVisibilityTimeout,
+	"retention":  sqs.QueueAttributeNameMessageRetentionPeriod,
+	"delay":      sqs.QueueAttributeNameDelaySeconds,
+	"wait":       sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds,
+	"max-size":   sqs.QueueAttributeNameMaximumMessageSize,
+}
+
+// QueueTuning holds the attributes a queue had before TuneQueue overrode
+// them.
+type QueueTuning struct {
+	svc   sqsiface.SQSAPI
+	url   string
+	saved map[string]*string
+}
+
+// TuneQueue overrides attributes of the queue a sink sends to, until the
+// returned tuning is restored. Attributes are named as in SQS, e.g.
+// VisibilityTimeout, or by a short name: visibility, retention, delay, wait or
+// max-size.
+func TuneQueue(sink Sink, overrides map[string]string) (*QueueTuning, error) {
+	queue, ok := sink.(*queueSink)
+	if !ok {
+		return nil, fmt.Errorf("only queue attributes can be tuned, %s is no queue", sink)
+	}
+
+	attributes := make(map[string]*string, len(overrides))
+	names := make([]*string, 0, len(overrides))
+
+	for name, value := range overrides {
+		if alias, ok := tuneAliases[strings.ToLower(name)]; ok {
+			name = alias
+		}
+		attributes[name] = aws.String(value)
+		names = append(names, aws.String(name))
+	}
+
+	resp, err := queue.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queue.url),
+		AttributeNames: names,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	tuning := &QueueTuning{svc: queue.svc, url: queue.url, saved: map[string]*string{}}
+
+	// Attributes which aren't set, e.g. a RedrivePolicy, are cleared again
+	// with an empty value.
+	for name := range attributes {
+		tuning.saved[name] = aws.String(aws.StringValue(resp.Attributes[name]))
+	}
+
+	if _, err := queue.svc.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(queue.url),
+		Attributes: attributes,
+	}); err != nil {
+		return nil, err
+	}
+
+	return tuning, nil
+}
+
+// Saved returns the attributes as they were before tuning, e.g. for logging.
+func (t *QueueTuning) Saved() string {
+	values := make([]string, 0, len(t.saved))
+	for name, value := range t.saved {
+		values = append(values, fmt.Sprintf("%s=%s", name, aws.StringValue(value)))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+// Restore sets the tuned attributes back to what they were.
+func (t *QueueTuning) Restore() error {
+	_, err := t.svc.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(t.url),
+		Attributes: t.saved,
+	})
+
+	return err
+}
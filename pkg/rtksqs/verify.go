@@ -0,0 +1,245 @@
+package rtksqs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// verifyVisibilityTimeout hides messages of a destination queue while it is
+// read back, so every message is seen once. They are released afterwards.
+const verifyVisibilityTimeout = 300
+
+// maxVerifyMessages is the most messages read back from a destination queue,
+// below the 120,000 messages a standard queue may hold in flight, which
+// leaves room for its consumers.
+const maxVerifyMessages = 100000
+
+// auditDigest is the first half of the SHA-256 of a body, collisions are
+// still unlikely among billions of messages, and audits of long moves take
+// half the memory.
+type auditDigest [sha256.Size / 2]byte
+
+func digestBody(body *string) auditDigest {
+	sum := sha256.Sum256([]byte(aws.StringValue(body)))
+
+	var digest auditDigest
+	copy(digest[:], sum[:])
+	return digest
+}
+
+// MoveAudit records the body digests of moved messages, to verify the
+// destination against once the move is done. It takes about 50 bytes per
+// distinct body.
+type MoveAudit struct {
+	mu     sync.Mutex
+	count  int
+	hashes map[auditDigest]int
+}
+
+// Add records moved messages.
+func (a *MoveAudit) Add(messages []*sqs.Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.hashes == nil {
+		a.hashes = map[auditDigest]int{}
+	}
+
+	for _, message := range messages {
+		a.hashes[digestBody(message.Body)]++
+		a.count++
+	}
+}
+
+// Count returns the number of recorded messages.
+func (a *MoveAudit) Count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.count
+}
+
+// VerifyResult compares the destination with a MoveAudit.
+type VerifyResult struct {
+	// Moved is the number of messages the audit recorded.
+	Moved int
+	// Read is the number of messages read back from the destination.
+	Read int
+	// Missing moved messages have no message with the same body in the
+	// destination.
+	Missing int
+	// Other messages in the destination weren't moved by the audited run,
+	// e.g. they were there before.
+	Other int
+}
+
+// Verified reports whether every moved message was found.
+func (r *VerifyResult) Verified() bool {
+	return r.Missing == 0
+}
+
+// Verifiable returns why a destination spec opened with options can't be read
+// back by VerifyMove, or nil. Reading back a queue receives its messages, so
+// queues with a redrive policy, which would move messages received too often
+// to their dead-letter queue, queues delaying messages and queues holding
+// more than VerifyMove reads aren't verifiable.
+func Verifiable(sess *session.Session, spec string, options Options) error {
+	switch {
+	case len(options.Redact) > 0 && (strings.HasPrefix(spec, csvScheme) || strings.HasPrefix(spec, sqliteScheme) || strings.HasPrefix(spec, fileScheme)):
+		return fmt.Errorf("%s is redacted, it can't be verified against the moved messages", spec)
+	case options.OffloadLarge != "":
+		return fmt.Errorf("%s may receive pointers to offloaded bodies, which can't be verified against the moved messages", spec)
+	case strings.HasPrefix(spec, csvScheme):
+		columns := options.CsvColumns
+		if columns == "" {
+			columns = DefaultCsvColumns
+		}
+		for _, column := range strings.Split(columns, ",") {
+			if strings.TrimSpace(column) == "body" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s has no body column to verify the move against", spec)
+	case strings.HasPrefix(spec, sqliteScheme), strings.HasPrefix(spec, fileScheme):
+		return nil
+	case spec == StdioSpec, strings.Contains(spec, "://") && !isQueueURL(spec), strings.HasPrefix(spec, lambdaScheme), strings.HasPrefix(spec, eventsScheme):
+		return fmt.Errorf("%s can't be read back to verify the move", spec)
+	case strings.HasSuffix(spec, ".fifo"):
+		return fmt.Errorf("FIFO queue %s can't be read back without blocking its message groups", spec)
+	case options.DelaySeconds > 0:
+		return fmt.Errorf("%s receives delayed messages, they can't be read back right after the move", spec)
+	default:
+		return verifiableQueue(sess, spec, options)
+	}
+}
+
+// verifiableQueue returns why the attributes of a destination queue keep it
+// from being read back, or nil.
+func verifiableQueue(sess *session.Session, spec string, options Options) error {
+	description, err := DescribeQueue(sess, spec, options)
+	if err != nil {
+		return err
+	}
+
+	attributes := description.Attributes
+	visible, _ := strconv.Atoi(attributes[sqs.QueueAttributeNameApproximateNumberOfMessages])
+
+	switch {
+	case attributes[sqs.QueueAttributeNameRedrivePolicy] != "":
+		return fmt.Errorf("%s has a redrive policy, reading it back raises the receive count of its messages and may move them to the dead-letter queue", spec)
+	case attributes[sqs.QueueAttributeNameDelaySeconds] != "" && attributes[sqs.QueueAttributeNameDelaySeconds] != "0":
+		return fmt.Errorf("%s delays its messages, they can't be read back right after the move", spec)
+	case visible >= maxVerifyMessages:
+		return fmt.Errorf("%s holds %d messages, more than the %d which can be read back", spec, visible, maxVerifyMessages)
+	default:
+		return nil
+	}
+}
+
+// VerifyMove reads back the destination spec, a queue, sqlite:// archive,
+// file:// or csv:// dump, without consuming it and checks that every message
+// recorded by the audit arrived with an unchanged body. The destination sink
+// must be closed first. Messages of a queue are hidden while it is read, up to
+// maxVerifyMessages of them, and released right after. See Verifiable for the
+// destinations which can't be read back.
+func VerifyMove(sess *session.Session, spec string, options Options, audit *MoveAudit) (*VerifyResult, error) {
+	if err := Verifiable(sess, spec, options); err != nil {
+		return nil, err
+	}
+
+	source, err := OpenSource(sess, spec, options)
+
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	queue, isQueue := source.(*queueSource)
+	if isQueue {
+		queue.visibilityTimeout = verifyVisibilityTimeout
+	}
+
+	audit.mu.Lock()
+	remaining := make(map[auditDigest]int, len(audit.hashes))
+	for hash, count := range audit.hashes {
+		remaining[hash] = count
+	}
+	result := &VerifyResult{Moved: audit.count}
+	audit.mu.Unlock()
+
+	var received []*sqs.Message
+	seen := map[string]bool{}
+
+	// Messages are never deleted, the sources of dumps and archives only
+	// consume on Delete.
+	for {
+		max := int64(DefaultBatchSize)
+		if isQueue {
+			if len(received) == maxVerifyMessages {
+				queue.release(received)
+				return nil, fmt.Errorf("%s holds more than the %d messages which can be read back", spec, maxVerifyMessages)
+			}
+			if left := int64(maxVerifyMessages - len(received)); left < max {
+				max = left
+			}
+		}
+
+		messages, err := source.Receive(max)
+
+		if err == nil && len(messages) == 0 {
+			break
+		}
+
+		if err != nil {
+			if isQueue {
+				queue.release(received)
+			}
+			return nil, err
+		}
+
+		if isQueue {
+			for _, message := range messages {
+				received = append(received, receiptOf(message))
+			}
+		}
+
+		for _, message := range messages {
+			// A queue message received twice reappeared after the
+			// visibility timeout.
+			if isQueue {
+				id := aws.StringValue(message.MessageId)
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+
+			result.Read++
+
+			hash := digestBody(message.Body)
+			if remaining[hash] > 0 {
+				remaining[hash]--
+			} else {
+				result.Other++
+			}
+		}
+	}
+
+	if isQueue {
+		if err := queue.release(received); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, count := range remaining {
+		result.Missing += count
+	}
+
+	return result, nil
+}
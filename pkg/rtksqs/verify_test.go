@@ -0,0 +1,91 @@
+package rtksqs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mercury2269/sqsmover/pkg/rtksqs/fakesqs"
+)
+
+func TestVerifiable(t *testing.T) {
+	fake := fakesqs.New()
+	createFakeQueue(t, fake, "plain")
+	createFakeQueue(t, fake, "dlq")
+
+	for name, attributes := range map[string]map[string]string{
+		"redriven": {sqs.QueueAttributeNameRedrivePolicy: `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:dlq","maxReceiveCount":"3"}`},
+		"delaying": {sqs.QueueAttributeNameDelaySeconds: "60"},
+	} {
+		if _, err := fake.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name), Attributes: aws.StringMap(attributes)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		spec    string
+		options Options
+		wantErr string
+	}{
+		{name: "queue", spec: "plain"},
+		{name: "dump", spec: "file://dump.ndjson"},
+		{name: "redrive policy", spec: "redriven", wantErr: "redrive policy"},
+		{name: "delaying queue", spec: "delaying", wantErr: "delays its messages"},
+		{name: "delayed sends", spec: "plain", options: Options{DelaySeconds: 30}, wantErr: "delayed messages"},
+		{name: "fifo", spec: "orders.fifo", wantErr: "FIFO"},
+		{name: "stdout", spec: StdioSpec, wantErr: "can't be read back"},
+		{name: "csv without body", spec: "csv://dump.csv", options: Options{CsvColumns: "id,attributes"}, wantErr: "no body column"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.SQS = fake
+			err := Verifiable(nil, test.spec, test.options)
+
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("got %q, want no error", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got %v, want an error about %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMove(t *testing.T) {
+	fake := fakesqs.New()
+	url := createFakeQueue(t, fake, "destination")
+
+	for _, body := range []string{"before", "a", "b"} {
+		if _, err := fake.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(url), MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	audit := &MoveAudit{}
+	audit.Add(testMessages("a", "b", "lost"))
+
+	result, err := VerifyMove(nil, "destination", Options{SQS: fake}, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := VerifyResult{Moved: 3, Read: 3, Missing: 1, Other: 1}
+	if *result != want {
+		t.Errorf("got %+v, want %+v", *result, want)
+	}
+
+	// The messages read back are released for the consumers.
+	attributes, err := fake.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(url),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameApproximateNumberOfMessages}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(attributes.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]); got != "3" {
+		t.Errorf("%s messages are visible, want all 3 released", got)
+	}
+}